@@ -0,0 +1,383 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/spf13/cobra"
+)
+
+// Canceled jobs are killed once they did not stop after this time
+const agentCancelTimeout = 30 * time.Second
+
+// States of jobs started by the agent
+const (
+	jobRunning  = "running"
+	jobFinished = "finished"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Run jobs requested as JSON commands on standard input",
+	Long: `Run jobs requested as JSON commands on standard input
+
+Front-ends can run Kojirou as a child process in this mode and send
+one command per line.  Every job downloads a manga by running
+Kojirou with the given arguments, and jobs can be canceled and
+queried while they run.
+
+  {"command": "start", "id": "1", "args": ["ID", "-l", "en"]}
+  {"command": "cancel", "id": "1"}
+  {"command": "query", "id": "1"}
+
+Events are written to standard output as one JSON object per line,
+which are the events of "--progress-socket" for the job named by
+"job", lines of output of the job with the type "output", the type
+"started" once a job starts, and the types "finished", "failed" and
+"canceled" once a job exits.  Queries are answered with the type
+"status" and the state of the job, or of all jobs when no identifier
+is given, and invalid commands with the type "error".  Running jobs
+are canceled once standard input is closed.  Canceled jobs are
+interrupted and only killed if they did not stop within 30 seconds.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		return runAgent(os.Stdin, os.Stdout)
+	},
+	DisableFlagsInUseLine: true,
+}
+
+type agentCommand struct {
+	Command string   `json:"command"`
+	ID      string   `json:"id"`
+	Args    []string `json:"args"`
+}
+
+type agentJob struct {
+	process *os.Process
+	state   string
+}
+
+// agent runs jobs as child processes, so jobs never share the global
+// state of a run and can be canceled at any time.
+type agent struct {
+	executable string
+	directory  string
+	out        *json.Encoder
+	jobs       map[string]*agentJob
+	order      []string
+	mutex      sync.Mutex
+	wg         sync.WaitGroup
+}
+
+func runAgent(in io.Reader, out io.Writer) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return formats.Errorf("executable: %w", err)
+	}
+	directory, err := os.MkdirTemp("", "kojirou-agent-")
+	if err != nil {
+		return formats.Errorf("sockets: %w", err)
+	}
+	defer os.RemoveAll(directory)
+
+	a := &agent{
+		executable: executable,
+		directory:  directory,
+		out:        json.NewEncoder(out),
+		jobs:       make(map[string]*agentJob),
+	}
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		command := agentCommand{}
+		if err := json.Unmarshal(scanner.Bytes(), &command); err != nil {
+			a.emit(formats.Event{Type: "error", Message: fmt.Sprintf("command: %v", err)})
+			continue
+		}
+		a.handle(command)
+	}
+
+	a.cancelAll()
+	a.wg.Wait()
+
+	return scanner.Err()
+}
+
+func (a *agent) handle(command agentCommand) {
+	var err error
+	switch command.Command {
+	case "start":
+		err = a.start(command.ID, command.Args)
+	case "cancel":
+		err = a.cancel(command.ID)
+	case "query":
+		err = a.query(command.ID)
+	default:
+		err = formats.Errorf(`not a valid command: "%v"`, command.Command)
+	}
+	if err != nil {
+		a.emit(formats.Event{Type: "error", Job: command.ID, Message: err.Error()})
+	}
+}
+
+// start runs Kojirou with the arguments, sending its progress to a
+// socket only used by this job.
+func (a *agent) start(id string, args []string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if id == "" {
+		return formats.Errorf("start: no job identifier given")
+	} else if _, ok := a.jobs[id]; ok {
+		return formats.Errorf(`start: job already exists: "%v"`, id)
+	}
+
+	socket := filepath.Join(a.directory, fmt.Sprintf("%v.sock", len(a.order)+1))
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return formats.Errorf("start: %w", err)
+	}
+	args = append([]string{"--progress-socket", socket, "--color", "never"}, args...)
+	cmd := exec.Command(a.executable, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		listener.Close()
+		return formats.Errorf("start: %w", err)
+	}
+	stderr := new(errorWriter)
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		listener.Close()
+		return formats.Errorf("start: %w", err)
+	}
+
+	job := &agentJob{process: cmd.Process, state: jobRunning}
+	a.jobs[id] = job
+	a.order = append(a.order, id)
+	a.emitLocked(formats.Event{Type: "started", Job: id, Message: strings.Join(args, " ")})
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		events := make(chan struct{})
+		go func() {
+			defer close(events)
+			a.forwardEvents(id, listener)
+		}()
+		// Output is read completely before waiting, as required
+		a.forwardOutput(id, stdout)
+		err := cmd.Wait()
+		// Jobs that fail before connecting leave the listener waiting
+		listener.Close()
+		<-events
+		a.finish(id, job, err, stderr.message())
+	}()
+
+	return nil
+}
+
+func (a *agent) cancel(id string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	job, ok := a.jobs[id]
+	if !ok {
+		return formats.Errorf(`cancel: no such job: "%v"`, id)
+	} else if job.state != jobRunning {
+		return formats.Errorf(`cancel: job not running: "%v"`, id)
+	}
+	job.state = jobCanceled
+
+	return interrupt(job.process)
+}
+
+func (a *agent) cancelAll() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for _, job := range a.jobs {
+		if job.state == jobRunning {
+			job.state = jobCanceled
+			interrupt(job.process) //nolint:errcheck
+		}
+	}
+}
+
+// interrupt asks the job to stop, so that it removes temporary files
+// and keeps existing archives intact, and kills it if it did not stop
+// in time.  Processes can only be killed on Windows.
+func interrupt(process *os.Process) error {
+	if err := process.Signal(os.Interrupt); err != nil {
+		return process.Kill()
+	}
+	time.AfterFunc(agentCancelTimeout, func() {
+		process.Kill() //nolint:errcheck
+	})
+
+	return nil
+}
+
+func (a *agent) query(id string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if id == "" {
+		for _, id := range a.order {
+			a.emitLocked(formats.Event{Type: "status", Job: id, Message: a.jobs[id].state})
+		}
+		return nil
+	}
+	job, ok := a.jobs[id]
+	if !ok {
+		return formats.Errorf(`query: no such job: "%v"`, id)
+	}
+	a.emitLocked(formats.Event{Type: "status", Job: id, Message: job.state})
+
+	return nil
+}
+
+func (a *agent) finish(id string, job *agentJob, err error, message string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	switch {
+	case job.state == jobCanceled:
+	case err != nil:
+		job.state = jobFailed
+	default:
+		job.state = jobFinished
+	}
+	event := formats.Event{Type: job.state, Job: id}
+	if job.state == jobFailed {
+		event.Message = message
+		if event.Message == "" {
+			event.Message = err.Error()
+		}
+	}
+	a.emitLocked(event)
+}
+
+// forwardEvents sends the progress events of the job, which connects
+// to the listener once.
+func (a *agent) forwardEvents(id string, listener net.Listener) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		event := formats.Event{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		event.Job = id
+		a.emit(event)
+	}
+}
+
+func (a *agent) forwardOutput(id string, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		a.emit(formats.Event{Type: "output", Job: id, Message: scanner.Text()})
+	}
+}
+
+func (a *agent) emit(event formats.Event) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.emitLocked(event)
+}
+
+func (a *agent) emitLocked(event formats.Event) {
+	a.out.Encode(event) //nolint:errcheck
+}
+
+// errorWriter keeps the error message written by failed jobs, or the
+// last line written when there is none.
+type errorWriter struct {
+	partial []byte
+	err     string
+	last    string
+	mutex   sync.Mutex
+}
+
+func (w *errorWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.addLine(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	// Progress bars are redrawn without ever ending their line
+	if i := bytes.LastIndexByte(w.partial, '\r'); i >= 0 {
+		w.partial = w.partial[i+1:]
+	}
+
+	return len(p), nil
+}
+
+func (w *errorWriter) addLine(line string) {
+	if i := strings.LastIndex(line, "\r"); i >= 0 {
+		line = line[i+1:]
+	}
+	line = strings.TrimSpace(line)
+	switch {
+	case line == "":
+	case isErrorLine(line) && w.err == "":
+		w.err = line
+	default:
+		w.last = line
+	}
+}
+
+// isErrorLine reports whether the line is an error message, in any
+// language, as jobs may select a language other than the agent.
+func isErrorLine(line string) bool {
+	for _, prefix := range formats.Translations("Error:") {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w *errorWriter) message() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.addLine(string(w.partial))
+	if w.err != "" {
+		return w.err
+	}
+
+	return w.last
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+}
@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/leotaku/kojirou/cmd/formats/kindle"
+	"github.com/leotaku/kojirou/cmd/hook"
+)
+
+// isArchiveOutput reports whether volumes should be written into a
+// single archive instead of a directory.
+func isArchiveOutput(out string) bool {
+	return isZip(out) || is7z(out)
+}
+
+func isZip(archive string) bool {
+	return strings.EqualFold(filepath.Ext(archive), ".zip")
+}
+
+func is7z(archive string) bool {
+	return strings.EqualFold(filepath.Ext(archive), ".7z")
+}
+
+// archiveSettingsPathname returns where the series settings of the
+// archive are kept.  Archives are only replaced as a whole, so they
+// are kept next to it instead of inside it.
+func archiveSettingsPathname(archive string) string {
+	return strings.TrimSuffix(archive, filepath.Ext(archive)) + "." + seriesSettingsFilename
+}
+
+// openArchiveOutput returns a temporary directory volumes are written
+// to before being packed into the archive.  The contents of an existing
+// archive are extracted to it first, so existing volumes are handled
+// the same as in directories.
+func openArchiveOutput(archive string) (string, error) {
+	directory, err := os.MkdirTemp("", "kojirou-archive-")
+	if err != nil {
+		return "", formats.Errorf("temporary directory: %w", err)
+	}
+
+	if is7z(archive) {
+		if _, err := os.Stat(archive); os.IsNotExist(err) {
+			return directory, nil
+		}
+		args, err := hook.Split(sevenZipCmdArg)
+		if err != nil {
+			os.RemoveAll(directory)
+			return "", formats.Errorf("extract: %w", err)
+		}
+		args = append(args, "x", "-y", "-o"+directory, archive)
+		if _, err := (hook.Runner{}).RunArgs(args, nil); err != nil {
+			os.RemoveAll(directory)
+			return "", formats.Errorf("extract: %w", err)
+		}
+		return directory, nil
+	}
+	zr, err := zip.OpenReader(archive)
+	if os.IsNotExist(err) {
+		return directory, nil
+	} else if err != nil {
+		os.RemoveAll(directory)
+		return "", err
+	}
+	defer zr.Close()
+	for _, file := range zr.File {
+		if err := extractFile(directory, file); err != nil {
+			os.RemoveAll(directory)
+			return "", formats.Errorf("extract '%v': %w", file.Name, err)
+		}
+	}
+
+	return directory, nil
+}
+
+func extractFile(directory string, file *zip.File) error {
+	pathname := filepath.Join(directory, filepath.FromSlash(file.Name))
+	if !strings.HasPrefix(pathname, directory+string(filepath.Separator)) {
+		return formats.Errorf("outside of archive")
+	} else if file.FileInfo().IsDir() {
+		return os.MkdirAll(pathname, os.ModePerm)
+	} else if err := os.MkdirAll(filepath.Dir(pathname), os.ModePerm); err != nil {
+		return err
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	f, err := os.Create(pathname)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Chtimes(pathname, file.Modified, file.Modified)
+}
+
+// closeArchiveOutput packs all files of the directory into the archive.
+// The archive is only replaced once completely written, so failed runs
+// keep the previous archive.
+func closeArchiveOutput(directory, archive string) error {
+	if err := os.MkdirAll(filepath.Dir(archive), os.ModePerm); err != nil {
+		return formats.Errorf("directory: %w", err)
+	} else if is7z(archive) {
+		return close7z(directory, archive)
+	}
+	f, err := kindle.CreateTemp(filepath.Dir(archive), "*-"+filepath.Base(archive))
+	if err != nil {
+		return formats.Errorf("create: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	p := formats.VanishingProgress("Archive")
+	zw := zip.NewWriter(p.NewProxyWriter(f))
+	err = filepath.WalkDir(directory, func(pathname string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(directory, pathname)
+		if err != nil {
+			return err
+		}
+		p.Increase(int(info.Size()))
+		return addArchiveFile(zw, filepath.ToSlash(name), pathname, info)
+	})
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil && syncArg != kindle.SyncPolicyNone {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		p.Cancel("Error")
+		return formats.Errorf("write: %w", err)
+	}
+	p.Done()
+
+	return os.Rename(f.Name(), archive)
+}
+
+// close7z packs the directory using the 7z command, which is the only
+// way to write 7z archives that does not require cgo.
+func close7z(directory, archive string) error {
+	// The command refuses to add files to an empty file, so it creates
+	// the archive in a directory of its own
+	temporary, err := os.MkdirTemp(filepath.Dir(archive), ".kojirou-")
+	if err != nil {
+		return formats.Errorf("create: %w", err)
+	}
+	defer os.RemoveAll(temporary)
+	pathname := filepath.Join(temporary, filepath.Base(archive))
+
+	p := formats.VanishingProgress("Archive")
+	args, err := hook.Split(sevenZipCmdArg)
+	if err != nil {
+		p.Cancel("Error")
+		return formats.Errorf("write: %w", err)
+	}
+	args = append(args, "a", "-t7z", "-mx=0", pathname, filepath.Join(directory, "*"))
+	if _, err := (hook.Runner{}).RunArgs(args, nil); err != nil {
+		p.Cancel("Error")
+		return formats.Errorf("write: %w", err)
+	}
+	if syncArg != kindle.SyncPolicyNone {
+		if err := syncFile(pathname); err != nil {
+			p.Cancel("Error")
+			return formats.Errorf("write: %w", err)
+		}
+	}
+	p.Done()
+
+	return os.Rename(pathname, archive)
+}
+
+func syncFile(pathname string) error {
+	f, err := os.Open(pathname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
+
+func addArchiveFile(zw *zip.Writer, name, pathname string, info fs.FileInfo) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	// Books and pages are already compressed, so they are stored as
+	// they are, while metadata is small either way
+	header.Method = zip.Store
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(pathname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+
+	return err
+}
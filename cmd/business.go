@@ -1,107 +1,930 @@
 package cmd
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
 
-	"github.com/leotaku/kojirou/cmd/crop"
+	"github.com/leotaku/kojirou/cmd/config"
+	"github.com/leotaku/kojirou/cmd/enrich"
 	"github.com/leotaku/kojirou/cmd/filter"
 	"github.com/leotaku/kojirou/cmd/formats"
 	"github.com/leotaku/kojirou/cmd/formats/disk"
 	"github.com/leotaku/kojirou/cmd/formats/download"
 	"github.com/leotaku/kojirou/cmd/formats/kindle"
+	"github.com/leotaku/kojirou/cmd/mock"
+	"github.com/leotaku/kojirou/cmd/sheet"
 	md "github.com/leotaku/kojirou/mangadex"
+	"github.com/leotaku/mobi"
+	"github.com/spf13/pflag"
 	"golang.org/x/text/language"
 )
 
-func run() error {
+// tokenEnv names the environment variable holding a MangaDex access
+// token, which is not given as a flag to keep it out of process lists.
+const tokenEnv = "KOJIROU_MANGADEX_TOKEN"
+
+var (
+	cfg   = new(config.Config)
+	style kindle.Style
+	crops []pageCrop
+	// Hashes of known credit pages, only set for --credit-hashes
+	creditHashes map[string]bool
+	// All chapters of the manga on MangaDex, before any filtering
+	upstream md.ChapterList
+	// Raw chapters and their alignment, only set for --interleave
+	raws        md.ChapterList
+	interleaved map[md.Identifier]md.Chapter
+	// Steps taken after volumes exceeded --max-memory
+	memoryReduced bool
+	stagedPages   string
+	// Transport for requests to other sites, which are not retried
+	otherTransport http.RoundTripper = http.DefaultTransport
+)
+
+func run(flags *pflag.FlagSet) (err error) {
+	defer handleInterrupts()()
+	formats.ASCII = asciiArg
+	formats.MaxPixels = maxPixelsArg
+	if noSIMDArg {
+		formats.DisableSIMD()
+	}
+	if progressSocketArg != "" {
+		closeEvents, err := formats.ConnectEvents(progressSocketArg)
+		if err != nil {
+			return formats.Errorf("progress socket: %w", err)
+		}
+		defer closeEvents() //nolint:errcheck
+	}
+	if jpegQualityArg < 1 || jpegQualityArg > 100 {
+		return formats.Errorf("jpeg quality: not between 1 and 100")
+	} else if codecQualityArg < 0 || codecQualityArg > 100 {
+		return formats.Errorf("codec quality: not between 0 and 100 (0 uses the encoder default)")
+	} else if colorQualityArg < 0 || colorQualityArg > 100 {
+		return formats.Errorf("color quality: not between 0 and 100 (0 uses the jpeg quality)")
+	}
+	if ioWorkersArg < 1 {
+		return formats.Errorf("io workers: must be at least 1")
+	} else if failRateArg < 0 || failRateArg > 1 {
+		return formats.Errorf("fail rate: not between 0 and 1")
+	} else if err := checkCoverFallback(coverFallbackArg); err != nil {
+		return formats.Errorf("cover fallback: %w", err)
+	} else if pageCacheLinksArg && pageCacheArg == "" {
+		return formats.Errorf("page cache links: no page cache given")
+	}
+	if sendOnlyArg {
+		if len(sendArg) == 0 {
+			return formats.Errorf("send only: no device given")
+		} else if outArg != "" {
+			return formats.Errorf("send only: cannot be combined with --out")
+		}
+		// The first device takes the place of the output directory, so
+		// volumes are never written to the disk of the host
+		outArg, kindleFolderModeArg, sendArg = sendArg[0], true, sendArg[1:]
+	}
+	archive := ""
+	if isArchiveOutput(outArg) {
+		// Volumes are written to a directory first, which is packed into
+		// the archive once all volumes were written successfully
+		archive = outArg
+		directory := ""
+		if directory, err = openArchiveOutput(archive); err != nil {
+			return formats.Errorf("archive: %w", err)
+		}
+		defer os.RemoveAll(directory)
+		defer func() {
+			if err == nil && !dryRunArg {
+				if err = closeArchiveOutput(directory, archive); err != nil {
+					err = formats.Errorf("archive: %w", err)
+				}
+			}
+		}()
+		outArg = directory
+	}
+	disk.SetReadConcurrency(ioWorkersArg)
+	processedStore.WithDirectory(pageCacheArg).WithHardLinks(pageCacheLinksArg)
+	formats.SetAVIFDecoder(decodeAVIFPage)
+	if jpegCmdArg != "" {
+		kindle.SetJPEGEncoder(encodeJPEGPage)
+	}
+	if optimizeArg {
+		kindle.SetJPEGOptimizer(optimizePage)
+	}
+
+	loaded, err := config.Load(configArg, flags.Changed("config"))
+	if err != nil {
+		return formats.Errorf("config: %w", err)
+	}
+	*cfg = *loaded
+	if cfg.UserAgent != "" {
+		download.SetUserAgent(cfg.UserAgent)
+	}
+
+	if err := download.SetIPVersion(ipVersionArg); err != nil {
+		return formats.Errorf("ip version: %w", err)
+	}
+	if caFileArg != "" || len(pinCertArg) > 0 {
+		config, err := tlsConfigFromFlags()
+		if err != nil {
+			return formats.Errorf("tls: %w", err)
+		}
+		download.SetTLSConfig(config)
+		// The default transport is shared with everything else in the
+		// process, so requests to other sites get their own copy
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = config
+		otherTransport = transport
+	}
+
+	finish, err := startSession()
+	if err != nil {
+		return formats.Errorf("session: %w", err)
+	}
+	defer func() {
+		if finishErr := finish(); finishErr != nil && err == nil {
+			err = formats.Errorf("session: %w", finishErr)
+		}
+	}()
+	// Faults are injected outside of recordings, so they are never
+	// recorded, but can be injected while replaying
+	if failRateArg > 0 || injectLatencyArg > 0 {
+		download.WrapTransport(func(rt http.RoundTripper) http.RoundTripper {
+			return mock.NewFaulty(rt, failRateArg, injectLatencyArg)
+		})
+	}
+
+	if apiBaseURLArg != "" {
+		base, err := url.Parse(apiBaseURLArg)
+		if err != nil {
+			return formats.Errorf("api base url: %w", err)
+		}
+		download.SetBaseURL(*base)
+	}
+	if apiVersionArg != "" {
+		download.SetAPIVersion(apiVersionArg)
+	}
+	token := os.Getenv(tokenEnv)
+	if token != "" {
+		download.SetToken(token)
+	}
+	if unpublishedArg {
+		if token == "" {
+			return formats.Errorf("unpublished chapters: no access token in %v", tokenEnv)
+		}
+		download.IncludeUnpublished()
+	}
+
 	manga, err := download.MangadexSkeleton(identifierArg)
 	if err != nil {
-		return fmt.Errorf("skeleton: %w", err)
+		return formats.Errorf("skeleton: %w", err)
+	}
+	settingsPathname := filepath.Join(kindle.NewNormalizedDirectory(outArg, manga.Info.Title, kindleFolderModeArg).BookDirectory(), seriesSettingsFilename)
+	if archive != "" {
+		settingsPathname = archiveSettingsPathname(archive)
+	}
+	if err := loadSeriesSettings(settingsPathname, flags); err != nil {
+		return formats.Errorf("series settings: %w", err)
+	}
+	if kccPresetArg != "" {
+		if err := applyKCCPreset(kccPresetArg, flags); err != nil {
+			return formats.Errorf("kcc preset: %w", err)
+		}
+	}
+	if profileArg != "" {
+		if err := applyProfile(profileArg, flags); err != nil {
+			return formats.Errorf("profile: %w", err)
+		}
+	}
+	// Formats are only known once series settings and presets are applied
+	if err := checkFormat(formatArg, kindleFolderModeArg); err != nil {
+		return formats.Errorf("format: %w", err)
+	} else if formatArg == kindle.FormatImages && (indexArg || len(sendArg) > 0) {
+		return formats.Errorf("format: images cannot be combined with --index or --send")
+	} else if sendOnlyArg && formatArg != kindle.FormatAZW3 {
+		return formats.Errorf("format: volumes are sent as azw3")
+	} else if err := checkCodec(encodingFor(formatArg, flags).pageCodec, formatArg); err != nil {
+		return formats.Errorf("page codec: %w", err)
+	} else if err := checkFormatDefaults(); err != nil {
+		return formats.Errorf("config: %w", err)
+	}
+	if _, err := parseSize(resizeArg); err != nil {
+		return formats.Errorf("resize: %w", err)
+	} else if maxWidthArg < 0 || maxHeightArg < 0 {
+		return formats.Errorf("resize: maximum sizes must be positive")
+	} else if _, ok := resizeFilters[resizeFilterArg]; !ok {
+		return formats.Errorf(`resize filter: not a valid filter: "%v"`, resizeFilterArg)
+	} else if grayLevelsArg != 0 && (grayLevelsArg < 2 || grayLevelsArg > 256) {
+		return formats.Errorf("gray levels: not between 2 and 256")
+	} else if ditherArg != "" && ditherArg != ditherFloydSteinberg && ditherArg != ditherOrdered {
+		return formats.Errorf(`dither: not a valid method: "%v"`, ditherArg)
+	} else if blackClipArg < 0 || whiteClipArg < 0 || blackClipArg+whiteClipArg >= 100 {
+		return formats.Errorf("auto levels: clip percentages must be positive and below 100 in total")
+	} else if sharpenArg < 0 || sharpenRadiusArg <= 0 {
+		return formats.Errorf("sharpen: amount and radius must be positive")
+	}
+	if style, err = loadStyle(manga.Info.ID); err != nil {
+		return formats.Errorf("style: %w", err)
+	}
+	crops = loadCrops(manga.Info.ID)
+	if creditHashesArg != "" {
+		if creditHashes, err = readCreditHashes(creditHashesArg); err != nil {
+			return formats.Errorf("credit hashes: %w", err)
+		}
 	}
 
 	chapters, err := getChapters(*manga)
 	if err != nil {
-		return fmt.Errorf("chapters: %w", err)
+		return formats.Errorf("chapters: %w", err)
 	}
 	*manga = manga.WithChapters(chapters)
 
 	formats.PrintSummary(manga)
+	if linksArg {
+		formats.PrintLinks(manga)
+	}
+	if interleaveArg != "" {
+		formats.PrintAlignment(
+			[]language.Tag{language.Make(languageArg), language.Make(interleaveArg)},
+			[]md.ChapterList{chapters, raws},
+		)
+	}
+	if gaps := formats.Discontinuities(manga); strictArg && len(gaps) > 0 {
+		return formats.Errorf("strict: missing chapters: %v", strings.Join(gaps, ", "))
+	} else if err := checkStrict(); err != nil {
+		return err
+	}
 	if dryRunArg {
+		formats.PrintWarnings()
 		return nil
 	}
 
+	// Replayed sessions should not depend on other sites
+	if enrichArg && replayArg == "" {
+		if err := enrichManga(manga); err != nil {
+			return formats.Errorf("metadata: %w", err)
+		}
+	}
+
 	covers, err := getCovers(manga)
 	if err != nil {
-		return fmt.Errorf("covers: %w", err)
+		return formats.Errorf("covers: %w", err)
 	}
 	*manga = manga.WithCovers(covers)
-
-	dir := kindle.NewNormalizedDirectory(outArg, manga.Info.Title, kindleFolderModeArg)
+	if err := applyCoverFallback(manga, coverFallbackArg); err != nil {
+		return formats.Errorf("covers: %w", err)
+	}
 	for _, volume := range manga.Sorted() {
-		if err := handleVolume(*manga, volume, dir); err != nil {
-			return fmt.Errorf("volume %v: %w", volume.Info.Identifier, err)
+		if volume.Cover == nil && coverFallbackArg != coverFallbackFirstPage {
+			formats.Warn("volume %v: no cover", volume.Info.Identifier)
+		}
+	}
+	if err := checkStrict(); err != nil {
+		return err
+	}
+
+	if forceArg {
+		onExistingArg = kindle.ExistingPolicyOverwrite
+	}
+	if maxMemoryArg > 0 {
+		debug.SetMemoryLimit(int64(maxMemoryArg))
+		defer func() {
+			if stagedPages != "" {
+				os.RemoveAll(stagedPages)
+			}
+		}()
+	}
+
+	dir := kindle.NewNormalizedDirectory(outArg, manga.Info.Title, kindleFolderModeArg).
+		WithStagingDirectory(stagingDirArg).
+		WithSync(syncArg).
+		WithSource(manga.Info.ID, languageArg).
+		WithMetadata(manga.Info).
+		WithFormat(formatArg).
+		WithKFXConverter(convertKFX).
+		WithBuildInfo(buildInfo(flags))
+	dir = encodingFor(formatArg, flags).apply(dir)
+	// Only books are written to targets
+	if formatArg != kindle.FormatImages {
+		dir, err = withTargets(dir, *manga, flags)
+		if err != nil {
+			return formats.Errorf("targets: %w", err)
 		}
 	}
 
+	removed, err := dir.NoteRemoved(upstream)
+	if err != nil {
+		return formats.Errorf("manifest: %w", err)
+	}
+	for _, tombstone := range removed {
+		formats.Warn("volume %v: chapter %v was removed from MangaDex", tombstone.Volume, tombstone.Identifier)
+	}
+
+	groups, parts := []string{""}, []md.ChapterList{chapters}
+	if splitByArg == "group" {
+		groups, parts = filter.SplitByGroup(chapters)
+	}
+
+	// Bars of volumes and their downloads are rendered together, so
+	// that they stay readable once volumes are built concurrently
+	progress := formats.StartProgressGroup("Volumes", false)
+	defer progress.Stop()
+	reports := make([]formats.VolumeReport, 0)
+	for i, part := range parts {
+		partManga := manga.WithChapters(part)
+		partDir, err := resolveCollisions(dir.WithGroupTag(groups[i]), partManga.Keys())
+		if err != nil {
+			return formats.Errorf("output: %w", err)
+		}
+
+		for _, volume := range partManga.Sorted() {
+			if err := checkInterrupted(); err != nil {
+				return err
+			}
+			monitor := formats.StartMemoryMonitor()
+			report, err := handleVolume(partManga, volume, partDir)
+			report.Group = groups[i]
+			report.PeakMemory = monitor.Stop()
+			if err != nil {
+				return formats.Errorf("volume %v: %w", volume.Info.Identifier, err)
+			}
+			reports = append(reports, report)
+
+			// Prevent running out of memory on the following volumes,
+			// first by doing less at once and then by keeping
+			// pages on disk until they are needed.  The whole heap
+			// is compared, as the limit applies to the whole process
+			if maxMemoryArg > 0 && monitor.Peak() > uint64(maxMemoryArg) {
+				if err := reduceMemory(); err != nil {
+					return formats.Errorf("volume %v: %w", volume.Info.Identifier, err)
+				}
+			}
+		}
+	}
+	progress.Stop()
+
+	if indexArg {
+		p := formats.VanishingProgress("Index")
+		if err := dir.WriteIndex(kindle.GenerateIndex(*manga), p); err != nil {
+			p.Cancel("Error")
+			return formats.Errorf("index: %w", err)
+		}
+		p.Done()
+	}
+	p := formats.VanishingProgress("Details")
+	if err := dir.WriteDetails(*manga, p); err != nil {
+		p.Cancel("Error")
+		return formats.Errorf("details: %w", err)
+	}
+	p.Done()
+	if err := writeSeriesSettings(settingsPathname, flags); err != nil {
+		return formats.Errorf("series settings: %w", err)
+	}
+	formats.PrintReport(reports)
+	formats.PrintWarnings()
+
 	return nil
 }
 
-func handleVolume(skeleton md.Manga, volume md.Volume, dir kindle.NormalizedDirectory) error {
-	p := formats.TitledProgress(fmt.Sprintf("Volume: %v", volume.Info.Identifier))
-	if dir.Has(volume.Info.Identifier) && !forceArg {
-		p.Cancel("Skipped")
+// reduceMemory reduces the memory used by the following volumes, once
+// by reducing concurrency and once more by staging pages on disk.
+func reduceMemory() error {
+	if !memoryReduced {
+		memoryReduced = true
+		download.ReduceConcurrency()
+		kindle.ReduceConcurrency()
+		return nil
+	} else if stagedPages != "" {
 		return nil
 	}
 
-	pages, err := getPages(volume, p)
+	directory, err := os.MkdirTemp(stagingDirArg, "kojirou-pages-")
+	if err != nil {
+		return formats.Errorf("staging pages: %w", err)
+	}
+	stagedPages = directory
+	download.StagePages(directory)
+
+	return nil
+}
+
+func handleVolume(skeleton md.Manga, volume md.Volume, dir kindle.NormalizedDirectory) (formats.VolumeReport, error) {
+	report := formats.VolumeReport{Identifier: volume.Info.Identifier}
+	p := formats.TitledProgress(fmt.Sprintf(formats.Translate("Volume: %v"), volume.Info.Identifier))
+	hash := volumeHash(volume)
+	filename, ok := dir.Filename(volume.Info.Identifier, hash, onExistingArg)
+	if !ok {
+		p.Cancel("Skipped")
+		report.Skipped = true
+		return report, nil
+	}
+
+	paths, err := getPaths(volume, p)
+	if err != nil {
+		return report, formats.Errorf("paths: %w", err)
+	}
+	rawPaths, err := getRawPaths(volume, p)
+	if err != nil {
+		return report, formats.Errorf("raw paths: %w", err)
+	}
+	pageHash := pagesHash(volume, append(paths, rawPaths...))
+	if onExistingArg == kindle.ExistingPolicyUpdate && dir.Unchanged(filename, pageHash) {
+		if err := dir.MarkUnchanged(filename, hash); err != nil {
+			p.Cancel("Error")
+			return report, formats.Errorf("manifest: %w", err)
+		}
+		p.Cancel("Unchanged")
+		report.Skipped = true
+		return report, nil
+	}
+
+	pages, err := getPages(volume, paths, p)
+	if err != nil {
+		return report, formats.Errorf("pages: %w", err)
+	} else if err := checkInterrupted(); err != nil {
+		return report, err
+	}
+	if len(rawPaths) > 0 {
+		raws, err := download.MangadexPages(rawPaths, dataSaverArg, p)
+		if err != nil {
+			return report, formats.Errorf("raw pages: %w", err)
+		}
+		pages = interleavePages(volume, pages, raws)
+	}
+	if dropCreditsArg || creditHashes != nil {
+		pages, report.Credits = dropCredits(pages, creditHashes)
+	}
+	if dedupPagesArg {
+		pages, report.Duplicates = dedupPages(pages)
+	}
+	if stitchSpreadsArg {
+		pages = stitchSpreads(pages, !leftToRightArg)
+	}
+	report.Stats = formats.ComputeStats(pages)
+
+	settings := processingFromFlags()
+	pages, err = processPages(pages, hashPages(pages, settings), settings)
 	if err != nil {
-		return fmt.Errorf("pages: %w", err)
+		return report, formats.Errorf("process: %w", err)
+	}
+	if overlayIDsArg {
+		pages = sheet.Stamp(pages)
+	}
+	var book mobi.Book
+	if formatArg != kindle.FormatImages {
+		book = volumeToMOBI(skeleton, volume, pages)
+	}
+	report.Pages = len(pages)
+	if err := checkStrict(); err != nil {
+		return report, err
+	} else if err := checkInterrupted(); err != nil {
+		return report, err
 	}
 
-	if autocropArg {
-		if err := autoCrop(pages); err != nil {
-			return fmt.Errorf("autocrop: %w", err)
+	p = formats.VanishingProgress("Writing...")
+	if formatArg == kindle.FormatImages {
+		err = dir.WriteImages(volume.Info.Identifier, filename, hash, pageHash, upstreamChapters(volume), pages, p)
+	} else {
+		err = dir.Write(volume.Info.Identifier, filename, hash, pageHash, upstreamChapters(volume), book, p)
+	}
+	if err != nil {
+		p.Cancel("Error")
+		return report, formats.Errorf("write: %w", err)
+	}
+	if modTime := newestRelease(volume); chapterMtimeArg && !modTime.IsZero() {
+		if err := dir.SetModTime(filename, modTime); err != nil {
+			p.Cancel("Error")
+			return report, formats.Errorf("modification time: %w", err)
 		}
 	}
+	if contactSheetArg && len(pages) > 0 {
+		if err := dir.WriteContactSheet(filename, sheet.Render(pages), p); err != nil {
+			p.Cancel("Error")
+			return report, formats.Errorf("contact sheet: %w", err)
+		}
+	}
+	p.Done()
+
+	return report, nil
+}
 
+// checkStrict fails once any warning was recorded and warnings are
+// treated as errors, so that no flawed volumes are written.
+func checkStrict() error {
+	if n := formats.CountWarnings(); strictArg && n > 0 {
+		formats.PrintWarnings()
+		return formats.Errorf("strict: %v warnings", n)
+	}
+
+	return nil
+}
+
+func volumeToMOBI(skeleton md.Manga, volume md.Volume, pages md.ImageList) mobi.Book {
 	mangaForVolume := skeleton.WithChapters(volume.Sorted()).WithPages(pages)
-	mobi := kindle.GenerateMOBI(mangaForVolume)
-	mobi.RightToLeft = !leftToRightArg
-	mobi.Title = fmt.Sprintf("%v: %v",
+	book := kindle.GenerateMOBI(mangaForVolume, style)
+	book.RightToLeft = !leftToRightArg
+	if book.CoverImage == nil && coverFallbackArg == coverFallbackFirstPage && len(book.Images) > 0 {
+		book.CoverImage = book.Images[0]
+	}
+	book.Title = fmt.Sprintf("%v: %v",
 		skeleton.Info.Title,
 		volume.Info.Identifier.StringFilled(fillVolumeNumberArg, 0, false),
 	)
+	if panelViewArg {
+		book = kindle.WithPanelView(book)
+	}
+	if mergeSubchaptersArg {
+		book = kindle.WithMergedSubchapters(book, volume.Sorted())
+	}
+	if contentWarningsArg {
+		book = kindle.WithContentWarnings(book, skeleton.Info)
+	}
+	if discussionPageArg {
+		book = kindle.WithDiscussion(book, skeleton.Info, volume.Sorted())
+	}
+	if splitByArg == "group" {
+		book = kindle.WithGroup(book, volume.Sorted()[0].Info.GroupNames.String())
+	}
 
-	p = formats.VanishingProgress("Writing...")
-	if err := dir.Write(volume.Info.Identifier, mobi, p); err != nil {
-		p.Cancel("Error")
-		return fmt.Errorf("write: %w", err)
+	return book
+}
+
+// enrichManga adds metadata from the configured providers to the
+// manga.  Failing providers only result in warnings.
+func enrichManga(manga *md.Manga) error {
+	names := cfg.Enrichers
+	if names == nil {
+		names = enrich.DefaultProviders
+	}
+	enricher, err := enrich.New(names)
+	if err != nil {
+		return err
 	}
+
+	p := formats.VanishingProgress("Metadata")
+	manga.Info = enricher.
+		WithCacheDirectory(enrich.DefaultCacheDirectory()).
+		WithTransport(download.WithUserAgent(otherTransport)).
+		Enrich(context.TODO(), manga.Info)
 	p.Done()
 
 	return nil
 }
 
+// loadVolumeMap returns the assignment of chapters to volumes given
+// by --volume-map or configured for the manga, if any.  Maps can be
+// read from files or downloaded, e.g. from community datasets.
+func loadVolumeMap(id string) (filter.VolumeMap, error) {
+	source := volumeMapArg
+	if series, ok := cfg.SeriesFor(id); ok && source == "" {
+		source = series.VolumeMap
+	}
+	if source == "" {
+		return nil, nil
+	}
+
+	var r io.ReadCloser
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		client := &http.Client{
+			Transport: download.WithUserAgent(otherTransport),
+			Timeout:   30 * time.Second,
+		}
+		resp, err := client.Get(source)
+		if err != nil {
+			return nil, err
+		} else if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, formats.Errorf("status: %v", resp.Status)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	}
+	defer r.Close()
+
+	return filter.ParseVolumeMap(r)
+}
+
+// loadStyle returns the style configured for the manga.
+func loadStyle(id string) (kindle.Style, error) {
+	series, ok := cfg.SeriesFor(id)
+	if !ok {
+		return kindle.Style{}, nil
+	}
+
+	read := func(pathname string) (string, error) {
+		if pathname == "" {
+			return "", nil
+		}
+		data, err := os.ReadFile(pathname)
+		return string(data), err
+	}
+	css, err := read(series.CSS)
+	if err != nil {
+		return kindle.Style{}, formats.Errorf("css: %w", err)
+	}
+	page, err := read(series.PageTemplate)
+	if err != nil {
+		return kindle.Style{}, formats.Errorf("page template: %w", err)
+	}
+
+	return kindle.NewStyle(css, page)
+}
+
+// tlsConfigFromFlags trusts the certificate authorities of the CA file,
+// e.g. those of networks intercepting TLS, and pins certificates.
+func tlsConfigFromFlags() (*tls.Config, error) {
+	roots := make([]*x509.Certificate, 0)
+	if caFileArg != "" {
+		data, err := os.ReadFile(caFileArg)
+		if err != nil {
+			return nil, formats.Errorf("ca file: %w", err)
+		}
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, formats.Errorf("ca file: %w", err)
+			}
+			roots = append(roots, cert)
+		}
+		if len(roots) == 0 {
+			return nil, formats.Errorf("ca file: no certificates found")
+		}
+	}
+
+	pins := make(download.PinnedHosts)
+	for _, pin := range pinCertArg {
+		host, hash, err := download.ParsePin(pin)
+		if err != nil {
+			return nil, formats.Errorf("pin: %w", err)
+		}
+		pins[host] = append(pins[host], hash)
+	}
+
+	return download.TLSConfig(roots, pins), nil
+}
+
+// startSession records or replays all requests according to the
+// flags.  The returned function must be called once all requests have
+// been made.
+func startSession() (func() error, error) {
+	switch {
+	case recordArg != "" && replayArg != "":
+		return nil, formats.Errorf("cannot record and replay at the same time")
+	case recordArg != "":
+		f, err := os.Create(recordArg)
+		if err != nil {
+			return nil, err
+		}
+		var recorder *mock.Recorder
+		download.WrapTransport(func(rt http.RoundTripper) http.RoundTripper {
+			recorder = mock.NewRecorder(f, rt, recordImageDataArg)
+			return recorder
+		})
+		return func() error {
+			if err := recorder.Close(); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		}, nil
+	case replayArg != "":
+		f, err := os.Open(replayArg)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		replayer, err := mock.NewReplayer(f)
+		if err != nil {
+			return nil, formats.Errorf("replay: %w", err)
+		}
+		download.WrapTransport(func(http.RoundTripper) http.RoundTripper {
+			return replayer
+		})
+		download.DisableRetryDelay()
+		return func() error { return nil }, nil
+	default:
+		return func() error { return nil }, nil
+	}
+}
+
+func withTargets(dir kindle.NormalizedDirectory, manga md.Manga, flags *pflag.FlagSet) (kindle.NormalizedDirectory, error) {
+	newTarget := func(target string, kindleFolder bool, format string) kindle.NormalizedDirectory {
+		mirror := kindle.NewNormalizedDirectory(target, manga.Info.Title, kindleFolder).
+			WithStagingDirectory(stagingDirArg).
+			WithSync(syncArg).
+			WithSource(manga.Info.ID, languageArg).
+			WithMetadata(manga.Info).
+			WithFormat(format).
+			WithKFXConverter(convertKFX)
+
+		return encodingFor(format, flags).apply(mirror)
+	}
+
+	for _, target := range sendArg {
+		dir = dir.WithMirrors(newTarget(target, true, kindle.FormatAZW3))
+	}
+	for _, target := range cfg.Targets {
+		if target.Format == kindle.FormatImages {
+			return dir, formats.Errorf(`target "%v": images are only written to the main output`, target)
+		} else if err := checkFormat(target.Format, target.KindleFolderMode); err != nil {
+			return dir, formats.Errorf(`target "%v": %w`, target, err)
+		} else if err := checkCodec(target.PageCodec, target.Format); err != nil {
+			return dir, formats.Errorf(`target "%v": page codec: %w`, target, err)
+		}
+		jpegOptions := kindle.JPEGOptions{
+			Subsampling: target.Subsampling,
+			Progressive: target.Progressive,
+			Trellis:     target.Trellis,
+		}
+		if !jpegOptions.IsZero() && jpegCmdArg == "" {
+			// The built-in encoder only writes baseline 4:2:0 pages
+			return dir, formats.Errorf(`target "%v": subsampling, progressive and trellis need --jpeg-cmd`, target)
+		}
+		settings := encodingFor(target.Format, flags)
+		if target.JPEGQuality != 0 {
+			settings.jpegQuality = target.JPEGQuality
+		}
+		if target.PageCodec != "" {
+			settings.pageCodec = target.PageCodec
+		}
+		if target.CodecQuality != 0 {
+			settings.codecQuality = target.CodecQuality
+		}
+		if target.Grayscale != nil {
+			settings.grayscale = *target.Grayscale
+		}
+		mirror := newTarget(target.Path, target.KindleFolderMode, target.Format).WithJPEGOptions(jpegOptions)
+		dir = dir.WithMirrors(settings.apply(mirror))
+	}
+
+	return dir, nil
+}
+
+// checkCodec ensures pages of books in the format can be encoded with
+// the codec, as only EPUB and CBZ readers commonly support codecs
+// other than JPEG and PNG.
+func checkCodec(codec, format string) error {
+	switch codec {
+	case "", kindle.CodecJPEG, kindle.CodecPNG:
+		return nil
+	case kindle.CodecWebP, kindle.CodecAVIF:
+		if format != kindle.FormatEPUB && format != kindle.FormatCBZ && format != kindle.FormatTachiyomi {
+			return formats.Errorf("%v requires epub, cbz or tachiyomi", codec)
+		}
+		return nil
+	default:
+		return formats.Errorf(`not a supported codec: "%v"`, codec)
+	}
+}
+
+// checkFormat ensures books can be written in the format, as only AZW3
+// and KFX books can be synchronized with Kindle devices.
+func checkFormat(format string, kindleFolder bool) error {
+	switch format {
+	case "", kindle.FormatAZW3, kindle.FormatKFX:
+		return nil
+	case kindle.FormatEPUB, kindle.FormatKEPUB, kindle.FormatCBZ, kindle.FormatTachiyomi, kindle.FormatImages:
+		if kindleFolder {
+			return formats.Errorf("kindle folder mode requires azw3 or kfx")
+		}
+		return nil
+	default:
+		return formats.Errorf(`not a supported format: "%v"`, format)
+	}
+}
+
+func resolveCollisions(dir kindle.NormalizedDirectory, identifiers []md.Identifier) (kindle.NormalizedDirectory, error) {
+	collisions := dir.Collisions(identifiers)
+	switch {
+	case len(collisions) == 0:
+		return dir, nil
+	case onCollisionArg == "tag":
+		tagged := make([]md.Identifier, 0)
+		for _, collision := range collisions {
+			tagged = append(tagged, collision.Identifier)
+		}
+		dir = dir.WithLanguageTags(tagged)
+		collisions = dir.Collisions(identifiers)
+	case onCollisionArg != "abort":
+		return dir, formats.Errorf(`not a valid collision policy: "%v"`, onCollisionArg)
+	}
+
+	if len(collisions) > 0 {
+		lines := make([]string, 0)
+		for _, collision := range collisions {
+			lines = append(lines, fmt.Sprintf("  %v: volume %v %v", collision.Filename, collision.Identifier, collision.Reason))
+		}
+		return dir, formats.Errorf("filename collisions:\n%v", strings.Join(lines, "\n"))
+	}
+
+	return dir, nil
+}
+
+// volumeHash identifies the upstream contents of a volume, so volumes
+// can be rebuilt once chapters are added, replaced or updated.
+func volumeHash(volume md.Volume) string {
+	hash := fnv.New64a()
+	for _, chapter := range volume.Sorted() {
+		fmt.Fprintln(hash, chapter.Info.ID, chapter.Info.Identifier, chapter.Info.Updated.Unix())
+		// Only chapters from disk have content hashes
+		if chapter.Info.Hash != "" {
+			fmt.Fprintln(hash, chapter.Info.Hash)
+		}
+		if raw, ok := interleaved[chapter.Info.Identifier]; ok {
+			fmt.Fprintln(hash, raw.Info.ID, raw.Info.Updated.Unix())
+		}
+	}
+
+	return fmt.Sprintf("%016x", hash.Sum64())
+}
+
+// upstreamChapters returns the chapters of the volume downloaded from
+// MangaDex, whose removal from MangaDex is tracked.
+func upstreamChapters(volume md.Volume) md.ChapterList {
+	return volume.Sorted().FilterBy(func(ci md.ChapterInfo) bool {
+		return ci.GroupNames.String() != "Filesystem"
+	})
+}
+
+// newestRelease returns the latest publish date of the chapters of the
+// volume, which is zero when no chapter has one, e.g. chapters from
+// disk.
+func newestRelease(volume md.Volume) time.Time {
+	result := time.Time{}
+	for _, chapter := range volume.Sorted() {
+		if chapter.Info.Published.After(result) {
+			result = chapter.Info.Published
+		}
+	}
+
+	return result
+}
+
+// pagesHash identifies the upstream pages of a volume.  The paths of
+// MangaDex pages contain hashes of their contents, so volumes can be
+// recognized as unchanged without downloading any pages.
+func pagesHash(volume md.Volume, paths md.PathList) string {
+	hash := fnv.New64a()
+	for _, chapter := range volume.Sorted() {
+		fmt.Fprintln(hash, chapter.Info.Identifier, chapter.Info.Hash)
+	}
+	for _, path := range paths {
+		fmt.Fprintln(hash, path.ChapterIdentifier, path.ImageIdentifier, path.Hash)
+	}
+
+	return fmt.Sprintf("%016x", hash.Sum64())
+}
+
 func getChapters(manga md.Manga) (md.ChapterList, error) {
 	chapters, err := download.MangadexChapters(identifierArg)
 	if err != nil {
-		return nil, fmt.Errorf("mangadex: %w", err)
+		return nil, formats.Errorf("mangadex: %w", err)
 	}
+	upstream = chapters
+	if os.Getenv(tokenEnv) != "" && !ignoreBlockedArg {
+		blocked, err := download.MangadexBlocked()
+		if err != nil {
+			return nil, formats.Errorf("blocked: %w", err)
+		}
+		chapters = filter.FilterByBlocked(chapters, blocked.Groups, blocked.Uploaders)
+	}
+	all := chapters
 
 	if diskArg != "" {
 		p := formats.VanishingProgress("Disk...")
-		diskChapters, err := disk.LoadChapters(diskArg, language.Make(languageArg), p)
+		diskChapters, err := loadDiskChapters(manga.Info, chapters, p)
 		if err != nil {
 			p.Cancel("Error")
-			return nil, fmt.Errorf("disk: %w", err)
+			return nil, formats.Errorf("disk: %w", err)
 		}
 		p.Done()
 		chapters = append(chapters, diskChapters...)
 	}
 
+	if volumeMap, err := loadVolumeMap(manga.Info.ID); err != nil {
+		return nil, formats.Errorf("volume map: %w", err)
+	} else if volumeMap != nil {
+		chapters = volumeMap.Apply(chapters)
+	}
+
 	chapters, err = filterAndSortFromFlags(chapters)
 	if err != nil {
-		return nil, fmt.Errorf("filter: %w", err)
+		return nil, formats.Errorf("filter: %w", err)
 	}
 
 	// Ensure chapters from disk are preferred
@@ -111,7 +934,76 @@ func getChapters(manga md.Manga) (md.ChapterList, error) {
 		})
 	}
 
-	return filter.RemoveDuplicates(chapters), nil
+	switch splitByArg {
+	case "":
+		chapters = filter.RemoveDuplicates(chapters)
+	case "group":
+		chapters = filter.RemoveDuplicatesByGroup(chapters)
+	default:
+		return nil, formats.Errorf(`not a valid split: "%v"`, splitByArg)
+	}
+	if languages := filter.Languages(chapters); len(languages) > 1 {
+		if !mixedLanguagesArg {
+			return nil, formats.Errorf("chapters in multiple languages: %v (use --mixed-languages to allow)", formats.FormatLanguages(languages))
+		}
+		formats.Warn("chapters in multiple languages: %v", formats.FormatLanguages(languages))
+	}
+
+	switch manga.Info.Status {
+	case "ongoing", "hiatus":
+		// Keep new chapters apart from specials until they are
+		// collected, so only this volume changes on updates
+		if onExistingArg == kindle.ExistingPolicyUpdate {
+			moved := 0
+			chapters, moved = filter.GroupTrailing(chapters, md.NewIdentifier("Ongoing"))
+			if moved > 0 {
+				formats.Warn("%v chapters without volume moved to the Ongoing volume", moved)
+			}
+		}
+	case "completed":
+		if chaptersFilter == "" && volumesFilter == "" {
+			checkFinalChapter(manga.Info, chapters)
+		}
+	}
+	if interleaveArg != "" {
+		lang := language.Make(interleaveArg)
+		raws = rawChapters(all, chapters, lang)
+		interleaved = alignChapters(chapters, raws, lang)
+	}
+
+	return chapters, nil
+}
+
+// checkFinalChapter warns when the final chapter of a completed series
+// is not among the given chapters.
+func checkFinalChapter(info md.MangaInfo, chapters md.ChapterList) {
+	if info.LastChapter.IsUnknown() {
+		return
+	}
+	for _, chapter := range chapters {
+		if chapter.Info.Identifier.Equal(info.LastChapter) {
+			return
+		}
+	}
+
+	formats.Warn("series is completed, but final chapter %v is missing", info.LastChapter)
+}
+
+func loadDiskChapters(info md.MangaInfo, known md.ChapterList, p formats.CliProgress) (md.ChapterList, error) {
+	lang := language.Make(languageArg)
+	if !diskSearchArg {
+		return disk.LoadChapters(diskArg, lang, p)
+	}
+
+	// Collections rarely record volumes for individual chapters
+	volumes := make(map[md.Identifier]md.Identifier)
+	for _, chapter := range filter.FilterByLanguage(known, lang) {
+		if !chapter.Info.VolumeIdentifier.IsSpecial() {
+			volumes[chapter.Info.Identifier] = chapter.Info.VolumeIdentifier
+		}
+	}
+
+	return disk.SearchChapters(diskArg, info, volumes, lang, p)
 }
 
 func getCovers(manga *md.Manga) (md.ImageList, error) {
@@ -119,19 +1011,19 @@ func getCovers(manga *md.Manga) (md.ImageList, error) {
 	covers, err := download.MangadexCovers(manga, p)
 	if err != nil {
 		p.Cancel("Error")
-		return nil, fmt.Errorf("mangadex: %w", err)
+		return nil, formats.Errorf("mangadex: %w", err)
 	}
 	p.Done()
 
 	// Covers from disk should automatically be preferred, because
 	// they appear later in the list and thus should override the
 	// earlier downloaded covers.
-	if diskArg != "" {
+	if diskArg != "" && !diskSearchArg {
 		p := formats.VanishingProgress("Disk...")
 		diskCovers, err := disk.LoadCovers(diskArg, p)
 		if err != nil {
 			p.Cancel("Error")
-			return nil, fmt.Errorf("disk: %w", err)
+			return nil, formats.Errorf("disk: %w", err)
 		}
 		p.Done()
 		covers = append(covers, diskCovers...)
@@ -140,44 +1032,36 @@ func getCovers(manga *md.Manga) (md.ImageList, error) {
 	return covers, nil
 }
 
-func getPages(volume md.Volume, p formats.CliProgress) (md.ImageList, error) {
-	mangadexPages, err := download.MangadexPages(volume.Sorted().FilterBy(func(ci md.ChapterInfo) bool {
+func getPaths(volume md.Volume, p formats.CliProgress) (md.PathList, error) {
+	paths, err := download.MangadexPaths(volume.Sorted().FilterBy(func(ci md.ChapterInfo) bool {
 		return ci.GroupNames.String() != "Filesystem"
-	}), dataSaverArg, p)
+	}), p)
 	if err != nil {
 		p.Cancel("Error")
-		return nil, fmt.Errorf("mangadex: %w", err)
+		return nil, formats.Errorf("mangadex: %w", err)
+	}
+
+	return paths, nil
+}
+
+func getPages(volume md.Volume, paths md.PathList, p formats.CliProgress) (md.ImageList, error) {
+	mangadexPages, err := download.MangadexPages(paths, dataSaverArg, p)
+	if err != nil {
+		p.Cancel("Error")
+		return nil, formats.Errorf("mangadex: %w", err)
 	}
 	diskPages, err := disk.LoadPages(volume.Sorted().FilterBy(func(ci md.ChapterInfo) bool {
 		return ci.GroupNames.String() == "Filesystem"
 	}), p)
 	if err != nil {
 		p.Cancel("Error")
-		return nil, fmt.Errorf("disk: %w", err)
+		return nil, formats.Errorf("disk: %w", err)
 	}
 	p.Done()
 
 	return append(mangadexPages, diskPages...), nil
 }
 
-func autoCrop(pages md.ImageList) error {
-	p := formats.VanishingProgress("Cropping..")
-	p.Increase(len(pages))
-
-	for i, page := range pages {
-		if cropped, err := crop.Crop(pages[i].Image, crop.Limited(pages[i].Image, 0.1)); err != nil {
-			p.Cancel("Error")
-			return fmt.Errorf("chapter %v: page %v: %w", page.ChapterIdentifier, page.ImageIdentifier, err)
-		} else {
-			pages[i].Image = cropped
-			p.Add(1)
-		}
-	}
-	p.Done()
-
-	return nil
-}
-
 func filterAndSortFromFlags(cl md.ChapterList) (md.ChapterList, error) {
 	if languageArg != "" {
 		lang := language.Make(languageArg)
@@ -194,6 +1078,9 @@ func filterAndSortFromFlags(cl md.ChapterList) (md.ChapterList, error) {
 		ranges := filter.ParseRanges(chaptersFilter)
 		cl = filter.FilterByIdentifier(cl, "Identifier", ranges)
 	}
+	if !keepTeasersArg {
+		cl = filter.RemoveTeasers(cl)
+	}
 
 	switch rankArg {
 	case "newest":
@@ -206,8 +1093,10 @@ func filterAndSortFromFlags(cl md.ChapterList) (md.ChapterList, error) {
 		cl = filter.SortByGroupViews(cl)
 	case "most":
 		cl = filter.SortByMost(cl)
+	case "quality":
+		return rankByQuality(cl)
 	default:
-		return nil, fmt.Errorf(`not a valid ranking algorithm: "%v"`, rankArg)
+		return nil, formats.Errorf(`not a valid ranking algorithm: "%v"`, rankArg)
 	}
 
 	return cl, nil
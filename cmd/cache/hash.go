@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"image"
+)
+
+// Hash identifies the contents of img.  Identical pages appearing in
+// multiple volumes (e.g. credits or covers) produce the same hash.
+func Hash(img image.Image) string {
+	hash := fnv.New128a()
+	writeImage(hash, img)
+
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+func writeImage(hash hash.Hash, img image.Image) {
+	bounds := img.Bounds()
+	binary.Write(hash, binary.LittleEndian, [4]int64{ //nolint:errcheck
+		int64(bounds.Min.X), int64(bounds.Min.Y),
+		int64(bounds.Max.X), int64(bounds.Max.Y),
+	})
+
+	// Images that are decoded lazily expose their decoded pixels
+	if lazy, ok := img.(interface{ Decoded() image.Image }); ok {
+		img = lazy.Decoded()
+	}
+
+	// Avoid the generic color model for common decoder outputs
+	switch img := img.(type) {
+	case *image.YCbCr:
+		hash.Write(img.Y)
+		hash.Write(img.Cb)
+		hash.Write(img.Cr)
+	case *image.Gray:
+		hash.Write(img.Pix)
+	case *image.RGBA:
+		hash.Write(img.Pix)
+	case *image.NRGBA:
+		hash.Write(img.Pix)
+	case *image.Paletted:
+		hash.Write(img.Pix)
+		for _, c := range img.Palette {
+			r, g, b, a := c.RGBA()
+			binary.Write(hash, binary.LittleEndian, [4]uint32{r, g, b, a}) //nolint:errcheck
+		}
+	default:
+		buf := make([]byte, 8)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r, g, b, a := img.At(x, y).RGBA()
+				binary.LittleEndian.PutUint16(buf[0:], uint16(r))
+				binary.LittleEndian.PutUint16(buf[2:], uint16(g))
+				binary.LittleEndian.PutUint16(buf[4:], uint16(b))
+				binary.LittleEndian.PutUint16(buf[6:], uint16(a))
+				hash.Write(buf)
+			}
+		}
+	}
+}
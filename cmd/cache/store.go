@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+)
+
+// Store holds processed images keyed by the hash of their source image
+// and the settings used for processing them.
+type Store struct {
+	lru       *LRU
+	directory string
+	link      bool
+}
+
+func NewStore(capacity int) *Store {
+	return &Store{lru: NewLRU(capacity)}
+}
+
+// WithDirectory makes the store also keep images as PNG files in the
+// directory, so that later runs reuse them.  Files are never removed,
+// the directory can be deleted to clear the store.
+func (s *Store) WithDirectory(directory string) *Store {
+	s.directory = directory
+	return s
+}
+
+// WithHardLinks makes the files of identical images hard links to a
+// single file in the directory, e.g. for pages that are the same after
+// processing them using different settings.  Files are copied instead
+// on filesystems without hard links.
+func (s *Store) WithHardLinks(link bool) *Store {
+	s.link = link
+	return s
+}
+
+func (s *Store) Get(source, settings string) (image.Image, bool) {
+	key := source + "/" + settings
+	if img, ok := s.lru.Get(key); ok {
+		return img.(image.Image), true
+	} else if s.directory == "" {
+		return nil, false
+	}
+
+	f, err := os.Open(s.pathname(key))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, false
+	}
+	s.lru.Add(key, img)
+
+	return img, true
+}
+
+// Add stores the image, failing only if it could not be written to
+// the directory.
+func (s *Store) Add(source, settings string, img image.Image) error {
+	key := source + "/" + settings
+	s.lru.Add(key, img)
+	if s.directory == "" {
+		return nil
+	}
+
+	buf := bytes.NewBuffer(nil)
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := enc.Encode(buf, img); err != nil {
+		return formats.Errorf("encode: %w", err)
+	}
+	if err := os.MkdirAll(s.directory, 0o755); err != nil {
+		return err
+	}
+	if !s.link {
+		return writeFile(s.pathname(key), buf.Bytes())
+	}
+
+	shared := filepath.Join(s.directory, "shared", Hash(img)+".png")
+	if _, err := os.Stat(shared); err != nil {
+		if err := os.MkdirAll(filepath.Dir(shared), 0o755); err != nil {
+			return err
+		} else if err := writeFile(shared, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	pathname := s.pathname(key)
+	if err := os.Remove(pathname); err != nil && !os.IsNotExist(err) {
+		return err
+	} else if err := os.Link(shared, pathname); err != nil {
+		return writeFile(pathname, buf.Bytes())
+	}
+
+	return nil
+}
+
+func (s *Store) pathname(key string) string {
+	hash := fnv.New128a()
+	hash.Write([]byte(key))
+
+	return filepath.Join(s.directory, fmt.Sprintf("%x.png", hash.Sum(nil)))
+}
+
+// writeFile replaces the file at once, so that concurrent runs never
+// read partially written images.
+func writeFile(pathname string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(pathname), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	} else if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), pathname)
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/leotaku/kojirou/cmd/formats"
+)
+
+const defaultFilename = "config.toml"
+
+type Config struct {
+	Targets []Target `toml:"target"`
+	Series  []Series `toml:"series"`
+	// Identifies the client to MangaDex and other sites, instead of
+	// the default including the version of Kojirou
+	UserAgent string `toml:"user-agent"`
+	// Metadata providers, in order of preference
+	Enrichers []string `toml:"enrichers"`
+	// Settings for all volumes written in a format, by format
+	Formats map[string]FormatDefaults `toml:"format"`
+}
+
+// Target describes an additional output for every generated volume.
+// Multiple targets share a single downloaded and processed set of
+// pages, so producing additional formats is cheap.
+type Target struct {
+	Name             string `toml:"name"`
+	Path             string `toml:"path"`
+	Format           string `toml:"format"`
+	KindleFolderMode bool   `toml:"kindle-folder-mode"`
+	JPEGQuality      int    `toml:"jpeg-quality"`
+	PageCodec        string `toml:"page-codec"`
+	CodecQuality     int    `toml:"codec-quality"`
+	Grayscale        *bool  `toml:"grayscale"`
+	// Options of the external JPEG encoder, where missing keys keep
+	// the defaults of the encoder
+	Subsampling string `toml:"subsampling"`
+	Progressive *bool  `toml:"progressive"`
+	Trellis     *bool  `toml:"trellis"`
+}
+
+// FormatDefaults changes the settings volumes in a format are written
+// with, unless they are given explicitly.  Missing keys keep the
+// built-in defaults of the format.
+type FormatDefaults struct {
+	JPEGQuality  *int    `toml:"jpeg-quality"`
+	PageCodec    *string `toml:"page-codec"`
+	CodecQuality *int    `toml:"codec-quality"`
+	Grayscale    *bool   `toml:"grayscale"`
+}
+
+// Series customizes the books generated for the manga with the given
+// MangaDex identifier.  Relative paths are resolved against the
+// directory of the configuration file.
+type Series struct {
+	ID           string `toml:"id"`
+	CSS          string `toml:"css"`
+	PageTemplate string `toml:"page-template"`
+	// File or URL assigning chapters to volumes, see --volume-map
+	VolumeMap string `toml:"volume-map"`
+	Crops     []Crop `toml:"crop"`
+}
+
+// Crop removes fixed margins from pages or keeps a fixed rectangle of
+// them, for series with watermarks or bars that autocrop keeps.  Pages
+// are selected by chapter ranges and by ranges of their number within
+// the chapter, where empty ranges select all.  The first matching crop
+// applies.
+type Crop struct {
+	Chapters string `toml:"chapters"`
+	Pages    string `toml:"pages"`
+	// Pixels removed from the top, right, bottom and left
+	Margins []int `toml:"margins"`
+	// Pixels of the left, top, right and bottom edges of the kept part
+	Rect []int `toml:"rect"`
+	// Pages are cropped after autocrop instead of replacing it
+	AfterAutocrop bool `toml:"after-autocrop"`
+}
+
+// DefaultPath returns the location of the configuration file that is
+// used when none is given explicitly.
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "kojirou", defaultFilename)
+}
+
+// Load reads the configuration file at pathname.  A missing file is
+// only an error when required is set.
+func Load(pathname string, required bool) (*Config, error) {
+	cfg := new(Config)
+	if pathname == "" {
+		return cfg, nil
+	}
+
+	meta, err := toml.DecodeFile(pathname, cfg)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return cfg, nil
+	} else if err != nil {
+		return nil, formats.Errorf("decode: %w", err)
+	} else if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		return nil, formats.Errorf("unknown key: %v", undecoded[0])
+	}
+
+	for i, target := range cfg.Targets {
+		if target.Path == "" {
+			return nil, formats.Errorf("target %v: no path", target.describe(i))
+		} else if target.JPEGQuality < 0 || target.JPEGQuality > 100 {
+			return nil, formats.Errorf("target %v: jpeg quality not between 0 and 100", target.describe(i))
+		} else if target.CodecQuality < 0 || target.CodecQuality > 100 {
+			return nil, formats.Errorf("target %v: codec quality not between 0 and 100", target.describe(i))
+		} else if target.Subsampling != "" && target.Subsampling != "4:2:0" && target.Subsampling != "4:4:4" {
+			return nil, formats.Errorf("target %v: subsampling not 4:2:0 or 4:4:4", target.describe(i))
+		}
+	}
+	for format, defaults := range cfg.Formats {
+		if q := defaults.JPEGQuality; q != nil && (*q < 1 || *q > 100) {
+			return nil, formats.Errorf("format %v: jpeg quality not between 1 and 100", format)
+		} else if q := defaults.CodecQuality; q != nil && (*q < 0 || *q > 100) {
+			return nil, formats.Errorf("format %v: codec quality not between 0 and 100 (0 uses the encoder default)", format)
+		}
+	}
+	for i, series := range cfg.Series {
+		if series.ID == "" {
+			return nil, formats.Errorf("series %v: no id", i+1)
+		}
+		for j, crop := range series.Crops {
+			if err := crop.validate(); err != nil {
+				return nil, formats.Errorf("series %v: crop %v: %w", i+1, j+1, err)
+			}
+		}
+		cfg.Series[i].CSS = resolve(pathname, series.CSS)
+		cfg.Series[i].PageTemplate = resolve(pathname, series.PageTemplate)
+		if !isURL(series.VolumeMap) {
+			cfg.Series[i].VolumeMap = resolve(pathname, series.VolumeMap)
+		}
+	}
+
+	return cfg, nil
+}
+
+// SeriesFor returns the customizations for the manga, if any.
+func (c *Config) SeriesFor(id string) (Series, bool) {
+	for _, series := range c.Series {
+		if series.ID == id {
+			return series, true
+		}
+	}
+
+	return Series{}, false
+}
+
+func resolve(configPath, pathname string) string {
+	if pathname == "" || filepath.IsAbs(pathname) {
+		return pathname
+	}
+
+	return filepath.Join(filepath.Dir(configPath), pathname)
+}
+
+func (c Crop) validate() error {
+	if (c.Margins == nil) == (c.Rect == nil) {
+		return formats.Errorf("requires either margins or rect")
+	} else if c.Margins != nil && len(c.Margins) != 4 {
+		return formats.Errorf("margins: not four values")
+	} else if c.Rect != nil && len(c.Rect) != 4 {
+		return formats.Errorf("rect: not four values")
+	}
+	for _, v := range append(c.Margins, c.Rect...) {
+		if v < 0 {
+			return formats.Errorf("negative value: %v", v)
+		}
+	}
+	if c.Rect != nil && (c.Rect[2] <= c.Rect[0] || c.Rect[3] <= c.Rect[1]) {
+		return formats.Errorf("rect: empty")
+	}
+
+	return nil
+}
+
+func isURL(pathname string) bool {
+	return strings.HasPrefix(pathname, "http://") || strings.HasPrefix(pathname, "https://")
+}
+
+func (t Target) describe(index int) string {
+	if t.Name != "" {
+		return fmt.Sprintf(`"%v"`, t.Name)
+	}
+
+	return fmt.Sprint(index + 1)
+}
+
+func (t Target) String() string {
+	if t.Name != "" {
+		return t.Name
+	}
+
+	return t.Path
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/leotaku/kojirou/cmd/formats/download"
+	md "github.com/leotaku/kojirou/mangadex"
+)
+
+// Covers used for volumes without a cover of their own
+const (
+	coverFallbackNone      = "none"
+	coverFallbackPrevious  = "previous"
+	coverFallbackMain      = "main"
+	coverFallbackFirstPage = "first-page"
+)
+
+func checkCoverFallback(fallback string) error {
+	switch fallback {
+	case coverFallbackNone, coverFallbackPrevious, coverFallbackMain, coverFallbackFirstPage:
+		return nil
+	default:
+		return formats.Errorf(`not a valid fallback: "%v"`, fallback)
+	}
+}
+
+// applyCoverFallback gives volumes without a cover the cover of the
+// nearest previous volume or the main cover of the series, depending
+// on the fallback.  Volumes before the first cover keep the main cover
+// when falling back to previous volumes.  First pages are only known
+// once pages are downloaded, so they are used in volumeToMOBI instead.
+func applyCoverFallback(manga *md.Manga, fallback string) error {
+	if fallback != coverFallbackPrevious && fallback != coverFallbackMain {
+		return nil
+	}
+
+	missing := false
+	for _, volume := range manga.Sorted() {
+		missing = missing || volume.Cover == nil
+	}
+	if !missing {
+		return nil
+	}
+	main, err := download.MangadexMainCover(manga)
+	if err != nil {
+		return formats.Errorf("main cover: %w", err)
+	}
+
+	previous := main
+	for _, volume := range manga.Sorted() {
+		if volume.Cover == nil {
+			volume.Cover = previous
+			if fallback == coverFallbackMain {
+				volume.Cover = main
+			}
+			manga.Volumes[volume.Info.Identifier] = volume
+		} else if fallback == coverFallbackPrevious {
+			previous = volume.Cover
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/leotaku/kojirou/cmd/cache"
+	"github.com/leotaku/kojirou/cmd/formats"
+	md "github.com/leotaku/kojirou/mangadex"
+)
+
+// Pages appearing identically in this many chapters of a volume are
+// considered credits of the scantlation group
+const creditMinChapters = 3
+
+// dropCredits removes pages that appear identically in many chapters
+// of the volume, or whose hash is known, as happens with credit and
+// recruitment pages of scantlation groups.  The last page of a chapter
+// is always kept, so no chapter is left empty.
+func dropCredits(pages md.ImageList, known map[string]bool) (md.ImageList, []formats.CreditPage) {
+	hashes := make([]string, len(pages))
+	chapters := make(map[string]map[md.Identifier]bool)
+	remaining := make(map[md.Identifier]int)
+	for i, page := range pages {
+		hashes[i] = cache.Hash(page.Image)
+		if chapters[hashes[i]] == nil {
+			chapters[hashes[i]] = make(map[md.Identifier]bool)
+		}
+		chapters[hashes[i]][page.ChapterIdentifier] = true
+		remaining[page.ChapterIdentifier]++
+	}
+
+	result := make(md.ImageList, 0, len(pages))
+	dropped := make([]formats.CreditPage, 0)
+	for i, page := range pages {
+		credit := known[hashes[i]] || len(chapters[hashes[i]]) >= creditMinChapters
+		if !credit || remaining[page.ChapterIdentifier] == 1 {
+			result = append(result, page)
+			continue
+		}
+		remaining[page.ChapterIdentifier]--
+		dropped = append(dropped, formats.CreditPage{
+			Chapter: page.ChapterIdentifier,
+			Page:    page.ImageIdentifier + 1,
+			Hash:    hashes[i],
+		})
+	}
+
+	return result, dropped
+}
+
+// readCreditHashes reads the hashes of credit pages, one per line, as
+// reported for removed pages.  Empty lines and lines starting with "#"
+// are ignored.
+func readCreditHashes(pathname string) (map[string]bool, error) {
+	f, err := os.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hashes := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		} else if len(text) != 32 || strings.Trim(text, "0123456789abcdef") != "" {
+			return nil, formats.Errorf("line %v: not a valid hash: %q", line, text)
+		}
+		hashes[text] = true
+	}
+
+	return hashes, scanner.Err()
+}
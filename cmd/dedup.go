@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"image"
+	"math/bits"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	md "github.com/leotaku/kojirou/mangadex"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// Pages are compared by the brightness gradients of a grid of this
+	// size, which survive resizing and re-encoding
+	dedupGrid = 16
+	// Largest number of differing gradients of duplicate pages
+	dedupMaxDistance = 20
+	// Smallest difference in brightness, of 255, between the darkest
+	// and brightest cell of the grid, so that blank pages are kept
+	dedupMinContrast = 24
+	// Largest difference in aspect ratio of duplicate pages
+	dedupAspectTolerance = 0.02
+)
+
+// pageFingerprint is a perceptual hash of a page, where every bit
+// tells whether a cell of the grid is brighter than its right
+// neighbor.
+type pageFingerprint struct {
+	bits   [dedupGrid * dedupGrid / 64]uint64
+	aspect float64
+}
+
+func (f pageFingerprint) distance(other pageFingerprint) int {
+	result := 0
+	for i := range f.bits {
+		result += bits.OnesCount64(f.bits[i] ^ other.bits[i])
+	}
+
+	return result
+}
+
+func (f pageFingerprint) similar(other pageFingerprint) bool {
+	diff := f.aspect - other.aspect
+	if diff < 0 {
+		diff = -diff
+	}
+
+	return diff <= dedupAspectTolerance*f.aspect && f.distance(other) <= dedupMaxDistance
+}
+
+// dedupPages removes pages that look like an earlier page of the
+// volume, even when they were resized or encoded differently, as
+// happens when overlapping chapters are uploaded or the same page is
+// both downloaded and read from disk.  The last page of a chapter is
+// always kept, so no chapter is left empty.
+func dedupPages(pages md.ImageList) (md.ImageList, int) {
+	remaining := make(map[md.Identifier]int)
+	for _, page := range pages {
+		remaining[page.ChapterIdentifier]++
+	}
+
+	result := make(md.ImageList, 0, len(pages))
+	kept := make([]pageFingerprint, 0, len(pages))
+	removed := 0
+	for _, page := range pages {
+		fingerprint, ok := fingerprintPage(formats.Decoded(page.Image))
+		if !ok {
+			result = append(result, page)
+			continue
+		}
+		duplicate := false
+		for _, other := range kept {
+			if fingerprint.similar(other) {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate && remaining[page.ChapterIdentifier] > 1 {
+			remaining[page.ChapterIdentifier]--
+			removed++
+			continue
+		}
+		kept = append(kept, fingerprint)
+		result = append(result, page)
+	}
+
+	return result, removed
+}
+
+// fingerprintPage returns the fingerprint of the page, unless the page
+// is almost uniform, e.g. blank.
+func fingerprintPage(img image.Image) (pageFingerprint, bool) {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return pageFingerprint{}, false
+	}
+
+	// Scaling to a multiple of the grid and averaging cells removes
+	// screen tones, which would otherwise dominate the gradients
+	const scale = 8
+	small := image.NewGray(image.Rect(0, 0, (dedupGrid+1)*scale, dedupGrid*scale))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, bounds, draw.Src, nil)
+	cells := [dedupGrid][dedupGrid + 1]int{}
+	for y := 0; y < dedupGrid*scale; y++ {
+		for x := 0; x < (dedupGrid+1)*scale; x++ {
+			cells[y/scale][x/scale] += int(small.Pix[y*small.Stride+x])
+		}
+	}
+
+	low, high := cells[0][0], cells[0][0]
+	result := pageFingerprint{aspect: float64(bounds.Dx()) / float64(bounds.Dy())}
+	for y := range cells {
+		for x := 0; x < dedupGrid; x++ {
+			if cells[y][x] > cells[y][x+1] {
+				i := y*dedupGrid + x
+				result.bits[i/64] |= 1 << (i % 64)
+			}
+		}
+		for _, v := range cells[y] {
+			if v < low {
+				low = v
+			} else if v > high {
+				high = v
+			}
+		}
+	}
+
+	return result, (high-low)/(scale*scale) >= dedupMinContrast
+}
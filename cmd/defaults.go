@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"github.com/leotaku/kojirou/cmd/config"
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/leotaku/kojirou/cmd/formats/kindle"
+	"github.com/spf13/pflag"
+)
+
+var kindleGrayscale = true
+
+// Built-in settings by format, which the configuration file overrides.
+// Most Kindle devices cannot show color, so pages of their formats are
+// converted to grayscale.
+var formatDefaults = map[string]config.FormatDefaults{
+	kindle.FormatAZW3: {Grayscale: &kindleGrayscale},
+	kindle.FormatKFX:  {Grayscale: &kindleGrayscale},
+}
+
+// encodingSettings describe how books of an output are encoded.
+type encodingSettings struct {
+	jpegQuality  int
+	pageCodec    string
+	codecQuality int
+	grayscale    bool
+	keepColor    bool
+	colorQuality int
+}
+
+// encodingFor returns the settings for books in the format.  Options
+// given explicitly take precedence over the settings of the format in
+// the configuration file, which take precedence over the built-in
+// settings of the format.
+func encodingFor(format string, flags *pflag.FlagSet) encodingSettings {
+	if format == "" {
+		format = kindle.FormatAZW3
+	}
+
+	result := encodingSettings{jpegQualityArg, pageCodecArg, codecQualityArg, false, keepColorArg, colorQualityArg}
+	for _, defaults := range []config.FormatDefaults{formatDefaults[format], cfg.Formats[format]} {
+		if defaults.JPEGQuality != nil && !flags.Changed("jpeg-quality") {
+			result.jpegQuality = *defaults.JPEGQuality
+		}
+		if defaults.PageCodec != nil && !flags.Changed("page-codec") {
+			result.pageCodec = *defaults.PageCodec
+		}
+		if defaults.CodecQuality != nil && !flags.Changed("codec-quality") {
+			result.codecQuality = *defaults.CodecQuality
+		}
+		if defaults.Grayscale != nil {
+			result.grayscale = *defaults.Grayscale
+		}
+	}
+
+	return result
+}
+
+// checkFormatDefaults ensures the settings of formats in the
+// configuration file can be used for their format.
+func checkFormatDefaults() error {
+	for format, defaults := range cfg.Formats {
+		if err := checkFormat(format, false); err != nil {
+			return formats.Errorf(`format "%v": %w`, format, err)
+		} else if defaults.PageCodec == nil {
+			continue
+		} else if err := checkCodec(*defaults.PageCodec, format); err != nil {
+			return formats.Errorf(`format "%v": page codec: %w`, format, err)
+		}
+	}
+
+	return nil
+}
+
+func (s encodingSettings) apply(dir kindle.NormalizedDirectory) kindle.NormalizedDirectory {
+	return dir.
+		WithQuality(s.jpegQuality).
+		WithCodec(s.pageCodec, s.codecQuality, encodePage).
+		WithGrayscale(s.grayscale).
+		WithColorPages(s.keepColor, s.colorQuality)
+}
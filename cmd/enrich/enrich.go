@@ -0,0 +1,189 @@
+package enrich
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	md "github.com/leotaku/kojirou/mangadex"
+)
+
+// DefaultProviders are used when no providers are configured.  Both
+// look up manga by the identifiers linked on MangaDex, so they never
+// return metadata for the wrong manga.
+var DefaultProviders = []string{"anilist", "mal"}
+
+const cacheMaxAge = 7 * 24 * time.Hour
+
+// Metadata holds information about a manga from another site.  Empty
+// fields are unknown to the site.
+type Metadata struct {
+	Description string    `json:"description,omitempty"`
+	Publisher   string    `json:"publisher,omitempty"`
+	Started     time.Time `json:"started,omitempty"`
+	Ended       time.Time `json:"ended,omitempty"`
+}
+
+// Provider looks up metadata on a single site.  Manga that are unknown
+// to the site result in empty metadata rather than an error.
+type Provider interface {
+	Lookup(ctx context.Context, client *http.Client, info md.MangaInfo) (Metadata, error)
+}
+
+var providers = map[string]Provider{
+	"anilist":   aniList{},
+	"mal":       myAnimeList{},
+	"wikipedia": wikipedia{},
+}
+
+// Enricher merges metadata from multiple providers, preferring the
+// providers given first.
+type Enricher struct {
+	names     []string
+	providers []Provider
+	client    *http.Client
+	cacheDir  string
+}
+
+func New(names []string) (*Enricher, error) {
+	e := &Enricher{
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, name := range names {
+		provider, ok := providers[name]
+		if !ok {
+			return nil, formats.Errorf(`not a supported provider: "%v"`, name)
+		}
+		e.names = append(e.names, name)
+		e.providers = append(e.providers, provider)
+	}
+
+	return e, nil
+}
+
+// WithCacheDirectory makes the enricher reuse metadata stored in the
+// directory for up to a week, instead of looking it up every time.
+func (e *Enricher) WithCacheDirectory(directory string) *Enricher {
+	e.cacheDir = directory
+	return e
+}
+
+// WithTransport makes the enricher send requests using the given
+// round tripper, e.g. to identify the client.
+func (e *Enricher) WithTransport(transport http.RoundTripper) *Enricher {
+	e.client.Transport = transport
+	return e
+}
+
+// Enrich fills the metadata of the manga with values from all
+// providers.  Descriptions from providers replace the one from
+// MangaDex.  Failing providers are skipped with a warning.
+func (e *Enricher) Enrich(ctx context.Context, info md.MangaInfo) md.MangaInfo {
+	merged := Metadata{}
+	for i, provider := range e.providers {
+		meta, err := e.lookup(ctx, e.names[i], provider, info)
+		if err != nil {
+			formats.Warn("metadata: %v: %v", e.names[i], err)
+			continue
+		}
+		merged = merge(merged, meta)
+	}
+	merged = merge(merged, Metadata{
+		Description: info.Description,
+		Publisher:   info.Publisher,
+		Started:     info.Started,
+		Ended:       info.Ended,
+	})
+
+	info.Description = merged.Description
+	info.Publisher = merged.Publisher
+	info.Started = merged.Started
+	info.Ended = merged.Ended
+
+	return info
+}
+
+func (e *Enricher) lookup(ctx context.Context, name string, provider Provider, info md.MangaInfo) (Metadata, error) {
+	pathname := ""
+	if e.cacheDir != "" && info.ID != "" {
+		pathname = filepath.Join(e.cacheDir, name, info.ID+".json")
+		if meta, ok := readCache(pathname); ok {
+			return meta, nil
+		}
+	}
+
+	meta, err := provider.Lookup(ctx, e.client, info)
+	if err != nil {
+		return meta, err
+	}
+	if pathname != "" {
+		if err := writeCache(pathname, meta); err != nil {
+			formats.Warn("metadata: %v: cache: %v", name, err)
+		}
+	}
+
+	return meta, nil
+}
+
+type cacheEntry struct {
+	Fetched  time.Time `json:"fetched"`
+	Metadata Metadata  `json:"metadata"`
+}
+
+func readCache(pathname string) (Metadata, bool) {
+	data, err := os.ReadFile(pathname)
+	if err != nil {
+		return Metadata{}, false
+	}
+	entry := cacheEntry{}
+	if err := json.Unmarshal(data, &entry); err != nil || time.Since(entry.Fetched) > cacheMaxAge {
+		return Metadata{}, false
+	}
+
+	return entry.Metadata, true
+}
+
+func writeCache(pathname string, meta Metadata) error {
+	data, err := json.Marshal(cacheEntry{time.Now(), meta})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(pathname), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(pathname, data, 0o644)
+}
+
+// merge fills the empty fields of a with the fields of b.
+func merge(a, b Metadata) Metadata {
+	if a.Description == "" {
+		a.Description = b.Description
+	}
+	if a.Publisher == "" {
+		a.Publisher = b.Publisher
+	}
+	if a.Started.IsZero() {
+		a.Started = b.Started
+	}
+	if a.Ended.IsZero() {
+		a.Ended = b.Ended
+	}
+
+	return a
+}
+
+// DefaultCacheDirectory returns the directory metadata is cached in
+// when none is given explicitly.
+func DefaultCacheDirectory() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "kojirou", "metadata")
+}
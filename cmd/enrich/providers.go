@@ -0,0 +1,196 @@
+package enrich
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	md "github.com/leotaku/kojirou/mangadex"
+)
+
+const (
+	aniListURL   = "https://graphql.anilist.co"
+	jikanURL     = "https://api.jikan.moe/v4/manga/"
+	wikipediaURL = "https://en.wikipedia.org/api/rest_v1/page/summary/"
+	aniListQuery = `query ($id: Int) {
+  Media(id: $id, type: MANGA) {
+    description(asHtml: false)
+    startDate { year month day }
+    endDate { year month day }
+  }
+}`
+)
+
+// aniList looks up manga by the AniList identifier linked on MangaDex.
+type aniList struct{}
+
+type aniListDate struct {
+	Year  int
+	Month int
+	Day   int
+}
+
+func (d aniListDate) Time() time.Time {
+	switch {
+	case d.Year == 0:
+		return time.Time{}
+	case d.Month == 0:
+		return time.Date(d.Year, 1, 1, 0, 0, 0, 0, time.UTC)
+	case d.Day == 0:
+		return time.Date(d.Year, time.Month(d.Month), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(d.Year, time.Month(d.Month), d.Day, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+func (aniList) Lookup(ctx context.Context, client *http.Client, info md.MangaInfo) (Metadata, error) {
+	id, err := strconv.Atoi(info.Links["al"])
+	if err != nil {
+		return Metadata{}, nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"query":     aniListQuery,
+		"variables": map[string]int{"id": id},
+	})
+	if err != nil {
+		return Metadata{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", aniListURL, bytes.NewReader(body))
+	if err != nil {
+		return Metadata{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	result := struct {
+		Data struct {
+			Media *struct {
+				Description string
+				StartDate   aniListDate
+				EndDate     aniListDate
+			}
+		}
+	}{}
+	if err := doJSON(client, req, &result); err != nil || result.Data.Media == nil {
+		return Metadata{}, err
+	}
+
+	return Metadata{
+		Description: cleanDescription(result.Data.Media.Description),
+		Started:     result.Data.Media.StartDate.Time(),
+		Ended:       result.Data.Media.EndDate.Time(),
+	}, nil
+}
+
+// myAnimeList looks up manga by the MyAnimeList identifier linked on
+// MangaDex, using the unofficial Jikan API.
+type myAnimeList struct{}
+
+func (myAnimeList) Lookup(ctx context.Context, client *http.Client, info md.MangaInfo) (Metadata, error) {
+	id, ok := info.Links["mal"]
+	if !ok {
+		return Metadata{}, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", jikanURL+url.PathEscape(id), nil)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	result := struct {
+		Data struct {
+			Synopsis  string
+			Published struct {
+				From *time.Time
+				To   *time.Time
+			}
+			Serializations []struct {
+				Name string
+			}
+		}
+	}{}
+	if err := doJSON(client, req, &result); err != nil {
+		return Metadata{}, err
+	}
+
+	meta := Metadata{Description: cleanDescription(result.Data.Synopsis)}
+	if from := result.Data.Published.From; from != nil {
+		meta.Started = *from
+	}
+	if to := result.Data.Published.To; to != nil {
+		meta.Ended = *to
+	}
+	if len(result.Data.Serializations) > 0 {
+		meta.Publisher = result.Data.Serializations[0].Name
+	}
+
+	return meta, nil
+}
+
+// wikipedia looks up the English Wikipedia article with the title of
+// the manga, which might describe a different work of the same name.
+type wikipedia struct{}
+
+func (wikipedia) Lookup(ctx context.Context, client *http.Client, info md.MangaInfo) (Metadata, error) {
+	if info.Title == "" {
+		return Metadata{}, nil
+	}
+
+	title := strings.ReplaceAll(info.Title, " ", "_")
+	req, err := http.NewRequestWithContext(ctx, "GET", wikipediaURL+url.PathEscape(title), nil)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	result := struct {
+		Type    string
+		Extract string
+	}{}
+	if err := doJSON(client, req, &result); err != nil || result.Type != "standard" {
+		return Metadata{}, err
+	}
+
+	return Metadata{Description: cleanDescription(result.Extract)}, nil
+}
+
+// doJSON decodes the response to req into result, leaving result
+// unchanged when the requested resource does not exist.
+func doJSON(client *http.Client, req *http.Request, result interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		io.Copy(io.Discard, resp.Body) //nolint:errcheck
+		return nil
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return formats.Errorf("status: %v", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return formats.Errorf("decode: %w", err)
+	}
+
+	return nil
+}
+
+// cleanDescription removes line break markup and credits such as
+// "(Source: ...)" from descriptions.
+func cleanDescription(description string) string {
+	description = strings.ReplaceAll(description, "<br>", "")
+	if i := strings.LastIndex(description, "(Source:"); i > 0 {
+		description = description[:i]
+	}
+
+	return strings.TrimSpace(description)
+}
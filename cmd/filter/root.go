@@ -3,6 +3,7 @@ package filter
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"time"
 
 	md "github.com/leotaku/kojirou/mangadex"
@@ -36,6 +37,50 @@ func FilterByIdentifier(cl md.ChapterList, field string, ranges Ranges) md.Chapt
 	})
 }
 
+// FilterByBlocked removes chapters by any of the given groups or
+// uploaders.
+func FilterByBlocked(cl md.ChapterList, groups, uploaders []string) md.ChapterList {
+	blocked := make(map[string]bool)
+	for _, id := range append(append([]string{}, groups...), uploaders...) {
+		blocked[id] = true
+	}
+
+	return cl.FilterBy(func(ci md.ChapterInfo) bool {
+		for _, id := range ci.GroupIDs {
+			if blocked[id] {
+				return false
+			}
+		}
+		return !blocked[ci.UploaderID]
+	})
+}
+
+// Teasers pointing to official apps are only this many pages long.
+const teaserPages = 3
+
+// RemoveTeasers removes short chapters that duplicate a release at
+// least three times as long in the same language, like teasers that
+// point readers to official apps.  Chapters without a known number of
+// pages are kept.
+func RemoveTeasers(cl md.ChapterList) md.ChapterList {
+	type release struct {
+		chapter  md.Identifier
+		language language.Tag
+	}
+	longest := make(map[release]int)
+	for _, c := range cl {
+		key := release{c.Info.Identifier, c.Info.Language}
+		if c.Info.Pages > longest[key] {
+			longest[key] = c.Info.Pages
+		}
+	}
+
+	return cl.FilterBy(func(ci md.ChapterInfo) bool {
+		key := release{ci.Identifier, ci.Language}
+		return ci.Pages == 0 || ci.Pages > teaserPages || longest[key] < ci.Pages*3
+	})
+}
+
 func SortByNewest(cl md.ChapterList) md.ChapterList {
 	return cl.SortBy(func(a, b md.ChapterInfo) bool {
 		return a.Published.After(b.Published)
@@ -83,6 +128,14 @@ func SortByMost(cl md.ChapterList) md.ChapterList {
 	})
 }
 
+// SortByScore prefers chapters with higher scores by ID, keeping the
+// order of chapters without a score.
+func SortByScore(cl md.ChapterList, scores map[string]float64) md.ChapterList {
+	return cl.SortBy(func(a, b md.ChapterInfo) bool {
+		return scores[a.ID] > scores[b.ID]
+	})
+}
+
 func RemoveDuplicates(cl md.ChapterList) md.ChapterList {
 	return cl.CollapseBy(func(c md.ChapterInfo) interface{} {
 		return struct {
@@ -95,6 +148,87 @@ func RemoveDuplicates(cl md.ChapterList) md.ChapterList {
 	})
 }
 
+// RemoveDuplicatesByGroup removes duplicate chapters like
+// RemoveDuplicates, but keeps chapters released by different groups.
+func RemoveDuplicatesByGroup(cl md.ChapterList) md.ChapterList {
+	return cl.CollapseBy(func(c md.ChapterInfo) interface{} {
+		return struct {
+			group   string
+			chapter md.Identifier
+			volume  md.Identifier
+		}{
+			gid(c),
+			c.Identifier,
+			c.VolumeIdentifier,
+		}
+	})
+}
+
+// SplitByGroup returns the chapters released by every group, in order
+// of the first chapter of each group.
+func SplitByGroup(cl md.ChapterList) (groups []string, chapters []md.ChapterList) {
+	indices := make(map[string]int)
+	for _, c := range cl {
+		index, ok := indices[gid(c.Info)]
+		if !ok {
+			index = len(groups)
+			indices[gid(c.Info)] = index
+			groups = append(groups, gid(c.Info))
+			chapters = append(chapters, make(md.ChapterList, 0))
+		}
+		chapters[index] = append(chapters[index], c)
+	}
+
+	return groups, chapters
+}
+
+// GroupTrailing moves chapters without a volume that come after all
+// chapters with a volume into the given volume, so they are kept
+// apart from special chapters.  The number of moved chapters is
+// returned as well.
+func GroupTrailing(cl md.ChapterList, volume md.Identifier) (md.ChapterList, int) {
+	unvolumed := md.NewIdentifier("Special")
+	last := md.NewIdentifier("0")
+	for _, c := range cl {
+		id := c.Info.Identifier
+		if !c.Info.VolumeIdentifier.IsSpecial() && !id.IsSpecial() && last.Less(id) {
+			last = id
+		}
+	}
+
+	result, moved := make(md.ChapterList, 0), 0
+	for _, c := range cl {
+		id := c.Info.Identifier
+		if c.Info.VolumeIdentifier.Equal(unvolumed) && !id.IsSpecial() && last.Less(id) {
+			c.Info.VolumeIdentifier = volume
+			moved++
+		}
+		result = append(result, c)
+	}
+
+	return result, moved
+}
+
+// Languages returns all known languages of the given chapters, from
+// the most to the least common.
+func Languages(cl md.ChapterList) []language.Tag {
+	counts := make(map[language.Tag]int)
+	result := make([]language.Tag, 0)
+	for _, c := range cl {
+		if c.Info.Language == language.Und {
+			continue
+		} else if counts[c.Info.Language] == 0 {
+			result = append(result, c.Info.Language)
+		}
+		counts[c.Info.Language] += 1
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return counts[result[i]] > counts[result[j]]
+	})
+
+	return result
+}
+
 func gid(ci md.ChapterInfo) string {
 	return ci.GroupNames.String()
 }
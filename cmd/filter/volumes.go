@@ -0,0 +1,61 @@
+package filter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	md "github.com/leotaku/kojirou/mangadex"
+)
+
+// VolumeMap assigns chapters to volumes, for series whose chapters are
+// not tagged with the volumes they were collected in.
+type VolumeMap []volumeChapters
+
+type volumeChapters struct {
+	volume   md.Identifier
+	chapters Ranges
+}
+
+// ParseVolumeMap reads lines like "3: 17..25,25.5" assigning ranges of
+// chapters, as given to --chapters, to a volume.  Empty lines and lines
+// starting with "#" are ignored.
+func ParseVolumeMap(r io.Reader) (VolumeMap, error) {
+	result := make(VolumeMap, 0)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		volume, chapters, ok := strings.Cut(text, ":")
+		volume, chapters = strings.TrimSpace(volume), strings.ReplaceAll(chapters, " ", "")
+		if !ok || volume == "" || chapters == "" {
+			return nil, fmt.Errorf("line %v: malformed: %q", line, text)
+		}
+		result = append(result, volumeChapters{
+			volume:   md.NewIdentifier(volume),
+			chapters: ParseRanges(chapters),
+		})
+	}
+
+	return result, scanner.Err()
+}
+
+// Apply moves chapters to the first volume whose ranges contain them,
+// replacing their volumes on MangaDex.  Other chapters are unchanged.
+func (m VolumeMap) Apply(cl md.ChapterList) md.ChapterList {
+	result := make(md.ChapterList, 0, len(cl))
+	for _, c := range cl {
+		for _, vc := range m {
+			if vc.chapters.Contains(c.Info.Identifier) {
+				c.Info.VolumeIdentifier = vc.volume
+				break
+			}
+		}
+		result = append(result, c)
+	}
+
+	return result
+}
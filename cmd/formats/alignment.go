@@ -0,0 +1,105 @@
+package formats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	md "github.com/leotaku/kojirou/mangadex"
+	"golang.org/x/text/language"
+)
+
+// PrintAlignment lists the chapter numbers that are missing in some of
+// the given languages, as well as chapters that are split differently
+// between languages, e.g. 5 in one language but 5.1 and 5.2 in another.
+func PrintAlignment(languages []language.Tag, lists []md.ChapterList) {
+	// Numbers of every language, grouped by their whole chapter number
+	numbers := make([]map[md.Identifier][]md.Identifier, len(lists))
+	wholes := make([]md.Identifier, 0)
+	seen := make(map[md.Identifier]bool)
+	for i, chapters := range lists {
+		numbers[i] = make(map[md.Identifier][]md.Identifier)
+		for _, chapter := range chapters {
+			id := chapter.Info.Identifier
+			if id.IsSpecial() || contains(numbers[i][id.Whole()], id) {
+				continue
+			}
+			numbers[i][id.Whole()] = append(numbers[i][id.Whole()], id)
+			if !seen[id.Whole()] {
+				seen[id.Whole()] = true
+				wholes = append(wholes, id.Whole())
+			}
+		}
+	}
+	sort.Slice(wholes, func(i, j int) bool { return wholes[i].Less(wholes[j]) })
+
+	missing := make([][]string, len(lists))
+	ambiguous := make([]string, 0)
+	for _, whole := range wholes {
+		parts := make([]string, 0)
+		for i := range lists {
+			ids := numbers[i][whole]
+			if len(ids) == 0 {
+				missing[i] = append(missing[i], whole.String())
+				continue
+			}
+			sort.Slice(ids, func(a, b int) bool { return ids[a].Less(ids[b]) })
+			parts = append(parts, fmt.Sprintf("%v: %v", languages[i], formatIdentifiers(ids)))
+		}
+		if len(parts) > 1 && !sameSplit(numbers, whole) {
+			ambiguous = append(ambiguous, fmt.Sprintf("%v (%v)", whole, strings.Join(parts, "; ")))
+		}
+	}
+
+	for i, lang := range languages {
+		if len(missing[i]) > 0 {
+			printStyledValue(warningLabelColor, fmt.Sprintf(Translate("Missing [%v]"), lang), strings.Join(missing[i], ", "))
+		}
+	}
+	if len(ambiguous) > 0 {
+		printStyledValue(warningLabelColor, "Ambiguous", strings.Join(ambiguous, ", "))
+	}
+}
+
+// sameSplit reports whether all languages that have parts of the
+// chapter have exactly the same parts.
+func sameSplit(numbers []map[md.Identifier][]md.Identifier, whole md.Identifier) bool {
+	var first []md.Identifier
+	for _, byWhole := range numbers {
+		ids := byWhole[whole]
+		if len(ids) == 0 {
+			continue
+		} else if first == nil {
+			first = ids
+		} else if len(ids) != len(first) {
+			return false
+		} else {
+			for i := range ids {
+				if !ids[i].Equal(first[i]) {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+func formatIdentifiers(ids []md.Identifier) string {
+	result := make([]string, 0)
+	for _, id := range ids {
+		result = append(result, id.String())
+	}
+
+	return strings.Join(result, ", ")
+}
+
+func contains(ids []md.Identifier, id md.Identifier) bool {
+	for _, other := range ids {
+		if other.Equal(id) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,65 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// The dimensions of AVIF images are stored in a box near the start of
+// the file, which is searched for in at most this many bytes
+const avifHeaderLimit = 1 << 16
+
+// decodeAVIF converts AVIF images to PNG, if set.  There is no decoder
+// for AVIF in the standard library, so images are decoded by external
+// programs.
+var decodeAVIF func(data []byte) ([]byte, error)
+
+// SetAVIFDecoder makes AVIF images be decoded by the function, which
+// receives the AVIF image and should return it as PNG.
+func SetAVIFDecoder(decode func(data []byte) ([]byte, error)) {
+	decodeAVIF = decode
+}
+
+func init() {
+	image.RegisterFormat("avif", "????ftypavif", decodeAVIFImage, decodeAVIFConfig)
+	image.RegisterFormat("avif", "????ftypavis", decodeAVIFImage, decodeAVIFConfig)
+}
+
+func decodeAVIFImage(r io.Reader) (image.Image, error) {
+	if decodeAVIF == nil {
+		return nil, Errorf("avif: no decoder")
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := decodeAVIF(data)
+	if err != nil {
+		return nil, Errorf("avif: %w", err)
+	}
+
+	return png.Decode(bytes.NewReader(decoded))
+}
+
+// decodeAVIFConfig reads the dimensions of the image from its image
+// spatial extents property.
+func decodeAVIFConfig(r io.Reader) (image.Config, error) {
+	header, err := io.ReadAll(io.LimitReader(r, avifHeaderLimit))
+	if err != nil {
+		return image.Config{}, err
+	}
+	i := bytes.Index(header, []byte("ispe"))
+	if i < 0 || i+16 > len(header) {
+		return image.Config{}, Errorf("avif: no dimensions")
+	}
+
+	return image.Config{
+		ColorModel: color.RGBAModel,
+		Width:      int(binary.BigEndian.Uint32(header[i+8:])),
+		Height:     int(binary.BigEndian.Uint32(header[i+12:])),
+	}, nil
+}
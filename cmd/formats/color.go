@@ -0,0 +1,86 @@
+package formats
+
+import (
+	"github.com/fatih/color"
+)
+
+type ColorMode int
+
+const (
+	ColorModeAuto ColorMode = iota
+	ColorModeAlways
+	ColorModeNever
+)
+
+func (m *ColorMode) String() string {
+	switch *m {
+	case ColorModeAuto:
+		return "auto"
+	case ColorModeAlways:
+		return "always"
+	case ColorModeNever:
+		return "never"
+	default:
+		panic("unreachable")
+	}
+}
+
+// Set must have pointer receiver so it doesn't change the value of a copy
+func (m *ColorMode) Set(v string) error {
+	switch v {
+	case "auto":
+		*m = ColorModeAuto
+	case "always":
+		*m = ColorModeAlways
+	case "never":
+		*m = ColorModeNever
+	default:
+		return Errorf(`must be one of: "auto", "always", or "never"`)
+	}
+
+	return nil
+}
+
+// Type is only used in help text
+func (m *ColorMode) Type() string {
+	return "color mode"
+}
+
+// SetColorMode decides whether output is colored.  In automatic mode,
+// output is colored when writing to a terminal and the NO_COLOR
+// environment variable is unset.
+func SetColorMode(mode ColorMode) {
+	switch mode {
+	case ColorModeAlways:
+		color.NoColor = false
+		// Colors created while NO_COLOR is set are disabled individually
+		for _, c := range append(groupColors, themeColors()...) {
+			c.EnableColor()
+		}
+	case ColorModeNever:
+		color.NoColor = true
+	}
+}
+
+var (
+	labelColor        = color.New(color.Underline)
+	warningLabelColor = color.New(color.Underline, color.FgYellow)
+	errorColor        = color.New(color.FgRed)
+	warningColor      = color.New(color.FgYellow)
+	successColor      = color.New(color.FgGreen)
+)
+
+func themeColors() []*color.Color {
+	return []*color.Color{labelColor, warningLabelColor, errorColor, warningColor, successColor}
+}
+
+// statusColors maps progress messages to their color in the theme.
+var statusColors = map[string]*color.Color{
+	"Error":    errorColor,
+	"Mismatch": errorColor,
+	"Skipped":  warningColor,
+}
+
+func ErrorPrefix() string {
+	return errorColor.Sprint(Translate("Error:"))
+}
@@ -0,0 +1,70 @@
+package formats
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+)
+
+// MaxPixels limits the dimensions of decoded images, as crafted images
+// can make decoders allocate huge amounts of memory.  Zero disables
+// the limit.
+var MaxPixels int64
+
+var ErrTooManyPixels = errors.New("too many pixels")
+
+// DecodeImage decodes an image after checking its dimensions against
+// MaxPixels, which only requires reading the image header.
+func DecodeImage(r io.Reader) (image.Image, string, error) {
+	img, format, _, err := decodeImage(r)
+	return img, format, err
+}
+
+// DecodePage decodes an image like DecodeImage, and additionally
+// estimates the quality it was encoded with.  The quality is zero for
+// lossless formats.
+func DecodePage(r io.Reader) (image.Image, int, error) {
+	img, _, quality, err := decodeImage(r)
+	return img, quality, err
+}
+
+// DecodePageConfig reads the dimensions and estimated quality of an
+// image like DecodePage, without decoding its pixels.
+func DecodePageConfig(r io.Reader) (image.Config, int, error) {
+	config, _, quality, err := decodeHeader(r, io.Discard)
+	return config, quality, err
+}
+
+func decodeImage(r io.Reader) (image.Image, string, int, error) {
+	header := bytes.NewBuffer(nil)
+	_, _, quality, err := decodeHeader(r, header)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	img, format, err := image.Decode(io.MultiReader(header, r))
+
+	return img, format, quality, err
+}
+
+// decodeHeader reads the image header, copying all bytes read to the
+// given writer, so decoding can continue from the same reader.
+func decodeHeader(r io.Reader, w io.Writer) (image.Config, string, int, error) {
+	header := bytes.NewBuffer(nil)
+	config, format, err := image.DecodeConfig(io.TeeReader(r, io.MultiWriter(header, w)))
+	if err != nil {
+		return config, "", 0, err
+	}
+	if MaxPixels > 0 && int64(config.Width)*int64(config.Height) > MaxPixels {
+		return config, "", 0, Errorf("%vx%v: %w", config.Width, config.Height, ErrTooManyPixels)
+	}
+
+	// Quantization tables precede the frame header, so they have
+	// already been read at this point
+	quality := 0
+	if format == "jpeg" {
+		quality = jpegQuality(header.Bytes())
+	}
+
+	return config, format, quality, nil
+}
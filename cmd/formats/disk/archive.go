@@ -0,0 +1,186 @@
+package disk
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	md "github.com/leotaku/kojirou/mangadex"
+)
+
+// Archives come from untrusted sources, so the total size of all
+// decompressed pages of a chapter is limited.
+const maxArchiveSize = 1 << 30
+
+var (
+	errArchiveTooLarge = errors.New("decompressed size exceeds limit")
+	errArchiveChanged  = errors.New("archive changed since loading")
+)
+
+func isArchive(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".cbz", ".zip":
+		return true
+	default:
+		return false
+	}
+}
+
+func isPage(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".avif":
+		return true
+	default:
+		return false
+	}
+}
+
+// loadArchive reads all pages of the archive in order, streaming
+// entries instead of extracting them to the filesystem.  Only the
+// headers of pages are decoded while loading, and every page is read
+// from the archive again once its pixels are needed, so that pages of
+// archives only use memory while they are decoded.
+func loadArchive(pathname string, p formats.Progress) (md.ImageList, error) {
+	zr, err := zip.OpenReader(pathname)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	files, err := archivePages(zr.File)
+	if err != nil {
+		return nil, err
+	}
+	indices := make(map[*zip.File]int, len(zr.File))
+	for i, file := range zr.File {
+		indices[file] = i
+	}
+
+	p.Increase(len(files))
+	capped := &cappedReader{n: maxArchiveSize}
+	result := make(md.ImageList, 0, len(files))
+	for _, file := range files {
+		p.Add(1)
+		rc, err := file.Open()
+		if err != nil {
+			return nil, formats.Errorf("open '%v': %w", file.Name, err)
+		}
+		// Entries are read completely, so that the limit applies to
+		// their actual size instead of the size they claim
+		before := capped.n
+		capped.r = rc
+		config, quality, err := formats.DecodePageConfig(capped)
+		_, rest := io.Copy(io.Discard, capped)
+		rc.Close()
+		if rest != nil {
+			return nil, formats.Errorf("read '%v': %w", file.Name, rest)
+		} else if errors.Is(err, formats.ErrTooManyPixels) {
+			formats.Warn("archive '%v': page %v: skipped: %v", pathname, file.Name, err)
+			continue
+		} else if err != nil {
+			return nil, formats.Errorf("decode '%v': %w", file.Name, err)
+		}
+		entry := archiveEntry{pathname, indices[file], file.Name, before - capped.n}
+		name := fmt.Sprintf("archive '%v': page %v", pathname, file.Name)
+		img := formats.NewLazyImage(config, name, entry.open)
+		result = append(result, md.Image{Image: img, Quality: quality})
+	}
+
+	return result, nil
+}
+
+// archiveEntry is a page of an archive that was loaded before.
+type archiveEntry struct {
+	pathname string
+	index    int
+	name     string
+	size     int64
+}
+
+// open opens the archive again and returns the contents of the entry,
+// which may not be larger than when it was loaded.
+func (e archiveEntry) open() (io.ReadCloser, error) {
+	zr, err := zip.OpenReader(e.pathname)
+	if err != nil {
+		return nil, err
+	}
+	if e.index >= len(zr.File) || zr.File[e.index].Name != e.name {
+		zr.Close()
+		return nil, errArchiveChanged
+	}
+	rc, err := zr.File[e.index].Open()
+	if err != nil {
+		zr.Close()
+		return nil, err
+	}
+
+	// One byte more than the entry is allowed, so that reading the
+	// entry to its end is not mistaken for exceeding the limit
+	return &entryReader{&cappedReader{rc, e.size + 1}, rc, zr}, nil
+}
+
+type entryReader struct {
+	io.Reader
+	entry   io.Closer
+	archive io.Closer
+}
+
+func (r *entryReader) Close() error {
+	r.entry.Close()
+	return r.archive.Close()
+}
+
+// archivePages returns all page entries sorted by name.  Archives
+// with entries that would escape the chapter when extracted are
+// rejected entirely, as they are certainly not well-meaning.
+func archivePages(files []*zip.File) ([]*zip.File, error) {
+	result := make([]*zip.File, 0)
+	for _, file := range files {
+		cleaned := path.Clean(file.Name)
+		switch {
+		case path.IsAbs(file.Name),
+			strings.Contains(file.Name, `\`),
+			cleaned == "..",
+			strings.HasPrefix(cleaned, "../"):
+			return nil, formats.Errorf("unsafe entry: '%v'", file.Name)
+		case file.Mode()&fs.ModeSymlink != 0:
+			return nil, formats.Errorf("unsafe entry: '%v': symbolic link", file.Name)
+		case file.Mode().IsDir() || !isPage(file.Name):
+			continue
+		}
+		result = append(result, file)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
+
+// cappedReader fails once more than n bytes have been read in total,
+// which protects against archives with forged entry sizes.
+type cappedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.n <= 0 {
+		return 0, errArchiveTooLarge
+	}
+	if int64(len(p)) > c.n {
+		p = p[:c.n]
+	}
+	n, err := c.r.Read(p)
+	c.n -= int64(n)
+
+	return n, err
+}
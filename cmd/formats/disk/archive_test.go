@@ -0,0 +1,146 @@
+package disk
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type nopProgress struct{}
+
+func (nopProgress) Increase(int)                         {}
+func (nopProgress) Add(int)                              {}
+func (nopProgress) NewProxyWriter(w io.Writer) io.Writer { return w }
+
+// writeArchive returns an archive with an entry for every header, with
+// the name as contents for entries that are neither pages nor
+// directories.
+func writeArchive(t *testing.T, headers ...*zip.FileHeader) []byte {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	zw := zip.NewWriter(buf)
+	for _, header := range headers {
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := []byte(header.Name)
+		if strings.HasSuffix(header.Name, "/") {
+			continue
+		} else if isPage(header.Name) {
+			data = pagePNG(t)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func pagePNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func symlink(name string) *zip.FileHeader {
+	header := &zip.FileHeader{Name: name}
+	header.SetMode(fs.ModeSymlink | 0o777)
+
+	return header
+}
+
+func TestArchivePagesUnsafe(t *testing.T) {
+	tests := map[string]*zip.FileHeader{
+		"traversal":          {Name: "../01.png"},
+		"nested traversal":   {Name: "a/../../01.png"},
+		"parent":             {Name: ".."},
+		"absolute":           {Name: "/etc/01.png"},
+		"backslash":          {Name: `..\01.png`},
+		"symlink":            symlink("01.png"),
+		"symlink to nothing": symlink("link"),
+	}
+	for name, header := range tests {
+		data := writeArchive(t, &zip.FileHeader{Name: "00.png"}, header)
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files, err := archivePages(zr.File); err == nil {
+			t.Errorf("%v: got %v pages, expected an error", name, len(files))
+		}
+	}
+}
+
+func TestArchivePages(t *testing.T) {
+	data := writeArchive(t,
+		&zip.FileHeader{Name: "b/02.png"},
+		&zip.FileHeader{Name: "b/"},
+		&zip.FileHeader{Name: "./01.jpg"},
+		&zip.FileHeader{Name: "credits.txt"},
+		&zip.FileHeader{Name: "a..b/03.png"},
+	)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := archivePages(zr.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	expected := []string{"./01.jpg", "a..b/03.png", "b/02.png"}
+	if len(names) != len(expected) {
+		t.Fatalf("got %q, expected %q", names, expected)
+	}
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Fatalf("got %q, expected %q", names, expected)
+		}
+	}
+}
+
+func TestLoadArchive(t *testing.T) {
+	pathname := filepath.Join(t.TempDir(), "01.cbz")
+	data := writeArchive(t, &zip.FileHeader{Name: "02.png"}, &zip.FileHeader{Name: "01.png", Method: zip.Deflate})
+	if err := os.WriteFile(pathname, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	pages, err := loadArchive(pathname, nopProgress{})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(pages) != 2 {
+		t.Fatalf("got %v pages, expected 2", len(pages))
+	}
+	// Pages are read from the archive again while decoding them, and
+	// pages that cannot be read are replaced by blank pages
+	for i, page := range pages {
+		if gray, _, _, _ := page.Image.At(2, 1).RGBA(); gray>>8 != 200 {
+			t.Errorf("page %v: got gray level %v, expected 200", i, gray>>8)
+		}
+	}
+}
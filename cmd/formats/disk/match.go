@@ -0,0 +1,117 @@
+package disk
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// MinTitleSimilarity is the similarity above which directory names
+// are considered to be the title of a manga.
+const MinTitleSimilarity = 0.85
+
+var (
+	// Tags like "[Group]" or "(Digital)" are not part of titles
+	bracketedRegex = regexp.MustCompile(`\[[^\]]*\]|\([^)]*\)|\{[^}]*\}`)
+	volumeRegex    = regexp.MustCompile(`(?i)(?:^|[^\pL])(?:v|vol|volume)[ ._-]*(\d+(?:\.\d+)?)`)
+	chapterRegex   = regexp.MustCompile(`(?i)(?:^|[^\pL])(?:c|ch|chap|chapter)[ ._-]*(\d+(?:\.\d+)?)`)
+	numberRegex    = regexp.MustCompile(`\d+(?:\.\d+)?`)
+)
+
+// TitleSimilarity compares two titles while ignoring case,
+// punctuation and bracketed tags.  The result ranges from zero for
+// entirely different titles to one for equal titles.
+func TitleSimilarity(a, b string) float64 {
+	ra, rb := []rune(normalizeTitle(a)), []rune(normalizeTitle(b))
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 0
+	}
+
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+// BestTitleSimilarity returns the highest similarity of the name to
+// any of the titles.
+func BestTitleSimilarity(name string, titles []string) float64 {
+	best := 0.0
+	for _, title := range titles {
+		if similarity := TitleSimilarity(name, title); similarity > best {
+			best = similarity
+		}
+	}
+
+	return best
+}
+
+// ParseNumbers extracts volume and chapter numbers from the names of
+// the directories and archives leading to a chapter, such as
+// "Vol. 02/Ch. 011" or "Title v02 c011.cbz".  Without any marked
+// chapter number, the last number of the last name is used.
+func ParseNumbers(names []string) (volume, chapter string) {
+	for _, name := range names {
+		name = bracketedRegex.ReplaceAllString(name, " ")
+		if match := volumeRegex.FindStringSubmatch(name); match != nil {
+			volume = match[1]
+		}
+		if match := chapterRegex.FindStringSubmatch(name); match != nil {
+			chapter = match[1]
+		}
+	}
+	if chapter == "" && len(names) > 0 {
+		last := bracketedRegex.ReplaceAllString(names[len(names)-1], " ")
+		last = volumeRegex.ReplaceAllString(last, " ")
+		if numbers := numberRegex.FindAllString(last, -1); len(numbers) > 0 {
+			chapter = numbers[len(numbers)-1]
+		}
+	}
+
+	return volume, chapter
+}
+
+func normalizeTitle(title string) string {
+	title = bracketedRegex.ReplaceAllString(title, " ")
+	result := strings.Builder{}
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			result.WriteRune(r)
+		}
+	}
+
+	return result.String()
+}
+
+func levenshtein(a, b []rune) int {
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		diagonal := row[0]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			next := minInt(row[j]+1, row[j-1]+1, diagonal+cost)
+			diagonal = row[j]
+			row[j] = next
+		}
+	}
+
+	return row[len(b)]
+}
+
+func minInt(first int, rest ...int) int {
+	for _, n := range rest {
+		if n < first {
+			first = n
+		}
+	}
+
+	return first
+}
@@ -1,24 +1,41 @@
 package disk
 
 import (
+	"archive/zip"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
 	"io/fs"
 	"os"
-	"path"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/leotaku/kojirou/cmd/formats"
 	md "github.com/leotaku/kojirou/mangadex"
+	_ "golang.org/x/image/webp"
+	"golang.org/x/sync/errgroup"
 	"golang.org/x/text/language"
 )
 
+// Files on network mounts are slow to read one at a time, so multiple
+// pages are read at once.
+var maxJobsRead = 4
+
+// SetReadConcurrency sets the number of pages read from disk at once,
+// independent of the number of pages processed at once.
+func SetReadConcurrency(jobs int) {
+	maxJobsRead = jobs
+}
+
 func LoadSkeleton(directory string) (*md.Manga, error) {
 	info := md.MangaInfo{
-		Title: path.Base(directory),
+		Title: filepath.Base(directory),
 	}
 
 	return &md.Manga{
@@ -31,29 +48,45 @@ func LoadChapters(directory string, lang language.Tag, p formats.Progress) (md.C
 	result := make(md.ChapterList, 0)
 	volumes, err := os.ReadDir(directory)
 	if err != nil {
-		return nil, fmt.Errorf("list '%v': %w", directory, err)
+		return nil, formats.Errorf("list '%v': %w", directory, err)
 	}
 	for _, volume := range volumes {
-		if !volume.IsDir() {
+		if !isDir(directory, volume) {
 			continue
 		}
-		chapters, err := os.ReadDir(path.Join(directory, volume.Name()))
+		chapters, err := os.ReadDir(filepath.Join(directory, volume.Name()))
 		if err != nil {
-			return nil, fmt.Errorf("list '%v': %w", directory, err)
+			return nil, formats.Errorf("list '%v': %w", directory, err)
 		}
 		for _, chapter := range chapters {
-			if !chapter.IsDir() {
+			name := chapter.Name()
+			switch {
+			case isDir(filepath.Join(directory, volume.Name()), chapter):
+			case isArchive(name):
+				name = strings.TrimSuffix(name, filepath.Ext(name))
+			default:
 				continue
 			}
 			p.Increase(1)
 			p.Add(1)
 
+			pathname := filepath.Join(directory, volume.Name(), chapter.Name())
+			hash, err := hashChapter(pathname)
+			if err != nil {
+				return nil, formats.Errorf("hash '%v': %w", pathname, err)
+			}
+			pages, err := countPages(pathname)
+			if err != nil {
+				return nil, formats.Errorf("count '%v': %w", pathname, err)
+			}
 			info := md.ChapterInfo{
-				Identifier:       md.NewIdentifier(chapter.Name()),
+				Identifier:       md.NewIdentifier(name),
 				VolumeIdentifier: md.NewIdentifier(volume.Name()),
 				GroupNames:       []string{"Filesystem"},
 				Language:         lang,
-				ID:               path.Join(directory, volume.Name(), chapter.Name()),
+				ID:               pathname,
+				Hash:             hash,
+				Pages:            pages,
 			}
 			result = append(result, md.Chapter{
 				Info:  info,
@@ -65,56 +98,236 @@ func LoadChapters(directory string, lang language.Tag, p formats.Progress) (md.C
 	return result, nil
 }
 
+// hashChapter identifies the contents of a chapter directory or
+// archive, so volumes can be rebuilt once pages are added or replaced.
+// Only names, sizes and modification times are used, so that no page
+// has to be read before it is known whether the chapter is needed.
+func hashChapter(pathname string) (string, error) {
+	hash := fnv.New64a()
+	if isArchive(pathname) {
+		if err := hashFile(hash, pathname); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%016x", hash.Sum64()), nil
+	}
+
+	pages, err := os.ReadDir(pathname)
+	if err != nil {
+		return "", err
+	}
+	for _, page := range pages {
+		if isDir(pathname, page) {
+			continue
+		}
+		fmt.Fprintln(hash, page.Name())
+		if err := hashFile(hash, filepath.Join(pathname, page.Name())); err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("%016x", hash.Sum64()), nil
+}
+
+func countPages(pathname string) (int, error) {
+	if isArchive(pathname) {
+		zr, err := zip.OpenReader(pathname)
+		if err != nil {
+			return 0, err
+		}
+		defer zr.Close()
+		files, err := archivePages(zr.File)
+
+		return len(files), err
+	}
+
+	pages, err := os.ReadDir(pathname)
+	if err != nil {
+		return 0, err
+	}
+	result := 0
+	for _, page := range pages {
+		if !isDir(pathname, page) {
+			result++
+		}
+	}
+
+	return result, nil
+}
+
+func hashFile(w io.Writer, pathname string) error {
+	info, err := os.Stat(pathname)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, info.Size(), info.ModTime().UnixNano())
+
+	return err
+}
+
 func LoadPages(cl md.ChapterList, p formats.Progress) (md.ImageList, error) {
-	result := make(md.ImageList, 0)
-	for _, chap := range cl {
+	// Pages are read concurrently, but kept in the order of chapters
+	chapters := make([][]*md.Image, len(cl))
+	decoded := &decodedFiles{files: make(map[int64][]decodedFile)}
+	eg := new(errgroup.Group)
+	eg.SetLimit(maxJobsRead)
+	for i, chap := range cl {
+		i, chap := i, chap
+		if isArchive(chap.Info.ID) {
+			eg.Go(func() error {
+				images, err := loadArchive(chap.Info.ID, p)
+				if err != nil {
+					return formats.Errorf("archive '%v': %w", chap.Info.Identifier, err)
+				}
+				chapters[i] = make([]*md.Image, len(images))
+				for id, img := range images {
+					img.ImageIdentifier = id
+					img.ChapterIdentifier = chap.Info.Identifier
+					img.VolumeIdentifier = chap.Info.VolumeIdentifier
+					chapters[i][id] = &img
+				}
+				return nil
+			})
+			continue
+		}
+
 		pages, err := os.ReadDir(chap.Info.ID)
 		if err != nil {
-			return nil, fmt.Errorf("list '%v': %w", chap.Info.Identifier, err)
+			eg.Wait() //nolint:errcheck
+			return nil, formats.Errorf("list '%v': %w", chap.Info.Identifier, err)
 		}
 
 		p.Increase(len(pages))
+		chapters[i] = make([]*md.Image, len(pages))
 		for id, page := range pages {
-			p.Add(1)
-
-			f, err := os.Open(path.Join(chap.Info.ID, page.Name()))
-			if err != nil {
-				return nil, err
-			}
-			img, _, err := image.Decode(f)
-			if err != nil {
-				return nil, err
+			id, page := id, page
+			if isDir(chap.Info.ID, page) {
+				p.Add(1)
+				continue
 			}
 
-			result = append(result, md.Image{
-				Image:             img,
-				ImageIdentifier:   id,
-				ChapterIdentifier: chap.Info.Identifier,
-				VolumeIdentifier:  chap.Info.VolumeIdentifier,
+			eg.Go(func() error {
+				defer p.Add(1)
+				file, err := decoded.decode(filepath.Join(chap.Info.ID, page.Name()))
+				if errors.Is(err, formats.ErrTooManyPixels) {
+					formats.Warn("chapter %v: page %v: skipped: %v", chap.Info.Identifier, page.Name(), err)
+					return nil
+				} else if err != nil {
+					return err
+				}
+
+				chapters[i][id] = &md.Image{
+					Image:             file.img,
+					Quality:           file.quality,
+					ImageIdentifier:   id,
+					ChapterIdentifier: chap.Info.Identifier,
+					VolumeIdentifier:  chap.Info.VolumeIdentifier,
+				}
+				return nil
 			})
 		}
 	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	size := 0
+	for _, images := range chapters {
+		size += len(images)
+	}
+	result := make(md.ImageList, 0, size)
+	for _, images := range chapters {
+		for _, img := range images {
+			if img != nil {
+				result = append(result, *img)
+			}
+		}
+	}
 
 	return result, nil
 }
 
+type decodedFile struct {
+	info    os.FileInfo
+	img     image.Image
+	quality int
+}
+
+// decodedFiles remembers decoded images by file size, so files that
+// are linked multiple times are only decoded once.
+type decodedFiles struct {
+	files map[int64][]decodedFile
+	mutex sync.Mutex
+}
+
+func (d *decodedFiles) decode(pathname string) (decodedFile, error) {
+	info, err := os.Stat(pathname)
+	if err != nil {
+		return decodedFile{}, err
+	}
+	d.mutex.Lock()
+	for _, file := range d.files[info.Size()] {
+		if os.SameFile(file.info, info) {
+			d.mutex.Unlock()
+			return file, nil
+		}
+	}
+	d.mutex.Unlock()
+
+	// Pages are only decoded once their pixels are needed, so that
+	// long series do not have to fit into memory
+	f, err := os.Open(pathname)
+	if err != nil {
+		return decodedFile{}, err
+	}
+	defer f.Close()
+	config, quality, err := formats.DecodePageConfig(f)
+	if err != nil {
+		return decodedFile{}, formats.Errorf("decode '%v': %w", pathname, err)
+	}
+	img := formats.NewLazyImage(config, fmt.Sprintf("page '%v'", pathname), func() (io.ReadCloser, error) {
+		return os.Open(pathname)
+	})
+	file := decodedFile{info, img, quality}
+	d.mutex.Lock()
+	d.files[info.Size()] = append(d.files[info.Size()], file)
+	d.mutex.Unlock()
+
+	return file, nil
+}
+
+// isDir reports whether the entry is a directory, following symbolic
+// links so collections can be organized using links.  Links cannot
+// form loops, as only a fixed depth of directories is ever read.
+func isDir(directory string, entry fs.DirEntry) bool {
+	if entry.Type()&fs.ModeSymlink == 0 {
+		return entry.IsDir()
+	}
+	info, err := os.Stat(filepath.Join(directory, entry.Name()))
+
+	return err == nil && info.IsDir()
+}
+
 func LoadCovers(directory string, p formats.Progress) (md.ImageList, error) {
-	result := make(md.ImageList, 0)
 	volumes, err := os.ReadDir(directory)
 	if err != nil {
-		return nil, fmt.Errorf("list '%v': %w", directory, err)
+		return nil, formats.Errorf("list '%v': %w", directory, err)
 	}
+	result := make(md.ImageList, 0, len(volumes))
 	p.Increase(len(volumes))
 	for _, volume := range volumes {
-		if !volume.IsDir() {
+		p.Add(1)
+		if !isDir(directory, volume) {
 			continue
 		}
 
 		img, err := readImage(directory, volume.Name())
 		if errors.Is(err, fs.ErrNotExist) {
 			continue
+		} else if errors.Is(err, formats.ErrTooManyPixels) {
+			formats.Warn("cover for directory '%v': skipped: %v", volume.Name(), err)
+			continue
 		} else if err != nil {
-			return nil, fmt.Errorf("cover for directory '%v': %w", volume.Name(), err)
+			return nil, formats.Errorf("cover for directory '%v': %w", volume.Name(), err)
 		}
 		result = append(result, md.Image{
 			Image:            img,
@@ -126,16 +339,16 @@ func LoadCovers(directory string, p formats.Progress) (md.ImageList, error) {
 }
 
 func readImage(directory, name string) (image.Image, error) {
-	for _, ext := range []string{".jpg", ".jpeg", ".png", ".gif"} {
-		f, err := os.Open(path.Join(directory, name+ext))
+	for _, ext := range []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".avif"} {
+		f, err := os.Open(filepath.Join(directory, name+ext))
 		if errors.Is(err, fs.ErrNotExist) {
 			continue
 		} else if err != nil {
-			return nil, fmt.Errorf("open: %w", err)
+			return nil, formats.Errorf("open: %w", err)
 		} else {
-			img, _, err := image.Decode(f)
+			img, _, err := formats.DecodeImage(f)
 			if err != nil {
-				return nil, fmt.Errorf("decode: %w", err)
+				return nil, formats.Errorf("decode: %w", err)
 			} else {
 				return img, nil
 			}
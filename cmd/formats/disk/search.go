@@ -0,0 +1,169 @@
+package disk
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	md "github.com/leotaku/kojirou/mangadex"
+	"golang.org/x/text/language"
+)
+
+const (
+	// Series directories are expected near the top of collections
+	maxSeriesDepth = 3
+	// Series rarely nest chapters deeper than volume directories
+	maxChapterDepth = 3
+)
+
+// FindSeries returns all directories in the collection whose names
+// match one of the titles or contain the MangaDex identifier of the
+// manga.  Matching directories are not searched any further.
+func FindSeries(directory string, manga md.MangaInfo) ([]string, error) {
+	titles := append([]string{manga.Title}, manga.AltTitles...)
+	result := make([]string, 0)
+	err := walkDirs(directory, maxSeriesDepth, func(pathname string, names []string) bool {
+		name := names[len(names)-1]
+		if (manga.ID != "" && strings.Contains(strings.ToLower(name), manga.ID)) ||
+			BestTitleSimilarity(name, titles) >= MinTitleSimilarity {
+			result = append(result, pathname)
+			return false
+		}
+		return true
+	})
+
+	return result, err
+}
+
+// SearchChapters loads the chapters of the manga from a collection of
+// many series, e.g. an extracted bulk download.  Series are found by
+// FindSeries and numbers are parsed from names by ParseNumbers.
+// Chapters without volume numbers are assigned the volume given by
+// volumes, if any.
+func SearchChapters(
+	directory string,
+	manga md.MangaInfo,
+	volumes map[md.Identifier]md.Identifier,
+	lang language.Tag,
+	p formats.Progress,
+) (md.ChapterList, error) {
+	series, err := FindSeries(directory, manga)
+	if err != nil {
+		return nil, formats.Errorf("search '%v': %w", directory, err)
+	} else if len(series) == 0 {
+		formats.Warn("no series matching '%v' found in '%v'", manga.Title, directory)
+		return nil, nil
+	}
+
+	result := make(md.ChapterList, 0)
+	for _, root := range series {
+		chapters, err := FindChapters(root)
+		if err != nil {
+			return nil, formats.Errorf("search '%v': %w", root, err)
+		}
+		for _, names := range chapters {
+			pathname := filepath.Join(append([]string{root}, names...)...)
+			volume, chapter := ParseNumbers(names)
+			if chapter == "" {
+				formats.Warn("'%v': skipped: no chapter number", pathname)
+				continue
+			}
+			p.Increase(1)
+			p.Add(1)
+
+			hash, err := hashChapter(pathname)
+			if err != nil {
+				return nil, formats.Errorf("hash '%v': %w", pathname, err)
+			}
+			pages, err := countPages(pathname)
+			if err != nil {
+				return nil, formats.Errorf("count '%v': %w", pathname, err)
+			}
+			info := md.ChapterInfo{
+				Identifier:       md.NewIdentifier(chapter),
+				VolumeIdentifier: md.NewWithFallback(volume, "Special"),
+				GroupNames:       []string{"Filesystem"},
+				Language:         lang,
+				ID:               pathname,
+				Hash:             hash,
+				Pages:            pages,
+			}
+			if known, ok := volumes[info.Identifier]; ok && volume == "" {
+				info.VolumeIdentifier = known
+			}
+			result = append(result, md.Chapter{
+				Info:  info,
+				Pages: make(map[int]image.Image, 0),
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// FindChapters returns the names leading to all chapter archives and
+// directories containing pages below the series directory.
+// Directories that also contain other files are skipped, as they are
+// most likely not chapters.
+func FindChapters(directory string) ([][]string, error) {
+	result := make([][]string, 0)
+	scan := func(pathname string, names []string) bool {
+		entries, err := os.ReadDir(pathname)
+		if err != nil {
+			return false
+		}
+		pages, others := 0, 0
+		for _, entry := range entries {
+			switch {
+			case isDir(pathname, entry):
+			case isArchive(entry.Name()):
+				result = append(result, append(append([]string{}, names...), entry.Name()))
+			case isPage(entry.Name()):
+				pages++
+			default:
+				others++
+			}
+		}
+		if pages > 0 && others == 0 {
+			result = append(result, append([]string{}, names...))
+		} else if pages > 0 {
+			formats.Warn("'%v': skipped: contains files other than pages", pathname)
+		}
+		return true
+	}
+
+	scan(directory, nil)
+	err := walkDirs(directory, maxChapterDepth, scan)
+
+	return result, err
+}
+
+// walkDirs calls visit for all directories below the root up to the
+// given depth, with the names leading to them.  Directories are only
+// descended into when visit returns true.
+func walkDirs(root string, depth int, visit func(pathname string, names []string) bool) error {
+	var walk func(pathname string, names []string) error
+	walk = func(pathname string, names []string) error {
+		entries, err := os.ReadDir(pathname)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if !isDir(pathname, entry) || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			child := filepath.Join(pathname, entry.Name())
+			childNames := append(append([]string{}, names...), entry.Name())
+			if visit(child, childNames) && len(childNames) < depth {
+				if err := walk(child, childNames); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	return walk(root, nil)
+}
@@ -1,6 +1,6 @@
 package download
 
-import "fmt"
+import "github.com/leotaku/kojirou/cmd/formats"
 
 type DataSaverPolicy int
 
@@ -33,7 +33,7 @@ func (p *DataSaverPolicy) Set(v string) error {
 	case "fallback":
 		*p = DataSaverPolicyFallback
 	default:
-		return fmt.Errorf(`must be one of: "no", "prefer", or "fallback"`)
+		return formats.Errorf(`must be one of: "no", "prefer", or "fallback"`)
 	}
 
 	return nil
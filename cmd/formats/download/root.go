@@ -3,39 +3,183 @@ package download
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"sort"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/leotaku/kojirou/cmd/formats"
 	md "github.com/leotaku/kojirou/mangadex"
+	"github.com/leotaku/kojirou/mangadex/api"
+	_ "golang.org/x/image/webp"
 	"golang.org/x/sync/errgroup"
 )
 
-const (
+var (
 	maxJobsChapter = 8
 	maxJobsImage   = 16
 )
 
 var (
+	transport      *http.Transport
+	retryClient    *retryablehttp.Client
 	httpClient     *http.Client
 	mangadexClient *md.Client
 )
 
+// ReduceConcurrency halves the number of images downloaded at once.
+func ReduceConcurrency() {
+	if maxJobsImage > 1 {
+		maxJobsImage /= 2
+	}
+}
+
+// stagingDirectory is where downloaded pages are kept until they are
+// needed, or empty if they are kept in memory.
+var stagingDirectory string
+
+// StagePages makes all following downloads write pages to the given
+// directory and decode them only while they are needed, which uses
+// less memory at the cost of reading pages from disk.
+func StagePages(directory string) {
+	stagingDirectory = directory
+}
+
+// SetBaseURL makes all following requests use the given API server
+// instead of MangaDex, e.g. a server started by "kojirou mock-server".
+func SetBaseURL(base url.URL) {
+	mangadexClient = mangadexClient.WithBaseURL(base)
+}
+
+// SetAPIVersion makes all following requests use the given version of
+// the MangaDex API.
+func SetAPIVersion(version string) {
+	mangadexClient = mangadexClient.WithAPIVersion(version)
+}
+
+// SetToken makes all following requests authenticate as the user the
+// given MangaDex access token belongs to.
+func SetToken(token string) {
+	mangadexClient = mangadexClient.WithToken(token)
+}
+
+// IncludeUnpublished makes chapters that are only visible to the
+// authenticated user be included in all following chapter lists.
+func IncludeUnpublished() {
+	mangadexClient = mangadexClient.WithUnpublished(true)
+}
+
+// userAgent identifies the client to servers, as the MangaDex API
+// rules require, or is empty for the default of Go.
+var userAgent string
+
+// SetUserAgent makes all following requests identify the client using
+// the given string.
+func SetUserAgent(agent string) {
+	userAgent = agent
+}
+
+// WithUserAgent returns a round tripper that identifies requests to
+// base using the string given to SetUserAgent, e.g. for requests to
+// other sites.
+func WithUserAgent(base http.RoundTripper) http.RoundTripper {
+	return agentTransport{base}
+}
+
+type agentTransport struct {
+	base http.RoundTripper
+}
+
+func (t agentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if userAgent == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	// Round trippers must not modify the given request
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", userAgent)
+
+	return t.base.RoundTrip(req)
+}
+
+// WrapTransport wraps the transport used by all following requests,
+// e.g. to record or replay responses.
+func WrapTransport(wrap func(http.RoundTripper) http.RoundTripper) {
+	retryClient.HTTPClient.Transport = wrap(retryClient.HTTPClient.Transport)
+}
+
+// IP versions connections can be restricted to
+const (
+	IPVersionAuto = "auto"
+	IPVersion4    = "4"
+	IPVersion6    = "6"
+)
+
+const (
+	// Connections over IPv4 are attempted after this long without a
+	// connection over IPv6, which is shorter than the default, as some
+	// MangaDex@Home nodes have broken IPv6
+	fallbackDelay = 100 * time.Millisecond
+	dialTimeout   = 10 * time.Second
+)
+
+// SetIPVersion makes all following connections use only the given IP
+// version, or both with a quick fallback from IPv6 to IPv4.
+func SetIPVersion(version string) error {
+	network := "tcp"
+	switch version {
+	case IPVersionAuto:
+	case IPVersion4:
+		network = "tcp4"
+	case IPVersion6:
+		network = "tcp6"
+	default:
+		return formats.Errorf(`not a valid IP version: "%v"`, version)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:       dialTimeout,
+		KeepAlive:     30 * time.Second,
+		FallbackDelay: fallbackDelay,
+	}
+	transport.DialContext = func(ctx context.Context, _, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	return nil
+}
+
+// DisableRetryDelay makes failed requests be retried immediately,
+// which is useful when responses do not come from the network.
+func DisableRetryDelay() {
+	retryClient.RetryWaitMin = 0
+	retryClient.RetryWaitMax = 0
+}
+
 func init() {
-	retry := retryablehttp.NewClient()
-	retry.Logger = nil
-	retry.RetryWaitMin = time.Second * 5
-	retry.Backoff = retryablehttp.LinearJitterBackoff
-	retry.CheckRetry = bodyReadableErrorPolicy
-	httpClient = retry.StandardClient()
-	mangadexClient = md.NewClient().WithHTTPClient(httpClient)
+	retryClient = retryablehttp.NewClient()
+	retryClient.Logger = nil
+	retryClient.RetryWaitMin = time.Second * 5
+	retryClient.Backoff = retryablehttp.LinearJitterBackoff
+	retryClient.CheckRetry = bodyReadableErrorPolicy
+	transport = retryClient.HTTPClient.Transport.(*http.Transport)
+	retryClient.HTTPClient.Transport = WithUserAgent(tlsErrors{transport})
+	httpClient = retryClient.StandardClient()
+	mangadexClient = md.NewClient().
+		WithHTTPClient(httpClient).
+		WithSchemaWarnings(func(w api.SchemaWarning) {
+			formats.Warn("%v", w)
+		})
 }
 
 func MangadexSkeleton(mangaID string) (*md.Manga, error) {
@@ -46,6 +190,12 @@ func MangadexChapters(mangaID string) (md.ChapterList, error) {
 	return mangadexClient.FetchChapters(context.TODO(), mangaID)
 }
 
+// MangadexBlocked returns the groups and uploaders blocked by the
+// authenticated user.
+func MangadexBlocked() (*md.Blocked, error) {
+	return mangadexClient.FetchBlocked(context.TODO())
+}
+
 func MangadexCovers(manga *md.Manga, p formats.Progress) (md.ImageList, error) {
 	ctx, cancel := context.WithCancel(context.TODO())
 	defer cancel()
@@ -66,7 +216,7 @@ func MangadexCovers(manga *md.Manga, p formats.Progress) (md.ImageList, error) {
 		close(coverPaths)
 	}()
 
-	coverImages, eg := pathsToImages(coverPaths, ctx, cancel, DataSaverPolicyNo)
+	coverImages, eg := pathsToImages(coverPaths, ctx, cancel, DataSaverPolicyNo, func() { p.Add(1) })
 
 	results := make(md.ImageList, len(covers))
 	for coverImage := range coverImages {
@@ -81,11 +231,37 @@ func MangadexCovers(manga *md.Manga, p formats.Progress) (md.ImageList, error) {
 	}
 }
 
-func MangadexPages(chapterList md.ChapterList, policy DataSaverPolicy, p formats.Progress) (md.ImageList, error) {
+// MangadexMainCover returns the main cover of the manga, which is nil
+// when the manga has none.
+func MangadexMainCover(manga *md.Manga) (image.Image, error) {
+	if manga.Info.CoverID == "" {
+		return nil, nil
+	}
 	ctx, cancel := context.WithCancel(context.TODO())
 	defer cancel()
 
-	eg, ctx := errgroup.WithContext(ctx)
+	path, err := mangadexClient.FetchCover(ctx, manga.Info.ID, manga.Info.CoverID)
+	if err != nil {
+		return nil, err
+	}
+	paths := make(chan md.Path, 1)
+	paths <- *path
+	close(paths)
+
+	images, eg := pathsToImages(paths, ctx, cancel, DataSaverPolicyNo, nil)
+	var result image.Image
+	for img := range images {
+		result = img.Image
+	}
+
+	return result, eg.Wait()
+}
+
+// MangadexPaths returns the paths of all pages of the chapters,
+// sorted by chapter and page.
+func MangadexPaths(chapterList md.ChapterList, p formats.Progress) (md.PathList, error) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
 
 	chapters := make(chan md.Chapter)
 	go func() {
@@ -96,13 +272,41 @@ func MangadexPages(chapterList md.ChapterList, policy DataSaverPolicy, p formats
 		close(chapters)
 	}()
 
-	paths, childEg := chaptersToPaths(chapters, ctx, cancel, p)
-	eg.Go(childEg.Wait)
+	paths, eg := chaptersToPaths(chapters, ctx, cancel, p)
 
-	images, childEg := pathsToImages(paths, ctx, cancel, policy)
-	eg.Go(childEg.Wait)
+	results := make(md.PathList, 0)
+	for path := range paths {
+		results = append(results, path)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].ChapterIdentifier.Equal(results[j].ChapterIdentifier) {
+			return results[i].ImageIdentifier < results[j].ImageIdentifier
+		}
+		return results[i].ChapterIdentifier.Less(results[j].ChapterIdentifier)
+	})
 
-	results := make(md.ImageList, 0)
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	} else {
+		return results, nil
+	}
+}
+
+func MangadexPages(pathList md.PathList, policy DataSaverPolicy, p formats.Progress) (md.ImageList, error) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	paths := make(chan md.Path)
+	go func() {
+		for _, path := range pathList {
+			paths <- path
+		}
+		close(paths)
+	}()
+
+	images, eg := pathsToImages(paths, ctx, cancel, policy, func() { p.Add(1) })
+
+	results := make(md.ImageList, 0, len(pathList))
 	for image := range images {
 		p.Add(1)
 		results = append(results, image)
@@ -115,6 +319,46 @@ func MangadexPages(chapterList md.ChapterList, policy DataSaverPolicy, p formats
 	}
 }
 
+// MangadexSamples downloads the given number of pages spread evenly
+// across the chapter.
+func MangadexSamples(chapter md.Chapter, samples int, policy DataSaverPolicy) (md.ImageList, error) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	paths, err := mangadexClient.FetchPaths(ctx, &chapter)
+	if err != nil {
+		return nil, formats.Errorf("paths: %w", err)
+	}
+	if len(paths) < samples {
+		samples = len(paths)
+	}
+
+	samplePaths := make(chan md.Path)
+	go func() {
+		defer close(samplePaths)
+		for i := 0; i < samples; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case samplePaths <- paths[(2*i+1)*len(paths)/(2*samples)]:
+			}
+		}
+	}()
+
+	sampleImages, eg := pathsToImages(samplePaths, ctx, cancel, policy, nil)
+
+	results := make(md.ImageList, 0, samples)
+	for sampleImage := range sampleImages {
+		results = append(results, sampleImage)
+	}
+
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	} else {
+		return results, nil
+	}
+}
+
 func chaptersToPaths(
 	chapters <-chan md.Chapter,
 	ctx context.Context,
@@ -129,7 +373,7 @@ func chaptersToPaths(
 		for {
 			select {
 			case <-ctx.Done():
-				return fmt.Errorf("canceled")
+				return formats.Errorf("canceled")
 			case chapter, ok := <-chapters:
 				if !ok {
 					return nil
@@ -138,13 +382,13 @@ func chaptersToPaths(
 					paths, err := mangadexClient.FetchPaths(ctx, &chapter)
 					if err != nil {
 						defer cancel()
-						return fmt.Errorf("chapter %v: paths: %w", chapter.Info.Identifier, err)
+						return formats.Errorf("chapter %v: paths: %w", chapter.Info.Identifier, err)
 					} else {
 						p.Add(1)
 						for _, path := range paths {
 							select {
 							case <-ctx.Done():
-								return fmt.Errorf("canceled")
+								return formats.Errorf("canceled")
 							case ch <- path:
 								p.Increase(1)
 							}
@@ -164,11 +408,14 @@ func chaptersToPaths(
 	return ch, eg
 }
 
+// pathsToImages downloads the images of all paths, calling skipped,
+// unless nil, for every image that is left out for being too large.
 func pathsToImages(
 	paths <-chan md.Path,
 	ctx context.Context,
 	cancel context.CancelFunc,
 	policy DataSaverPolicy,
+	skipped func(),
 ) (<-chan md.Image, *errgroup.Group) {
 	ch := make(chan md.Image)
 	eg, ctx := errgroup.WithContext(ctx)
@@ -178,22 +425,28 @@ func pathsToImages(
 		for {
 			select {
 			case <-ctx.Done():
-				return fmt.Errorf("canceled")
+				return formats.Errorf("canceled")
 			case path, ok := <-paths:
 				if !ok {
 					return nil
 				}
 				eg.Go(func() error {
-					img, err := getImageWithPolicy(httpClient, ctx, path, policy)
-					if err != nil {
+					page, err := getImageWithPolicy(httpClient, ctx, path, policy)
+					if errors.Is(err, formats.ErrTooManyPixels) {
+						formats.Warn("chapter %v: image %v: skipped: %v", path.ChapterIdentifier, path.ImageIdentifier, err)
+						if skipped != nil {
+							skipped()
+						}
+						return nil
+					} else if err != nil {
 						defer cancel()
-						return fmt.Errorf("chapter %v: image %v: %w", path.ChapterIdentifier, path.ImageIdentifier, err)
+						return formats.Errorf("chapter %v: image %v: %w", path.ChapterIdentifier, path.ImageIdentifier, err)
 					}
 
 					select {
 					case <-ctx.Done():
-						return fmt.Errorf("canceled")
-					case ch <- path.WithImage(img):
+						return formats.Errorf("canceled")
+					case ch <- page:
 						return nil
 					}
 				})
@@ -209,7 +462,7 @@ func pathsToImages(
 	return ch, eg
 }
 
-func getImageWithPolicy(client *http.Client, ctx context.Context, path md.Path, policy DataSaverPolicy) (image.Image, error) {
+func getImageWithPolicy(client *http.Client, ctx context.Context, path md.Path, policy DataSaverPolicy) (md.Image, error) {
 	resp := new(http.Response)
 	err := error(nil)
 
@@ -221,39 +474,69 @@ func getImageWithPolicy(client *http.Client, ctx context.Context, path md.Path,
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("download: %w", err)
+		return md.Image{}, formats.Errorf("download: %w", err)
 	}
 
-	img, _, err := image.Decode(resp.Body)
+	img, quality, err := decodePage(resp.Body, path)
 	defer resp.Body.Close()
 
 	if err != nil && policy == DataSaverPolicyFallback {
 		return getImageWithPolicy(client, ctx, path, DataSaverPolicyPrefer)
 	} else if err != nil {
-		return nil, fmt.Errorf("decode: %w", err)
+		return md.Image{}, formats.Errorf("decode: %w", err)
 	} else {
-		return img, nil
+		page := path.WithImage(img)
+		page.Quality = quality
+		return page, nil
+	}
+}
+
+func decodePage(r io.Reader, path md.Path) (image.Image, int, error) {
+	if stagingDirectory == "" {
+		return formats.DecodePage(r)
+	}
+
+	f, err := os.CreateTemp(stagingDirectory, "page-*")
+	if err != nil {
+		return nil, 0, formats.Errorf("stage: %w", err)
+	}
+	defer f.Close()
+	config, quality, err := formats.DecodePageConfig(io.TeeReader(r, f))
+	if err != nil {
+		return nil, 0, err
+	} else if _, err := io.Copy(f, r); err != nil {
+		return nil, 0, err
 	}
+	name := fmt.Sprintf("chapter %v: image %v", path.ChapterIdentifier, path.ImageIdentifier)
+	img := formats.NewLazyImage(config, name, func() (io.ReadCloser, error) {
+		return os.Open(f.Name())
+	})
+
+	return img, quality, nil
 }
 
 func getResp(client *http.Client, ctx context.Context, url string) (*http.Response, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("prepare: %w", err)
+		return nil, formats.Errorf("prepare: %w", err)
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("do: %w", err)
+		return nil, formats.Errorf("do: %w", err)
 	}
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("status: %v", resp.Status)
+		return nil, formats.Errorf("status: %v", resp.Status)
 	}
 
 	return resp, nil
 }
 
 func bodyReadableErrorPolicy(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	// Certificates do not become valid by retrying
+	if errors.As(err, new(TLSError)) {
+		return false, err
+	}
 	if retry, err := retryablehttp.DefaultRetryPolicy(ctx, resp, err); retry || err != nil {
 		return retry, err
 	}
@@ -0,0 +1,132 @@
+package download
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+)
+
+// PinnedHosts maps hosts to the base64-encoded SHA-256 hashes of the
+// public keys their certificates may be issued for.
+type PinnedHosts map[string][]string
+
+// ParsePin parses a pin like "api.mangadex.org=BASE64".
+func ParsePin(pin string) (host, hash string, err error) {
+	host, hash, ok := strings.Cut(pin, "=")
+	if !ok || host == "" {
+		return "", "", formats.Errorf(`not a valid pin: "%v"`, pin)
+	}
+	if data, err := base64.StdEncoding.DecodeString(hash); err != nil || len(data) != sha256.Size {
+		return "", "", formats.Errorf(`not a valid SHA-256 hash: "%v"`, hash)
+	}
+
+	return host, hash, nil
+}
+
+// TLSConfig returns the configuration for connections that trust the
+// certificates in roots in addition to those of the system, if any, and
+// only accept certificates of pinned hosts with one of their keys.
+func TLSConfig(roots []*x509.Certificate, pins PinnedHosts) *tls.Config {
+	config := new(tls.Config)
+	if len(roots) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, cert := range roots {
+			pool.AddCert(cert)
+		}
+		config.RootCAs = pool
+	}
+	if len(pins) > 0 {
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPin(state, pins)
+		}
+	}
+
+	return config
+}
+
+// SetTLSConfig makes all following requests use the configuration for
+// secure connections.
+func SetTLSConfig(config *tls.Config) {
+	transport.TLSClientConfig = config
+}
+
+var errPinMismatch = errors.New("no key matches the pinned keys")
+
+// verifyPin ensures that any certificate of the verified chain has a
+// pinned key, so both certificates of the host and of its issuers can
+// be pinned.
+func verifyPin(state tls.ConnectionState, pins PinnedHosts) error {
+	hashes, ok := pins[state.ServerName]
+	if !ok {
+		return nil
+	}
+	for _, chain := range state.VerifiedChains {
+		for _, cert := range chain {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, hash := range hashes {
+				if base64.StdEncoding.EncodeToString(sum[:]) == hash {
+					return nil
+				}
+			}
+		}
+	}
+
+	return errPinMismatch
+}
+
+// TLSError explains why a secure connection to a host failed, as
+// errors of certificate validation are hard to understand.
+type TLSError struct {
+	Host string
+	Hint string
+	Err  error
+}
+
+func (e TLSError) Error() string {
+	return fmt.Sprintf("%v: %v (%v)", e.Host, e.Hint, e.Err)
+}
+
+func (e TLSError) Unwrap() error {
+	return e.Err
+}
+
+// tlsErrors is a round tripper that explains failed certificate
+// validation.
+type tlsErrors struct {
+	base http.RoundTripper
+}
+
+func (t tlsErrors) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		return resp, nil
+	}
+
+	var (
+		unknown  x509.UnknownAuthorityError
+		invalid  x509.CertificateInvalidError
+		hostname x509.HostnameError
+	)
+	switch {
+	case errors.As(err, &unknown):
+		err = TLSError{req.URL.Host, "certificate issued by an untrusted authority, networks intercepting TLS require --ca-file", err}
+	case errors.As(err, &invalid):
+		err = TLSError{req.URL.Host, "certificate is invalid, e.g. expired or not yet valid", err}
+	case errors.As(err, &hostname):
+		err = TLSError{req.URL.Host, "certificate belongs to a different host", err}
+	case errors.Is(err, errPinMismatch):
+		err = TLSError{req.URL.Host, "certificate does not match --pin-cert", err}
+	}
+
+	return nil, err
+}
@@ -0,0 +1,99 @@
+package formats
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+// Events that take longer to send are dropped, so stalled front-ends
+// cannot stall downloads
+const eventTimeout = time.Second
+
+// Event is a machine-readable update for front-ends, so they do not
+// have to parse the output meant for terminals.  Events are sent as
+// one JSON object per line.
+type Event struct {
+	// One of "start", "progress", "done", "cancel" and "warning"
+	Type string `json:"type"`
+	// Identifies the progress, as titles like "Volume" repeat
+	ID      int64  `json:"id,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Current int64  `json:"current,omitempty"`
+	Total   int64  `json:"total,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Identifies the job of events forwarded by "kojirou agent"
+	Job string `json:"job,omitempty"`
+}
+
+var (
+	events      net.Conn
+	eventsMutex sync.Mutex
+	lastEventID int64
+)
+
+// ConnectEvents sends events for all following progress and warnings
+// to the Unix socket at pathname, on which a front-end is listening.
+// The returned function closes the connection.
+func ConnectEvents(pathname string) (func() error, error) {
+	conn, err := net.Dial("unix", pathname)
+	if err != nil {
+		return nil, err
+	}
+
+	eventsMutex.Lock()
+	events = conn
+	eventsMutex.Unlock()
+
+	return func() error {
+		eventsMutex.Lock()
+		defer eventsMutex.Unlock()
+
+		events = nil
+		return conn.Close()
+	}, nil
+}
+
+// emit sends the event, if connected.  Front-ends that disconnect or
+// stall receive no further events.
+func emit(event Event) {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+	if events == nil {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	events.SetWriteDeadline(time.Now().Add(eventTimeout)) //nolint:errcheck
+	if _, err := events.Write(append(data, '\n')); err != nil {
+		events.Close()
+		events = nil
+	}
+}
+
+// emitProgress sends the state of the bar, which is identified by the
+// values set in newBar.
+func emitProgress(kind string, bar *pb.ProgressBar) {
+	id, _ := bar.Get("id").(int64)
+	title, _ := bar.Get("title").(string)
+	message, _ := bar.Get("message").(string)
+	emit(Event{
+		Type:    kind,
+		ID:      id,
+		Title:   title,
+		Current: bar.Current(),
+		Total:   bar.Total(),
+		Message: message,
+	})
+}
+
+func nextEventID() int64 {
+	return atomic.AddInt64(&lastEventID, 1)
+}
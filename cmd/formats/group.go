@@ -0,0 +1,164 @@
+package formats
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/cheggaaa/pb/v3/termutil"
+)
+
+const groupRefreshRate = 200 * time.Millisecond
+
+var (
+	activeGroupMutex sync.Mutex
+	activeGroup      *ProgressGroup
+)
+
+// ProgressGroup renders the progress of concurrent work without
+// garbling the terminal.  While a group is started, all progress
+// created by TitledProgress and VanishingProgress is rendered by the
+// group, either as separate bars or summarized into a single bar.
+type ProgressGroup struct {
+	mutex   sync.Mutex
+	bars    []*pb.ProgressBar
+	summary *pb.ProgressBar
+	lines   int
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// StartProgressGroup starts rendering progress as a group until Stop
+// is called.  Summarized groups render a single bar with the given
+// title, which is also used on consoles that cannot move the cursor.
+func StartProgressGroup(title string, summarize bool) *ProgressGroup {
+	g := &ProgressGroup{
+		bars:    make([]*pb.ProgressBar, 0),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if summarize || legacyConsole {
+		g.summary = startStatic(newBar(title))
+		emitProgress("start", g.summary)
+	}
+
+	activeGroupMutex.Lock()
+	activeGroup = g
+	activeGroupMutex.Unlock()
+	go g.render()
+
+	return g
+}
+
+// Stop renders the final state of all bars and stops the group.
+// Stopping a group again does nothing.
+func (g *ProgressGroup) Stop() {
+	g.once.Do(g.stopOnce)
+}
+
+func (g *ProgressGroup) stopOnce() {
+	activeGroupMutex.Lock()
+	if activeGroup == g {
+		activeGroup = nil
+	}
+	activeGroupMutex.Unlock()
+
+	if g.summary != nil {
+		g.summary.Finish()
+		emitProgress("done", g.summary)
+	}
+	close(g.stop)
+	<-g.stopped
+}
+
+func (g *ProgressGroup) progress(title string, vanishing bool) CliProgress {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.summary != nil {
+		return CliProgress{bar: g.summary, shared: true}
+	}
+	bar := newBar(title)
+	bar.Set(pb.CleanOnFinish, vanishing)
+	g.bars = append(g.bars, startStatic(bar))
+	emitProgress("start", bar)
+
+	return CliProgress{bar: bar}
+}
+
+func (g *ProgressGroup) render() {
+	defer close(g.stopped)
+	for {
+		select {
+		case <-g.stop:
+			g.print(true)
+			return
+		case <-time.After(groupRefreshRate):
+			g.print(false)
+		}
+	}
+}
+
+// print redraws all unfinished bars below the finished bars, which
+// are printed once and then scroll away like regular output.
+func (g *ProgressGroup) print(final bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	width, err := termutil.TerminalWidth()
+	if err != nil {
+		width = 80
+	}
+	if g.summary != nil {
+		// A single line can be redrawn on any console
+		g.summary.SetWidth(width - 1)
+		fmt.Fprintf(os.Stderr, "\r%v", g.summary.String())
+		if final {
+			fmt.Fprintln(os.Stderr)
+		}
+		return
+	}
+
+	out := strings.Builder{}
+	if g.lines > 0 {
+		fmt.Fprintf(&out, "\033[%dA\033[J", g.lines)
+	}
+
+	remaining := make([]*pb.ProgressBar, 0, len(g.bars))
+	live := make([]string, 0, len(g.bars))
+	for _, bar := range g.bars {
+		bar.SetWidth(width - 1)
+		switch {
+		case bar.IsFinished() || final:
+			if !bar.GetBool(pb.CleanOnFinish) {
+				fmt.Fprintf(&out, "\r%v\n", bar.String())
+			}
+		default:
+			remaining = append(remaining, bar)
+			live = append(live, bar.String())
+		}
+	}
+	for _, line := range live {
+		fmt.Fprintf(&out, "\r%v\n", line)
+	}
+
+	g.bars = remaining
+	g.lines = len(live)
+	fmt.Fprint(os.Stderr, out.String())
+}
+
+func currentGroup() *ProgressGroup {
+	activeGroupMutex.Lock()
+	defer activeGroupMutex.Unlock()
+
+	return activeGroup
+}
+
+func startStatic(bar *pb.ProgressBar) *pb.ProgressBar {
+	bar.Set(pb.Static, true)
+	return bar.Start()
+}
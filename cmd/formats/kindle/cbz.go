@@ -0,0 +1,189 @@
+package kindle
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	md "github.com/leotaku/kojirou/mangadex"
+	"github.com/leotaku/mobi"
+	"golang.org/x/text/language"
+)
+
+// comicInfo is the metadata format read by comic servers like Komga
+// and Kavita, see https://anansi-project.github.io/docs/comicinfo.
+type comicInfo struct {
+	XMLName     xml.Name        `xml:"ComicInfo"`
+	Title       string          `xml:"Title,omitempty"`
+	Series      string          `xml:"Series,omitempty"`
+	Number      string          `xml:"Number,omitempty"`
+	Volume      string          `xml:"Volume,omitempty"`
+	Summary     string          `xml:"Summary,omitempty"`
+	Writer      string          `xml:"Writer,omitempty"`
+	Penciller   string          `xml:"Penciller,omitempty"`
+	Translator  string          `xml:"Translator,omitempty"`
+	Publisher   string          `xml:"Publisher,omitempty"`
+	Year        int             `xml:"Year,omitempty"`
+	Genre       string          `xml:"Genre,omitempty"`
+	Tags        string          `xml:"Tags,omitempty"`
+	Web         string          `xml:"Web,omitempty"`
+	LanguageISO string          `xml:"LanguageISO,omitempty"`
+	Manga       string          `xml:"Manga,omitempty"`
+	PageCount   int             `xml:"PageCount"`
+	Notes       string          `xml:"Notes,omitempty"`
+	Pages       []comicInfoPage `xml:"Pages>Page,omitempty"`
+}
+
+type comicInfoPage struct {
+	Image    int    `xml:"Image,attr"`
+	Type     string `xml:"Type,attr,omitempty"`
+	Bookmark string `xml:"Bookmark,attr,omitempty"`
+}
+
+// comicInfo returns the metadata shared by all volumes of the manga,
+// so comic servers group volumes written by different runs correctly.
+func (n *NormalizedDirectory) comicInfo(identifier *md.Identifier) comicInfo {
+	info := comicInfo{
+		Series:    n.title,
+		Summary:   n.info.Description,
+		Writer:    strings.Join(n.info.Authors, ", "),
+		Penciller: strings.Join(n.info.Artists, ", "),
+		Publisher: n.info.Publisher,
+		Manga:     "Yes",
+	}
+	if identifier != nil && !identifier.IsSpecial() {
+		info.Number = identifier.String()
+		info.Volume = identifier.String()
+	}
+	genres, tags := make([]string, 0), make([]string, 0)
+	for _, tag := range n.info.Tags {
+		if tag.Group == "genre" {
+			genres = append(genres, tag.Name)
+		} else {
+			tags = append(tags, tag.Name)
+		}
+	}
+	info.Genre = strings.Join(genres, ", ")
+	info.Tags = strings.Join(tags, ", ")
+	if n.manga != "" {
+		info.Web = fmt.Sprintf("https://mangadex.org/title/%v", n.manga)
+	}
+	if !n.info.Started.IsZero() {
+		info.Year = n.info.Started.Year()
+	}
+	if n.build.Version != "" {
+		info.Notes = fmt.Sprintf("kojirou %v %v", n.build.Version, n.build.Settings)
+	}
+
+	return info
+}
+
+// withBookmarks marks the first page of every chapter with its title,
+// which comic readers show as the table of contents.
+func (c comicInfo) withBookmarks(bookmarks map[int]string) comicInfo {
+	pages := make(map[int]comicInfoPage)
+	for _, page := range c.Pages {
+		pages[page.Image] = page
+	}
+	for image, title := range bookmarks {
+		page := pages[image]
+		page.Image, page.Bookmark = image, title
+		pages[image] = page
+	}
+
+	c.Pages = make([]comicInfoPage, 0, len(pages))
+	for _, page := range pages {
+		c.Pages = append(c.Pages, page)
+	}
+	sort.Slice(c.Pages, func(i, j int) bool {
+		return c.Pages[i].Image < c.Pages[j].Image
+	})
+
+	return c
+}
+
+// encodeCBZ converts the book to a comic archive.  Only pages with
+// images are kept, and the cover is stored as the first page, as most
+// comic readers do not know about separate covers.
+func encodeCBZ(book mobi.Book, info comicInfo, codec pageCodec) ([]byte, error) {
+	images := book.Images
+	info.Title = book.Title
+	if book.Publisher != "" {
+		info.Publisher = book.Publisher
+	}
+	if book.Language != language.Und {
+		info.LanguageISO = book.Language.String()
+	}
+	if len(book.Authors) > 0 {
+		info.Writer = strings.Join(book.Authors, ", ")
+	}
+	if len(book.Contributors) > 0 {
+		info.Translator = strings.Join(book.Contributors, ", ")
+	}
+	if !book.PublishedDate.IsZero() {
+		info.Year = book.PublishedDate.Year()
+	}
+	if book.RightToLeft {
+		info.Manga = "YesAndRightToLeft"
+	}
+	offset := 0
+	if book.CoverImage != nil {
+		images = append(append(images[:0:0], book.CoverImage), images...)
+		info.Pages = []comicInfoPage{{Image: 0, Type: "FrontCover"}}
+		offset = 1
+	}
+	info.PageCount = len(images)
+	info = info.withBookmarks(chapterBookmarks(book, offset))
+
+	encoded, err := codec.encodeAll(images)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	zw := zip.NewWriter(buf)
+	for i, data := range encoded {
+		if err := writeZipImage(zw, fmt.Sprintf("%04d.%v", i, codec.extension()), data); err != nil {
+			return nil, formats.Errorf("image %v: %w", i, err)
+		}
+	}
+	data, err := xml.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeZipFile(zw, "ComicInfo.xml", append([]byte(xml.Header), data...)); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// chapterBookmarks returns the titles of chapters by the page their
+// first image is stored as.  Chapters without images are skipped.
+func chapterBookmarks(book mobi.Book, offset int) map[int]string {
+	bookmarks := make(map[int]string)
+	for _, chapter := range book.Chapters {
+		for _, chunk := range chapter.Chunks {
+			match := embedRegex.FindStringSubmatch(chunk.Body)
+			if match == nil {
+				continue
+			}
+			index, _ := strconv.ParseInt(match[1], 32, 0)
+			if index > 0 && int(index) <= len(book.Images) {
+				// Titles of untitled chapters end with a separator
+				bookmarks[int(index)-1+offset] = strings.TrimSuffix(chapter.Title, ": ")
+			}
+			break
+		}
+	}
+
+	return bookmarks
+}
@@ -0,0 +1,268 @@
+package kindle
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/leotaku/mobi/jfif"
+	"golang.org/x/sync/errgroup"
+)
+
+// Codecs pages of EPUB and CBZ books can be encoded with.  Pages of
+// books in all formats can be encoded losslessly as PNG.
+const (
+	CodecJPEG = "jpeg"
+	CodecPNG  = "png"
+	CodecWebP = "webp"
+	CodecAVIF = "avif"
+)
+
+// optimizeJPEG losslessly shrinks encoded JPEG pages, if set.
+var optimizeJPEG func(data []byte) ([]byte, error)
+
+// SetJPEGOptimizer makes all JPEG pages be passed to the function after
+// encoding, which should shrink them without changing their pixels.
+// Results that are not smaller than the original page are discarded.
+func SetJPEGOptimizer(optimize func(data []byte) ([]byte, error)) {
+	optimizeJPEG = optimize
+}
+
+// JPEGOptions are settings of external JPEG encoders beyond the
+// quality.  The built-in encoder always writes baseline pages with
+// 4:2:0 chroma subsampling, so it only supports the zero value.
+type JPEGOptions struct {
+	// Chroma subsampling, either "4:2:0" or "4:4:4"
+	Subsampling string
+	// Progressive pages and trellis quantization, if not nil
+	Progressive *bool
+	Trellis     *bool
+}
+
+// IsZero reports whether the options keep all defaults of the encoder.
+func (o JPEGOptions) IsZero() bool {
+	return o.Subsampling == "" && o.Progressive == nil && o.Trellis == nil
+}
+
+// encodeJPEGExternal encodes JPEG pages instead of the built-in
+// encoder, if set.
+var encodeJPEGExternal func(img image.Image, quality int, options JPEGOptions) ([]byte, error)
+
+// SetJPEGEncoder makes all JPEG pages be encoded by the function, e.g.
+// by running a faster or more efficient encoder like mozjpeg.  Pages
+// are still passed to the optimizer afterwards.
+func SetJPEGEncoder(encode func(img image.Image, quality int, options JPEGOptions) ([]byte, error)) {
+	encodeJPEGExternal = encode
+}
+
+// PageEncoder encodes a page with a codec other than JPEG.  Zero
+// quality uses the default quality of the encoder.
+type PageEncoder func(img image.Image, codec string, quality int) ([]byte, error)
+
+// pageCodec describes how pages of a single book are encoded.  Color
+// pages keep their colors if keepColor is set, and are encoded with
+// colorQuality, unless zero.
+type pageCodec struct {
+	name         string
+	quality      int
+	jpeg         JPEGOptions
+	grayscale    bool
+	keepColor    bool
+	colorQuality int
+	encode       PageEncoder
+}
+
+func (c pageCodec) extension() string {
+	switch c.name {
+	case CodecPNG:
+		return "png"
+	case CodecWebP:
+		return "webp"
+	case CodecAVIF:
+		return "avif"
+	default:
+		return "jpg"
+	}
+}
+
+func (c pageCodec) mediaType() string {
+	switch c.name {
+	case CodecPNG:
+		return "image/png"
+	case CodecWebP:
+		return "image/webp"
+	case CodecAVIF:
+		return "image/avif"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// encodeAll encodes the images concurrently, as encoders other than
+// JPEG are slow external programs.
+func (c pageCodec) encodeAll(images []image.Image) ([][]byte, error) {
+	result := make([][]byte, len(images))
+	eg := new(errgroup.Group)
+	eg.SetLimit(maxJobsEncode)
+	for i, img := range images {
+		i, img := i, img
+		eg.Go(func() error {
+			data, err := c.encodeImage(img)
+			if err != nil {
+				return formats.Errorf("image %v: %w", i, err)
+			}
+			result[i] = data
+			return nil
+		})
+	}
+
+	return result, eg.Wait()
+}
+
+func (c pageCodec) encodeImage(img image.Image) ([]byte, error) {
+	img = formats.Decoded(img)
+	quality := c.quality
+	if c.keepColor && formats.IsColorPage(img) {
+		if c.colorQuality != 0 {
+			quality = c.colorQuality
+		}
+	} else if c.grayscale {
+		gray, release := toGray(img)
+		defer release()
+		img = gray
+	}
+	if c.name == "" || c.name == CodecJPEG {
+		return encodeJPEG(img, quality, c.jpeg)
+	} else if c.name == CodecPNG {
+		return encodePNG(img)
+	} else if c.encode == nil {
+		return nil, formats.Errorf("%v: no encoder", c.name)
+	}
+
+	data, err := c.encode(img, c.name, quality)
+	if err != nil {
+		return nil, formats.Errorf("%v: %w", c.name, err)
+	} else if len(data) == 0 {
+		return nil, formats.Errorf("%v: empty output", c.name)
+	}
+
+	return data, nil
+}
+
+// encodeJPEG encodes the page like the Kindle conversion tools do and
+// optimizes the result.
+func encodeJPEG(img image.Image, quality int, options JPEGOptions) ([]byte, error) {
+	if encodeJPEGExternal != nil {
+		return encodeJPEGWithExternal(img, quality, options)
+	} else if !options.IsZero() {
+		return nil, formats.Errorf("jpeg: options need an external encoder")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := jfif.Encode(buf, formats.Decoded(img), jpegOptions(quality)); err != nil {
+		return nil, err
+	}
+
+	return optimize(buf.Bytes())
+}
+
+// encodeJPEGWithExternal encodes the page with the external encoder
+// and optimizes the result.  Zero quality is passed as the default
+// quality of the built-in encoder, so pages look the same.
+func encodeJPEGWithExternal(img image.Image, quality int, options JPEGOptions) ([]byte, error) {
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+
+	data, err := encodeJPEGExternal(formats.Decoded(img), quality, options)
+	if err != nil {
+		return nil, formats.Errorf("jpeg: %w", err)
+	} else if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return nil, formats.Errorf("jpeg: not a JPEG image")
+	}
+
+	return optimize(data)
+}
+
+// encodePNG encodes the page losslessly, which keeps screen tones and
+// line art free of artifacts at the cost of larger pages.
+func encodePNG(img image.Image) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := enc.Encode(buf, formats.Decoded(img)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// optimize passes the page to the optimizer, if any.  Metadata is
+// stripped afterwards, as optimizers may copy or add it.
+func optimize(data []byte) ([]byte, error) {
+	if optimizeJPEG == nil {
+		return stripMetadata(data), nil
+	}
+
+	optimized, err := optimizeJPEG(data)
+	if err != nil {
+		return nil, formats.Errorf("optimize: %w", err)
+	} else if len(optimized) == 0 || len(optimized) >= len(data) {
+		return stripMetadata(data), nil
+	} else if !bytes.HasPrefix(optimized, []byte{0xff, 0xd8}) {
+		return nil, formats.Errorf("optimize: not a JPEG image")
+	}
+
+	return stripMetadata(optimized), nil
+}
+
+// stripMetadata removes all application segments except JFIF and Adobe
+// headers, which are required to decode the image, and all comments
+// from the JPEG image.  This removes EXIF and XMP data in particular.
+// Malformed images are returned unchanged.
+func stripMetadata(data []byte) []byte {
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return data
+	}
+
+	result := append(make([]byte, 0, len(data)), data[:2]...)
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return data
+		}
+		marker := data[i+1]
+		if marker == 0xff {
+			// Markers may be preceded by any number of fill bytes
+			i++
+			continue
+		} else if marker == 0xda {
+			// Entropy-coded data follows, which is kept as it is
+			return append(result, data[i:]...)
+		}
+
+		end := i + 2 + (int(data[i+2])<<8 | int(data[i+3]))
+		if end > len(data) {
+			return data
+		}
+		if marker != 0xfe && (marker < 0xe1 || marker > 0xef || marker == 0xee) {
+			result = append(result, data[i:end]...)
+		}
+		i = end
+	}
+
+	return data
+}
+
+// toGray converts the image to 8-bit grayscale, which e-ink screens
+// show just the same while being a third of the size to encode.  The
+// returned function releases the converted pixels once encoded.
+func toGray(img image.Image) (image.Image, func()) {
+	if _, ok := img.(*image.Gray); ok {
+		return img, func() {}
+	}
+	gray := formats.NewGray(img.Bounds())
+	formats.ConvertGray(gray, img)
+
+	return gray, func() { formats.ReleaseGray(gray) }
+}
@@ -0,0 +1,97 @@
+package kindle
+
+import (
+	"fmt"
+	"html/template"
+	"image"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/leotaku/kojirou/mangadex"
+	"github.com/leotaku/mobi"
+	"github.com/leotaku/mobi/records"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	discussionTemplateString = `<section class="discussion">
+<h1>Discussion</h1>
+<p>Talk about {{ .Title }} on MangaDex</p>
+<img src="kindle:embed:{{ .Image }}?mime=image/jpeg" alt="QR code for {{ .URL }}">
+<p><a href="{{ .URL }}">{{ .URL }}</a></p>
+{{- if .Chapters }}
+<h2>Chapter comments</h2>
+<p>
+{{- range $i, $chapter := .Chapters }}{{ if $i }}, {{ end }}<a href="{{ $chapter.URL }}">{{ $chapter.Name }}</a>{{ end -}}
+</p>
+{{- end }}
+</section>`
+	discussionCSS = `
+.discussion {
+    text-align: center;
+}
+
+.discussion img {
+    display: block;
+    width: 60%;
+    margin: 0 auto;
+}`
+	// Side length of the QR code image, large enough to be scanned
+	// from e-ink screens
+	discussionCodeSize = 600
+)
+
+var discussionTemplate = template.Must(template.New("discussion").Parse(discussionTemplateString))
+
+type discussionLink struct {
+	Name string
+	URL  string
+}
+
+// WithDiscussion appends a page with a QR code linking to the page of
+// the manga on MangaDex, followed by links to every chapter, where its
+// comments are found.  Books are returned unchanged for manga loaded
+// from disk.
+func WithDiscussion(book mobi.Book, info mangadex.MangaInfo, chapters mangadex.ChapterList) mobi.Book {
+	if info.ID == "" {
+		return book
+	}
+
+	url := fmt.Sprintf("https://mangadex.org/title/%v", info.ID)
+	code, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		formats.Warn("discussion page: %v", err)
+		return book
+	}
+	// Codes are paletted, which most formats would store as color
+	img := code.Image(discussionCodeSize)
+	gray := image.NewGray(img.Bounds())
+	formats.ConvertGray(gray, img)
+
+	links := make([]discussionLink, 0)
+	for _, chapter := range chapters {
+		// Chapters loaded from disk have no comments
+		if chapter.Info.GroupNames.String() == "Filesystem" {
+			continue
+		}
+		links = append(links, discussionLink{
+			Name: chapter.Info.Identifier.String(),
+			URL:  fmt.Sprintf("https://mangadex.org/chapter/%v", chapter.Info.ID),
+		})
+	}
+
+	book.Images = append(book.Images, gray)
+	page := templateToString(discussionTemplate, struct {
+		Title    string
+		Image    string
+		URL      string
+		Chapters []discussionLink
+	}{info.Title, records.To32(len(book.Images)), url, links})
+
+	book.Chapters = append(book.Chapters, mobi.Chapter{
+		Title:  "Discussion",
+		Chunks: mobi.Chunks(page),
+	})
+	book.CSSFlows = append(book.CSSFlows, discussionCSS)
+
+	return book
+}
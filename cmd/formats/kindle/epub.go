@@ -0,0 +1,307 @@
+package kindle
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	"image/jpeg"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/leotaku/mobi"
+)
+
+const (
+	epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+	epubPageCSS = `
+html, body {
+    margin: 0;
+    padding: 0;
+    width: 100%;
+    height: 100%;
+}
+
+img {
+    width: 100%;
+    height: 100%;
+    object-fit: contain;
+}`
+	// Kobo devices lay out pages inside of these elements
+	kepubPageCSS = `
+#book-columns, #book-inner {
+    width: 100%;
+    height: 100%;
+}`
+	// Used for pages without any image, e.g. content warnings
+	epubDefaultWidth  = 1072
+	epubDefaultHeight = 1448
+)
+
+var (
+	// Pages reference images like "kindle:embed:0001?mime=image/jpeg"
+	embedRegex = regexp.MustCompile(`kindle:embed:([0-9A-V]{4})(\?mime=[a-z/]+)?`)
+	// XHTML requires void elements to be closed
+	voidRegex = regexp.MustCompile(`<(img|br|hr)((?:\s[^>]*?)?)\s*/?>`)
+	// Images are described for screen readers, unless page templates
+	// already do so
+	altRegex = regexp.MustCompile(`\salt=`)
+	// Text and images are marked for Kobo devices to track progress
+	koboRegex = regexp.MustCompile(`<img[^>]*/>|>[^<]*[^<\s][^<]*<`)
+)
+
+// encodeEPUB converts the book to a fixed-layout EPUB 3 file.  Every
+// chunk of the book becomes a single page, so books generated for
+// Kindle devices are laid out the same on other readers.  Books for
+// Kobo devices are marked up as KEPUB, so the native comic reader of
+// the device is used.
+func encodeEPUB(book mobi.Book, build BuildInfo, codec pageCodec, kobo bool) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	zw := zip.NewWriter(buf)
+
+	// The mimetype must come first and be stored without compression
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return nil, err
+	}
+	io.WriteString(w, "application/epub+zip") //nolint:errcheck
+	if err := writeZipFile(zw, "META-INF/container.xml", []byte(epubContainer)); err != nil {
+		return nil, err
+	}
+
+	images := append([]image.Image{}, book.Images...)
+	if book.CoverImage != nil {
+		images = append(images, book.CoverImage)
+	}
+	encoded, err := codec.encodeAll(images)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]string, 0)
+	spine := make([]string, 0)
+	nav := make([]string, 0)
+	for i := range book.Images {
+		name := fmt.Sprintf("images/%04d.%v", i+1, codec.extension())
+		if err := writeZipImage(zw, "OEBPS/"+name, encoded[i]); err != nil {
+			return nil, formats.Errorf("image %v: %w", i+1, err)
+		}
+		items = append(items, fmt.Sprintf(`<item id="image-%04d" href="%v" media-type="%v"/>`, i+1, name, codec.mediaType()))
+	}
+	if book.CoverImage != nil {
+		name := "cover." + codec.extension()
+		if err := writeZipImage(zw, "OEBPS/"+name, encoded[len(encoded)-1]); err != nil {
+			return nil, formats.Errorf("cover: %w", err)
+		}
+		items = append(items, fmt.Sprintf(`<item id="cover" href="%v" media-type="%v" properties="cover-image"/>`, name, codec.mediaType()))
+	}
+
+	page := 0
+	for _, chapter := range book.Chapters {
+		for j, chunk := range chapter.Chunks {
+			page++
+			name := fmt.Sprintf("pages/%04d.xhtml", page)
+			if j == 0 {
+				nav = append(nav, fmt.Sprintf(`<li><a href="%v">%v</a></li>`, name, html.EscapeString(chapter.Title)))
+			}
+			if err := writeZipFile(zw, "OEBPS/"+name, epubPage(book, chapter.Title, chunk.Body, codec, kobo)); err != nil {
+				return nil, err
+			}
+			id := fmt.Sprintf("page-%04d", page)
+			items = append(items, fmt.Sprintf(`<item id="%v" href="%v" media-type="application/xhtml+xml"/>`, id, name))
+			spine = append(spine, fmt.Sprintf(`<itemref idref="%v"/>`, id))
+		}
+	}
+
+	css := strings.Join(append(append([]string{}, book.CSSFlows...), epubPageCSS), "\n")
+	if kobo {
+		css += "\n" + kepubPageCSS
+	}
+	if err := writeZipFile(zw, "OEBPS/style.css", []byte(css)); err != nil {
+		return nil, err
+	}
+	if err := writeZipFile(zw, "OEBPS/nav.xhtml", epubNav(book, nav)); err != nil {
+		return nil, err
+	}
+	if err := writeZipFile(zw, "OEBPS/content.opf", epubPackage(book, build, kobo, items, spine)); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func epubPackage(book mobi.Book, build BuildInfo, kobo bool, items, spine []string) []byte {
+	modified := book.CreatedDate
+	if !build.Time.IsZero() {
+		modified = build.Time
+	}
+	direction := "ltr"
+	if book.RightToLeft {
+		direction = "rtl"
+	}
+
+	b := new(strings.Builder)
+	fmt.Fprintln(b, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(b, `<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid" prefix="rendition: http://www.idpf.org/vocab/rendition/#">`)
+	fmt.Fprintln(b, `<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">`)
+	fmt.Fprintf(b, "<dc:identifier id=\"uid\">urn:kojirou:%v</dc:identifier>\n", encodeASIN(book.UniqueID))
+	fmt.Fprintf(b, "<dc:title>%v</dc:title>\n", html.EscapeString(book.Title))
+	fmt.Fprintf(b, "<dc:language>%v</dc:language>\n", book.Language)
+	for _, author := range book.Authors {
+		fmt.Fprintf(b, "<dc:creator>%v</dc:creator>\n", html.EscapeString(author))
+	}
+	for _, contributor := range book.Contributors {
+		fmt.Fprintf(b, "<dc:contributor>%v</dc:contributor>\n", html.EscapeString(contributor))
+	}
+	if book.Publisher != "" {
+		fmt.Fprintf(b, "<dc:publisher>%v</dc:publisher>\n", html.EscapeString(book.Publisher))
+	}
+	if !book.PublishedDate.IsZero() {
+		fmt.Fprintf(b, "<dc:date>%v</dc:date>\n", book.PublishedDate.Format("2006-01-02"))
+	}
+	if build.Version != "" {
+		fmt.Fprintf(b, "<dc:source>%v</dc:source>\n", html.EscapeString(fmt.Sprintf("kojirou %v %v", build.Version, build.Settings)))
+	}
+	fmt.Fprintf(b, "<meta property=\"dcterms:modified\">%v</meta>\n", modified.UTC().Format(time.RFC3339))
+	fmt.Fprintln(b, `<meta property="schema:accessMode">visual</meta>`)
+	fmt.Fprintln(b, `<meta property="schema:accessModeSufficient">visual</meta>`)
+	fmt.Fprintln(b, `<meta property="schema:accessibilityFeature">tableOfContents</meta>`)
+	fmt.Fprintln(b, `<meta property="schema:accessibilityFeature">readingOrder</meta>`)
+	fmt.Fprintln(b, `<meta property="schema:accessibilityHazard">none</meta>`)
+	fmt.Fprintln(b, `<meta property="schema:accessibilitySummary">Pages are images of comic art without text alternatives beyond their chapter and page.</meta>`)
+	fmt.Fprintln(b, `<meta property="rendition:layout">pre-paginated</meta>`)
+	fmt.Fprintln(b, `<meta property="rendition:spread">landscape</meta>`)
+	if kobo {
+		fmt.Fprintln(b, `<meta property="rendition:orientation">auto</meta>`)
+		fmt.Fprintln(b, `<meta name="book-type" content="comic"/>`)
+	}
+	if book.CoverImage != nil {
+		fmt.Fprintln(b, `<meta name="cover" content="cover"/>`)
+	}
+	fmt.Fprintln(b, `</metadata>`)
+	fmt.Fprintln(b, `<manifest>`)
+	fmt.Fprintln(b, `<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>`)
+	fmt.Fprintln(b, `<item id="style" href="style.css" media-type="text/css"/>`)
+	fmt.Fprintln(b, strings.Join(items, "\n"))
+	fmt.Fprintln(b, `</manifest>`)
+	fmt.Fprintf(b, "<spine page-progression-direction=\"%v\">\n", direction)
+	fmt.Fprintln(b, strings.Join(spine, "\n"))
+	fmt.Fprintln(b, `</spine>`)
+	fmt.Fprintln(b, `</package>`)
+
+	return []byte(b.String())
+}
+
+func epubNav(book mobi.Book, entries []string) []byte {
+	b := new(strings.Builder)
+	fmt.Fprintln(b, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(b, `<!DOCTYPE html>`)
+	fmt.Fprintln(b, `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">`)
+	fmt.Fprintf(b, "<head><title>%v</title></head>\n", html.EscapeString(book.Title))
+	fmt.Fprintln(b, `<body><nav epub:type="toc"><ol>`)
+	fmt.Fprintln(b, strings.Join(entries, "\n"))
+	fmt.Fprintln(b, `</ol></nav></body>`)
+	fmt.Fprintln(b, `</html>`)
+
+	return []byte(b.String())
+}
+
+// epubPage wraps the body of a page generated for Kindle devices in an
+// XHTML document, with its viewport set to the size of its image.
+// Images without alternative text are described by their chapter and
+// page, as a placeholder for actual descriptions.
+func epubPage(book mobi.Book, title, body string, codec pageCodec, kobo bool) []byte {
+	width, height := epubDefaultWidth, epubDefaultHeight
+	page := int64(0)
+	body = embedRegex.ReplaceAllStringFunc(body, func(embed string) string {
+		index, _ := strconv.ParseInt(embedRegex.FindStringSubmatch(embed)[1], 32, 0)
+		if index > 0 && int(index) <= len(book.Images) {
+			bounds := book.Images[index-1].Bounds()
+			width, height = bounds.Dx(), bounds.Dy()
+			page = index
+		}
+		return fmt.Sprintf("../images/%04d.%v", index, codec.extension())
+	})
+	body = voidRegex.ReplaceAllStringFunc(body, func(element string) string {
+		match := voidRegex.FindStringSubmatch(element)
+		if match[1] == "img" && !altRegex.MatchString(match[2]) {
+			alt := fmt.Sprintf("%v, page %v", title, page)
+			match[2] += fmt.Sprintf(` alt="%v"`, html.EscapeString(alt))
+		}
+		return "<" + match[1] + match[2] + "/>"
+	})
+	if kobo {
+		body = koboSpans(body)
+	}
+
+	b := new(strings.Builder)
+	fmt.Fprintln(b, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(b, `<!DOCTYPE html>`)
+	fmt.Fprintln(b, `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">`)
+	fmt.Fprintf(b, "<head><title>%v</title>\n", html.EscapeString(title))
+	fmt.Fprintf(b, "<meta name=\"viewport\" content=\"width=%v, height=%v\"/>\n", width, height)
+	fmt.Fprintln(b, `<link rel="stylesheet" type="text/css" href="../style.css"/></head>`)
+	fmt.Fprintf(b, "<body>%v</body>\n", body)
+	fmt.Fprintln(b, `</html>`)
+
+	return []byte(b.String())
+}
+
+// koboSpans marks every image and piece of text in the same way as
+// Kobo software does when converting books to KEPUB.
+func koboSpans(body string) string {
+	segment := 0
+	body = koboRegex.ReplaceAllStringFunc(body, func(match string) string {
+		segment++
+		span := fmt.Sprintf(`<span class="koboSpan" id="kobo.%v.1">`, segment)
+		if strings.HasPrefix(match, "<img") {
+			return span + match + "</span>"
+		}
+		return ">" + span + match[1:len(match)-1] + "</span><"
+	})
+
+	return `<div id="book-columns"><div id="book-inner">` + body + `</div></div>`
+}
+
+func jpegOptions(quality int) *jpeg.Options {
+	if quality == 0 {
+		return nil
+	}
+
+	return &jpeg.Options{Quality: quality}
+}
+
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+
+	return err
+}
+
+func writeZipImage(zw *zip.Writer, name string, data []byte) error {
+	// Images are already compressed, so they are stored as they are
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+
+	return err
+}
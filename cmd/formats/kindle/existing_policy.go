@@ -0,0 +1,50 @@
+package kindle
+
+import "github.com/leotaku/kojirou/cmd/formats"
+
+type ExistingPolicy int
+
+const (
+	ExistingPolicySkip ExistingPolicy = iota
+	ExistingPolicyOverwrite
+	ExistingPolicyRename
+	ExistingPolicyUpdate
+)
+
+func (p *ExistingPolicy) String() string {
+	switch *p {
+	case ExistingPolicySkip:
+		return "skip"
+	case ExistingPolicyOverwrite:
+		return "overwrite"
+	case ExistingPolicyRename:
+		return "rename"
+	case ExistingPolicyUpdate:
+		return "update"
+	default:
+		panic("unreachable")
+	}
+}
+
+// Set must have pointer receiver so it doesn't change the value of a copy
+func (p *ExistingPolicy) Set(v string) error {
+	switch v {
+	case "skip":
+		*p = ExistingPolicySkip
+	case "overwrite":
+		*p = ExistingPolicyOverwrite
+	case "rename":
+		*p = ExistingPolicyRename
+	case "update":
+		*p = ExistingPolicyUpdate
+	default:
+		return formats.Errorf(`must be one of: "skip", "overwrite", "rename", or "update"`)
+	}
+
+	return nil
+}
+
+// Type is only used in help text
+func (p *ExistingPolicy) Type() string {
+	return "existing policy"
+}
@@ -1,84 +1,833 @@
 package kindle
 
 import (
+	"bytes"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"image"
 	"image/jpeg"
+	"io"
 	"io/fs"
+	"math/rand"
 	"os"
-	"path"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/leotaku/kojirou/cmd/cache"
 	"github.com/leotaku/kojirou/cmd/formats"
 	md "github.com/leotaku/kojirou/mangadex"
 	"github.com/leotaku/mobi"
+	"golang.org/x/sync/errgroup"
 )
 
+const (
+	thumbnailCacheSize = 16
+	indexFilename      = "index.azw3"
+	// Files are written in chunks of this size, so progress advances
+	// while large books are written to slow devices
+	writeChunkSize = 1 << 20
+)
+
+// Formats books can be written in.
+const (
+	FormatAZW3  = "azw3"
+	FormatEPUB  = "epub"
+	FormatCBZ   = "cbz"
+	FormatKEPUB = "kepub"
+	// Books are converted from AZW3 by external tools
+	FormatKFX = "kfx"
+	// Volumes are written as CBZ with metadata for the local source of
+	// Tachiyomi and Mihon
+	FormatTachiyomi = "tachiyomi"
+	// Pages are written as plain images instead of books
+	FormatImages = "images"
+)
+
+// Volumes commonly share covers, so encoded thumbnails are reused.
+var thumbnailCache = cache.NewLRU(thumbnailCacheSize)
+
 type NormalizedDirectory struct {
 	bookDirectory      string
 	thumbnailDirectory string
+	stagingDirectory   string
+	sync               SyncPolicy
+	manifest           *Manifest
+	title              string
+	info               md.MangaInfo
+	manga              string
+	language           string
+	tagged             map[md.Identifier]bool
+	group              string
+	build              BuildInfo
+	format             string
+	quality            int
+	jpegOptions        JPEGOptions
+	codec              string
+	codecQuality       int
+	grayscale          bool
+	keepColor          bool
+	colorQuality       int
+	encoder            PageEncoder
+	convertKFX         func(azw3 []byte) ([]byte, error)
+	mirrors            []NormalizedDirectory
+}
+
+type Collision struct {
+	Filename   string
+	Identifier md.Identifier
+	Reason     string
 }
 
 func NewNormalizedDirectory(target, title string, kindleFolder bool) NormalizedDirectory {
+	n := newNormalizedDirectory(target, title, kindleFolder)
+	n.title = title
+
+	return n
+}
+
+func newNormalizedDirectory(target, title string, kindleFolder bool) NormalizedDirectory {
 	switch {
 	case kindleFolder && target == "":
 		return NormalizedDirectory{
-			bookDirectory:      path.Join("kindle", "documents", pathnameFromTitle(title)),
-			thumbnailDirectory: path.Join("kindle", "system", "thumbnails"),
+			bookDirectory:      filepath.Join("kindle", "documents", pathnameFromTitle(title)),
+			thumbnailDirectory: filepath.Join("kindle", "system", "thumbnails"),
+			manifest:           new(Manifest),
 		}
 	case kindleFolder:
 		return NormalizedDirectory{
-			bookDirectory:      path.Join(target, "documents", pathnameFromTitle(title)),
-			thumbnailDirectory: path.Join(target, "system", "thumbnails"),
+			bookDirectory:      filepath.Join(target, "documents", pathnameFromTitle(title)),
+			thumbnailDirectory: filepath.Join(target, "system", "thumbnails"),
+			manifest:           new(Manifest),
 		}
 	case target == "":
 		return NormalizedDirectory{
 			bookDirectory: pathnameFromTitle(title),
+			manifest:      new(Manifest),
 		}
 	default:
 		return NormalizedDirectory{
 			bookDirectory: target,
+			manifest:      new(Manifest),
 		}
 	}
 }
 
+// WithStagingDirectory makes files be written to the given directory
+// first and only moved to their final location once complete.  Files
+// that could not be written completely are kept for debugging.
+func (n NormalizedDirectory) WithStagingDirectory(directory string) NormalizedDirectory {
+	n.stagingDirectory = directory
+	return n
+}
+
+// WithSync makes all files be written according to the sync policy,
+// so that failed writes are noticed before volumes count as written.
+func (n NormalizedDirectory) WithSync(policy SyncPolicy) NormalizedDirectory {
+	n.sync = policy
+	return n
+}
+
+// WithSource records which manga and language the volumes written to
+// the directory belong to.
+func (n NormalizedDirectory) WithSource(mangaID, language string) NormalizedDirectory {
+	n.manga = mangaID
+	n.language = language
+	return n
+}
+
+// WithMetadata records the metadata of the manga, which is embedded
+// in formats read by comic servers.
+func (n NormalizedDirectory) WithMetadata(info md.MangaInfo) NormalizedDirectory {
+	n.info = info
+	return n
+}
+
+// WithFormat makes books be written in the given format instead of
+// AZW3.  Filenames given for other formats are changed accordingly.
+func (n NormalizedDirectory) WithFormat(format string) NormalizedDirectory {
+	n.format = format
+	return n
+}
+
+// WithQuality sets the JPEG quality pages are encoded with, from 1 to
+// 100.  Zero uses the default quality of the encoder.
+func (n NormalizedDirectory) WithQuality(quality int) NormalizedDirectory {
+	n.quality = quality
+	return n
+}
+
+// WithJPEGOptions sets the options of the external JPEG encoder pages
+// are encoded with.  Options other than the zero value fail with the
+// built-in encoder.
+func (n NormalizedDirectory) WithJPEGOptions(options JPEGOptions) NormalizedDirectory {
+	n.jpegOptions = options
+	return n
+}
+
+// WithCodec makes pages of EPUB and CBZ books be encoded with the
+// given codec and quality instead of JPEG, using the given encoder.
+// Books in other formats always use JPEG.
+func (n NormalizedDirectory) WithCodec(codec string, quality int, encoder PageEncoder) NormalizedDirectory {
+	n.codec = codec
+	n.codecQuality = quality
+	n.encoder = encoder
+	return n
+}
+
+// WithGrayscale makes pages be converted to grayscale when encoded, so
+// pages are smaller on devices that cannot show color anyway.
+func (n NormalizedDirectory) WithGrayscale(grayscale bool) NormalizedDirectory {
+	n.grayscale = grayscale
+	return n
+}
+
+// WithColorPages makes color pages, e.g. color covers or inserts, keep
+// their colors even when pages are converted to grayscale, and be
+// encoded with the given quality instead.  Zero quality uses the
+// quality of other pages.
+func (n NormalizedDirectory) WithColorPages(keep bool, quality int) NormalizedDirectory {
+	n.keepColor = keep
+	n.colorQuality = quality
+	return n
+}
+
+// WithKFXConverter sets the function that converts AZW3 books to KFX
+// for directories in the KFX format, e.g. by running Kindle Previewer.
+func (n NormalizedDirectory) WithKFXConverter(convert func(azw3 []byte) ([]byte, error)) NormalizedDirectory {
+	n.convertKFX = convert
+	return n
+}
+
+// WithBuildInfo records how books written to the directory were
+// generated, both in the books and in the manifest.
+func (n NormalizedDirectory) WithBuildInfo(build BuildInfo) NormalizedDirectory {
+	n.build = build
+	return n
+}
+
+// WithLanguageTags makes the given volumes be written to filenames
+// that include the language, e.g. "0003 (en).azw3".
+func (n NormalizedDirectory) WithLanguageTags(identifiers []md.Identifier) NormalizedDirectory {
+	tagged := make(map[md.Identifier]bool)
+	for id := range n.tagged {
+		tagged[id] = true
+	}
+	for _, id := range identifiers {
+		tagged[id] = true
+	}
+	n.tagged = tagged
+
+	return n
+}
+
+// WithGroupTag makes all volumes be written to filenames that include
+// the given scantlation group, e.g. "0003 [Group].azw3".
+func (n NormalizedDirectory) WithGroupTag(group string) NormalizedDirectory {
+	n.group = group
+	return n
+}
+
+// BookDirectory returns the directory volumes are written to.
+func (n NormalizedDirectory) BookDirectory() string {
+	return n.bookDirectory
+}
+
 func (n *NormalizedDirectory) Has(identifier md.Identifier) bool {
-	filename := identifier.StringFilled(4, 2, false) + ".azw3"
-	return exists(path.Join(n.bookDirectory, filename))
+	return exists(filepath.Join(n.bookDirectory, n.volumeFilename(identifier, 1)))
+}
+
+// Collisions returns all volumes that would be written to the same
+// file as another volume or to a file belonging to a different manga
+// or language.
+func (n *NormalizedDirectory) Collisions(identifiers []md.Identifier) []Collision {
+	if err := n.manifest.load(n.bookDirectory); err != nil {
+		return nil
+	}
+
+	collisions := make([]Collision, 0)
+	seen := make(map[string]md.Identifier)
+	for _, id := range identifiers {
+		filename := n.volumeFilename(id, 1)
+		// Filenames are compared like on case-insensitive filesystems
+		key := strings.ToLower(filename)
+		if other, ok := seen[key]; ok {
+			collisions = append(collisions, Collision{
+				Filename:   filename,
+				Identifier: id,
+				Reason:     fmt.Sprintf("same file as volume %v", other),
+			})
+			continue
+		}
+		seen[key] = id
+
+		entry, ok := n.manifest.Files[filename]
+		switch {
+		case !ok || entry.Manga == "" || entry.Language == "":
+		case entry.Manga != n.manga:
+			collisions = append(collisions, Collision{
+				Filename:   filename,
+				Identifier: id,
+				Reason:     fmt.Sprintf("already written for manga %v", entry.Manga),
+			})
+		case entry.Language != n.language:
+			collisions = append(collisions, Collision{
+				Filename:   filename,
+				Identifier: id,
+				Reason:     fmt.Sprintf("already written for language %v", entry.Language),
+			})
+		}
+	}
+
+	return collisions
+}
+
+// Filename returns the name of the file a volume with the given
+// content hash should be written to, or false if the volume should
+// not be written according to the policy.
+func (n *NormalizedDirectory) Filename(identifier md.Identifier, hash string, policy ExistingPolicy) (string, bool) {
+	filename := n.volumeFilename(identifier, 1)
+	if !exists(filepath.Join(n.bookDirectory, filename)) {
+		return filename, true
+	}
+
+	switch policy {
+	case ExistingPolicyOverwrite:
+		return filename, true
+	case ExistingPolicyRename:
+		for version := 2; ; version++ {
+			filename := n.volumeFilename(identifier, version)
+			if !exists(filepath.Join(n.bookDirectory, filename)) {
+				return filename, true
+			}
+		}
+	case ExistingPolicyUpdate:
+		if err := n.manifest.load(n.bookDirectory); err != nil {
+			return filename, true
+		}
+		entry, ok := n.manifest.Files[filename]
+		return filename, !ok || entry.Hash != hash
+	default:
+		return "", false
+	}
+}
+
+// Unchanged reports whether the file was written from pages with the
+// given hash, so it would not change when written again.
+func (n *NormalizedDirectory) Unchanged(filename, pageHash string) bool {
+	if err := n.manifest.load(n.bookDirectory); err != nil {
+		return false
+	}
+	entry, ok := n.manifest.Files[filename]
+
+	return ok && entry.PageHash != "" && entry.PageHash == pageHash &&
+		exists(filepath.Join(n.bookDirectory, filename))
+}
+
+// NoteRemoved records tombstones for chapters of written volumes that
+// are not part of the upstream chapters of the manga anymore, so later
+// rebuilds do not lose track of them, and drops tombstones of chapters
+// that were restored.  The tombstones recorded in the directory for the
+// first time are returned.
+func (n *NormalizedDirectory) NoteRemoved(upstream md.ChapterList) ([]Tombstone, error) {
+	result := []Tombstone(nil)
+	now := time.Now()
+	for i, dir := range append([]NormalizedDirectory{*n}, n.mirrors...) {
+		if err := dir.manifest.load(dir.bookDirectory); err != nil {
+			return nil, err
+		}
+		before := len(dir.manifest.Removed)
+		removed := dir.manifest.removedChapters(n.manga, upstream, now)
+		if len(removed) == 0 && len(dir.manifest.Removed) == before {
+			continue
+		} else if err := dir.manifest.save(dir.bookDirectory); err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			result = removed
+		}
+	}
+
+	return result, nil
 }
 
-func (n *NormalizedDirectory) Write(identifier md.Identifier, mobi mobi.Book, p formats.Progress) error {
+// MarkUnchanged records the new content hash for a file that is not
+// written again because its pages are unchanged.
+func (n *NormalizedDirectory) MarkUnchanged(filename, hash string) error {
+	for _, dir := range append([]NormalizedDirectory{*n}, n.mirrors...) {
+		if err := dir.manifest.load(dir.bookDirectory); err != nil {
+			return err
+		}
+		filename := dir.withExtension(filename)
+		if entry, ok := dir.manifest.Files[filename]; ok {
+			entry.Hash = hash
+			dir.manifest.Files[filename] = entry
+			if err := dir.manifest.save(dir.bookDirectory); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (n *NormalizedDirectory) Write(identifier md.Identifier, filename, hash, pageHash string, chapters md.ChapterList, mobi mobi.Book, p formats.Progress) error {
+	return n.writeBook(filename, mobi, &ManifestEntry{
+		Identifier: identifier,
+		Manga:      n.manga,
+		Language:   n.language,
+		ASIN:       encodeASIN(mobi.UniqueID),
+		Hash:       hash,
+		PageHash:   pageHash,
+		Pages:      len(mobi.Images),
+		Minutes:    int(formats.ReadingTime(len(mobi.Images)) / time.Minute),
+		Version:    n.build.Version,
+		Settings:   n.build.Settings,
+		Chapters:   manifestChapters(chapters),
+	}, p)
+}
+
+// WriteIndex writes a book generated by GenerateIndex.
+func (n *NormalizedDirectory) WriteIndex(mobi mobi.Book, p formats.Progress) error {
+	return n.writeBook(indexFilename, mobi, nil, p)
+}
+
+// WriteContactSheet writes an overview image for the book with the
+// given filename.  Contact sheets are only meant for checking books
+// before copying them, so they are not written to mirrors.
+func (n *NormalizedDirectory) WriteContactSheet(filename string, sheet image.Image, p formats.Progress) error {
+	pathname := filepath.Join(n.bookDirectory, trimExtension(filename)+" (contact sheet).jpg")
+	return n.writeFile(pathname, p, func(w io.Writer) error {
+		return jpeg.Encode(w, sheet, &jpeg.Options{Quality: 80})
+	})
+}
+
+// WriteImages writes the pages of a volume as JPEG or PNG files to a
+// directory with one subdirectory per chapter, e.g. "0001/0003/002.jpg",
+// instead of writing a book.  Pages of earlier versions are removed.
+// The directory also receives the metadata of the volume as
+// ComicInfo.xml.
+func (n *NormalizedDirectory) WriteImages(identifier md.Identifier, filename, hash, pageHash string, chapters md.ChapterList, pages md.ImageList, p formats.Progress) error {
 	if n.bookDirectory == "" {
-		return fmt.Errorf("unsupported configuration: no book output")
+		return formats.Errorf("unsupported configuration: no book output")
 	}
-	filename := identifier.StringFilled(4, 2, false) + ".azw3"
 
-	f, err := create(path.Join(n.bookDirectory, filename))
+	directory := filepath.Join(n.bookDirectory, filename)
+	if err := n.manifest.load(n.bookDirectory); err != nil {
+		return formats.Errorf("manifest: %w", err)
+	}
+	if _, ok := n.manifest.Files[filename]; ok {
+		if err := os.RemoveAll(directory); err != nil {
+			return formats.Errorf("remove: %w", err)
+		}
+	}
+
+	extension := "jpg"
+	if n.codec == CodecPNG {
+		extension = "png"
+	}
+	numbers := make(map[md.Identifier]int)
+	eg := new(errgroup.Group)
+	eg.SetLimit(maxJobsEncode)
+	for _, page := range pages {
+		page := page
+		numbers[page.ChapterIdentifier]++
+		pathname := filepath.Join(
+			directory,
+			page.ChapterIdentifier.StringFilled(4, 2, false),
+			fmt.Sprintf("%03d.%v", numbers[page.ChapterIdentifier], extension),
+		)
+		eg.Go(func() error {
+			img, quality := formats.Decoded(page.Image), n.quality
+			if n.keepColor && formats.IsColorPage(img) {
+				if n.colorQuality != 0 {
+					quality = n.colorQuality
+				}
+			} else if n.grayscale {
+				gray, release := toGray(img)
+				defer release()
+				img = gray
+			}
+			if n.codec == CodecPNG {
+				data, err := encodePNG(img)
+				if err != nil {
+					return err
+				}
+				return n.writeData(pathname, data, p)
+			}
+			if encodeJPEGExternal != nil {
+				data, err := encodeJPEGWithExternal(img, quality, n.jpegOptions)
+				if err != nil {
+					return err
+				}
+				return n.writeData(pathname, data, p)
+			}
+			buf := bytes.NewBuffer(nil)
+			if err := jpeg.Encode(buf, img, jpegOptions(quality)); err != nil {
+				return err
+			}
+			data, err := optimize(buf.Bytes())
+			if err != nil {
+				return err
+			}
+			return n.writeData(pathname, data, p)
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	// Comic servers read volumes stored as folders of images as well
+	info := n.comicInfo(&identifier)
+	info.Title = fmt.Sprintf("%v: %v", n.title, identifier)
+	info.LanguageISO = n.language
+	info.PageCount = len(pages)
+	bookmarks := make(map[int]string)
+	for i, page := range pages {
+		if i == 0 || page.ChapterIdentifier != pages[i-1].ChapterIdentifier {
+			bookmarks[i] = fmt.Sprintf("Chapter %v", page.ChapterIdentifier)
+		}
+	}
+	data, err := xml.MarshalIndent(info.withBookmarks(bookmarks), "", "  ")
 	if err != nil {
-		return fmt.Errorf("create: %w", err)
+		return formats.Errorf("comic info: %w", err)
 	}
-	if err := mobi.Realize().Write(p.NewProxyWriter(f)); err != nil {
-		f.Close()
-		return fmt.Errorf("write: %w", err)
+	data = append([]byte(xml.Header), data...)
+	if err := n.writeData(filepath.Join(directory, "ComicInfo.xml"), data, p); err != nil {
+		return formats.Errorf("comic info: %w", err)
 	}
-	f.Close()
 
-	if n.thumbnailDirectory != "" && mobi.CoverImage != nil {
-		f, err := create(path.Join(n.thumbnailDirectory, mobi.GetThumbFilename()))
+	n.manifest.Files[filename] = ManifestEntry{
+		Identifier: identifier,
+		Manga:      n.manga,
+		Language:   n.language,
+		Hash:       hash,
+		PageHash:   pageHash,
+		Pages:      len(pages),
+		Minutes:    int(formats.ReadingTime(len(pages)) / time.Minute),
+		Version:    n.build.Version,
+		Settings:   n.build.Settings,
+		Chapters:   manifestChapters(chapters),
+		Written:    time.Now(),
+	}
+	if err := n.manifest.save(n.bookDirectory); err != nil {
+		return formats.Errorf("manifest: %w", err)
+	}
+
+	return nil
+}
+
+// SetModTime changes the modification time of the written volume
+// with the given filename, including its copies in mirrors, so that
+// file managers and sync tools order volumes by release.  Volumes
+// written as images have the time set on all of their files.
+func (n *NormalizedDirectory) SetModTime(filename string, modTime time.Time) error {
+	dirs := append([]NormalizedDirectory{*n}, n.mirrors...)
+	if n.bookFormat() == FormatImages {
+		dirs = dirs[:1]
+	}
+	for _, dir := range dirs {
+		pathname := filepath.Join(dir.bookDirectory, dir.withExtension(filename))
+		err := filepath.WalkDir(pathname, func(path string, _ fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Chtimes(path, modTime, modTime)
+		})
 		if err != nil {
-			return fmt.Errorf("create: %w", err)
+			return err
 		}
-		if err := jpeg.Encode(p.NewProxyWriter(f), mobi.CoverImage, nil); err != nil {
+	}
+
+	return nil
+}
+
+// WithMirrors makes all books also be written to the given
+// directories, e.g. the mount points of multiple devices.  Books are
+// only generated once, no matter the number of mirrors.
+func (n NormalizedDirectory) WithMirrors(mirrors ...NormalizedDirectory) NormalizedDirectory {
+	n.mirrors = append(append([]NormalizedDirectory{}, n.mirrors...), mirrors...)
+	return n
+}
+
+func (n *NormalizedDirectory) writeBook(filename string, mobi mobi.Book, entry *ManifestEntry, p formats.Progress) error {
+	if n.bookDirectory == "" {
+		return formats.Errorf("unsupported configuration: no book output")
+	}
+
+	// Every format is only encoded once, no matter the number of mirrors
+	dirs := append([]*NormalizedDirectory{n}, make([]*NormalizedDirectory, len(n.mirrors))...)
+	for i := range n.mirrors {
+		dirs[i+1] = &n.mirrors[i]
+	}
+	books := make(map[encoding][]byte)
+	for _, dir := range dirs {
+		if _, ok := books[dir.encoding()]; ok {
+			continue
+		}
+		book, err := n.encodeBook(mobi, dir.encoding(), entry)
+		if err != nil {
+			return formats.Errorf("encode: %w", err)
+		}
+		books[dir.encoding()] = book
+	}
+	thumbnail := []byte(nil)
+	if mobi.CoverImage != nil {
+		var err error
+		if thumbnail, err = encodeThumbnail(mobi.CoverImage); err != nil {
+			return formats.Errorf("encode: %w", err)
+		}
+	}
+
+	eg := new(errgroup.Group)
+	for i, dir := range dirs {
+		i, dir := i, dir
+		eg.Go(func() error {
+			book := books[dir.encoding()]
+			err := dir.writeFiles(dir.withExtension(filename), mobi.GetThumbFilename(), book, thumbnail, entry, p)
+			if err != nil && i > 0 {
+				return formats.Errorf("mirror '%v': %w", dir.bookDirectory, err)
+			}
+			return err
+		})
+	}
+
+	return eg.Wait()
+}
+
+// encoding identifies the settings books are encoded with, so books
+// are only encoded once for directories with the same settings.
+type encoding struct {
+	format       string
+	quality      int
+	jpeg         JPEGOptions
+	codec        string
+	grayscale    bool
+	keepColor    bool
+	colorQuality int
+}
+
+func (n *NormalizedDirectory) encoding() encoding {
+	enc := encoding{n.bookFormat(), n.quality, n.jpegOptions, CodecJPEG, n.grayscale, n.keepColor, n.colorQuality}
+	if enc.format == FormatTachiyomi {
+		enc.format = FormatCBZ
+	}
+	if n.codec == CodecPNG {
+		enc.quality, enc.jpeg, enc.codec = 0, JPEGOptions{}, n.codec
+	} else if n.codec != "" && n.codec != CodecJPEG && (enc.format == FormatEPUB || enc.format == FormatCBZ) {
+		enc.quality, enc.jpeg, enc.codec = n.codecQuality, JPEGOptions{}, n.codec
+	}
+
+	return enc
+}
+
+func (n *NormalizedDirectory) encodeBook(mobi mobi.Book, enc encoding, entry *ManifestEntry) ([]byte, error) {
+	codec := pageCodec{enc.codec, enc.quality, enc.jpeg, enc.grayscale, enc.keepColor, enc.colorQuality, n.encoder}
+	switch enc.format {
+	case FormatEPUB:
+		return encodeEPUB(mobi, n.build, codec, false)
+	case FormatKEPUB:
+		return encodeEPUB(mobi, n.build, codec, true)
+	case FormatCBZ:
+		identifier := (*md.Identifier)(nil)
+		if entry != nil {
+			identifier = &entry.Identifier
+		}
+		return encodeCBZ(mobi, n.comicInfo(identifier), codec)
+	}
+
+	db, err := realize(mobi, n.build, codec)
+	if err != nil {
+		return nil, err
+	}
+	book := bytes.NewBuffer(nil)
+	if err := db.Write(book); err != nil {
+		return nil, err
+	}
+	if enc.format != FormatKFX {
+		return book.Bytes(), nil
+	} else if n.convertKFX == nil {
+		return nil, formats.Errorf("kfx: no converter")
+	}
+
+	kfx, err := n.convertKFX(book.Bytes())
+	if err != nil {
+		return nil, formats.Errorf("kfx: %w", err)
+	}
+
+	return kfx, nil
+}
+
+func (n *NormalizedDirectory) writeFiles(
+	filename, thumbFilename string,
+	book, thumbnail []byte,
+	entry *ManifestEntry,
+	p formats.Progress,
+) error {
+	if err := n.writeData(filepath.Join(n.bookDirectory, filename), book, p); err != nil {
+		return err
+	}
+	if n.thumbnailDirectory != "" && thumbnail != nil && n.bookFormat() == FormatAZW3 {
+		pathname := filepath.Join(n.thumbnailDirectory, thumbFilename)
+		if err := n.writeData(pathname, thumbnail, p); err != nil {
+			return err
+		}
+	}
+	if entry == nil {
+		return nil
+	}
+
+	if err := n.manifest.load(n.bookDirectory); err != nil {
+		return formats.Errorf("manifest: %w", err)
+	}
+	recorded := *entry
+	recorded.Written = time.Now()
+	n.manifest.Files[filename] = recorded
+	if err := n.manifest.save(n.bookDirectory); err != nil {
+		return formats.Errorf("manifest: %w", err)
+	}
+
+	return nil
+}
+
+// writeData writes the data to the file, counting its size towards the
+// progress.
+func (n *NormalizedDirectory) writeData(pathname string, data []byte, p formats.Progress) error {
+	p.Increase(len(data))
+	return n.writeFile(pathname, p, writeBytes(data))
+}
+
+func writeBytes(data []byte) func(io.Writer) error {
+	return func(w io.Writer) error {
+		for len(data) > 0 {
+			size := len(data)
+			if size > writeChunkSize {
+				size = writeChunkSize
+			}
+			if _, err := w.Write(data[:size]); err != nil {
+				return err
+			}
+			data = data[size:]
+		}
+		return nil
+	}
+}
+
+func (n *NormalizedDirectory) writeFile(pathname string, p formats.Progress, write func(io.Writer) error) error {
+	if n.stagingDirectory == "" {
+		f, err := create(pathname, n.sync)
+		if err != nil {
+			return formats.Errorf("create: %w", err)
+		}
+		if err := write(p.NewProxyWriter(f)); err != nil {
 			f.Close()
-			return fmt.Errorf("write: %w", err)
+			return formats.Errorf("write: %w", err)
 		}
+		return closeFile(f, n.sync)
+	}
+
+	if err := os.MkdirAll(n.stagingDirectory, os.ModePerm); err != nil {
+		return formats.Errorf("staging: %w", err)
+	}
+	f, err := CreateTemp(n.stagingDirectory, "*-"+filepath.Base(pathname))
+	if err != nil {
+		return formats.Errorf("staging: %w", err)
+	}
+	if err := write(p.NewProxyWriter(f)); err != nil {
 		f.Close()
+		return formats.Errorf("write: %w (staged data kept at '%v')", err, f.Name())
+	}
+	if err := closeFile(f, n.sync); err != nil {
+		return formats.Errorf("write: %w (staged data kept at '%v')", err, f.Name())
+	}
+	if err := move(f.Name(), pathname, n.sync); err != nil {
+		return formats.Errorf("move: %w (staged data kept at '%v')", err, f.Name())
 	}
 
 	return nil
 }
 
+func (n *NormalizedDirectory) volumeFilename(identifier md.Identifier, version int) string {
+	base := identifier.StringFilled(4, 2, false)
+	if n.tagged[identifier] {
+		base = fmt.Sprintf("%v (%v)", base, n.language)
+	}
+	if n.group != "" {
+		base = fmt.Sprintf("%v [%v]", base, pathnameFromTitle(n.group))
+	}
+
+	if version > 1 {
+		base = fmt.Sprintf("%v (v%v)", base, version)
+	}
+	if n.extension() == "" {
+		return base
+	}
+
+	return fmt.Sprintf("%v.%v", base, n.extension())
+}
+
+func (n *NormalizedDirectory) bookFormat() string {
+	if n.format == "" {
+		return FormatAZW3
+	}
+
+	return n.format
+}
+
+func (n *NormalizedDirectory) extension() string {
+	switch n.bookFormat() {
+	case FormatKEPUB:
+		// Kobo devices only use their own renderer for this extension
+		return "kepub.epub"
+	case FormatTachiyomi:
+		return "cbz"
+	case FormatImages:
+		return ""
+	default:
+		return n.bookFormat()
+	}
+}
+
+// withExtension changes the filename to the format of the directory.
+func (n *NormalizedDirectory) withExtension(filename string) string {
+	if n.extension() == "" {
+		return trimExtension(filename)
+	}
+
+	return fmt.Sprintf("%v.%v", trimExtension(filename), n.extension())
+}
+
+// trimExtension removes the extension of any format from the filename.
+// Volumes written as images have no extension, but their names may
+// contain dots, e.g. "0012.5".
+func trimExtension(filename string) string {
+	for _, ext := range []string{".kepub.epub", ".azw3", ".kfx", ".epub", ".cbz"} {
+		if strings.HasSuffix(filename, ext) {
+			return strings.TrimSuffix(filename, ext)
+		}
+	}
+
+	return filename
+}
+
+func encodeThumbnail(cover image.Image) ([]byte, error) {
+	key := cache.Hash(cover)
+	if cached, ok := thumbnailCache.Get(key); ok {
+		return cached.([]byte), nil
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buf, cover, nil); err != nil {
+		return nil, err
+	}
+	thumbnailCache.Add(key, buf.Bytes())
+
+	return buf.Bytes(), nil
+}
+
 func pathnameFromTitle(filename string) string {
 	switch runtime.GOOS {
 	case "windows":
@@ -111,13 +860,79 @@ func exists(pathname string) bool {
 	}
 }
 
-func create(pathname string) (*os.File, error) {
-	if err := os.MkdirAll(path.Dir(pathname), os.ModePerm); err != nil {
-		return nil, fmt.Errorf("directory: %w", err)
+func move(from, to string, policy SyncPolicy) error {
+	if err := os.MkdirAll(filepath.Dir(to), os.ModePerm); err != nil {
+		return formats.Errorf("directory: %w", err)
+	}
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+
+	// Staging and target directory may be on different devices
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := create(to, policy)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := closeFile(dst, policy); err != nil {
+		return err
+	}
+
+	return os.Remove(from)
+}
+
+func create(pathname string, policy SyncPolicy) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(pathname), os.ModePerm); err != nil {
+		return nil, formats.Errorf("directory: %w", err)
+	}
+	flag := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if policy == SyncPolicyWriteThrough {
+		flag |= os.O_SYNC
 	}
-	if f, err := os.Create(pathname); err != nil {
-		return nil, fmt.Errorf("file: %w", err)
+	if f, err := os.OpenFile(pathname, flag, 0o666); err != nil {
+		return nil, formats.Errorf("file: %w", err)
 	} else {
 		return f, nil
 	}
 }
+
+// CreateTemp is like os.CreateTemp, but the file is created with the
+// same permissions as other written files instead of only being
+// readable by its owner, so it can be renamed into place.
+func CreateTemp(directory, pattern string) (*os.File, error) {
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+	for i := 0; i < 10000; i++ {
+		pathname := filepath.Join(directory, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10)+suffix)
+		f, err := os.OpenFile(pathname, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		return f, err
+	}
+
+	return nil, &fs.PathError{Op: "createtemp", Path: filepath.Join(directory, pattern), Err: fs.ErrExist}
+}
+
+// closeFile closes the file, syncing it first unless the policy is
+// none.  Network filesystems often only report failed writes here.
+func closeFile(f *os.File, policy SyncPolicy) error {
+	if policy != SyncPolicyNone {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return formats.Errorf("sync: %w", err)
+		}
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,112 @@
+package kindle
+
+import (
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"image"
+
+	"github.com/leotaku/kojirou/mangadex"
+	"github.com/leotaku/mobi"
+	"github.com/leotaku/mobi/records"
+	"golang.org/x/image/draw"
+)
+
+const (
+	indexThumbnailHeight = 320
+	indexTemplateString  = `<h1>{{ .Title }}</h1>
+{{- if .Description }}
+<p class="description">{{ .Description }}</p>
+{{- end }}
+{{- range .Volumes }}
+<div class="volume">
+  {{- if .Image }}<img src="kindle:embed:{{ .Image }}?mime=image/jpeg">{{ end -}}
+  <p>{{ .Name }}</p>
+</div>
+{{- end }}`
+	indexCSS = `
+.volume {
+    page-break-inside: avoid;
+    text-align: center;
+    margin-bottom: 1em;
+}
+
+.volume img {
+    max-width: 100%;
+}
+
+.description {
+    page-break-after: always;
+}`
+)
+
+var indexTemplate = template.Must(template.New("index").Parse(indexTemplateString))
+
+type indexVolume struct {
+	Name  string
+	Image string
+}
+
+// GenerateIndex generates a small book listing all volumes of the
+// manga together with their covers, which helps navigation on
+// devices without support for collections.
+func GenerateIndex(manga mangadex.Manga) mobi.Book {
+	images := make([]image.Image, 0)
+	volumes := make([]indexVolume, 0)
+	for _, vol := range manga.Sorted() {
+		entry := indexVolume{Name: fmt.Sprintf("Volume %v", vol.Info.Identifier)}
+		if vol.Cover != nil {
+			images = append(images, scaleToHeight(vol.Cover, indexThumbnailHeight))
+			entry.Image = records.To32(len(images))
+		}
+		volumes = append(volumes, entry)
+	}
+
+	page := templateToString(indexTemplate, struct {
+		Title       string
+		Description string
+		Volumes     []indexVolume
+	}{manga.Info.Title, manga.Info.Description, volumes})
+
+	cover := image.Image(nil)
+	if len(images) > 0 {
+		cover = images[0]
+	}
+
+	return mobi.Book{
+		Title:       fmt.Sprintf("%v: Index", manga.Info.Title),
+		Authors:     manga.Info.Authors,
+		Publisher:   manga.Info.Publisher,
+		CreatedDate: mobiCreatedDate,
+		Language:    mangaToLanguage(manga),
+		CoverImage:  cover,
+		Images:      images,
+		Chapters: []mobi.Chapter{{
+			Title:  "Index",
+			Chunks: mobi.Chunks(page),
+		}},
+		CSSFlows: []string{indexCSS},
+		UniqueID: indexUniqueID(manga),
+	}
+}
+
+func indexUniqueID(manga mangadex.Manga) uint32 {
+	hash := fnv.New32()
+	hash.Write([]byte(manga.Info.ID))
+	hash.Write([]byte("index"))
+
+	return hash.Sum32()
+}
+
+func scaleToHeight(img image.Image, height int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dy() <= height {
+		return img
+	}
+
+	width := bounds.Dx() * height / bounds.Dy()
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+
+	return scaled
+}
@@ -0,0 +1,137 @@
+package kindle
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+)
+
+// LibrarySeries summarizes the volumes written to a single directory,
+// according to its manifest.
+type LibrarySeries struct {
+	Directory string
+	Manga     string
+	Language  string
+	Volumes   int
+	// Only volumes written by versions recording pages are counted
+	Pages        int
+	CountedPages int
+	Size         uint64
+	Formats      []string
+	// When the newest volume was written
+	Written time.Time
+	// Chapters of written volumes since removed from MangaDex
+	Removed []Tombstone
+}
+
+// ReadLibrary returns every directory below the given directory that
+// volumes were written to, sorted by path.  Volumes listed in a
+// manifest, but since removed, are ignored.
+func ReadLibrary(directory string) ([]LibrarySeries, error) {
+	result := make([]LibrarySeries, 0)
+	err := filepath.WalkDir(directory, func(pathname string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != manifestFilename {
+			return nil
+		}
+
+		series, err := readSeries(filepath.Dir(pathname))
+		if err != nil {
+			return formats.Errorf("%v: %w", pathname, err)
+		}
+		if series.Volumes > 0 {
+			result = append(result, series)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Directory < result[j].Directory
+	})
+
+	return result, nil
+}
+
+func readSeries(directory string) (LibrarySeries, error) {
+	manifest := new(Manifest)
+	if err := manifest.load(directory); err != nil {
+		return LibrarySeries{}, err
+	}
+
+	series := LibrarySeries{Directory: directory, Removed: manifest.Removed}
+	formats := make(map[string]bool)
+	for filename, entry := range manifest.Files {
+		size, err := sizeOf(filepath.Join(directory, filename))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return LibrarySeries{}, err
+		}
+
+		series.Volumes++
+		series.Size += size
+		if entry.Pages > 0 {
+			series.Pages += entry.Pages
+			series.CountedPages++
+		}
+		if entry.Written.After(series.Written) {
+			series.Written = entry.Written
+		}
+		if entry.Manga != "" {
+			series.Manga, series.Language = entry.Manga, entry.Language
+		}
+		formats[formatOf(filename)] = true
+	}
+	for format := range formats {
+		series.Formats = append(series.Formats, format)
+	}
+	sort.Strings(series.Formats)
+
+	return series, nil
+}
+
+// sizeOf returns the size of a file or, for volumes written as images,
+// of all files in a directory.
+func sizeOf(pathname string) (uint64, error) {
+	size := uint64(0)
+	err := filepath.WalkDir(pathname, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += uint64(info.Size())
+
+		return nil
+	})
+
+	return size, err
+}
+
+// formatOf returns the format of a volume from its filename.  Volumes
+// read by Tachiyomi are indistinguishable from other comic archives.
+func formatOf(filename string) string {
+	switch {
+	case strings.HasSuffix(filename, ".kepub.epub"):
+		return FormatKEPUB
+	case trimExtension(filename) == filename:
+		return FormatImages
+	default:
+		return strings.TrimPrefix(filepath.Ext(filename), ".")
+	}
+}
@@ -0,0 +1,150 @@
+package kindle
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	md "github.com/leotaku/kojirou/mangadex"
+)
+
+const manifestFilename = ".kojirou.json"
+
+// Manifest records which volumes have been written to a directory,
+// so later runs can detect volumes whose contents changed.
+type Manifest struct {
+	Files map[string]ManifestEntry `json:"files"`
+	// Chapters of written volumes that were since removed from
+	// MangaDex, which are kept even once the volumes are rebuilt
+	Removed []Tombstone `json:"removed,omitempty"`
+
+	loaded bool
+}
+
+type ManifestEntry struct {
+	Identifier md.Identifier `json:"identifier"`
+	Manga      string        `json:"manga"`
+	Language   string        `json:"language"`
+	ASIN       string        `json:"asin"`
+	Hash       string        `json:"hash"`
+	PageHash   string        `json:"pageHash,omitempty"`
+	Pages      int           `json:"pages,omitempty"`
+	Minutes    int           `json:"readingMinutes,omitempty"`
+	Version    string        `json:"version,omitempty"`
+	Settings   string        `json:"settings,omitempty"`
+	// MangaDex chapters the volume was written from
+	Chapters []ManifestChapter `json:"chapters,omitempty"`
+	Written  time.Time         `json:"written"`
+}
+
+type ManifestChapter struct {
+	ID         string        `json:"id"`
+	Identifier md.Identifier `json:"identifier"`
+}
+
+// Tombstone records a chapter of a written volume that was removed
+// from MangaDex, and when that was first noticed.
+type Tombstone struct {
+	ID         string        `json:"id"`
+	Identifier md.Identifier `json:"identifier"`
+	Volume     md.Identifier `json:"volume"`
+	Noticed    time.Time     `json:"noticed"`
+}
+
+func manifestChapters(chapters md.ChapterList) []ManifestChapter {
+	result := make([]ManifestChapter, 0, len(chapters))
+	for _, chapter := range chapters {
+		result = append(result, ManifestChapter{chapter.Info.ID, chapter.Info.Identifier})
+	}
+
+	return result
+}
+
+// removedChapters records tombstones for all chapters of volumes of the
+// manga that are not part of the upstream chapters, and returns the
+// tombstones that were not recorded before.  Tombstones of chapters
+// that are part of the upstream chapters again are dropped.
+func (m *Manifest) removedChapters(manga string, upstream md.ChapterList, now time.Time) []Tombstone {
+	known := make(map[string]bool)
+	for _, chapter := range upstream {
+		known[chapter.Info.ID] = true
+	}
+	removed := make([]Tombstone, 0, len(m.Removed))
+	for _, tombstone := range m.Removed {
+		if !known[tombstone.ID] {
+			removed = append(removed, tombstone)
+		}
+	}
+	for _, tombstone := range removed {
+		known[tombstone.ID] = true
+	}
+
+	result := make([]Tombstone, 0)
+	for _, filename := range sortedFilenames(m.Files) {
+		entry := m.Files[filename]
+		if entry.Manga != manga {
+			continue
+		}
+		for _, chapter := range entry.Chapters {
+			if !known[chapter.ID] {
+				known[chapter.ID] = true
+				result = append(result, Tombstone{chapter.ID, chapter.Identifier, entry.Identifier, now})
+			}
+		}
+	}
+	m.Removed = append(removed, result...)
+
+	return result
+}
+
+func sortedFilenames(files map[string]ManifestEntry) []string {
+	result := make([]string, 0, len(files))
+	for filename := range files {
+		result = append(result, filename)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func (m *Manifest) load(directory string) error {
+	if m.loaded {
+		return nil
+	}
+	m.loaded = true
+	m.Files = make(map[string]ManifestEntry)
+
+	data, err := os.ReadFile(filepath.Join(directory, manifestFilename))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return formats.Errorf("read: %w", err)
+	} else if err := json.Unmarshal(data, m); err != nil {
+		return formats.Errorf("decode: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Manifest) save(directory string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return formats.Errorf("encode: %w", err)
+	}
+
+	f, err := create(filepath.Join(directory, manifestFilename), SyncPolicyNone)
+	if err != nil {
+		return formats.Errorf("create: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return formats.Errorf("write: %w", err)
+	}
+
+	return f.Close()
+}
@@ -16,7 +16,7 @@ import (
 )
 
 const (
-	pageTemplateString = `<div>.</div><img src="kindle:embed:{{ . }}?mime=image/jpeg">`
+	pageTemplateString = `<div id="{{ .Anchor }}">.</div><img src="kindle:embed:{{ .Image }}?mime=image/jpeg">`
 	basePageCSS        = `
 div {
     display: none
@@ -30,9 +30,12 @@ img {
 }`
 )
 
-var pageTemplate = template.Must(template.New("page").Parse(pageTemplateString))
+var (
+	pageTemplate    = template.Must(template.New("page").Parse(pageTemplateString))
+	mobiCreatedDate = time.Unix(0, 0)
+)
 
-func GenerateMOBI(manga mangadex.Manga) mobi.Book {
+func GenerateMOBI(manga mangadex.Manga, style Style) mobi.Book {
 	chapters := make([]mobi.Chapter, 0)
 	images := make([]image.Image, 0)
 	pageImageIndex := 1
@@ -42,9 +45,12 @@ func GenerateMOBI(manga mangadex.Manga) mobi.Book {
 		for _, chap := range vol.Sorted() {
 			groupNames = append(groupNames, chap.Info.GroupNames...)
 			pages := make([]string, 0)
-			for _, img := range chap.Sorted() {
+			for i, img := range chap.Sorted() {
 				images = append(images, img)
-				pages = append(pages, templateToString(pageTemplate, records.To32(pageImageIndex)))
+				pages = append(pages, templateToString(style.pageTemplate(), pageData{
+					Anchor: pageAnchor(chap.Info, i),
+					Image:  records.To32(pageImageIndex),
+				}))
 				pageImageIndex++
 			}
 			title := fmt.Sprintf("%v: %v", chap.Info.Identifier, chap.Info.Title)
@@ -57,21 +63,84 @@ func GenerateMOBI(manga mangadex.Manga) mobi.Book {
 	groupNames = deduplicate(groupNames)
 
 	return mobi.Book{
-		Title:        mangaToTitle(manga),
-		Authors:      manga.Info.Authors,
-		Contributors: groupNames,
-		CreatedDate:  time.Unix(0, 0),
-		Language:     mangaToLanguage(manga),
-		FixedLayout:  true,
-		RightToLeft:  true,
-		CoverImage:   mangaToCover(manga),
-		Images:       images,
-		Chapters:     chapters,
-		CSSFlows:     []string{basePageCSS},
-		UniqueID:     mangaToUniqueID(manga),
+		Title:         mangaToTitle(manga),
+		Authors:       manga.Info.Authors,
+		Contributors:  groupNames,
+		Publisher:     manga.Info.Publisher,
+		CreatedDate:   mobiCreatedDate,
+		PublishedDate: manga.Info.Started,
+		Language:      mangaToLanguage(manga),
+		FixedLayout:   true,
+		RightToLeft:   true,
+		CoverImage:    mangaToCover(manga),
+		Images:        images,
+		Chapters:      chapters,
+		CSSFlows:      style.cssFlows(),
+		UniqueID:      mangaToUniqueID(manga),
+	}
+}
+
+// WithGroup marks the book as the release of a single scantlation
+// group.  The unique identifier is changed, so releases of the same
+// volume by different groups are kept apart by readers.
+func WithGroup(book mobi.Book, group string) mobi.Book {
+	hash := fnv.New32()
+	hash.Write([]byte(group))
+	book.Title = fmt.Sprintf("%v [%v]", book.Title, group)
+	book.UniqueID ^= hash.Sum32()
+
+	return book
+}
+
+// WithMergedSubchapters merges the parts of split chapters, like 31.1
+// and 31.2, into a single chapter of the table of contents, which is
+// titled after the first part.  The chapters must be those the book was
+// generated from, in order.
+func WithMergedSubchapters(book mobi.Book, chapters mangadex.ChapterList) mobi.Book {
+	if len(chapters) != len(book.Chapters) {
+		return book
 	}
+
+	merged := make([]mobi.Chapter, 0)
+	for i, chapter := range book.Chapters {
+		this, last := chapters[i].Info.Identifier, mangadex.Identifier{}
+		if i > 0 {
+			last = chapters[i-1].Info.Identifier
+		}
+		if i > 0 && !this.IsSpecial() && this.Whole().Equal(last.Whole()) {
+			previous := &merged[len(merged)-1]
+			previous.Chunks = append(previous.Chunks, chapter.Chunks...)
+			continue
+		}
+		if next := i + 1; next < len(chapters) && !this.IsSpecial() && this.Whole().Equal(chapters[next].Info.Identifier.Whole()) {
+			chapter.Title = fmt.Sprintf("%v: %v", this.Whole(), chapters[i].Info.Title)
+		}
+		merged = append(merged, chapter)
+	}
+	book.Chapters = merged
+
+	return book
+}
+
+type pageData struct {
+	Anchor string
+	Image  string
+}
+
+// pageAnchor returns an identifier for the page that stays the same
+// as long as the chapter is not replaced.  Anchors have a fixed width,
+// so the text offsets of unchanged pages are stable across rebuilds
+// and readers keep their position after a volume is updated.
+func pageAnchor(chapter mangadex.ChapterInfo, page int) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(chapter.ID))
+
+	return fmt.Sprintf("p%08x-%04d", hash.Sum32(), page)
 }
 
+// mangaToUniqueID derives a stable identifier from the manga and its
+// volumes, which is also used as the ASIN of the book.  This makes
+// rebuilt books replace, rather than duplicate, earlier versions.
 func mangaToUniqueID(manga mangadex.Manga) uint32 {
 	hash := fnv.New32()
 	hash.Write([]byte(manga.Info.ID))
@@ -0,0 +1,271 @@
+package kindle
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/leotaku/mobi"
+)
+
+const (
+	// Pages are searched for panels at about this resolution
+	panelGrid = 200
+	// Brightness below which pixels count as ink
+	panelInk = 0.75
+	// Largest fraction of ink in lines of gutters between panels
+	panelGutterInk = 0.02
+	// Smallest width of gutters, in lines of the searched resolution
+	panelMinGutter = 2
+	// Smallest size of panels as a fraction of the page, so that
+	// speech bubbles and page numbers outside of panels are ignored
+	panelMinArea = 0.03
+	// Largest magnification, so small panels are not blown up
+	panelMaxZoom = 3
+	// Marks pages with panel view, see hasPanelView
+	panelClass = "app-amzn-magnify"
+	panelCSS   = `
+.panel-view {
+    display: block;
+    position: absolute;
+    top: 0;
+    left: 0;
+    width: 100%;
+    height: 100%;
+}
+
+.panel-view a {
+    display: block;
+    position: absolute;
+}
+
+.panel-target {
+    display: none;
+    position: absolute;
+    top: 0;
+    left: 0;
+    width: 100%;
+    height: 100%;
+    overflow: hidden;
+}
+
+.panel-target img {
+    position: absolute;
+}`
+)
+
+// panel is a region of a page, in fractions of its size.
+type panel struct {
+	X, Y, W, H float64
+}
+
+// WithPanelView makes Kindle devices zoom into the panels of pages one
+// after another when a page is double-tapped.  Panels are detected from
+// the white gutters between them and ordered in reading direction.
+// Pages without at least two panels, e.g. full-page illustrations, are
+// left unchanged.
+func WithPanelView(book mobi.Book) mobi.Book {
+	chapters := make([]mobi.Chapter, len(book.Chapters))
+	for i, chapter := range book.Chapters {
+		chapter.Chunks = append([]mobi.Chunk{}, chapter.Chunks...)
+		for j, chunk := range chapter.Chunks {
+			match := embedRegex.FindStringSubmatch(chunk.Body)
+			if match == nil {
+				continue
+			}
+			index, _ := strconv.ParseInt(match[1], 32, 0)
+			if index < 1 || int(index) > len(book.Images) {
+				continue
+			}
+			panels := detectPanels(formats.Decoded(book.Images[index-1]), book.RightToLeft)
+			if len(panels) > 1 {
+				chapter.Chunks[j].Body += panelMarkup(match[0], int(index), panels)
+			}
+		}
+		chapters[i] = chapter
+	}
+	book.Chapters = chapters
+	book.CSSFlows = append(append([]string{}, book.CSSFlows...), panelCSS)
+
+	return book
+}
+
+// hasPanelView reports whether any page of the book has panel view, as
+// devices only magnify regions of books marked accordingly.
+func hasPanelView(book mobi.Book) bool {
+	for _, chapter := range book.Chapters {
+		for _, chunk := range chapter.Chunks {
+			if strings.Contains(chunk.Body, panelClass) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// panelMarkup returns the regions that are tapped, followed by the
+// magnified panels they lead to.  Magnified panels are the whole image
+// scaled and moved so that the panel is centered on the screen.
+func panelMarkup(embed string, index int, panels []panel) string {
+	b := new(strings.Builder)
+	b.WriteString(`<section class="panel-view">`)
+	for i, p := range panels {
+		fmt.Fprintf(b, `<a class="%v" style="left:%.2f%%;top:%.2f%%;width:%.2f%%;height:%.2f%%" `,
+			panelClass, p.X*100, p.Y*100, p.W*100, p.H*100)
+		fmt.Fprintf(b, `data-app-amzn-magnify='{"targetId":"panel-%v-%v","ordinal":%v}'></a>`, index, i+1, i+1)
+	}
+	b.WriteString(`</section>`)
+	for i, p := range panels {
+		zoom := 1 / p.W
+		if 1/p.H < zoom {
+			zoom = 1 / p.H
+		}
+		if zoom > panelMaxZoom {
+			zoom = panelMaxZoom
+		}
+		left := (0.5 - (p.X+p.W/2)*zoom) * 100
+		top := (0.5 - (p.Y+p.H/2)*zoom) * 100
+		fmt.Fprintf(b, `<section class="panel-target" id="panel-%v-%v">`, index, i+1)
+		fmt.Fprintf(b, `<img src="%v" alt="" style="width:%.2f%%;left:%.2f%%;top:%.2f%%">`, embed, zoom*100, left, top)
+		b.WriteString(`</section>`)
+	}
+
+	return b.String()
+}
+
+// detectPanels splits the page at gutters, alternating between rows
+// and columns, until no more gutters are found.  Rows are read from top
+// to bottom and columns in reading direction.
+func detectPanels(img image.Image, rightToLeft bool) []panel {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil
+	}
+	step := bounds.Dx()/panelGrid + 1
+	if bounds.Dy() > bounds.Dx() {
+		step = bounds.Dy()/panelGrid + 1
+	}
+	width, height := (bounds.Dx()+step-1)/step, (bounds.Dy()+step-1)/step
+	ink := make([][]bool, height)
+	for y := range ink {
+		ink[y] = make([]bool, width)
+		for x := range ink[y] {
+			r, g, b, _ := img.At(bounds.Min.X+x*step, bounds.Min.Y+y*step).RGBA()
+			ink[y][x] = (0.299*float64(r)+0.587*float64(g)+0.114*float64(b))/0xffff < panelInk
+		}
+	}
+
+	grid := inkGrid{ink, rightToLeft}
+	result := make([]panel, 0)
+	for _, r := range grid.cut(image.Rect(0, 0, width, height), true, false) {
+		p := panel{
+			X: float64(r.Min.X) / float64(width),
+			Y: float64(r.Min.Y) / float64(height),
+			W: float64(r.Dx()) / float64(width),
+			H: float64(r.Dy()) / float64(height),
+		}
+		if p.W*p.H >= panelMinArea {
+			result = append(result, p)
+		}
+	}
+
+	return result
+}
+
+type inkGrid struct {
+	ink         [][]bool
+	rightToLeft bool
+}
+
+// cut returns the panels within the rectangle.  Rectangles without
+// gutters in one direction are tried in the other direction once more,
+// before they are considered a single panel.
+func (g inkGrid) cut(r image.Rectangle, rows, tried bool) []image.Rectangle {
+	parts := g.split(r, rows)
+	switch {
+	case len(parts) == 0:
+		return nil
+	case len(parts) == 1 && tried:
+		return parts
+	case len(parts) == 1:
+		return g.cut(parts[0], !rows, true)
+	}
+
+	result := make([]image.Rectangle, 0)
+	for _, part := range parts {
+		result = append(result, g.cut(part, !rows, false)...)
+	}
+
+	return result
+}
+
+// split returns the parts of the rectangle between gutters, which are
+// rows or columns with hardly any ink.
+func (g inkGrid) split(r image.Rectangle, rows bool) []image.Rectangle {
+	length, breadth := r.Dy(), r.Dx()
+	if !rows {
+		length, breadth = breadth, length
+	}
+
+	gutters := make([]bool, length+panelMinGutter)
+	for i := range gutters {
+		gutters[i] = i >= length || g.countInk(r, rows, i) <= int(float64(breadth)*panelGutterInk)
+	}
+
+	// Gutters narrower than panelMinGutter are white lines within panels
+	parts := make([]image.Rectangle, 0)
+	start := -1
+	for i := 0; i <= length; i++ {
+		if start < 0 && !gutters[i] {
+			start = i
+		}
+		if start < 0 || !isGutter(gutters[i:i+panelMinGutter]) {
+			continue
+		}
+		if rows {
+			parts = append(parts, image.Rect(r.Min.X, r.Min.Y+start, r.Max.X, r.Min.Y+i))
+		} else {
+			parts = append(parts, image.Rect(r.Min.X+start, r.Min.Y, r.Min.X+i, r.Max.Y))
+		}
+		start = -1
+	}
+	if !rows && g.rightToLeft {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+
+	return parts
+}
+
+func isGutter(lines []bool) bool {
+	for _, gutter := range lines {
+		if !gutter {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (g inkGrid) countInk(r image.Rectangle, rows bool, i int) int {
+	count := 0
+	if rows {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if g.ink[r.Min.Y+i][x] {
+				count++
+			}
+		}
+	} else {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			if g.ink[y][r.Min.X+i] {
+				count++
+			}
+		}
+	}
+
+	return count
+}
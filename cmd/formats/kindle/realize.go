@@ -0,0 +1,125 @@
+package kindle
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/leotaku/mobi"
+	"github.com/leotaku/mobi/pdb"
+	"github.com/leotaku/mobi/records"
+	"github.com/leotaku/mobi/types"
+	"golang.org/x/sync/errgroup"
+)
+
+var maxJobsEncode = runtime.NumCPU()
+
+// ReduceConcurrency halves the number of images encoded at once.
+func ReduceConcurrency() {
+	if maxJobsEncode > 1 {
+		maxJobsEncode /= 2
+	}
+}
+
+// BuildInfo describes how a book was generated, so that unexpected
+// results can be traced back to their settings long after.
+type BuildInfo struct {
+	Time     time.Time
+	Version  string
+	Settings string
+}
+
+// realize converts the book to a Palm database while encoding image
+// records concurrently, as image encoding dominates generation time.
+func realize(book mobi.Book, build BuildInfo, codec pageCodec) (pdb.Database, error) {
+	if codec.name == CodecPNG {
+		book = withMediaType(book, codec.mediaType())
+	}
+	db := book.Realize()
+
+	// Kindle devices identify sideloaded books by either of the ASIN
+	// entries, so rebuilt volumes replace the book on the device
+	if null, ok := db.Records[0].(records.NullRecord); ok {
+		null.EXTHSection.AddString(types.EXTHASIN5XX, encodeASIN(book.UniqueID))
+		if build.Version != "" {
+			null.EXTHSection.AddString(types.EXTHSource, fmt.Sprintf("kojirou %v %v", build.Version, build.Settings))
+		}
+		if !build.Time.IsZero() {
+			null.EXTHSection.AddString(types.EXTHLastUpdate, build.Time.UTC().Format(time.RFC3339))
+		}
+		if hasPanelView(book) {
+			null.EXTHSection.AddString(types.EXTHRegionMagnification, "true")
+		}
+		db.ReplaceRecord(0, null)
+	}
+
+	// Image records are added in this order, and are encoded from the
+	// images directly, so lazily decoded images are encoded quickly
+	images := append([]image.Image{}, book.Images...)
+	for _, img := range []image.Image{book.CoverImage, book.ThumbImage} {
+		if img != nil {
+			images = append(images, img)
+		}
+	}
+
+	eg := new(errgroup.Group)
+	eg.SetLimit(maxJobsEncode)
+	index := 0
+	for i, rec := range db.Records {
+		if _, ok := rec.(records.ImageRecord); !ok {
+			continue
+		}
+		i, write := i, rec.Write
+		if index < len(images) {
+			img, codec := images[index], codec
+			if index >= len(book.Images) && codec.name == CodecPNG {
+				// Devices expect covers and thumbnails as JPEG
+				codec.name = CodecJPEG
+			}
+			write = func(w io.Writer) error {
+				data, err := codec.encodeImage(img)
+				if err != nil {
+					return err
+				}
+				_, err = w.Write(data)
+				return err
+			}
+		}
+		index++
+		eg.Go(func() error {
+			buf := bytes.NewBuffer(nil)
+			if err := write(buf); err != nil {
+				return formats.Errorf("record %v: %w", i, err)
+			}
+			db.Records[i] = pdb.RawRecord(buf.Bytes())
+			return nil
+		})
+	}
+
+	return db, eg.Wait()
+}
+
+// withMediaType changes the media type of all embedded images, as
+// pages reference their images as JPEG.
+func withMediaType(book mobi.Book, mediaType string) mobi.Book {
+	chapters := make([]mobi.Chapter, len(book.Chapters))
+	for i, chapter := range book.Chapters {
+		chapter.Chunks = append([]mobi.Chunk{}, chapter.Chunks...)
+		for j, chunk := range chapter.Chunks {
+			chapter.Chunks[j].Body = embedRegex.ReplaceAllString(chunk.Body, "kindle:embed:${1}?mime="+mediaType)
+		}
+		chapters[i] = chapter
+	}
+	book.Chapters = chapters
+
+	return book
+}
+
+// encodeASIN mirrors the fake ASIN embedded by the mobi package.
+func encodeASIN(id uint32) string {
+	return fmt.Sprintf("%015x", id)
+}
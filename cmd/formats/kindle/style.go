@@ -0,0 +1,51 @@
+package kindle
+
+import (
+	"html/template"
+	"io"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+)
+
+// Style customizes the presentation of pages, e.g. margins or a dark
+// background for night mode.  The zero value is the default style.
+type Style struct {
+	CSS  string
+	page *template.Template
+}
+
+// NewStyle returns a style with additional CSS and, unless empty, a
+// template that replaces the default page template.  The template is
+// executed with the fields "Anchor" and "Image" for every page.
+func NewStyle(css, page string) (Style, error) {
+	style := Style{CSS: css}
+	if page == "" {
+		return style, nil
+	}
+
+	tpl, err := template.New("page").Parse(page)
+	if err != nil {
+		return style, formats.Errorf("page template: %w", err)
+	} else if err := tpl.Execute(io.Discard, pageData{}); err != nil {
+		return style, formats.Errorf("page template: %w", err)
+	}
+	style.page = tpl
+
+	return style, nil
+}
+
+func (s Style) pageTemplate() *template.Template {
+	if s.page != nil {
+		return s.page
+	}
+
+	return pageTemplate
+}
+
+func (s Style) cssFlows() []string {
+	if s.CSS != "" {
+		return []string{basePageCSS, s.CSS}
+	}
+
+	return []string{basePageCSS}
+}
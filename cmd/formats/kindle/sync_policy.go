@@ -0,0 +1,50 @@
+package kindle
+
+import "github.com/leotaku/kojirou/cmd/formats"
+
+// SyncPolicy describes how written files are made to reach the disk,
+// which matters for network filesystems and devices that report writes
+// as successful before they were stored.
+type SyncPolicy int
+
+const (
+	SyncPolicyNone SyncPolicy = iota
+	// Files are synced once completely written
+	SyncPolicyFsync
+	// Every write only returns once it was stored
+	SyncPolicyWriteThrough
+)
+
+func (p *SyncPolicy) String() string {
+	switch *p {
+	case SyncPolicyNone:
+		return "none"
+	case SyncPolicyFsync:
+		return "fsync"
+	case SyncPolicyWriteThrough:
+		return "write-through"
+	default:
+		panic("unreachable")
+	}
+}
+
+// Set must have pointer receiver so it doesn't change the value of a copy
+func (p *SyncPolicy) Set(v string) error {
+	switch v {
+	case "none":
+		*p = SyncPolicyNone
+	case "fsync":
+		*p = SyncPolicyFsync
+	case "write-through":
+		*p = SyncPolicyWriteThrough
+	default:
+		return formats.Errorf(`must be one of: "none", "fsync", or "write-through"`)
+	}
+
+	return nil
+}
+
+// Type is only used in help text
+func (p *SyncPolicy) Type() string {
+	return "sync policy"
+}
@@ -0,0 +1,91 @@
+package kindle
+
+import (
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	md "github.com/leotaku/kojirou/mangadex"
+)
+
+// tachiyomiDetails is the metadata format read by the local source of
+// Tachiyomi and Mihon, see https://mihon.app/docs/guides/local-source/.
+type tachiyomiDetails struct {
+	Title       string   `json:"title"`
+	Author      string   `json:"author,omitempty"`
+	Artist      string   `json:"artist,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Genre       []string `json:"genre,omitempty"`
+	Status      string   `json:"status"`
+}
+
+// Status codes of the local source, where zero means unknown.
+var tachiyomiStatus = map[string]string{
+	"ongoing":   "1",
+	"completed": "2",
+	"cancelled": "5",
+	"hiatus":    "6",
+}
+
+// WriteDetails writes the metadata and cover of the manga next to the
+// volumes of all directories in the Tachiyomi format, so the local
+// source shows the series like any other.
+func (n *NormalizedDirectory) WriteDetails(manga md.Manga, p formats.Progress) error {
+	details := tachiyomiDetails{
+		Title:       manga.Info.Title,
+		Author:      strings.Join(manga.Info.Authors, ", "),
+		Artist:      strings.Join(manga.Info.Artists, ", "),
+		Description: manga.Info.Description,
+		Status:      "0",
+	}
+	for _, tag := range manga.Info.Tags {
+		details.Genre = append(details.Genre, tag.Name)
+	}
+	if status, ok := tachiyomiStatus[manga.Info.Status]; ok {
+		details.Status = status
+	}
+	data, err := json.MarshalIndent(details, "", "  ")
+	if err != nil {
+		return formats.Errorf("encode: %w", err)
+	}
+
+	// The local source only shows a single cover per series
+	cover := image.Image(nil)
+	for _, volume := range manga.Sorted() {
+		if volume.Cover != nil {
+			cover = volume.Cover
+			break
+		}
+	}
+
+	for i, dir := range append([]NormalizedDirectory{*n}, n.mirrors...) {
+		if dir.bookFormat() != FormatTachiyomi || dir.bookDirectory == "" {
+			continue
+		}
+		err := dir.writeDetails(data, cover, p)
+		if err != nil && i > 0 {
+			return formats.Errorf("mirror '%v': %w", dir.bookDirectory, err)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n *NormalizedDirectory) writeDetails(details []byte, cover image.Image, p formats.Progress) error {
+	if err := n.writeData(filepath.Join(n.bookDirectory, "details.json"), details, p); err != nil {
+		return err
+	}
+	if cover == nil {
+		return nil
+	}
+
+	return n.writeFile(filepath.Join(n.bookDirectory, "cover.jpg"), p, func(w io.Writer) error {
+		return jpeg.Encode(w, formats.Decoded(cover), jpegOptions(n.quality))
+	})
+}
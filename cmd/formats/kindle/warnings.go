@@ -0,0 +1,85 @@
+package kindle
+
+import (
+	"html/template"
+	"strings"
+
+	"github.com/leotaku/kojirou/mangadex"
+	"github.com/leotaku/mobi"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+const (
+	warningsTemplateString = `<section class="warnings">
+<h1>Content Warnings</h1>
+{{- if .Rating }}
+<p>Rated {{ .Rating }}</p>
+{{- end }}
+{{- range .Groups }}
+<h2>{{ .Name }}</h2>
+<p>{{ join .Tags ", " }}</p>
+{{- end }}
+</section>`
+	warningsCSS = `
+.warnings {
+    text-align: center;
+    page-break-after: always;
+}`
+)
+
+var warningsTemplate = template.Must(template.New("warnings").
+	Funcs(template.FuncMap{"join": strings.Join}).
+	Parse(warningsTemplateString))
+
+// Tag groups in order of importance, content tags being the actual
+// warnings, e.g. "Gore" or "Sexual Violence".
+var warningsGroups = []struct {
+	group string
+	name  string
+}{
+	{"content", "Content"},
+	{"theme", "Themes"},
+	{"genre", "Genres"},
+	{"format", "Format"},
+}
+
+type warningsGroup struct {
+	Name string
+	Tags []string
+}
+
+// WithContentWarnings prepends a page listing the content rating and
+// tags of the manga to the book.  Books are returned unchanged when
+// nothing is known about the manga, e.g. for manga loaded from disk.
+func WithContentWarnings(book mobi.Book, info mangadex.MangaInfo) mobi.Book {
+	if info.ContentRating == "" && len(info.Tags) == 0 {
+		return book
+	}
+
+	groups := make([]warningsGroup, 0)
+	for _, g := range warningsGroups {
+		tags := make([]string, 0)
+		for _, tag := range info.Tags {
+			if tag.Group == g.group {
+				tags = append(tags, tag.Name)
+			}
+		}
+		if len(tags) > 0 {
+			groups = append(groups, warningsGroup{g.name, deduplicate(tags)})
+		}
+	}
+
+	page := templateToString(warningsTemplate, struct {
+		Rating string
+		Groups []warningsGroup
+	}{cases.Title(language.English).String(info.ContentRating), groups})
+
+	book.Chapters = append([]mobi.Chapter{{
+		Title:  "Content Warnings",
+		Chunks: mobi.Chunks(page),
+	}}, book.Chapters...)
+	book.CSSFlows = append(book.CSSFlows, warningsCSS)
+
+	return book
+}
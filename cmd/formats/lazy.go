@@ -0,0 +1,130 @@
+package formats
+
+import (
+	"container/list"
+	"image"
+	"image/color"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Decoded pages are only kept for as many pages as are commonly used
+// at once, so the memory used does not grow with the number of pages.
+var (
+	maxDecoded   = 4 * runtime.NumCPU()
+	decoded      = list.New()
+	decodedMutex sync.Mutex
+)
+
+// LazyImage is an image that is only decoded once its pixels are
+// used, and which is released again once enough other lazy images were
+// decoded.  Dimensions are known without decoding.
+type LazyImage struct {
+	config image.Config
+	open   func() (io.ReadCloser, error)
+	name   string
+	img    atomic.Value
+	mutex  sync.Mutex
+}
+
+type decodedImage struct {
+	image.Image
+}
+
+// NewLazyImage returns an image that is decoded from the data returned
+// by open whenever its pixels are needed.  The name is used in warnings
+// for images that cannot be decoded.
+func NewLazyImage(config image.Config, name string, open func() (io.ReadCloser, error)) *LazyImage {
+	return &LazyImage{config: config, name: name, open: open}
+}
+
+func (l *LazyImage) ColorModel() color.Model {
+	return l.config.ColorModel
+}
+
+func (l *LazyImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, l.config.Width, l.config.Height)
+}
+
+func (l *LazyImage) At(x, y int) color.Color {
+	return l.Decoded().At(x, y)
+}
+
+func (l *LazyImage) SubImage(r image.Rectangle) image.Image {
+	type subImager interface {
+		SubImage(r image.Rectangle) image.Image
+	}
+
+	img := l.Decoded()
+	if img, ok := img.(subImager); ok {
+		return img.SubImage(r)
+	}
+
+	return img
+}
+
+// Decoded returns the decoded image.  Images that cannot be decoded
+// anymore, e.g. because their file was changed, are replaced by a
+// blank image of the same size and a warning.
+func (l *LazyImage) Decoded() image.Image {
+	if img, ok := l.img.Load().(decodedImage); ok && img.Image != nil {
+		return img.Image
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if img, ok := l.img.Load().(decodedImage); ok && img.Image != nil {
+		return img.Image
+	}
+	img, err := l.decode()
+	if err != nil {
+		Warn("%v: replaced by blank page: %v", l.name, err)
+		img = image.NewGray(l.Bounds())
+	}
+	l.img.Store(decodedImage{img})
+	retain(l)
+
+	return img
+}
+
+func (l *LazyImage) decode() (image.Image, error) {
+	rc, err := l.open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	img, _, err := DecodeImage(rc)
+	if err != nil {
+		return nil, err
+	} else if img.Bounds() != l.Bounds() {
+		return nil, Errorf("size changed since loading")
+	}
+
+	return img, nil
+}
+
+// retain remembers the image as decoded and releases the pixels of the
+// images decoded longest ago.
+func retain(l *LazyImage) {
+	decodedMutex.Lock()
+	defer decodedMutex.Unlock()
+
+	decoded.PushBack(l)
+	for decoded.Len() > maxDecoded {
+		oldest := decoded.Remove(decoded.Front()).(*LazyImage)
+		oldest.img.Store(decodedImage{})
+	}
+}
+
+// Decoded returns the pixels of images that are decoded lazily, so
+// that encoders can use their optimized paths for common image types.
+// Other images are returned as they are.
+func Decoded(img image.Image) image.Image {
+	if lazy, ok := img.(interface{ Decoded() image.Image }); ok {
+		return lazy.Decoded()
+	}
+
+	return img
+}
@@ -0,0 +1,83 @@
+package formats
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	md "github.com/leotaku/kojirou/mangadex"
+)
+
+type SeriesReport struct {
+	Name         string
+	Volumes      int
+	Pages        int
+	CountedPages int
+	Size         uint64
+	Formats      []string
+	// Negative when updates were not checked
+	Updated int
+	Removed []RemovedChapter
+}
+
+type RemovedChapter struct {
+	Chapter md.Identifier
+	Volume  md.Identifier
+	Noticed time.Time
+}
+
+// PrintLibrary prints one line for every series, followed by the
+// totals of the whole library.
+func PrintLibrary(series []SeriesReport) {
+	volumes, size, pending := 0, uint64(0), 0
+	for _, report := range series {
+		printValue(report.Name, formatSeries(report))
+		for _, removed := range report.Removed {
+			printValue("Removed", fmt.Sprintf(
+				Translate("chapter %v of volume %v, noticed %v"),
+				removed.Chapter, removed.Volume, removed.Noticed.Format("2006-01-02"),
+			))
+		}
+		volumes += report.Volumes
+		size += report.Size
+		if report.Updated > 0 {
+			pending++
+		}
+	}
+
+	result := printer.Sprintf(Translate("%d series, %v, %v"), len(series), formatVolumes(volumes), FormatBytes(size))
+	if pending > 0 {
+		result += ", " + warningColor.Sprint(printer.Sprintf(Translate("%d with updates"), pending))
+	}
+	printValue("Total", result)
+}
+
+func formatSeries(report SeriesReport) string {
+	parts := []string{
+		formatVolumes(report.Volumes),
+		FormatBytes(report.Size),
+	}
+	if report.CountedPages > 0 {
+		parts = append(parts, printer.Sprintf(Translate("%d pages on average"), report.Pages/report.CountedPages))
+	}
+	parts = append(parts, strings.Join(report.Formats, "/"))
+	if len(report.Removed) > 0 {
+		parts = append(parts, warningColor.Sprint(printer.Sprintf(Translate("%d chapters removed upstream"), len(report.Removed))))
+	}
+	switch {
+	case report.Updated > 0:
+		parts = append(parts, warningColor.Sprint(printer.Sprintf(Translate("%d chapters updated"), report.Updated)))
+	case report.Updated == 0:
+		parts = append(parts, successColor.Sprint(Translate("up to date")))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func formatVolumes(volumes int) string {
+	if volumes == 1 {
+		return printer.Sprintf(Translate("%d volume"), volumes)
+	}
+
+	return printer.Sprintf(Translate("%d volumes"), volumes)
+}
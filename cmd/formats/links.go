@@ -0,0 +1,42 @@
+package formats
+
+import (
+	"fmt"
+	"strings"
+
+	md "github.com/leotaku/kojirou/mangadex"
+)
+
+const mangadexURL = "https://mangadex.org"
+
+// PrintLinks lists where the selected chapters and their groups can be
+// found on MangaDex, so that questionable picks are easily checked.
+// Chapters loaded from disk are listed by their path instead.
+func PrintLinks(manga *md.Manga) {
+	groups := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, chapter := range sortChapters(manga.Chapters()) {
+		info := chapter.Info
+		name := fmt.Sprintf(Translate("Chapter %v"), info.Identifier)
+		if info.GroupNames.String() == "Filesystem" {
+			printValue(name, info.ID)
+			continue
+		}
+		printValue(name, fmt.Sprintf("%v/chapter/%v", mangadexURL, info.ID))
+
+		for i, id := range info.GroupIDs {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			groupName := id
+			if i < len(info.GroupNames) {
+				groupName = info.GroupNames[i]
+			}
+			groups = append(groups, fmt.Sprintf("%v (%v/group/%v)", groupName, mangadexURL, id))
+		}
+	}
+	if len(groups) > 0 {
+		printValue("Group links", strings.Join(groups, ", "))
+	}
+}
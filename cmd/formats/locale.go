@@ -0,0 +1,81 @@
+package formats
+
+import (
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/width"
+)
+
+// ASCII restricts progress output to ASCII characters, for terminals
+// that cannot render the default glyphs.
+var ASCII bool
+
+// printer formats numbers according to the locale of the environment,
+// like most other command line tools.
+var printer = message.NewPrinter(localeFromEnv("LC_ALL", "LC_NUMERIC", "LANG"))
+
+// localeFromEnv returns the locale of the first of the given
+// environment variables that is set.
+func localeFromEnv(keys ...string) language.Tag {
+	for _, key := range keys {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+
+		// POSIX locales look like "de_DE.UTF-8@euro"
+		value = strings.SplitN(value, ".", 2)[0]
+		value = strings.SplitN(value, "@", 2)[0]
+		if tag, err := language.Parse(strings.ReplaceAll(value, "_", "-")); err == nil {
+			return tag
+		}
+		return language.Und
+	}
+
+	return language.Und
+}
+
+// displayWidth returns the number of terminal columns needed to
+// display the string, as East Asian wide characters need two.
+func displayWidth(s string) int {
+	result := 0
+	for _, r := range s {
+		switch width.LookupRune(r).Kind() {
+		case width.EastAsianWide, width.EastAsianFullwidth:
+			result += 2
+		default:
+			if !unicode.Is(unicode.Mn, r) {
+				result++
+			}
+		}
+	}
+
+	return result
+}
+
+func padRight(s string, columns int) string {
+	if n := columns - displayWidth(s); n > 0 {
+		return s + strings.Repeat(" ", n)
+	}
+
+	return s
+}
+
+// formatTitle isolates right-to-left titles, so that terminals with
+// bidirectional text support do not reorder surrounding text.
+func formatTitle(title string) string {
+	if ASCII || legacyConsole {
+		return title
+	}
+	for _, r := range title {
+		if unicode.In(r, unicode.Arabic, unicode.Hebrew, unicode.Syriac, unicode.Thaana, unicode.Nko) {
+			return "⁨" + title + "⁩"
+		}
+	}
+
+	return title
+}
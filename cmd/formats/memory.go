@@ -0,0 +1,152 @@
+package formats
+
+import (
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const memorySampleInterval = time.Millisecond * 250
+
+// Live heap objects are measured instead of memory obtained from the
+// operating system, as the runtime rarely returns memory once obtained,
+// which would attribute the memory of earlier volumes to later ones.
+const memoryMetric = "/memory/classes/heap/objects:bytes"
+
+type MemoryMonitor struct {
+	baseline uint64
+	peak     uint64
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// StartMemoryMonitor starts sampling the memory used from now on, so
+// that memory still used by earlier work is not counted.
+func StartMemoryMonitor() *MemoryMonitor {
+	m := &MemoryMonitor{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	m.baseline = liveHeap()
+	m.peak = m.baseline
+
+	go func() {
+		ticker := time.NewTicker(memorySampleInterval)
+		defer ticker.Stop()
+		defer close(m.done)
+		for {
+			select {
+			case <-ticker.C:
+				m.sample()
+			case <-m.stop:
+				m.sample()
+				return
+			}
+		}
+	}()
+
+	return m
+}
+
+// Stop ends sampling and returns the peak memory used since the
+// monitor was started.
+func (m *MemoryMonitor) Stop() uint64 {
+	close(m.stop)
+	<-m.done
+
+	return atomic.LoadUint64(&m.peak) - m.baseline
+}
+
+// Peak returns the highest memory used while the monitor was running,
+// including memory still used by earlier work.
+func (m *MemoryMonitor) Peak() uint64 {
+	return atomic.LoadUint64(&m.peak)
+}
+
+func (m *MemoryMonitor) sample() {
+	if used := liveHeap(); used > atomic.LoadUint64(&m.peak) {
+		atomic.StoreUint64(&m.peak, used)
+	}
+}
+
+func liveHeap() uint64 {
+	samples := []metrics.Sample{{Name: memoryMetric}}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+
+	return samples[0].Value.Uint64()
+}
+
+type ByteSize uint64
+
+var byteUnits = []struct {
+	suffix string
+	size   uint64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+func (b *ByteSize) String() string {
+	if *b == 0 {
+		return "none"
+	}
+
+	return FormatBytes(uint64(*b))
+}
+
+// Set must have pointer receiver so it doesn't change the value of a copy
+func (b *ByteSize) Set(v string) error {
+	v = strings.TrimSpace(v)
+	if v == "none" || v == "0" {
+		*b = 0
+		return nil
+	}
+
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(strings.ToUpper(v), strings.ToUpper(unit.suffix)) {
+			number := strings.TrimSpace(v[:len(v)-len(unit.suffix)])
+			if parsed, err := strconv.ParseFloat(number, 64); err != nil || parsed < 0 {
+				return Errorf("not a valid size: %v", v)
+			} else {
+				*b = ByteSize(parsed * float64(unit.size))
+				return nil
+			}
+		}
+	}
+
+	if parsed, err := strconv.ParseUint(v, 10, 64); err != nil {
+		return Errorf("not a valid size: %v", v)
+	} else {
+		*b = ByteSize(parsed)
+	}
+
+	return nil
+}
+
+// Type is only used in help text
+func (b *ByteSize) Type() string {
+	return "size"
+}
+
+func FormatBytes(n uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return printer.Sprintf("%d %v", n, units[unit])
+	} else {
+		return printer.Sprintf("%.1f %v", value, units[unit])
+	}
+}
@@ -0,0 +1,691 @@
+package formats
+
+import (
+	"fmt"
+
+	"golang.org/x/text/language"
+)
+
+// messageLanguages lists the languages messages are translated to.
+// English is first, so that it is used when nothing else matches.
+var messageLanguages = []language.Tag{
+	language.English,
+	language.BrazilianPortuguese,
+	language.Spanish,
+}
+
+// translations maps the English text of user-facing messages to their
+// translation, by language.  Messages without a translation are
+// printed in English.
+var translations = map[language.Tag]map[string]string{
+	language.BrazilianPortuguese: {
+		// Summary
+		"Title":                    "Título",
+		"Author":                   "Autor",
+		"Status":                   "Status",
+		"Groups":                   "Grupos",
+		"Chapters":                 "Capítulos",
+		"Languages":                "Idiomas",
+		"Pages":                    "Páginas",
+		"Discontinuities":          "Descontinuidades",
+		"Ongoing":                  "Em andamento",
+		"Completed":                "Concluído",
+		"Hiatus":                   "Em hiato",
+		"Cancelled":                "Cancelado",
+		"%v (final chapter %v)":    "%v (capítulo final %v)",
+		"Chapter %v":               "Capítulo %v",
+		"Group links":              "Links dos grupos",
+		"Missing [%v]":             "Faltando [%v]",
+		"Ambiguous":                "Ambíguos",
+		"page":                     "página",
+		"pages":                    "páginas",
+		"%d %v, about %d min":      "%d %v, cerca de %d min",
+		"%d %v, about %d h":        "%d %v, cerca de %d h",
+		"%d %v, about %d h %d min": "%d %v, cerca de %d h %d min",
+
+		// Report
+		"Volume %v":      "Volume %v",
+		"written":        "gravado",
+		"skipped":        "ignorado",
+		"%v peak memory": "pico de memória de %v",
+		"%.0f%% brightness, %.0f%% color, %.0f%% double pages": "%.0f%% de brilho, %.0f%% em cores, %.0f%% de páginas duplas",
+		", %.0f JPEG quality": ", qualidade JPEG %.0f",
+		"Warning":             "Aviso",
+		"Error:":              "Erro:",
+
+		"%v credit pages removed":             "%v páginas de créditos removidas",
+		"%v duplicate pages removed":          "%v páginas duplicadas removidas",
+		"Removed":                             "Removida",
+		"chapter %v, page %v (%v)":            "capítulo %v, página %v (%v)",
+		"chapter %v of volume %v, noticed %v": "capítulo %v do volume %v, notado em %v",
+
+		// Progress
+		"Volume: %v": "Volume: %v",
+		"Index":      "Índice",
+		"Details":    "Detalhes",
+		"Metadata":   "Metadados",
+		"Covers":     "Capas",
+		"Sampling":   "Amostragem",
+		"Processing": "Processando",
+		"Writing...": "Gravando...",
+		"Disk...":    "Disco...",
+		"Archive":    "Arquivo",
+		"Error":      "Erro",
+		"Skipped":    "Ignorado",
+		"Unchanged":  "Inalterado",
+
+		// Warnings
+		"%v: replaced by blank page: %v":                         "%v: substituída por página em branco: %v",
+		"no series matching '%v' found in '%v'":                  "nenhuma série correspondente a '%v' encontrada em '%v'",
+		"'%v': skipped: no chapter number":                       "'%v': ignorado: sem número de capítulo",
+		"'%v': skipped: contains files other than pages":         "'%v': ignorado: contém arquivos que não são páginas",
+		"chapter %v: page %v: skipped: %v":                       "capítulo %v: página %v: ignorada: %v",
+		"cover for directory '%v': skipped: %v":                  "capa do diretório '%v': ignorada: %v",
+		"archive '%v': page %v: skipped: %v":                     "arquivo '%v': página %v: ignorada: %v",
+		"chapter %v: image %v: skipped: %v":                      "capítulo %v: imagem %v: ignorada: %v",
+		"volume %v: no cover":                                    "volume %v: sem capa",
+		"volume %v: chapter %v was removed from MangaDex":        "volume %v: capítulo %v foi removido do MangaDex",
+		"chapters in multiple languages: %v":                     "capítulos em vários idiomas: %v",
+		"series is completed, but final chapter %v is missing":   "a série está concluída, mas falta o capítulo final %v",
+		"%v chapters without volume moved to the Ongoing volume": "%v capítulos sem volume movidos para o volume Ongoing",
+		"%v chapters without %v raws are not interleaved":        "%v capítulos sem raws em %v não foram intercalados",
+		"metadata: %v: %v":                                       "metadados: %v: %v",
+		"metadata: %v: cache: %v":                                "metadados: %v: cache: %v",
+		"kcc preset: ignored options without equivalent: %v":     "predefinição do kcc: opções sem equivalente ignoradas: %v",
+		"page cache: %v":                                         "cache de páginas: %v",
+
+		// Library
+		"Total":                        "Total",
+		"%d series, %v, %v":            "%d séries, %v, %v",
+		"%d with updates":              "%d com atualizações",
+		"%d volume":                    "%d volume",
+		"%d volumes":                   "%d volumes",
+		"%d pages on average":          "%d páginas em média",
+		"%d chapters updated":          "%d capítulos atualizados",
+		"%d chapters removed upstream": "%d capítulos removidos do MangaDex",
+		"up to date":                   "atualizada",
+		"%v: updates: %v":              "%v: atualizações: %v",
+
+		// Errors
+		"%v requires epub, cbz or tachiyomi": "%v requer epub, cbz ou tachiyomi",
+		"api base url: %w":                   "URL base da API: %w",
+		"archive: %w":                        "arquivo compactado: %w",
+		"auto levels: clip percentages must be positive and below 100 in total": "níveis automáticos: as porcentagens de corte devem ser positivas e somar menos de 100",
+		"autocrop: %w":                   "corte automático: %w",
+		"blocked: %w":                    "bloqueado: %w",
+		"ca file: %w":                    "arquivo de CA: %w",
+		"ca file: no certificates found": "arquivo de CA: nenhum certificado encontrado",
+		"cannot record and replay at the same time": "não é possível gravar e reproduzir ao mesmo tempo",
+		"case %v: %w":             "caso %v: %w",
+		"chapter %v: %w":          "capítulo %v: %w",
+		"chapter %v: page %v: %w": "capítulo %v: página %v: %w",
+		"chapters in multiple languages: %v (use --mixed-languages to allow)": "capítulos em vários idiomas: %v (use --mixed-languages para permitir)",
+		"chapters: %w": "capítulos: %w",
+		"codec quality: not between 0 and 100 (0 uses the encoder default)": "qualidade do codec: não está entre 0 e 100 (0 usa o padrão do codificador)",
+		"color quality: not between 0 and 100 (0 uses the jpeg quality)":    "qualidade das cores: não está entre 0 e 100 (0 usa a qualidade jpeg)",
+		"config: %w":                     "configuração: %w",
+		"contact sheet: %w":              "folha de contatos: %w",
+		"cover fallback: %w":             "capa alternativa: %w",
+		"covers: %w":                     "capas: %w",
+		"create: %w":                     "criação: %w",
+		"credit hashes: %w":              "hashes de créditos: %w",
+		"crop: %w":                       "corte: %w",
+		"decode: %w":                     "decodificação: %w",
+		"details: %w":                    "detalhes: %w",
+		"directory: %w":                  "diretório: %w",
+		"disk: %w":                       "disco: %w",
+		"encode: %w":                     "codificação: %w",
+		"executable: %w":                 "executável: %w",
+		"extract '%v': %w":               "extração de '%v': %w",
+		"extract: %w":                    "extração: %w",
+		"fail rate: not between 0 and 1": "taxa de falhas: não está entre 0 e 1",
+		"filename collisions:\n%v":       "colisões de nomes de arquivo:\n%v",
+		"filter: %w":                     "filtro: %w",
+		"fixtures: %w":                   "dados de teste: %w",
+		"format: %w":                     "formato: %w",
+		"format: images cannot be combined with --index or --send": "formato: images não pode ser combinado com --index ou --send",
+		"format: volumes are sent as azw3":                         "formato: volumes são enviados como azw3",
+		"golden mismatch:\n%v":                                     "divergência da referência:\n%v",
+		"golden: %w":                                               "referência: %w",
+		"gray levels: not between 2 and 256":                       "níveis de cinza: não está entre 2 e 256",
+		"index: %w":                                                "índice: %w",
+		"input: %w":                                                "entrada: %w",
+		"interrupted":                                              "interrompido",
+		"io workers: must be at least 1":                           "processos de E/S: deve ser pelo menos 1",
+		"ip version: %w":                                           "versão do IP: %w",
+		"jpeg quality: not between 1 and 100":                      "qualidade jpeg: não está entre 1 e 100",
+		"kcc preset: %w":                                           "predefinição do kcc: %w",
+		"kindle folder mode requires azw3 or kfx":                  "o modo de pasta do Kindle requer azw3 ou kfx",
+		"lang: %w":                                                 "idioma: %w",
+		"language: %w":                                             "idioma: %w",
+		"library: %w":                                              "biblioteca: %w",
+		"line %v: not a valid hash: %q":                            "linha %v: não é um hash válido: %q",
+		"main cover: %w":                                           "capa principal: %w",
+		"malformed line: %q":                                       "linha malformada: %q",
+		"manifest: %w":                                             "manifesto: %w",
+		"metadata: %w":                                             "metadados: %w",
+		"modification time: %w":                                    "data de modificação: %w",
+		"no directory given":                                       "nenhum diretório informado",
+		"no output directory given":                                "nenhum diretório de saída informado",
+		"no profile":                                               "nenhum perfil",
+		"not a supported codec: %v":                                "codec não suportado: %v",
+		"nothing left of %vx%v page":                               "nada restou da página de %vx%v",
+		"output: %w":                                               "saída: %w",
+		"output: no kfx file written":                              "saída: nenhum arquivo kfx gravado",
+		"outside of archive":                                       "fora do arquivo compactado",
+		"page codec: %w":                                           "codec de página: %w",
+		"page template: %w":                                        "modelo de página: %w",
+		"page cache links: no page cache given":                    "links do cache de páginas: nenhum cache de páginas informado",
+		"pages: %w":                                                "páginas: %w",
+		"paths: %w":                                                "caminhos: %w",
+		"plan: %w":                                                 "plano: %w",
+		"plan: line %v: %w":                                        "plano: linha %v: %w",
+		"plan: line %v: malformed: %q":                             "plano: linha %v: malformada: %q",
+		"process: %w":                                              "processamento: %w",
+		"profile: %w":                                              "perfil: %w",
+		"progress socket: %w":                                      "socket de progresso: %w",
+		"raw pages: %w":                                            "páginas raw: %w",
+		"raw paths: %w":                                            "caminhos raw: %w",
+		"replay: %w":                                               "reprodução: %w",
+		"resize: %w":                                               "redimensionamento: %w",
+		"resize: maximum sizes must be positive":                   "redimensionamento: os tamanhos máximos devem ser positivos",
+		"search: %w":                                               "busca: %w",
+		"selftest: %w":                                             "autoteste: %w",
+		"send only: cannot be combined with --out":                 "somente envio: não pode ser combinado com --out",
+		"send only: no device given":                               "somente envio: nenhum dispositivo informado",
+		"series settings: %w":                                      "configurações da série: %w",
+		"session: %w":                                              "sessão: %w",
+		"sharpen: amount and radius must be positive":              "nitidez: intensidade e raio devem ser positivos",
+		"skeleton: %w":                                             "esqueleto: %w",
+		"staging pages: %w":                                        "preparação das páginas: %w",
+		"start: %w":                                                "início: %w",
+		"start: no job identifier given":                           "início: nenhum identificador de tarefa informado",
+		"strict: %v warnings":                                      "estrito: %v avisos",
+		"strict: missing chapters: %v":                             "estrito: capítulos faltando: %v",
+		"style: %w":                                                "estilo: %w",
+		"targets: %w":                                              "destinos: %w",
+		"temporary directory: %w":                                  "diretório temporário: %w",
+		"unexpected argument: %v":                                  "argumento inesperado: %v",
+		"unknown key: %v":                                          "chave desconhecida: %v",
+		"unpublished chapters: no access token in %v":              "capítulos não publicados: nenhum token de acesso em %v",
+		"upscale: %w":                                              "ampliação: %w",
+		"volume %v: pages: %w":                                     "volume %v: páginas: %w",
+		"volume %v: process: %w":                                   "volume %v: processamento: %w",
+		"volume %v: write: %w":                                     "volume %v: gravação: %w",
+		"volume map: %w":                                           "mapa de volumes: %w",
+		"write: %w":                                                "gravação: %w",
+		"cancel: job not running: \"%v\"":                          "cancelamento: tarefa não está em execução: \"%v\"",
+		"cancel: no such job: \"%v\"":                              "cancelamento: tarefa inexistente: \"%v\"",
+		"dither: not a valid method: \"%v\"":                       "pontilhado: método inválido: \"%v\"",
+		"format \"%v\" is not supported":                           "formato \"%v\" não é suportado",
+		"format \"%v\": %w":                                        "formato \"%v\": %w",
+		"format \"%v\": page codec: %w":                            "formato \"%v\": codec de página: %w",
+		"not a positive size: \"%v\"":                              "não é um tamanho positivo: \"%v\"",
+		"not a size: \"%v\"":                                       "não é um tamanho: \"%v\"",
+		"not a supported codec: \"%v\"":                            "codec não suportado: \"%v\"",
+		"not a supported format: \"%v\"":                           "formato não suportado: \"%v\"",
+		"not a valid collision policy: \"%v\"":                     "política de colisão inválida: \"%v\"",
+		"not a valid command: \"%v\"":                              "comando inválido: \"%v\"",
+		"not a valid fallback: \"%v\"":                             "alternativa inválida: \"%v\"",
+		"not a valid ranking algorithm: \"%v\"":                    "algoritmo de classificação inválido: \"%v\"",
+		"not a valid split: \"%v\"":                                "divisão inválida: \"%v\"",
+		"profile \"%v\" is not a Kindle device: %v":                "o perfil \"%v\" não é um dispositivo Kindle: %v",
+		"query: no such job: \"%v\"":                               "consulta: tarefa inexistente: \"%v\"",
+		"resize filter: not a valid filter: \"%v\"":                "filtro de redimensionamento: filtro inválido: \"%v\"",
+		"start: job already exists: \"%v\"":                        "início: a tarefa já existe: \"%v\"",
+		"target \"%v\": %w":                                        "destino \"%v\": %w",
+		"target \"%v\": images are only written to the main output":           "destino \"%v\": imagens só são gravadas na saída principal",
+		"target \"%v\": page codec: %w":                                       "destino \"%v\": codec de página: %w",
+		"target \"%v\": subsampling, progressive and trellis need --jpeg-cmd": "destino \"%v\": subsampling, progressive e trellis precisam de --jpeg-cmd",
+		"unknown profile: \"%v\" (one of %v)":                                 "perfil desconhecido: \"%v\" (um de %v)",
+		"unknown profile: \"%v\"":                                             "perfil desconhecido: \"%v\"",
+
+		// Configuration
+		"target %v: no path":                                                          "destino %v: sem caminho",
+		"target %v: jpeg quality not between 0 and 100":                               "destino %v: qualidade jpeg não está entre 0 e 100",
+		"target %v: codec quality not between 0 and 100":                              "destino %v: qualidade do codec não está entre 0 e 100",
+		"target %v: subsampling not 4:2:0 or 4:4:4":                                   "destino %v: subsampling não é 4:2:0 nem 4:4:4",
+		"format %v: jpeg quality not between 1 and 100":                               "formato %v: qualidade jpeg não está entre 1 e 100",
+		"format %v: codec quality not between 0 and 100 (0 uses the encoder default)": "formato %v: qualidade do codec não está entre 0 e 100 (0 usa o padrão do codificador)",
+		"series %v: no id":                                                            "série %v: sem id",
+		"series %v: crop %v: %w":                                                      "série %v: corte %v: %w",
+		"requires either margins or rect":                                             "requer margins ou rect",
+		"margins: not four values":                                                    "margins: não são quatro valores",
+		"rect: not four values":                                                       "rect: não são quatro valores",
+		"rect: empty":                                                                 "rect: vazio",
+		"negative value: %v":                                                          "valor negativo: %v",
+		"not a supported provider: \"%v\"":                                            "provedor não suportado: \"%v\"",
+		"must be one of: \"auto\", \"always\", or \"never\"":                          "deve ser um de: \"auto\", \"always\" ou \"never\"",
+		"not a valid size: %v":                                                        "tamanho inválido: %v",
+
+		// Images
+		"avif: no decoder":                       "avif: sem decodificador",
+		"avif: no dimensions":                    "avif: sem dimensões",
+		"size changed since loading":             "o tamanho mudou desde o carregamento",
+		"image %v: %w":                           "imagem %v: %w",
+		"cover: %w":                              "capa: %w",
+		"%v: no encoder":                         "%v: sem codificador",
+		"%v: empty output":                       "%v: saída vazia",
+		"jpeg: options need an external encoder": "jpeg: as opções precisam de um codificador externo",
+		"jpeg: not a JPEG image":                 "jpeg: não é uma imagem JPEG",
+		"optimize: %w":                           "otimização: %w",
+		"optimize: not a JPEG image":             "otimização: não é uma imagem JPEG",
+
+		// Books
+		"record %v: %w": "registro %v: %w",
+		"must be one of: \"skip\", \"overwrite\", \"rename\", or \"update\"": "deve ser um de: \"skip\", \"overwrite\", \"rename\" ou \"update\"",
+		"must be one of: \"none\", \"fsync\", or \"write-through\"":          "deve ser um de: \"none\", \"fsync\" ou \"write-through\"",
+		"unsupported configuration: no book output":                          "configuração não suportada: nenhuma saída de livro",
+		"remove: %w":                           "remoção: %w",
+		"comic info: %w":                       "informações do quadrinho: %w",
+		"mirror '%v': %w":                      "espelhamento de '%v': %w",
+		"kfx: no converter":                    "kfx: sem conversor",
+		"staging: %w":                          "preparação: %w",
+		"write: %w (staged data kept at '%v')": "gravação: %w (dados preparados mantidos em '%v')",
+		"move: %w (staged data kept at '%v')":  "movimentação: %w (dados preparados mantidos em '%v')",
+		"file: %w":                             "arquivo: %w",
+		"sync: %w":                             "sincronização: %w",
+		"read: %w":                             "leitura: %w",
+
+		// Disk
+		"search '%v': %w":                   "busca em '%v': %w",
+		"hash '%v': %w":                     "hash de '%v': %w",
+		"count '%v': %w":                    "contagem de '%v': %w",
+		"list '%v': %w":                     "listagem de '%v': %w",
+		"archive '%v': %w":                  "arquivo compactado '%v': %w",
+		"decode '%v': %w":                   "decodificação de '%v': %w",
+		"cover for directory '%v': %w":      "capa do diretório '%v': %w",
+		"open: %w":                          "abertura: %w",
+		"open '%v': %w":                     "abertura de '%v': %w",
+		"read '%v': %w":                     "leitura de '%v': %w",
+		"stage '%v': %w":                    "preparação de '%v': %w",
+		"unsafe entry: '%v'":                "entrada insegura: '%v'",
+		"unsafe entry: '%v': symbolic link": "entrada insegura: '%v': link simbólico",
+
+		// Download
+		"must be one of: \"no\", \"prefer\", or \"fallback\"": "deve ser um de: \"no\", \"prefer\" ou \"fallback\"",
+		"not a valid IP version: \"%v\"":                      "versão de IP inválida: \"%v\"",
+		"not a valid pin: \"%v\"":                             "pin inválido: \"%v\"",
+		"not a valid SHA-256 hash: \"%v\"":                    "hash SHA-256 inválido: \"%v\"",
+		"canceled":                                            "cancelado",
+		"chapter %v: paths: %w":                               "capítulo %v: caminhos: %w",
+		"chapter %v: image %v: %w":                            "capítulo %v: imagem %v: %w",
+		"stage: %w":                                           "preparação: %w",
+		"prepare: %w":                                         "preparação da requisição: %w",
+		"do: %w":                                              "requisição: %w",
+
+		// Commands
+		"empty command":                     "comando vazio",
+		"%v: timed out after %v":            "%v: tempo esgotado após %v",
+		"%v: temporary files: %w":           "%v: arquivos temporários: %w",
+		"command ends with a backslash: %v": "o comando termina com uma barra invertida: %v",
+		"unterminated %c quote: %v":         "aspas %c não fechadas: %v",
+	},
+	language.Spanish: {
+		// Summary
+		"Title":                    "Título",
+		"Author":                   "Autor",
+		"Status":                   "Estado",
+		"Groups":                   "Grupos",
+		"Chapters":                 "Capítulos",
+		"Languages":                "Idiomas",
+		"Pages":                    "Páginas",
+		"Discontinuities":          "Discontinuidades",
+		"Ongoing":                  "En curso",
+		"Completed":                "Completado",
+		"Hiatus":                   "En pausa",
+		"Cancelled":                "Cancelado",
+		"%v (final chapter %v)":    "%v (capítulo final %v)",
+		"Chapter %v":               "Capítulo %v",
+		"Group links":              "Enlaces de grupos",
+		"Missing [%v]":             "Faltan [%v]",
+		"Ambiguous":                "Ambiguos",
+		"page":                     "página",
+		"pages":                    "páginas",
+		"%d %v, about %d min":      "%d %v, unos %d min",
+		"%d %v, about %d h":        "%d %v, unas %d h",
+		"%d %v, about %d h %d min": "%d %v, unas %d h %d min",
+
+		// Report
+		"Volume %v":      "Volumen %v",
+		"written":        "escrito",
+		"skipped":        "omitido",
+		"%v peak memory": "pico de memoria de %v",
+		"%.0f%% brightness, %.0f%% color, %.0f%% double pages": "%.0f%% de brillo, %.0f%% en color, %.0f%% de páginas dobles",
+		", %.0f JPEG quality": ", calidad JPEG %.0f",
+		"Warning":             "Advertencia",
+		"Error:":              "Error:",
+
+		"%v credit pages removed":             "%v páginas de créditos eliminadas",
+		"%v duplicate pages removed":          "%v páginas duplicadas eliminadas",
+		"Removed":                             "Eliminada",
+		"chapter %v, page %v (%v)":            "capítulo %v, página %v (%v)",
+		"chapter %v of volume %v, noticed %v": "capítulo %v del volumen %v, notado el %v",
+
+		// Progress
+		"Volume: %v": "Volumen: %v",
+		"Index":      "Índice",
+		"Details":    "Detalles",
+		"Metadata":   "Metadatos",
+		"Covers":     "Portadas",
+		"Sampling":   "Muestreo",
+		"Processing": "Procesando",
+		"Writing...": "Escribiendo...",
+		"Disk...":    "Disco...",
+		"Archive":    "Archivo",
+		"Error":      "Error",
+		"Skipped":    "Omitido",
+		"Unchanged":  "Sin cambios",
+
+		// Warnings
+		"%v: replaced by blank page: %v":                         "%v: reemplazada por una página en blanco: %v",
+		"no series matching '%v' found in '%v'":                  "no se encontró ninguna serie que coincida con '%v' en '%v'",
+		"'%v': skipped: no chapter number":                       "'%v': omitido: sin número de capítulo",
+		"'%v': skipped: contains files other than pages":         "'%v': omitido: contiene archivos que no son páginas",
+		"chapter %v: page %v: skipped: %v":                       "capítulo %v: página %v: omitida: %v",
+		"cover for directory '%v': skipped: %v":                  "portada del directorio '%v': omitida: %v",
+		"archive '%v': page %v: skipped: %v":                     "archivo '%v': página %v: omitida: %v",
+		"chapter %v: image %v: skipped: %v":                      "capítulo %v: imagen %v: omitida: %v",
+		"volume %v: no cover":                                    "volumen %v: sin portada",
+		"volume %v: chapter %v was removed from MangaDex":        "volumen %v: el capítulo %v fue eliminado de MangaDex",
+		"chapters in multiple languages: %v":                     "capítulos en varios idiomas: %v",
+		"series is completed, but final chapter %v is missing":   "la serie está completada, pero falta el capítulo final %v",
+		"%v chapters without volume moved to the Ongoing volume": "%v capítulos sin volumen movidos al volumen Ongoing",
+		"%v chapters without %v raws are not interleaved":        "%v capítulos sin raws en %v no se intercalaron",
+		"metadata: %v: %v":                                       "metadatos: %v: %v",
+		"metadata: %v: cache: %v":                                "metadatos: %v: caché: %v",
+		"kcc preset: ignored options without equivalent: %v":     "preajuste de kcc: opciones sin equivalente ignoradas: %v",
+		"page cache: %v":                                         "caché de páginas: %v",
+
+		// Library
+		"Total":                        "Total",
+		"%d series, %v, %v":            "%d series, %v, %v",
+		"%d with updates":              "%d con actualizaciones",
+		"%d volume":                    "%d volumen",
+		"%d volumes":                   "%d volúmenes",
+		"%d pages on average":          "%d páginas de media",
+		"%d chapters updated":          "%d capítulos actualizados",
+		"%d chapters removed upstream": "%d capítulos eliminados de MangaDex",
+		"up to date":                   "al día",
+		"%v: updates: %v":              "%v: actualizaciones: %v",
+
+		// Errors
+		"%v requires epub, cbz or tachiyomi": "%v requiere epub, cbz o tachiyomi",
+		"api base url: %w":                   "URL base de la API: %w",
+		"archive: %w":                        "archivo comprimido: %w",
+		"auto levels: clip percentages must be positive and below 100 in total": "niveles automáticos: los porcentajes de recorte deben ser positivos y sumar menos de 100",
+		"autocrop: %w":                   "recorte automático: %w",
+		"blocked: %w":                    "bloqueado: %w",
+		"ca file: %w":                    "archivo de CA: %w",
+		"ca file: no certificates found": "archivo de CA: no se encontró ningún certificado",
+		"cannot record and replay at the same time": "no se puede grabar y reproducir a la vez",
+		"case %v: %w":             "caso %v: %w",
+		"chapter %v: %w":          "capítulo %v: %w",
+		"chapter %v: page %v: %w": "capítulo %v: página %v: %w",
+		"chapters in multiple languages: %v (use --mixed-languages to allow)": "capítulos en varios idiomas: %v (use --mixed-languages para permitirlo)",
+		"chapters: %w": "capítulos: %w",
+		"codec quality: not between 0 and 100 (0 uses the encoder default)": "calidad del códec: no está entre 0 y 100 (0 usa el valor predeterminado del codificador)",
+		"color quality: not between 0 and 100 (0 uses the jpeg quality)":    "calidad del color: no está entre 0 y 100 (0 usa la calidad jpeg)",
+		"config: %w":                     "configuración: %w",
+		"contact sheet: %w":              "hoja de contactos: %w",
+		"cover fallback: %w":             "portada alternativa: %w",
+		"covers: %w":                     "portadas: %w",
+		"create: %w":                     "creación: %w",
+		"credit hashes: %w":              "hashes de créditos: %w",
+		"crop: %w":                       "recorte: %w",
+		"decode: %w":                     "decodificación: %w",
+		"details: %w":                    "detalles: %w",
+		"directory: %w":                  "directorio: %w",
+		"disk: %w":                       "disco: %w",
+		"encode: %w":                     "codificación: %w",
+		"executable: %w":                 "ejecutable: %w",
+		"extract '%v': %w":               "extracción de '%v': %w",
+		"extract: %w":                    "extracción: %w",
+		"fail rate: not between 0 and 1": "tasa de fallos: no está entre 0 y 1",
+		"filename collisions:\n%v":       "colisiones de nombres de archivo:\n%v",
+		"filter: %w":                     "filtro: %w",
+		"fixtures: %w":                   "datos de prueba: %w",
+		"format: %w":                     "formato: %w",
+		"format: images cannot be combined with --index or --send": "formato: images no se puede combinar con --index o --send",
+		"format: volumes are sent as azw3":                         "formato: los volúmenes se envían como azw3",
+		"golden mismatch:\n%v":                                     "discrepancia con la referencia:\n%v",
+		"golden: %w":                                               "referencia: %w",
+		"gray levels: not between 2 and 256":                       "niveles de gris: no está entre 2 y 256",
+		"index: %w":                                                "índice: %w",
+		"input: %w":                                                "entrada: %w",
+		"interrupted":                                              "interrumpido",
+		"io workers: must be at least 1":                           "procesos de E/S: debe ser al menos 1",
+		"ip version: %w":                                           "versión de IP: %w",
+		"jpeg quality: not between 1 and 100":                      "calidad jpeg: no está entre 1 y 100",
+		"kcc preset: %w":                                           "preajuste de kcc: %w",
+		"kindle folder mode requires azw3 or kfx":                  "el modo de carpeta de Kindle requiere azw3 o kfx",
+		"lang: %w":                                                 "idioma: %w",
+		"language: %w":                                             "idioma: %w",
+		"library: %w":                                              "biblioteca: %w",
+		"line %v: not a valid hash: %q":                            "línea %v: no es un hash válido: %q",
+		"main cover: %w":                                           "portada principal: %w",
+		"malformed line: %q":                                       "línea mal formada: %q",
+		"manifest: %w":                                             "manifiesto: %w",
+		"metadata: %w":                                             "metadatos: %w",
+		"modification time: %w":                                    "fecha de modificación: %w",
+		"no directory given":                                       "no se indicó ningún directorio",
+		"no output directory given":                                "no se indicó ningún directorio de salida",
+		"no profile":                                               "ningún perfil",
+		"not a supported codec: %v":                                "códec no compatible: %v",
+		"nothing left of %vx%v page":                               "no queda nada de la página de %vx%v",
+		"output: %w":                                               "salida: %w",
+		"output: no kfx file written":                              "salida: no se escribió ningún archivo kfx",
+		"outside of archive":                                       "fuera del archivo comprimido",
+		"page codec: %w":                                           "códec de página: %w",
+		"page template: %w":                                        "plantilla de página: %w",
+		"page cache links: no page cache given":                    "enlaces de la caché de páginas: no se indicó ninguna caché de páginas",
+		"pages: %w":                                                "páginas: %w",
+		"paths: %w":                                                "rutas: %w",
+		"plan: line %v: %w":                                        "plan: línea %v: %w",
+		"plan: line %v: malformed: %q":                             "plan: línea %v: mal formada: %q",
+		"process: %w":                                              "procesamiento: %w",
+		"profile: %w":                                              "perfil: %w",
+		"progress socket: %w":                                      "socket de progreso: %w",
+		"raw pages: %w":                                            "páginas raw: %w",
+		"raw paths: %w":                                            "rutas raw: %w",
+		"replay: %w":                                               "reproducción: %w",
+		"resize: %w":                                               "redimensionado: %w",
+		"resize: maximum sizes must be positive":                   "redimensionado: los tamaños máximos deben ser positivos",
+		"search: %w":                                               "búsqueda: %w",
+		"selftest: %w":                                             "autoprueba: %w",
+		"send only: cannot be combined with --out":                 "solo envío: no se puede combinar con --out",
+		"send only: no device given":                               "solo envío: no se indicó ningún dispositivo",
+		"series settings: %w":                                      "ajustes de la serie: %w",
+		"session: %w":                                              "sesión: %w",
+		"sharpen: amount and radius must be positive":              "nitidez: la intensidad y el radio deben ser positivos",
+		"skeleton: %w":                                             "esqueleto: %w",
+		"staging pages: %w":                                        "preparación de las páginas: %w",
+		"start: %w":                                                "inicio: %w",
+		"start: no job identifier given":                           "inicio: no se indicó ningún identificador de tarea",
+		"status: %v":                                               "estado: %v",
+		"strict: %v warnings":                                      "estricto: %v advertencias",
+		"strict: missing chapters: %v":                             "estricto: capítulos faltantes: %v",
+		"style: %w":                                                "estilo: %w",
+		"targets: %w":                                              "destinos: %w",
+		"temporary directory: %w":                                  "directorio temporal: %w",
+		"unexpected argument: %v":                                  "argumento inesperado: %v",
+		"unknown key: %v":                                          "clave desconocida: %v",
+		"unpublished chapters: no access token in %v":              "capítulos no publicados: ningún token de acceso en %v",
+		"upscale: %w":                                              "ampliación: %w",
+		"volume %v: %w":                                            "volumen %v: %w",
+		"volume %v: pages: %w":                                     "volumen %v: páginas: %w",
+		"volume %v: process: %w":                                   "volumen %v: procesamiento: %w",
+		"volume %v: write: %w":                                     "volumen %v: escritura: %w",
+		"volume map: %w":                                           "mapa de volúmenes: %w",
+		"write: %w":                                                "escritura: %w",
+		"cancel: job not running: \"%v\"":                          "cancelación: la tarea no está en ejecución: \"%v\"",
+		"cancel: no such job: \"%v\"":                              "cancelación: no existe la tarea: \"%v\"",
+		"dither: not a valid method: \"%v\"":                       "tramado: método no válido: \"%v\"",
+		"format \"%v\" is not supported":                           "el formato \"%v\" no es compatible",
+		"format \"%v\": %w":                                        "formato \"%v\": %w",
+		"format \"%v\": page codec: %w":                            "formato \"%v\": códec de página: %w",
+		"not a positive size: \"%v\"":                              "no es un tamaño positivo: \"%v\"",
+		"not a size: \"%v\"":                                       "no es un tamaño: \"%v\"",
+		"not a supported codec: \"%v\"":                            "códec no compatible: \"%v\"",
+		"not a supported format: \"%v\"":                           "formato no compatible: \"%v\"",
+		"not a valid collision policy: \"%v\"":                     "política de colisión no válida: \"%v\"",
+		"not a valid command: \"%v\"":                              "comando no válido: \"%v\"",
+		"not a valid fallback: \"%v\"":                             "alternativa no válida: \"%v\"",
+		"not a valid ranking algorithm: \"%v\"":                    "algoritmo de clasificación no válido: \"%v\"",
+		"not a valid split: \"%v\"":                                "división no válida: \"%v\"",
+		"profile \"%v\" is not a Kindle device: %v":                "el perfil \"%v\" no es un dispositivo Kindle: %v",
+		"query: no such job: \"%v\"":                               "consulta: no existe la tarea: \"%v\"",
+		"resize filter: not a valid filter: \"%v\"":                "filtro de redimensionado: filtro no válido: \"%v\"",
+		"start: job already exists: \"%v\"":                        "inicio: la tarea ya existe: \"%v\"",
+		"target \"%v\": %w":                                        "destino \"%v\": %w",
+		"target \"%v\": images are only written to the main output":           "destino \"%v\": las imágenes solo se escriben en la salida principal",
+		"target \"%v\": page codec: %w":                                       "destino \"%v\": códec de página: %w",
+		"target \"%v\": subsampling, progressive and trellis need --jpeg-cmd": "destino \"%v\": subsampling, progressive y trellis necesitan --jpeg-cmd",
+		"unknown profile: \"%v\" (one of %v)":                                 "perfil desconocido: \"%v\" (uno de %v)",
+		"unknown profile: \"%v\"":                                             "perfil desconocido: \"%v\"",
+
+		// Configuration
+		"target %v: no path":                                                          "destino %v: sin ruta",
+		"target %v: jpeg quality not between 0 and 100":                               "destino %v: calidad jpeg no está entre 0 y 100",
+		"target %v: codec quality not between 0 and 100":                              "destino %v: calidad del códec no está entre 0 y 100",
+		"target %v: subsampling not 4:2:0 or 4:4:4":                                   "destino %v: subsampling no es 4:2:0 ni 4:4:4",
+		"format %v: jpeg quality not between 1 and 100":                               "formato %v: calidad jpeg no está entre 1 y 100",
+		"format %v: codec quality not between 0 and 100 (0 uses the encoder default)": "formato %v: calidad del códec no está entre 0 y 100 (0 usa el valor predeterminado del codificador)",
+		"series %v: no id":                                                            "serie %v: sin id",
+		"series %v: crop %v: %w":                                                      "serie %v: recorte %v: %w",
+		"requires either margins or rect":                                             "requiere margins o rect",
+		"margins: not four values":                                                    "margins: no son cuatro valores",
+		"rect: not four values":                                                       "rect: no son cuatro valores",
+		"rect: empty":                                                                 "rect: vacío",
+		"negative value: %v":                                                          "valor negativo: %v",
+		"not a supported provider: \"%v\"":                                            "proveedor no compatible: \"%v\"",
+		"must be one of: \"auto\", \"always\", or \"never\"":                          "debe ser uno de: \"auto\", \"always\" o \"never\"",
+		"not a valid size: %v":                                                        "tamaño no válido: %v",
+
+		// Images
+		"avif: no decoder":                       "avif: sin decodificador",
+		"avif: no dimensions":                    "avif: sin dimensiones",
+		"size changed since loading":             "el tamaño cambió desde la carga",
+		"image %v: %w":                           "imagen %v: %w",
+		"cover: %w":                              "portada: %w",
+		"%v: no encoder":                         "%v: sin codificador",
+		"%v: empty output":                       "%v: salida vacía",
+		"jpeg: options need an external encoder": "jpeg: las opciones necesitan un codificador externo",
+		"jpeg: not a JPEG image":                 "jpeg: no es una imagen JPEG",
+		"optimize: %w":                           "optimización: %w",
+		"optimize: not a JPEG image":             "optimización: no es una imagen JPEG",
+
+		// Books
+		"record %v: %w": "registro %v: %w",
+		"must be one of: \"skip\", \"overwrite\", \"rename\", or \"update\"": "debe ser uno de: \"skip\", \"overwrite\", \"rename\" o \"update\"",
+		"must be one of: \"none\", \"fsync\", or \"write-through\"":          "debe ser uno de: \"none\", \"fsync\" o \"write-through\"",
+		"unsupported configuration: no book output":                          "configuración no compatible: ninguna salida de libro",
+		"remove: %w":                           "eliminación: %w",
+		"comic info: %w":                       "información del cómic: %w",
+		"mirror '%v': %w":                      "réplica de '%v': %w",
+		"kfx: no converter":                    "kfx: sin conversor",
+		"staging: %w":                          "preparación: %w",
+		"write: %w (staged data kept at '%v')": "escritura: %w (datos preparados conservados en '%v')",
+		"move: %w (staged data kept at '%v')":  "movimiento: %w (datos preparados conservados en '%v')",
+		"file: %w":                             "archivo: %w",
+		"sync: %w":                             "sincronización: %w",
+		"read: %w":                             "lectura: %w",
+
+		// Disk
+		"search '%v': %w":                   "búsqueda en '%v': %w",
+		"hash '%v': %w":                     "hash de '%v': %w",
+		"count '%v': %w":                    "conteo de '%v': %w",
+		"list '%v': %w":                     "listado de '%v': %w",
+		"archive '%v': %w":                  "archivo comprimido '%v': %w",
+		"decode '%v': %w":                   "decodificación de '%v': %w",
+		"cover for directory '%v': %w":      "portada del directorio '%v': %w",
+		"open: %w":                          "apertura: %w",
+		"open '%v': %w":                     "apertura de '%v': %w",
+		"read '%v': %w":                     "lectura de '%v': %w",
+		"stage '%v': %w":                    "preparación de '%v': %w",
+		"unsafe entry: '%v'":                "entrada insegura: '%v'",
+		"unsafe entry: '%v': symbolic link": "entrada insegura: '%v': enlace simbólico",
+
+		// Download
+		"must be one of: \"no\", \"prefer\", or \"fallback\"": "debe ser uno de: \"no\", \"prefer\" o \"fallback\"",
+		"not a valid IP version: \"%v\"":                      "versión de IP no válida: \"%v\"",
+		"not a valid pin: \"%v\"":                             "pin no válido: \"%v\"",
+		"not a valid SHA-256 hash: \"%v\"":                    "hash SHA-256 no válido: \"%v\"",
+		"canceled":                                            "cancelado",
+		"chapter %v: paths: %w":                               "capítulo %v: rutas: %w",
+		"chapter %v: image %v: %w":                            "capítulo %v: imagen %v: %w",
+		"download: %w":                                        "descarga: %w",
+		"stage: %w":                                           "preparación: %w",
+		"prepare: %w":                                         "preparación de la solicitud: %w",
+		"do: %w":                                              "solicitud: %w",
+
+		// Commands
+		"empty command":                     "comando vacío",
+		"%v: timed out after %v":            "%v: se agotó el tiempo tras %v",
+		"%v: temporary files: %w":           "%v: archivos temporales: %w",
+		"command ends with a backslash: %v": "el comando termina con una barra invertida: %v",
+		"unterminated %c quote: %v":         "comillas %c sin cerrar: %v",
+	},
+}
+
+var messages = matchMessages(localeFromEnv("LC_ALL", "LC_MESSAGES", "LANG"))
+
+// SetLanguage changes the language of user-facing messages, instead of
+// using the locale of the environment.
+func SetLanguage(lang string) error {
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return err
+	}
+	messages = matchMessages(tag)
+
+	return nil
+}
+
+func matchMessages(tag language.Tag) map[string]string {
+	matcher := language.NewMatcher(messageLanguages)
+	if _, index, confidence := matcher.Match(tag); confidence != language.No {
+		return translations[messageLanguages[index]]
+	}
+
+	return nil
+}
+
+// Translate returns the message in the selected language.  Format
+// strings are translated before arguments are substituted, e.g.
+// fmt.Sprintf(Translate("Volume %v"), id).
+func Translate(message string) string {
+	if translated, ok := messages[message]; ok {
+		return translated
+	}
+
+	return message
+}
+
+// Translations returns the message and its translations to all
+// supported languages, e.g. to recognize output of other processes.
+func Translations(message string) []string {
+	result := []string{message}
+	for _, messages := range translations {
+		if translated, ok := messages[message]; ok {
+			result = append(result, translated)
+		}
+	}
+
+	return result
+}
+
+// Errorf is like fmt.Errorf, but with the format translated to the
+// selected language, e.g. "jpeg quality: not between 1 and 100".
+// Formats are translated as a whole, so that every message reads in a
+// single language.  Arguments like wrapped errors and paths are never
+// translated, but errors wrapped from Kojirou itself already are.
+func Errorf(format string, args ...interface{}) error {
+	// Untranslated formats are passed on as they are, which lets vet
+	// check calls like those of fmt.Errorf
+	if translated := Translate(format); translated != format {
+		return fmt.Errorf(translated, args...)
+	}
+
+	return fmt.Errorf(format, args...)
+}
@@ -0,0 +1,31 @@
+package formats
+
+import (
+	"image"
+	"sync"
+)
+
+// Pixels of images that are only needed while a page is processed or
+// encoded are reused, so large volumes do not allocate and collect
+// megabytes of pixels for every page.  Pages of a volume mostly have
+// the same size, so buffers are rarely too small to be reused.
+var pixelPool sync.Pool
+
+// NewGray returns a grayscale image with the given bounds, whose pixels
+// may be left over from an image given to ReleaseGray.  Callers must
+// overwrite all pixels before reading them.
+func NewGray(r image.Rectangle) *image.Gray {
+	size := r.Dx() * r.Dy()
+	if buf, ok := pixelPool.Get().(*[]uint8); ok && cap(*buf) >= size {
+		return &image.Gray{Pix: (*buf)[:size], Stride: r.Dx(), Rect: r}
+	}
+
+	return image.NewGray(r)
+}
+
+// ReleaseGray allows the pixels of the image to be reused.  The image
+// must not be used afterwards.
+func ReleaseGray(img *image.Gray) {
+	buf := img.Pix[:0]
+	pixelPool.Put(&buf)
+}
@@ -1,22 +1,70 @@
 package formats
 
 import (
+	"fmt"
 	"io"
 
 	"github.com/cheggaaa/pb/v3"
+	"github.com/cheggaaa/pb/v3/termutil"
+	"github.com/fatih/color"
 )
 
 const (
+	progressBar      = `{{ bar . "|" "█" "▌" " " "|" }}`
+	progressBarASCII = `{{ bar . "|" "#" ">" " " "|" }}`
 	progressTemplate = `` +
-		`{{ string . "prefix" | printf "%-10v" }}` +
-		`{{ bar . "|" "█" "▌" " " "|" }}` + `{{ " " }}` +
+		`{{ string . "prefix" }}` +
+		`%v` + `{{ " " }}` +
 		`{{ if string . "message" }}` +
-		`{{   string . "message" | printf "%-15v" }}` +
+		`{{   status . }}` +
 		`{{ else }}` +
-		`{{   counters . | printf "%-15v" }}` +
+		`{{   localizedCounters . | printf "%%-15v" }}` +
 		`{{ end }}` + `{{ " |" }}`
 )
 
+func init() {
+	pb.RegisterElement("localizedCounters", pb.ElementFunc(func(state *pb.State, args ...string) string {
+		format := func(v int64) string {
+			if state.GetBool(pb.Bytes) {
+				return FormatBytes(uint64(v))
+			}
+			return printer.Sprint(v)
+		}
+
+		if state.Total() > 0 {
+			return format(state.Value()) + " / " + format(state.Total())
+		}
+		return format(state.Value())
+	}), false)
+	pb.RegisterElement("status", pb.ElementFunc(func(state *pb.State, args ...string) string {
+		message, _ := state.Get("message").(string)
+		padded := padRight(Translate(message), 15)
+		if c, ok := statusColors[message]; ok {
+			return c.Sprint(padded)
+		}
+		return padded
+	}), false)
+}
+
+func newBar(title string) *pb.ProgressBar {
+	bar := progressBar
+	if ASCII || legacyConsole {
+		bar = progressBarASCII
+	}
+
+	result := pb.New(0).
+		SetTemplate(pb.ProgressBarTemplate(fmt.Sprintf(progressTemplate, bar))).
+		Set("prefix", padRight(Translate(title), 10)).
+		Set("title", title).
+		Set("id", nextEventID()).
+		Set(pb.Color, !color.NoColor)
+	if width, err := termutil.TerminalWidth(); legacyConsole && err == nil {
+		result.SetWidth(width - 1)
+	}
+
+	return result
+}
+
 type Progress interface {
 	Increase(int)
 	Add(int)
@@ -26,14 +74,18 @@ type Progress interface {
 type CliProgress struct {
 	bar       *pb.ProgressBar
 	firstCall bool
+	// Shared progress belongs to a summarized group, which finishes it
+	shared bool
 }
 
 func (p CliProgress) Increase(n int) {
 	p.bar.AddTotal(int64(n))
+	emitProgress("progress", p.bar)
 }
 
 func (p CliProgress) Add(n int) {
 	p.bar.Add(n)
+	emitProgress("progress", p.bar)
 }
 
 func (p CliProgress) NewProxyWriter(w io.Writer) io.Writer {
@@ -41,28 +93,44 @@ func (p CliProgress) NewProxyWriter(w io.Writer) io.Writer {
 }
 
 func (p CliProgress) Done() {
+	if p.shared {
+		return
+	}
 	p.bar.Finish()
+	if p.bar.Get("message") == nil {
+		emitProgress("done", p.bar)
+	}
 }
 
 func (p *CliProgress) Cancel(message string) {
+	if p.shared {
+		return
+	}
 	p.bar.Set("message", message)
 	p.bar.SetTotal(1).SetCurrent(1)
 	p.Done()
+	emitProgress("cancel", p.bar)
 }
 
 func TitledProgress(title string) CliProgress {
-	bar := pb.New(0).SetTemplate(progressTemplate)
-	bar.Set("prefix", title)
+	if g := currentGroup(); g != nil {
+		return g.progress(title, false)
+	}
+	bar := newBar(title)
 	bar.Start()
+	emitProgress("start", bar)
 
-	return CliProgress{bar, true}
+	return CliProgress{bar: bar, firstCall: true}
 }
 
 func VanishingProgress(title string) CliProgress {
-	bar := pb.New(0).SetTemplate(progressTemplate)
-	bar.Set("prefix", title)
+	if g := currentGroup(); g != nil {
+		return g.progress(title, true)
+	}
+	bar := newBar(title)
 	bar.Set(pb.CleanOnFinish, true)
 	bar.Start()
+	emitProgress("start", bar)
 
-	return CliProgress{bar, true}
+	return CliProgress{bar: bar, firstCall: true}
 }
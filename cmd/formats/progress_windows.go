@@ -0,0 +1,26 @@
+//go:build windows
+// +build windows
+
+package formats
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// legacyConsole reports whether progress is written to a console that
+// does not support escape sequences, even after asking for them.
+// Such consoles also render block glyphs as garbage and move the
+// cursor to the next line after writing the last column.
+var legacyConsole = func() bool {
+	handle := windows.Handle(os.Stderr.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return false
+	} else if mode&windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING != 0 {
+		return false
+	}
+
+	return windows.SetConsoleMode(handle, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING) != nil
+}()
@@ -0,0 +1,94 @@
+package formats
+
+import "math"
+
+// The luminance quantization table suggested by the JPEG standard in
+// zigzag order.  Encoders derive their tables by scaling it according
+// to the quality setting.
+var standardLuminance = [64]int{
+	16, 11, 12, 14, 12, 10, 16, 14,
+	13, 14, 18, 17, 16, 19, 24, 40,
+	26, 24, 22, 22, 24, 49, 35, 37,
+	29, 40, 58, 51, 61, 60, 57, 51,
+	56, 55, 64, 72, 92, 78, 64, 68,
+	87, 69, 55, 56, 80, 109, 81, 87,
+	95, 98, 103, 104, 103, 62, 77, 113,
+	121, 112, 100, 120, 92, 101, 103, 99,
+}
+
+// jpegQuality estimates the quality setting a JPEG image was encoded
+// with by comparing its luminance table against the standard table,
+// inverting the scaling used by libjpeg.  Zero means unknown.
+func jpegQuality(header []byte) int {
+	table, ok := luminanceTable(header)
+	if !ok {
+		return 0
+	}
+
+	scale := 0.0
+	for i, value := range table {
+		scale += float64(value) * 100 / float64(standardLuminance[i])
+	}
+	scale /= float64(len(table))
+
+	quality := 5000 / scale
+	if scale <= 100 {
+		quality = (200 - scale) / 2
+	}
+
+	return int(math.Round(math.Max(1, math.Min(100, quality))))
+}
+
+// luminanceTable finds the first quantization table in the segments
+// preceding the image data, which is used for luminance by convention.
+func luminanceTable(data []byte) ([64]int, bool) {
+	table := [64]int{}
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return table, false
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return table, false
+		}
+		switch marker := data[i+1]; {
+		case marker == 0xff:
+			i++
+			continue
+		case marker == 0xda:
+			return table, false
+		case marker != 0xdb:
+			i += 2 + (int(data[i+2])<<8 | int(data[i+3]))
+			continue
+		}
+
+		end := i + 2 + (int(data[i+2])<<8 | int(data[i+3]))
+		if end > len(data) {
+			return table, false
+		}
+		for segment := data[i+4 : end]; len(segment) > 0; {
+			wide, id := segment[0]>>4 == 1, segment[0]&0x0f
+			size := 64
+			if wide {
+				size = 128
+			}
+			if len(segment) < 1+size {
+				return table, false
+			}
+			if id == 0 {
+				for k := range table {
+					if wide {
+						table[k] = int(segment[1+2*k])<<8 | int(segment[2+2*k])
+					} else {
+						table[k] = int(segment[1+k])
+					}
+				}
+				return table, true
+			}
+			segment = segment[1+size:]
+		}
+		i = end
+	}
+
+	return table, false
+}
@@ -0,0 +1,33 @@
+package formats
+
+import "time"
+
+// Readers spend about this long on an average manga page.
+const timePerPage = 20 * time.Second
+
+func ReadingTime(pages int) time.Duration {
+	return time.Duration(pages) * timePerPage
+}
+
+// FormatPages describes the number of pages and the time needed to
+// read them, e.g. "1,234 pages, about 6 h 51 min".
+func FormatPages(pages int) string {
+	minutes := int(ReadingTime(pages).Round(time.Minute) / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+
+	unit := Translate("pages")
+	if pages == 1 {
+		unit = Translate("page")
+	}
+
+	switch {
+	case minutes < 60:
+		return printer.Sprintf(Translate("%d %v, about %d min"), pages, unit, minutes)
+	case minutes%60 == 0:
+		return printer.Sprintf(Translate("%d %v, about %d h"), pages, unit, minutes/60)
+	default:
+		return printer.Sprintf(Translate("%d %v, about %d h %d min"), pages, unit, minutes/60, minutes%60)
+	}
+}
@@ -0,0 +1,84 @@
+package formats
+
+import (
+	"fmt"
+	"strings"
+
+	md "github.com/leotaku/kojirou/mangadex"
+)
+
+type VolumeReport struct {
+	Identifier md.Identifier
+	// Only set when volumes are split by group
+	Group      string
+	Skipped    bool
+	Pages      int
+	Stats      PageStats
+	PeakMemory uint64
+	// Pages removed as credits of scantlation groups
+	Credits []CreditPage
+	// Number of pages removed as duplicates of other pages
+	Duplicates int
+}
+
+// CreditPage identifies a removed credit page.  The hash can be added
+// to the list of known credit pages.
+type CreditPage struct {
+	Chapter md.Identifier
+	Page    int
+	Hash    string
+}
+
+func PrintReport(reports []VolumeReport) {
+	for _, report := range reports {
+		name := fmt.Sprintf(Translate("Volume %v"), report.Identifier)
+		if report.Group != "" {
+			name += fmt.Sprintf(" [%v]", report.Group)
+		}
+		printValue(name, formatReport(report))
+		for _, credit := range report.Credits {
+			printValue("Removed", fmt.Sprintf(Translate("chapter %v, page %v (%v)"), credit.Chapter, credit.Page, credit.Hash))
+		}
+	}
+}
+
+func formatReport(report VolumeReport) string {
+	parts := make([]string, 0)
+	if report.Skipped {
+		parts = append(parts, warningColor.Sprint(Translate("skipped")))
+	} else {
+		parts = append(parts, successColor.Sprint(Translate("written")))
+	}
+	if report.Pages > 0 {
+		parts = append(parts, FormatPages(report.Pages))
+	}
+	if stats := report.Stats; stats.Pages > 0 {
+		parts = append(parts, formatStats(stats))
+	}
+	if len(report.Credits) > 0 {
+		parts = append(parts, fmt.Sprintf(Translate("%v credit pages removed"), len(report.Credits)))
+	}
+	if report.Duplicates > 0 {
+		parts = append(parts, fmt.Sprintf(Translate("%v duplicate pages removed"), report.Duplicates))
+	}
+	if report.PeakMemory > 0 {
+		parts = append(parts, fmt.Sprintf(Translate("%v peak memory"), FormatBytes(report.PeakMemory)))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func formatStats(stats PageStats) string {
+	percent := func(n int) float64 {
+		return float64(n) * 100 / float64(stats.Pages)
+	}
+
+	result := printer.Sprintf(Translate("%.0f%% brightness, %.0f%% color, %.0f%% double pages"),
+		stats.Brightness*100, percent(stats.Color), percent(stats.Spreads),
+	)
+	if stats.Quality > 0 {
+		result += printer.Sprintf(Translate(", %.0f JPEG quality"), stats.Quality)
+	}
+
+	return result
+}
@@ -0,0 +1,267 @@
+package formats
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// The pixel loops that take most of the time of processing pages have
+// fast paths using SIMD instructions on processors that support them.
+// Fast paths produce exactly the same pixels as the portable code, so
+// they only need to be disabled to rule them out when debugging.
+var simdEnabled = haveSIMD()
+
+// DisableSIMD makes all following image operations use portable code.
+func DisableSIMD() {
+	simdEnabled = false
+}
+
+// ConvertGray draws the image onto the grayscale image of the same
+// bounds, like draw.Draw with draw.Src.
+func ConvertGray(dst *image.Gray, src image.Image) {
+	src = Decoded(src)
+	bounds := dst.Bounds()
+	if !simdEnabled || !bounds.In(src.Bounds()) {
+		draw.Draw(dst, bounds, src, bounds.Min, draw.Src)
+		return
+	}
+
+	switch src := src.(type) {
+	case *image.Gray:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			copy(dst.Pix[dst.PixOffset(bounds.Min.X, y):][:bounds.Dx()], src.Pix[src.PixOffset(bounds.Min.X, y):])
+		}
+	case *image.RGBA:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			row := dst.Pix[dst.PixOffset(bounds.Min.X, y):][:bounds.Dx()]
+			grayRGBARow(row, src.Pix[src.PixOffset(bounds.Min.X, y):])
+		}
+	case *image.YCbCr:
+		// Chroma is repeated for every pixel it belongs to, so that
+		// all subsample ratios share the same fast path
+		cb, cr := make([]uint8, bounds.Dx()), make([]uint8, bounds.Dx())
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := range cb {
+				ci := src.COffset(bounds.Min.X+x, y)
+				cb[x], cr[x] = src.Cb[ci], src.Cr[ci]
+			}
+			row := dst.Pix[dst.PixOffset(bounds.Min.X, y):][:bounds.Dx()]
+			grayYCbCrRow(row, src.Y[src.YOffset(bounds.Min.X, y):], cb, cr)
+		}
+	default:
+		draw.Draw(dst, bounds, src, bounds.Min, draw.Src)
+	}
+}
+
+// ApplyLookup replaces every color channel of the image by its value
+// in the table, e.g. to apply gamma or levels.  Alpha is kept.
+func ApplyLookup(img image.Image, table *[256]uint8) {
+	switch img := img.(type) {
+	case *image.Gray:
+		lookupRows(img.Pix, img.Stride, img.Rect.Dx(), img.Rect.Dy(), 1, table)
+	case *image.RGBA:
+		lookupRows(img.Pix, img.Stride, img.Rect.Dx()*4, img.Rect.Dy(), 4, table)
+	}
+}
+
+func lookupRows(pix []uint8, stride, width, height, channels int, table *[256]uint8) {
+	for y := 0; y < height; y++ {
+		if simdEnabled {
+			lookupRow(pix[y*stride:][:width], channels, table)
+		} else {
+			lookupGeneric(pix[y*stride:][:width], channels, table)
+		}
+	}
+}
+
+// AddWeighted adds the values of src multiplied by the weight to dst,
+// which is the inner loop of blurs and resampling.
+func AddWeighted(dst, src []float64, weight float64) {
+	if simdEnabled {
+		addWeightedRow(dst, src[:len(dst)], weight)
+	} else {
+		addWeightedGeneric(dst, src, weight)
+	}
+}
+
+// ScaleGray resamples the grayscale image to the bounds of dst using
+// the kernel, like the Scale method of the kernel with draw.Src.
+// Resampling both directions of the page in one buffer of a single
+// channel is much faster than the generic code for grayscale pages.
+func ScaleGray(dst, src *image.Gray, kernel *draw.Kernel) {
+	if !simdEnabled {
+		kernel.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+		return
+	}
+
+	dw, dh := dst.Rect.Dx(), dst.Rect.Dy()
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	if dw <= 0 || dh <= 0 || sw <= 0 || sh <= 0 {
+		return
+	}
+	horizontal, vertical := newDistrib(kernel, dw, sw), newDistrib(kernel, dh, sh)
+
+	// Columns are resampled into a temporary image as wide as dst and
+	// as high as src, whose rows are then resampled into dst
+	tmp := make([]float64, dw*sh)
+	for y := 0; y < sh; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, s := range horizontal.sources {
+			var pr float64
+			for _, c := range horizontal.contribs[s.i:s.j] {
+				pr += float64(uint32(row[c.coord])*0x101) * c.weight
+			}
+			tmp[y*dw+x] = pr * s.invTotalWeightFFFF
+		}
+	}
+
+	sum := make([]float64, dw)
+	for y, s := range vertical.sources {
+		for x := range sum {
+			sum[x] = 0
+		}
+		var pa float64
+		for _, c := range vertical.contribs[s.i:s.j] {
+			AddWeighted(sum, tmp[c.coord*dw:], c.weight)
+			pa += c.weight
+		}
+		row := dst.Pix[y*dst.Stride:][:dw]
+		for x, pr := range sum {
+			if pr > pa {
+				pr = pa
+			}
+			row[x] = uint8(ftou(pr*s.invTotalWeight) >> 8)
+		}
+	}
+}
+
+// The following mirrors the weights computed by the kernels of
+// golang.org/x/image/draw, so that scaled pages are identical.
+
+type source struct {
+	i, j               int
+	invTotalWeight     float64
+	invTotalWeightFFFF float64
+}
+
+type contrib struct {
+	coord  int
+	weight float64
+}
+
+type distrib struct {
+	sources  []source
+	contribs []contrib
+}
+
+func newDistrib(q *draw.Kernel, dw, sw int) distrib {
+	scale := float64(sw) / float64(dw)
+	halfWidth, kernelArgScale := q.Support, 1.0
+	if scale > 1 {
+		halfWidth *= scale
+		kernelArgScale = 1 / scale
+	}
+
+	n, sources := 0, make([]source, dw)
+	for x := range sources {
+		center := (float64(x)+0.5)*scale - 0.5
+		i := int(math.Floor(center - halfWidth))
+		if i < 0 {
+			i = 0
+		}
+		j := int(math.Ceil(center + halfWidth))
+		if j > sw {
+			j = sw
+			if j < i {
+				j = i
+			}
+		}
+		sources[x] = source{i: i, j: j, invTotalWeight: center}
+		n += j - i
+	}
+
+	contribs := make([]contrib, 0, n)
+	for k, b := range sources {
+		totalWeight := 0.0
+		l := len(contribs)
+		for coord := b.i; coord < b.j; coord++ {
+			t := (b.invTotalWeight - float64(coord)) * kernelArgScale
+			if t < 0 {
+				t = -t
+			}
+			if t >= q.Support {
+				continue
+			}
+			weight := q.At(t)
+			if weight == 0 {
+				continue
+			}
+			totalWeight += weight
+			contribs = append(contribs, contrib{coord, weight})
+		}
+		totalWeight = 1 / totalWeight
+		sources[k] = source{
+			i:                  l,
+			j:                  len(contribs),
+			invTotalWeight:     totalWeight,
+			invTotalWeightFFFF: totalWeight / 0xffff,
+		}
+	}
+
+	return distrib{sources, contribs}
+}
+
+func ftou(f float64) uint16 {
+	i := int32(0xffff*f + 0.5)
+	if i > 0xffff {
+		return 0xffff
+	} else if i > 0 {
+		return uint16(i)
+	}
+
+	return 0
+}
+
+// Portable versions of the row loops, used for the pixels left over by
+// the fast paths and on other processors.
+
+func grayRGBAGeneric(dst, src []uint8) {
+	for i := range dst {
+		p := src[i*4 : i*4+3]
+		r, g, b := uint32(p[0])*0x101, uint32(p[1])*0x101, uint32(p[2])*0x101
+		dst[i] = uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 24)
+	}
+}
+
+func grayYCbCrGeneric(dst, y, cb, cr []uint8) {
+	clamp := func(v int32) uint32 {
+		if uint32(v)&0xff000000 == 0 {
+			return uint32(v >> 8)
+		}
+		return uint32(^(v >> 31) & 0xffff)
+	}
+	for i := range dst {
+		yy1 := int32(y[i]) * 0x10101
+		cb1, cr1 := int32(cb[i])-128, int32(cr[i])-128
+		r := clamp(yy1 + 91881*cr1)
+		g := clamp(yy1 - 22554*cb1 - 46802*cr1)
+		b := clamp(yy1 + 116130*cb1)
+		dst[i] = uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 24)
+	}
+}
+
+func lookupGeneric(row []uint8, channels int, table *[256]uint8) {
+	for i, v := range row {
+		if channels == 1 || i%channels != 3 {
+			row[i] = table[v]
+		}
+	}
+}
+
+func addWeightedGeneric(dst, src []float64, weight float64) {
+	for i, v := range src[:len(dst)] {
+		dst[i] += weight * v
+	}
+}
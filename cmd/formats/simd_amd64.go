@@ -0,0 +1,72 @@
+//go:build amd64 && !purego
+// +build amd64,!purego
+
+package formats
+
+import "golang.org/x/sys/cpu"
+
+func haveSIMD() bool {
+	return cpu.X86.HasAVX2
+}
+
+// Implemented in simd_amd64.s, processing only whole blocks of 8
+// pixels, or 32 bytes for lookups and 4 values for weighted sums.
+
+//go:noescape
+func grayRGBAAVX2(dst, src *uint8, n int)
+
+//go:noescape
+func grayYCbCrAVX2(dst, y, cb, cr *uint8, n int)
+
+//go:noescape
+func lookupAVX2(row *uint8, n int, table *[256]uint8, keep *[32]uint8)
+
+//go:noescape
+func addWeightedAVX2(dst, src *float64, n int, weight float64)
+
+// Bytes kept by lookups, which are the alpha bytes of RGBA pixels
+var (
+	keepNone  = [32]uint8{}
+	keepAlpha = [32]uint8{
+		3: 0xff, 7: 0xff, 11: 0xff, 15: 0xff, 19: 0xff, 23: 0xff, 27: 0xff, 31: 0xff,
+	}
+)
+
+func grayRGBARow(dst, src []uint8) {
+	n := len(dst) &^ 7
+	if n > 0 {
+		_ = src[n*4-1]
+		grayRGBAAVX2(&dst[0], &src[0], n)
+	}
+	grayRGBAGeneric(dst[n:], src[n*4:])
+}
+
+func grayYCbCrRow(dst, y, cb, cr []uint8) {
+	n := len(dst) &^ 7
+	if n > 0 {
+		_, _, _ = y[n-1], cb[n-1], cr[n-1]
+		grayYCbCrAVX2(&dst[0], &y[0], &cb[0], &cr[0], n)
+	}
+	grayYCbCrGeneric(dst[n:], y[n:], cb[n:], cr[n:])
+}
+
+func lookupRow(row []uint8, channels int, table *[256]uint8) {
+	n := len(row) &^ 31
+	if n > 0 {
+		keep := &keepNone
+		if channels == 4 {
+			keep = &keepAlpha
+		}
+		lookupAVX2(&row[0], n, table, keep)
+	}
+	lookupGeneric(row[n:], channels, table)
+}
+
+func addWeightedRow(dst, src []float64, weight float64) {
+	n := len(dst) &^ 3
+	if n > 0 {
+		_ = src[n-1]
+		addWeightedAVX2(&dst[0], &src[0], n, weight)
+	}
+	addWeightedGeneric(dst[n:], src[n:], weight)
+}
@@ -0,0 +1,210 @@
+//go:build amd64 && !purego
+// +build amd64,!purego
+
+#include "textflag.h"
+
+// Moves the lowest byte of every 32-bit value to the front of its lane
+DATA packShuffle<>+0x00(SB)/8, $0x808080800c080400
+DATA packShuffle<>+0x08(SB)/8, $0x8080808080808080
+DATA packShuffle<>+0x10(SB)/8, $0x808080800c080400
+DATA packShuffle<>+0x18(SB)/8, $0x8080808080808080
+GLOBL packShuffle<>(SB), RODATA|NOPTR, $32
+
+// Moves the front of both lanes next to each other
+DATA packPermute<>+0x00(SB)/8, $0x0000000400000000
+DATA packPermute<>+0x08(SB)/8, $0x0000000000000000
+DATA packPermute<>+0x10(SB)/8, $0x0000000000000000
+DATA packPermute<>+0x18(SB)/8, $0x0000000000000000
+GLOBL packPermute<>(SB), RODATA|NOPTR, $32
+
+DATA weightBlue<>+0x00(SB)/8, $0x00001d2f00001d2f
+DATA weightBlue<>+0x08(SB)/8, $0x00001d2f00001d2f
+DATA weightBlue<>+0x10(SB)/8, $0x00001d2f00001d2f
+DATA weightBlue<>+0x18(SB)/8, $0x00001d2f00001d2f
+GLOBL weightBlue<>(SB), RODATA|NOPTR, $32
+
+DATA roundHalf<>+0x00(SB)/8, $0x0000800000008000
+DATA roundHalf<>+0x08(SB)/8, $0x0000800000008000
+DATA roundHalf<>+0x10(SB)/8, $0x0000800000008000
+DATA roundHalf<>+0x18(SB)/8, $0x0000800000008000
+GLOBL roundHalf<>(SB), RODATA|NOPTR, $32
+
+#define BROADCAST(value, reg, xreg) \
+	MOVL $value, AX \
+	VMOVD AX, xreg \
+	VPBROADCASTD xreg, reg
+
+// Stores the lowest bytes of the eight 32-bit values of Y1 at DI
+#define PACK \
+	VPSHUFB packShuffle<>(SB), Y1, Y1 \
+	VPERMD Y1, Y8, Y1 \
+	VMOVQ X1, (DI)
+
+// func grayRGBAAVX2(dst, src *uint8, n int)
+TEXT ·grayRGBAAVX2(SB), NOSPLIT, $0-24
+	MOVQ dst+0(FP), DI
+	MOVQ src+8(FP), SI
+	MOVQ n+16(FP), CX
+	BROADCAST(0xff, Y15, X15)
+	BROADCAST(19595, Y14, X14)
+	BROADCAST(38470, Y13, X13)
+	BROADCAST(7471, Y12, X12)
+	BROADCAST(0x101, Y11, X11)
+	BROADCAST(0x8000, Y10, X10)
+	VMOVDQU packPermute<>(SB), Y8
+
+rgbaLoop:
+	VMOVDQU (SI), Y0
+	VPAND   Y15, Y0, Y1
+	VPSRLD  $8, Y0, Y2
+	VPAND   Y15, Y2, Y2
+	VPSRLD  $16, Y0, Y3
+	VPAND   Y15, Y3, Y3
+	VPMULLD Y14, Y1, Y1
+	VPMULLD Y13, Y2, Y2
+	VPMULLD Y12, Y3, Y3
+	VPADDD  Y2, Y1, Y1
+	VPADDD  Y3, Y1, Y1
+	VPMULLD Y11, Y1, Y1
+	VPADDD  Y10, Y1, Y1
+	VPSRLD  $24, Y1, Y1
+	PACK
+	ADDQ    $32, SI
+	ADDQ    $8, DI
+	SUBQ    $8, CX
+	JNZ     rgbaLoop
+	VZEROUPPER
+	RET
+
+// func grayYCbCrAVX2(dst, y, cb, cr *uint8, n int)
+TEXT ·grayYCbCrAVX2(SB), NOSPLIT, $0-40
+	MOVQ dst+0(FP), DI
+	MOVQ y+8(FP), SI
+	MOVQ cb+16(FP), BX
+	MOVQ cr+24(FP), DX
+	MOVQ n+32(FP), CX
+	BROADCAST(0x10101, Y15, X15)
+	BROADCAST(128, Y14, X14)
+	BROADCAST(91881, Y13, X13)
+	BROADCAST(22554, Y12, X12)
+	BROADCAST(46802, Y11, X11)
+	BROADCAST(116130, Y10, X10)
+	BROADCAST(0xffff, Y9, X9)
+	VMOVDQU packPermute<>(SB), Y8
+	BROADCAST(19595, Y7, X7)
+	BROADCAST(38470, Y6, X6)
+
+ycbcrLoop:
+	VPMOVZXBD (SI), Y0
+	VPMOVZXBD (BX), Y1
+	VPMOVZXBD (DX), Y2
+	VPMULLD   Y15, Y0, Y0
+	VPSUBD    Y14, Y1, Y1
+	VPSUBD    Y14, Y2, Y2
+
+	// Red, green and blue like color.YCbCr, clamped to 16 bits
+	VPMULLD Y13, Y2, Y3
+	VPADDD  Y0, Y3, Y3
+	VPMULLD Y12, Y1, Y4
+	VPSUBD  Y4, Y0, Y4
+	VPMULLD Y11, Y2, Y5
+	VPSUBD  Y5, Y4, Y4
+	VPMULLD Y10, Y1, Y5
+	VPADDD  Y0, Y5, Y5
+	VPXOR   Y0, Y0, Y0
+	VPSRAD  $8, Y3, Y3
+	VPMAXSD Y0, Y3, Y3
+	VPMINSD Y9, Y3, Y3
+	VPSRAD  $8, Y4, Y4
+	VPMAXSD Y0, Y4, Y4
+	VPMINSD Y9, Y4, Y4
+	VPSRAD  $8, Y5, Y5
+	VPMAXSD Y0, Y5, Y5
+	VPMINSD Y9, Y5, Y5
+
+	// Gray like color.GrayModel
+	VPMULLD Y7, Y3, Y1
+	VPMULLD Y6, Y4, Y4
+	VPMULLD weightBlue<>(SB), Y5, Y5
+	VPADDD  Y4, Y1, Y1
+	VPADDD  Y5, Y1, Y1
+	VPADDD  roundHalf<>(SB), Y1, Y1
+	VPSRLD  $24, Y1, Y1
+	PACK
+	ADDQ    $8, SI
+	ADDQ    $8, BX
+	ADDQ    $8, DX
+	ADDQ    $8, DI
+	SUBQ    $8, CX
+	JNZ     ycbcrLoop
+	VZEROUPPER
+	RET
+
+// Looks up the bytes of Y0 whose upper half is h in the 16 bytes of
+// the table starting at 16*h, and adds them to Y3
+#define LOOKUP(h) \
+	VBROADCASTI128 (16*h)(DX), Y4 \
+	VPSHUFB        Y2, Y4, Y4 \
+	VPCMPEQB       Y13, Y1, Y5 \
+	VPAND          Y5, Y4, Y4 \
+	VPOR           Y4, Y3, Y3 \
+	VPADDB         Y12, Y13, Y13
+
+// func lookupAVX2(row *uint8, n int, table *[256]uint8, keep *[32]uint8)
+TEXT ·lookupAVX2(SB), NOSPLIT, $0-32
+	MOVQ row+0(FP), SI
+	MOVQ n+8(FP), CX
+	MOVQ table+16(FP), DX
+	MOVQ keep+24(FP), BX
+	BROADCAST(0x0f0f0f0f, Y15, X15)
+	BROADCAST(0x01010101, Y12, X12)
+	VMOVDQU (BX), Y11
+
+lookupLoop:
+	VMOVDQU (SI), Y0
+	VPSRLW  $4, Y0, Y1
+	VPAND   Y15, Y1, Y1
+	VPAND   Y15, Y0, Y2
+	VPXOR   Y3, Y3, Y3
+	VPXOR   Y13, Y13, Y13
+	LOOKUP(0)
+	LOOKUP(1)
+	LOOKUP(2)
+	LOOKUP(3)
+	LOOKUP(4)
+	LOOKUP(5)
+	LOOKUP(6)
+	LOOKUP(7)
+	LOOKUP(8)
+	LOOKUP(9)
+	LOOKUP(10)
+	LOOKUP(11)
+	LOOKUP(12)
+	LOOKUP(13)
+	LOOKUP(14)
+	LOOKUP(15)
+	VPBLENDVB Y11, Y0, Y3, Y3
+	VMOVDQU   Y3, (SI)
+	ADDQ      $32, SI
+	SUBQ      $32, CX
+	JNZ       lookupLoop
+	VZEROUPPER
+	RET
+
+// func addWeightedAVX2(dst, src *float64, n int, weight float64)
+TEXT ·addWeightedAVX2(SB), NOSPLIT, $0-32
+	MOVQ         dst+0(FP), DI
+	MOVQ         src+8(FP), SI
+	MOVQ         n+16(FP), CX
+	VBROADCASTSD weight+24(FP), Y15
+
+weightedLoop:
+	VMULPD  (SI), Y15, Y0
+	VADDPD  (DI), Y0, Y0
+	VMOVUPD Y0, (DI)
+	ADDQ    $32, SI
+	ADDQ    $32, DI
+	SUBQ    $4, CX
+	JNZ     weightedLoop
+	VZEROUPPER
+	RET
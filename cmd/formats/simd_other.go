@@ -0,0 +1,24 @@
+//go:build !amd64 || purego
+// +build !amd64 purego
+
+package formats
+
+func haveSIMD() bool {
+	return false
+}
+
+func grayRGBARow(dst, src []uint8) {
+	grayRGBAGeneric(dst, src)
+}
+
+func grayYCbCrRow(dst, y, cb, cr []uint8) {
+	grayYCbCrGeneric(dst, y, cb, cr)
+}
+
+func lookupRow(row []uint8, channels int, table *[256]uint8) {
+	lookupGeneric(row, channels, table)
+}
+
+func addWeightedRow(dst, src []float64, weight float64) {
+	addWeightedGeneric(dst, src, weight)
+}
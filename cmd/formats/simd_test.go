@@ -0,0 +1,130 @@
+package formats
+
+import (
+	"bytes"
+	"image"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+// Sizes with widths that are not multiples of the blocks processed by
+// the fast paths, so that the portable code handles leftover pixels.
+var simdSizes = []image.Point{{1, 1}, {7, 3}, {8, 8}, {31, 5}, {33, 17}, {97, 64}}
+
+// withSIMD runs the function once with the fast paths and once with
+// the portable code.
+func withSIMD(t *testing.T, f func()) {
+	t.Helper()
+	if !haveSIMD() {
+		t.Skip("no SIMD instructions on this processor")
+	}
+	defer func(enabled bool) { simdEnabled = enabled }(simdEnabled)
+
+	simdEnabled = true
+	f()
+	simdEnabled = false
+	f()
+}
+
+func randomImages(r *rand.Rand, size image.Point) map[string]image.Image {
+	// Images start at an offset, so that the bounds of subimages and
+	// strides wider than rows are handled
+	rect := image.Rect(3, 5, 3+size.X, 5+size.Y)
+	gray := image.NewGray(rect)
+	r.Read(gray.Pix)
+	rgba := image.NewRGBA(rect)
+	r.Read(rgba.Pix)
+	result := map[string]image.Image{
+		"gray":    gray,
+		"rgba":    rgba,
+		"subrgba": rgba.SubImage(image.Rect(4, 6, 3+size.X, 5+size.Y)),
+	}
+	for name, ratio := range map[string]image.YCbCrSubsampleRatio{
+		"ycbcr420": image.YCbCrSubsampleRatio420,
+		"ycbcr422": image.YCbCrSubsampleRatio422,
+		"ycbcr444": image.YCbCrSubsampleRatio444,
+	} {
+		ycbcr := image.NewYCbCr(rect, ratio)
+		r.Read(ycbcr.Y)
+		r.Read(ycbcr.Cb)
+		r.Read(ycbcr.Cr)
+		result[name] = ycbcr
+	}
+
+	return result
+}
+
+func TestConvertGray(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, size := range simdSizes {
+		for name, src := range randomImages(r, size) {
+			results := make([]*image.Gray, 0, 2)
+			withSIMD(t, func() {
+				dst := image.NewGray(src.Bounds())
+				ConvertGray(dst, src)
+				results = append(results, dst)
+			})
+			if !bytes.Equal(results[0].Pix, results[1].Pix) {
+				t.Errorf("%v %v: fast path differs from portable code", name, size)
+			}
+		}
+	}
+}
+
+func TestApplyLookup(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	table := [256]uint8{}
+	r.Read(table[:])
+	for _, size := range simdSizes {
+		images := randomImages(r, size)
+		for _, name := range []string{"gray", "rgba"} {
+			results := make([][]uint8, 0, 2)
+			withSIMD(t, func() {
+				var pix []uint8
+				switch img := images[name].(type) {
+				case *image.Gray:
+					dst := *img
+					dst.Pix = append([]uint8(nil), img.Pix...)
+					ApplyLookup(&dst, &table)
+					pix = dst.Pix
+				case *image.RGBA:
+					dst := *img
+					dst.Pix = append([]uint8(nil), img.Pix...)
+					ApplyLookup(&dst, &table)
+					pix = dst.Pix
+				}
+				results = append(results, pix)
+			})
+			if !bytes.Equal(results[0], results[1]) {
+				t.Errorf("%v %v: fast path differs from portable code", name, size)
+			}
+		}
+	}
+}
+
+func TestScaleGray(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	kernels := map[string]*draw.Kernel{
+		"bilinear":   draw.BiLinear,
+		"catmullrom": draw.CatmullRom,
+	}
+	targets := []image.Point{{1, 1}, {5, 9}, {16, 16}, {45, 31}, {150, 200}}
+	for _, size := range simdSizes {
+		src := randomImages(r, size)["gray"].(*image.Gray)
+		for name, kernel := range kernels {
+			for _, target := range targets {
+				results := make([]*image.Gray, 0, 2)
+				withSIMD(t, func() {
+					dst := image.NewGray(image.Rect(0, 0, target.X, target.Y))
+					ScaleGray(dst, src, kernel)
+					results = append(results, dst)
+				})
+				if !bytes.Equal(results[0].Pix, results[1].Pix) {
+					t.Errorf("%v %v to %v: fast path differs from portable code", name, size, target)
+				}
+			}
+		}
+	}
+}
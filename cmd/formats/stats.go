@@ -0,0 +1,162 @@
+package formats
+
+import (
+	"image"
+	"math"
+
+	md "github.com/leotaku/kojirou/mangadex"
+)
+
+const (
+	statsSamples       = 100
+	colorfulDifference = 40 << 8
+	colorfulFraction   = 0.02
+)
+
+// PageStats summarizes the pages of a volume, so changes in quality,
+// e.g. because of a different scantlation group, become apparent.
+type PageStats struct {
+	Pages      int
+	Brightness float64
+	Color      int
+	Spreads    int
+	Quality    float64
+}
+
+// ComputeStats samples a grid of pixels from every page.  Pages count
+// as color pages when a small fraction of samples is clearly colorful,
+// so yellowed paper in scans is not mistaken for color.
+func ComputeStats(pages md.ImageList) PageStats {
+	stats := PageStats{Pages: len(pages)}
+	lossy := 0
+	for _, page := range pages {
+		bounds := page.Image.Bounds()
+		if bounds.Empty() {
+			continue
+		}
+		if bounds.Dx() > bounds.Dy() {
+			stats.Spreads++
+		}
+		if page.Quality > 0 {
+			stats.Quality += float64(page.Quality)
+			lossy++
+		}
+
+		brightness, colorful, samples := 0.0, 0, 0
+		stepX, stepY := bounds.Dx()/statsSamples+1, bounds.Dy()/statsSamples+1
+		for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+			for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+				r, g, b, _ := page.Image.At(x, y).RGBA()
+				brightness += (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 0xffff
+				if isColorful(r, g, b) {
+					colorful++
+				}
+				samples++
+			}
+		}
+		stats.Brightness += brightness / float64(samples)
+		if float64(colorful) > float64(samples)*colorfulFraction {
+			stats.Color++
+		}
+	}
+
+	if stats.Pages > 0 {
+		stats.Brightness /= float64(stats.Pages)
+	}
+	if lossy > 0 {
+		stats.Quality /= float64(lossy)
+	}
+
+	return stats
+}
+
+// IsColorPage reports whether the page is a color page, e.g. a color
+// cover or insert, like pages are counted in ComputeStats.
+func IsColorPage(img image.Image) bool {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return false
+	}
+
+	colorful, samples := 0, 0
+	stepX, stepY := bounds.Dx()/statsSamples+1, bounds.Dy()/statsSamples+1
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			if r, g, b, _ := img.At(x, y).RGBA(); isColorful(r, g, b) {
+				colorful++
+			}
+			samples++
+		}
+	}
+
+	return float64(colorful) > float64(samples)*colorfulFraction
+}
+
+func isColorful(r, g, b uint32) bool {
+	return maxOf(r, g, b)-minOf(r, g, b) > colorfulDifference
+}
+
+// PageQuality measures properties of a page that correlate with its
+// visual quality.  Values are only meaningful in comparison with
+// other releases of the same page.
+type PageQuality struct {
+	Resolution  float64
+	Sharpness   float64
+	Compression float64
+}
+
+// MeasureQuality returns the resolution in megapixels, the sharpness
+// as the average magnitude of the Laplacian over a grid of samples,
+// and the estimated encoding quality, which is 100 for lossless
+// formats.
+func MeasureQuality(page md.Image) PageQuality {
+	bounds := page.Image.Bounds()
+	result := PageQuality{
+		Resolution:  float64(bounds.Dx()*bounds.Dy()) / 1e6,
+		Compression: 100,
+	}
+	if page.Quality > 0 {
+		result.Compression = float64(page.Quality)
+	}
+
+	luma := func(x, y int) float64 {
+		r, g, b, _ := page.Image.At(x, y).RGBA()
+		return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 0x101
+	}
+	samples := 0
+	stepX, stepY := bounds.Dx()/statsSamples+1, bounds.Dy()/statsSamples+1
+	for y := bounds.Min.Y + 1; y < bounds.Max.Y-1; y += stepY {
+		for x := bounds.Min.X + 1; x < bounds.Max.X-1; x += stepX {
+			laplacian := 4*luma(x, y) - luma(x-1, y) - luma(x+1, y) - luma(x, y-1) - luma(x, y+1)
+			result.Sharpness += math.Abs(laplacian)
+			samples++
+		}
+	}
+	if samples > 0 {
+		result.Sharpness /= float64(samples)
+	}
+
+	return result
+}
+
+func maxOf(values ...uint32) uint32 {
+	result := values[0]
+	for _, v := range values {
+		if v > result {
+			result = v
+		}
+	}
+
+	return result
+}
+
+func minOf(values ...uint32) uint32 {
+	result := values[0]
+	for _, v := range values {
+		if v < result {
+			result = v
+		}
+	}
+
+	return result
+}
@@ -5,7 +5,10 @@ import (
 	"strings"
 
 	"github.com/fatih/color"
+	"github.com/leotaku/kojirou/cmd/filter"
 	md "github.com/leotaku/kojirou/mangadex"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
 )
 
 var groupColors = []*color.Color{
@@ -24,27 +27,68 @@ var groupColors = []*color.Color{
 }
 
 func PrintSummary(manga *md.Manga) {
-	sorted := manga.Chapters().SortBy(func(a md.ChapterInfo, b md.ChapterInfo) bool {
-		if a.VolumeIdentifier.Equal(b.VolumeIdentifier) {
-			return a.Identifier.Less(b.Identifier)
-		} else {
-			return a.VolumeIdentifier.Less(b.VolumeIdentifier)
-		}
-	})
+	sorted := sortChapters(manga.Chapters())
 	groups, numbers := formatChapterMapping(sorted)
+	languages := filter.Languages(sorted)
+	if len(languages) > 1 {
+		annotateLanguages(sorted, languages[0], numbers)
+	}
 	discontinuities := formatDiscontinuities(sorted)
 
-	printValue("Title", manga.Info.Title)
+	printValue("Title", formatTitle(manga.Info.Title))
 	printValue("Author", manga.Info.Authors)
+	if manga.Info.Status != "" {
+		printValue("Status", formatStatus(manga.Info))
+	}
 	if len(numbers) > 0 {
 		printValue("Groups", strings.Join(groups, ", "))
 		printValue("Chapters", strings.Join(numbers, ", "))
 	}
+	if len(languages) > 1 {
+		printStyledValue(warningLabelColor, "Languages", FormatLanguages(languages))
+	}
+	if pages := countPages(sorted); pages > 0 {
+		printValue("Pages", FormatPages(pages))
+	}
 	if len(discontinuities) > 0 {
 		printValue("Discontinuities", strings.Join(discontinuities, ", "))
 	}
 }
 
+// Discontinuities returns the gaps between chapter numbers of the
+// manga, e.g. "3..5" when chapter 4 is missing.
+func Discontinuities(manga *md.Manga) []string {
+	return formatDiscontinuities(sortChapters(manga.Chapters()))
+}
+
+func sortChapters(chapters md.ChapterList) md.ChapterList {
+	return chapters.SortBy(func(a md.ChapterInfo, b md.ChapterInfo) bool {
+		if a.VolumeIdentifier.Equal(b.VolumeIdentifier) {
+			return a.Identifier.Less(b.Identifier)
+		} else {
+			return a.VolumeIdentifier.Less(b.VolumeIdentifier)
+		}
+	})
+}
+
+func formatStatus(info md.MangaInfo) string {
+	status := Translate(cases.Title(language.English).String(info.Status))
+	if info.Status == "completed" && !info.LastChapter.IsUnknown() {
+		return fmt.Sprintf(Translate("%v (final chapter %v)"), status, info.LastChapter)
+	}
+
+	return status
+}
+
+func countPages(chapters md.ChapterList) int {
+	result := 0
+	for _, chapter := range chapters {
+		result += chapter.Info.Pages
+	}
+
+	return result
+}
+
 func formatChapterMapping(chapters md.ChapterList) (groups, numbers []string) {
 	colorIndices := make(map[string]int)
 	for _, chapter := range chapters {
@@ -68,6 +112,25 @@ func formatChapterMapping(chapters md.ChapterList) (groups, numbers []string) {
 	return groups, numbers
 }
 
+// annotateLanguages marks the formatted numbers of chapters that are
+// not in the main language of a series.
+func annotateLanguages(chapters md.ChapterList, main language.Tag, numbers []string) {
+	for i, chapter := range chapters {
+		if lang := chapter.Info.Language; lang != main && lang != language.Und {
+			numbers[i] += warningColor.Sprintf(" [%v]", lang)
+		}
+	}
+}
+
+func FormatLanguages(languages []language.Tag) string {
+	result := make([]string, 0)
+	for _, lang := range languages {
+		result = append(result, lang.String())
+	}
+
+	return strings.Join(result, ", ")
+}
+
 func formatDiscontinuities(chapters md.ChapterList) (discontinuities []string) {
 	last := md.NewIdentifier("0")
 	if len(chapters) > 0 {
@@ -90,6 +153,9 @@ func formatDiscontinuities(chapters md.ChapterList) (discontinuities []string) {
 }
 
 func printValue(name, value interface{}) {
-	underlined := color.New(color.Underline)
-	fmt.Printf("%v: %v\n", underlined.Sprint(name), value)
+	printStyledValue(labelColor, name, value)
+}
+
+func printStyledValue(style *color.Color, name, value interface{}) {
+	fmt.Printf("%v: %v\n", style.Sprint(Translate(fmt.Sprint(name))), value)
 }
@@ -0,0 +1,44 @@
+package formats
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	warnings      []string
+	warningsMutex sync.Mutex
+)
+
+// Warn records a problem that did not stop processing.  Warnings are
+// printed once all volumes have been written, as printing them
+// immediately would garble progress bars.
+func Warn(format string, args ...interface{}) {
+	warningsMutex.Lock()
+	defer warningsMutex.Unlock()
+
+	warning := fmt.Sprintf(Translate(format), args...)
+	warnings = append(warnings, warning)
+	emit(Event{Type: "warning", Message: warning})
+}
+
+func PrintWarnings() {
+	// Bars of a progress group would be redrawn over the warnings
+	if g := currentGroup(); g != nil {
+		g.Stop()
+	}
+	warningsMutex.Lock()
+	defer warningsMutex.Unlock()
+
+	for _, warning := range warnings {
+		printStyledValue(warningLabelColor, "Warning", warning)
+	}
+}
+
+// CountWarnings returns the number of warnings recorded so far.
+func CountWarnings() int {
+	warningsMutex.Lock()
+	defer warningsMutex.Unlock()
+
+	return len(warnings)
+}
@@ -13,6 +13,12 @@ import (
 	"golang.org/x/text/language"
 )
 
+// filterAnnotation marks flags that select chapters for download.
+const filterAnnotation = "kojirou_filter"
+
+// seriesAnnotation marks flags that are remembered for every series.
+const seriesAnnotation = "kojirou_series"
+
 func writeHelp(cmd *cobra.Command, w io.Writer) {
 	groups := make(map[string][]pflag.Flag)
 	cmd.Flags().VisitAll(func(f *pflag.Flag) {
@@ -20,7 +26,7 @@ func writeHelp(cmd *cobra.Command, w io.Writer) {
 		case f.Hidden:
 		case strings.HasPrefix(f.Name, "help") || f.Name == "version":
 			groups["3Flags"] = append(groups["3Flags"], *f)
-		case strings.HasSuffix(f.Name, "s"):
+		case len(f.Annotations[filterAnnotation]) > 0:
 			groups["2Filters"] = append(groups["2Filters"], *f)
 		default:
 			groups["1Options"] = append(groups["1Options"], *f)
@@ -0,0 +1,165 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+)
+
+const maxStderr = 4096
+
+var ErrOutputTooLarge = errors.New("output exceeds limit")
+
+// Runner executes external helper commands, so that a misbehaving
+// helper fails loudly instead of hanging or filling the disk.
+type Runner struct {
+	// Timeout kills commands running longer than this, zero disables it
+	Timeout time.Duration
+	// MaxOutput limits both standard output and, when sandboxed, the
+	// size of all files left in the working directory
+	MaxOutput int64
+	// Sandbox runs commands in a separate temporary directory with a
+	// restricted environment
+	Sandbox bool
+}
+
+// Run executes the command with input as standard input and returns
+// its standard output.  Commands are split into arguments using Split
+// and never run through a shell.
+func (r Runner) Run(command string, input []byte) ([]byte, error) {
+	args, err := Split(command)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RunArgs(args, input)
+}
+
+// RunArgs executes the program named by the first argument like Run,
+// for commands with arguments that may contain whitespace.
+func (r Runner) RunArgs(args []string, input []byte) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, formats.Errorf("empty command")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if r.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+	}
+	defer cancel()
+
+	// Commands are killed once their output is too large, as they
+	// would otherwise block on writing until the timeout
+	stdout := &cappedBuffer{n: r.MaxOutput, exceed: cancel}
+	stderr := &cappedBuffer{n: maxStderr, truncate: true}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// Children of killed commands may keep pipes open indefinitely
+	cmd.WaitDelay = time.Second
+
+	if r.Sandbox {
+		dir, err := os.MkdirTemp("", "kojirou-hook-")
+		if err != nil {
+			return nil, formats.Errorf("sandbox: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		cmd.Dir = dir
+		cmd.Env = sandboxEnv(dir)
+	}
+
+	err := cmd.Run()
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return nil, formats.Errorf("%v: timed out after %v", args[0], r.Timeout)
+	case stdout.exceeded:
+		return nil, formats.Errorf("%v: %w", args[0], ErrOutputTooLarge)
+	case err != nil && stderr.buf.Len() > 0:
+		return nil, formats.Errorf("%v: %w: %v", args[0], err, strings.TrimSpace(stderr.buf.String()))
+	case err != nil:
+		return nil, formats.Errorf("%v: %w", args[0], err)
+	}
+
+	if r.Sandbox {
+		size, err := dirSize(cmd.Dir)
+		if err != nil {
+			return nil, formats.Errorf("sandbox: %w", err)
+		} else if r.MaxOutput > 0 && size > r.MaxOutput {
+			return nil, formats.Errorf("%v: temporary files: %w", args[0], ErrOutputTooLarge)
+		}
+	}
+
+	return stdout.buf.Bytes(), nil
+}
+
+// sandboxEnv only passes on variables required to find and run
+// programs, while redirecting all temporary files to dir.
+func sandboxEnv(dir string) []string {
+	env := []string{
+		"HOME=" + dir,
+		"TMPDIR=" + dir,
+		"TMP=" + dir,
+		"TEMP=" + dir,
+	}
+	for _, key := range []string{"PATH", "LANG", "SYSTEMROOT"} {
+		if value, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+value)
+		}
+	}
+
+	return env
+}
+
+func dirSize(dir string) (int64, error) {
+	size := int64(0)
+	err := filepath.WalkDir(dir, func(pathname string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+
+		return nil
+	})
+
+	return size, err
+}
+
+// cappedBuffer stops accepting data after n bytes, either by failing
+// the write and calling exceed or, if truncate is set, by silently
+// discarding the rest.
+type cappedBuffer struct {
+	buf      bytes.Buffer
+	n        int64
+	truncate bool
+	exceed   func()
+	exceeded bool
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if c.n > 0 && int64(c.buf.Len()+len(p)) > c.n {
+		if c.truncate {
+			c.buf.Write(p[:c.n-int64(c.buf.Len())])
+			return len(p), nil
+		}
+		c.exceeded = true
+		if c.exceed != nil {
+			c.exceed()
+		}
+		return 0, ErrOutputTooLarge
+	}
+
+	return c.buf.Write(p)
+}
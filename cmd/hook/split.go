@@ -0,0 +1,68 @@
+package hook
+
+import (
+	"strings"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+)
+
+// Split splits the command into arguments like a POSIX shell splits
+// words, so arguments containing whitespace can be quoted.  Quotes and
+// backslashes are removed, but nothing is expanded.
+func Split(command string) ([]string, error) {
+	var (
+		args   []string
+		word   strings.Builder
+		inWord bool
+		quote  rune
+		escape bool
+	)
+	for _, r := range command {
+		switch {
+		case escape:
+			// Within double quotes, backslashes only escape characters
+			// that would otherwise be special
+			if quote == '"' && !strings.ContainsRune("\\\"$`", r) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			escape = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escape, inWord = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	switch {
+	case escape:
+		return nil, formats.Errorf("command ends with a backslash: %v", command)
+	case quote != 0:
+		return nil, formats.Errorf("unterminated %c quote: %v", quote, command)
+	case inWord:
+		args = append(args, word.String())
+	}
+
+	return args, nil
+}
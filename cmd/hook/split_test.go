@@ -0,0 +1,52 @@
+package hook
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+	}{
+		{"", nil},
+		{"  \t ", nil},
+		{"cjpeg -quality 80", []string{"cjpeg", "-quality", "80"}},
+		{"  cjpeg \t -quality\n80  ", []string{"cjpeg", "-quality", "80"}},
+		{`magick - -resize "50%" png:-`, []string{"magick", "-", "-resize", "50%", "png:-"}},
+		{`'/opt/Kindle Previewer/kpv' {input}`, []string{"/opt/Kindle Previewer/kpv", "{input}"}},
+		{`"/opt/Kindle Previewer/kpv" {input}`, []string{"/opt/Kindle Previewer/kpv", "{input}"}},
+		{`a'b c'"d e"f`, []string{"ab cd ef"}},
+		{`'' ""`, []string{"", ""}},
+		{`'a\b "c"'`, []string{`a\b "c"`}},
+		{`a\ b c\"d \'e\'`, []string{"a b", `c"d`, "'e'"}},
+		{`"a\"b" "c\\d" "e\$f" "g\` + "`" + `h"`, []string{`a"b`, `c\d`, "e$f", "g`h"}},
+		{`"a\b" "c\'d" "e\n"`, []string{`a\b`, `c\'d`, `e\n`}},
+		{`"it's" 'say "hi"'`, []string{"it's", `say "hi"`}},
+	}
+	for _, test := range tests {
+		args, err := Split(test.command)
+		if err != nil {
+			t.Errorf("%q: %v", test.command, err)
+		} else if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%q: got %q, expected %q", test.command, args, test.args)
+		}
+	}
+}
+
+func TestSplitErrors(t *testing.T) {
+	tests := []string{
+		`cjpeg \`,
+		`"a\`,
+		`cjpeg "-quality 80`,
+		`cjpeg '-quality 80`,
+		`'it's'`,
+		`"a\"`,
+	}
+	for _, command := range tests {
+		if args, err := Split(command); err == nil {
+			t.Errorf("%q: got %q, expected an error", command, args)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"github.com/leotaku/kojirou/cmd/filter"
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/leotaku/kojirou/cmd/formats/download"
+	md "github.com/leotaku/kojirou/mangadex"
+	"golang.org/x/text/language"
+)
+
+// rawChapters returns the best release of every chapter in the given
+// language.  Raws outside the range of the translated chapters are
+// ignored, as they are neither interleaved nor worth reporting.
+func rawChapters(all, chapters md.ChapterList, lang language.Tag) md.ChapterList {
+	first, last := md.UnknownIdentifier(), md.UnknownIdentifier()
+	for _, chapter := range chapters {
+		id := chapter.Info.Identifier
+		if id.IsSpecial() {
+			continue
+		}
+		if first.IsUnknown() || id.Less(first) {
+			first = id.Whole()
+		}
+		if last.IsUnknown() || last.Less(id) {
+			last = id
+		}
+	}
+
+	raws := filter.SortByMost(filter.FilterByLanguage(all, lang)).FilterBy(func(ci md.ChapterInfo) bool {
+		id := ci.Identifier
+		return !id.IsSpecial() && !first.IsUnknown() && first.LessOrEqual(id) && id.Whole().LessOrEqual(last)
+	})
+
+	return raws.CollapseBy(func(ci md.ChapterInfo) interface{} {
+		return ci.Identifier
+	})
+}
+
+// alignChapters pairs every chapter with the raw chapter of the same
+// number.  Volumes are ignored, as raws and translations are frequently
+// collected differently.
+func alignChapters(chapters, raws md.ChapterList, lang language.Tag) map[md.Identifier]md.Chapter {
+	byNumber := make(map[md.Identifier]md.Chapter)
+	for _, raw := range raws {
+		byNumber[raw.Info.Identifier] = raw
+	}
+
+	result := make(map[md.Identifier]md.Chapter)
+	missing := 0
+	for _, chapter := range chapters {
+		if raw, ok := byNumber[chapter.Info.Identifier]; ok {
+			result[chapter.Info.Identifier] = raw
+		} else {
+			missing++
+		}
+	}
+	if missing > 0 {
+		formats.Warn("%v chapters without %v raws are not interleaved", missing, lang)
+	}
+
+	return result
+}
+
+func getRawPaths(volume md.Volume, p formats.CliProgress) (md.PathList, error) {
+	raws := make(md.ChapterList, 0)
+	for _, chapter := range volume.Sorted() {
+		if raw, ok := interleaved[chapter.Info.Identifier]; ok {
+			raws = append(raws, raw)
+		}
+	}
+	if len(raws) == 0 {
+		return nil, nil
+	}
+
+	paths, err := download.MangadexPaths(raws, p)
+	if err != nil {
+		p.Cancel("Error")
+		return nil, formats.Errorf("mangadex: %w", err)
+	}
+
+	return paths, nil
+}
+
+// interleavePages places every raw page directly after the translated
+// page with the same index.  Surplus pages of either language are kept
+// at the end of their chapter.
+func interleavePages(volume md.Volume, translated, raws md.ImageList) md.ImageList {
+	result := make(md.ImageList, 0, len(translated)+len(raws))
+	for _, page := range translated {
+		page.ImageIdentifier *= 2
+		result = append(result, page)
+	}
+	for _, page := range raws {
+		page.VolumeIdentifier = volume.Info.Identifier
+		page.ImageIdentifier = page.ImageIdentifier*2 + 1
+		result = append(result, page)
+	}
+
+	return result
+}
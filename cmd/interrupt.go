@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+)
+
+// interrupted is set once the run received an interrupt or terminate
+// signal.  Runs stop before the next step of a volume instead of
+// exiting at once, so that archives, staged pages and other deferred
+// cleanup are handled like for any other error.
+var interrupted atomic.Bool
+
+// handleInterrupts starts recording interrupts until the returned
+// function is called.  A second signal exits immediately, for users
+// that do not want to wait for the current step.
+func handleInterrupts() func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			interrupted.Store(true)
+		case <-done:
+			return
+		}
+		select {
+		case <-signals:
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// checkInterrupted fails once the run was interrupted.
+func checkInterrupted() error {
+	if interrupted.Load() {
+		return formats.Errorf("interrupted")
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/spf13/pflag"
+)
+
+type kccProfile struct {
+	name   string
+	kindle bool
+}
+
+// Device profiles of Kindle Comic Converter.  Only Kindle devices are
+// supported, as all books are generated in a Kindle format.
+var kccProfiles = map[string]kccProfile{
+	"K1":     {"Kindle 1", true},
+	"K2":     {"Kindle 2", true},
+	"K34":    {"Kindle Keyboard/Touch", true},
+	"K578":   {"Kindle 5/7/8", true},
+	"KDX":    {"Kindle DX/DXG", true},
+	"KPW":    {"Kindle Paperwhite 1/2", true},
+	"KV":     {"Kindle Paperwhite 3/4/Voyage/Oasis", true},
+	"KPW5":   {"Kindle Paperwhite 5/Signature Edition", true},
+	"KO":     {"Kindle Oasis 2/3", true},
+	"K11":    {"Kindle 11", true},
+	"KS":     {"Kindle Scribe", true},
+	"KoMT":   {"Kobo Mini/Touch", false},
+	"KoG":    {"Kobo Glo", false},
+	"KoGHD":  {"Kobo Glo HD", false},
+	"KoA":    {"Kobo Aura", false},
+	"KoAHD":  {"Kobo Aura HD", false},
+	"KoAH2O": {"Kobo Aura H2O", false},
+	"KoAO":   {"Kobo Aura ONE", false},
+	"KoN":    {"Kobo Nia", false},
+	"KoC":    {"Kobo Clara HD/Clara 2E", false},
+	"KoL":    {"Kobo Libra H2O/Libra 2", false},
+	"KoF":    {"Kobo Forma", false},
+	"KoS":    {"Kobo Sage", false},
+	"KoE":    {"Kobo Elipsa", false},
+	"Rmk1":   {"reMarkable 1", false},
+	"Rmk2":   {"reMarkable 2", false},
+	"OTHER":  {"Other", false},
+}
+
+// kccUnsupported lists options of Kindle Comic Converter that change
+// the processing of pages in ways Kojirou does not support.
+var kccUnsupported = []string{
+	"upscale", "stretch", "splitter", "gamma", "hq", "blackborders",
+	"whiteborders", "forcecolor", "forcepng", "mozjpeg", "croppingpower",
+	"batchsplit", "nokepub",
+}
+
+// applyKCCPreset maps a Kindle Comic Converter profile followed by
+// command line options, e.g. "KPW5 --manga-style", onto the options
+// of Kojirou.  Options that were given explicitly are never changed.
+func applyKCCPreset(preset string, flags *pflag.FlagSet) error {
+	fields := strings.Fields(preset)
+	if len(fields) == 0 {
+		return formats.Errorf("no profile")
+	}
+	profile, ok := kccProfiles[fields[0]]
+	if !ok {
+		return formats.Errorf(`unknown profile: "%v"`, fields[0])
+	} else if !profile.kindle {
+		return formats.Errorf(`profile "%v" is not a Kindle device: %v`, fields[0], profile.name)
+	}
+
+	kcc := pflag.NewFlagSet("kcc", pflag.ContinueOnError)
+	kcc.Usage = func() {}
+	mangaStyle := kcc.BoolP("manga-style", "m", false, "")
+	cropping := kcc.IntP("cropping", "c", 2, "")
+	format := kcc.StringP("format", "f", "Auto", "")
+	kcc.BoolP("upscale", "u", false, "")
+	kcc.BoolP("stretch", "s", false, "")
+	kcc.IntP("splitter", "r", 0, "")
+	kcc.Float64P("gamma", "g", 0, "")
+	kcc.BoolP("hq", "q", false, "")
+	kcc.BoolP("blackborders", "b", false, "")
+	kcc.BoolP("whiteborders", "w", false, "")
+	kcc.Bool("forcecolor", false, "")
+	kcc.Bool("forcepng", false, "")
+	kcc.Bool("mozjpeg", false, "")
+	kcc.Float64("croppingpower", 1, "")
+	kcc.Int("batchsplit", 0, "")
+	kcc.Bool("nokepub", false, "")
+	if err := kcc.Parse(fields[1:]); err != nil {
+		return err
+	} else if kcc.NArg() > 0 {
+		return formats.Errorf("unexpected argument: %v", kcc.Arg(0))
+	}
+
+	switch strings.ToUpper(*format) {
+	case "AUTO", "MOBI":
+	default:
+		return formats.Errorf(`format "%v" is not supported`, *format)
+	}
+	if !flags.Changed("left-to-right") {
+		leftToRightArg = !*mangaStyle
+	}
+	if !flags.Changed("autocrop") {
+		autocropArg = *cropping > 0
+	}
+
+	ignored := make([]string, 0)
+	for _, name := range kccUnsupported {
+		if kcc.Changed(name) {
+			ignored = append(ignored, "--"+name)
+		}
+	}
+	if len(ignored) > 0 {
+		sort.Strings(ignored)
+		formats.Warn("kcc preset: ignored options without equivalent: %v", strings.Join(ignored, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/leotaku/kojirou/cmd/filter"
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/leotaku/kojirou/cmd/formats/disk"
+	"github.com/leotaku/kojirou/cmd/formats/download"
+	md "github.com/leotaku/kojirou/mangadex"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/language"
+)
+
+var (
+	matchDiskArg  string
+	matchPlanArg  string
+	matchApplyArg string
+	matchOutArg   string
+)
+
+var matchCmd = &cobra.Command{
+	Use:   "match [flags..] <identifier>",
+	Short: "Propose how chapters on disk map to volumes and chapters on MangaDex",
+	Long: `Propose how chapters on disk map to volumes and chapters on MangaDex
+
+Chapter directories and archives below the given directory are
+assigned volume and chapter numbers parsed from their names, e.g.
+"Vol. 02/Ch. 011" or "Title v02 c011.cbz".  Chapters without a
+volume number are assigned the volume of the same chapter on
+MangaDex.  The directory may also be a collection of many series,
+in which case only directories named like the manga are used.
+
+The proposal is written as a plan with one chapter per line,
+which can be edited before applying it.
+
+  $ kojirou match ID --disk downloads/ --plan plan.txt
+
+Applying the plan creates a directory in the layout expected by
+the "--disk" option, which only contains links to the original
+chapters, so no files are renamed or moved.
+
+  $ kojirou match --apply plan.txt --out matched/
+  $ kojirou ID --disk matched/`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if matchApplyArg != "" {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		if matchApplyArg != "" {
+			return applyMatchPlan(matchApplyArg, matchOutArg)
+		}
+		return writeMatchPlan(args[0], matchDiskArg, matchPlanArg)
+	},
+	DisableFlagsInUseLine: true,
+}
+
+type matchEntry struct {
+	volume  md.Identifier
+	chapter md.Identifier
+	path    string
+}
+
+func writeMatchPlan(identifier, directory, pathname string) error {
+	if directory == "" {
+		return formats.Errorf("no directory given")
+	}
+	manga, err := download.MangadexSkeleton(identifier)
+	if err != nil {
+		return formats.Errorf("skeleton: %w", err)
+	}
+	chapters, err := download.MangadexChapters(identifier)
+	if err != nil {
+		return formats.Errorf("chapters: %w", err)
+	}
+	chapters = filter.FilterByLanguage(chapters, language.Make(languageArg))
+
+	known := make(map[md.Identifier]md.Identifier)
+	for _, chapter := range chapters {
+		known[chapter.Info.Identifier] = chapter.Info.VolumeIdentifier
+	}
+
+	roots, err := disk.FindSeries(directory, manga.Info)
+	if err != nil {
+		return formats.Errorf("search: %w", err)
+	} else if len(roots) == 0 {
+		roots = []string{directory}
+	}
+
+	entries := make([]matchEntry, 0)
+	notes := make([]string, 0)
+	for _, root := range roots {
+		found, err := disk.FindChapters(root)
+		if err != nil {
+			return formats.Errorf("search: %w", err)
+		}
+		for _, names := range found {
+			path := filepath.Join(append([]string{root}, names...)...)
+			volume, chapter := disk.ParseNumbers(names)
+			if chapter == "" {
+				notes = append(notes, fmt.Sprintf("no chapter number: %v", path))
+				continue
+			}
+			entry := matchEntry{
+				volume:  md.NewWithFallback(volume, "Special"),
+				chapter: md.NewIdentifier(chapter),
+				path:    path,
+			}
+			if knownVolume, ok := known[entry.chapter]; !ok {
+				notes = append(notes, fmt.Sprintf("not on MangaDex: %v", path))
+			} else if volume == "" {
+				entry.volume = knownVolume
+			}
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].volume.Equal(entries[j].volume) {
+			return entries[i].chapter.Less(entries[j].chapter)
+		}
+		return entries[i].volume.Less(entries[j].volume)
+	})
+
+	w := io.Writer(os.Stdout)
+	if pathname != "" {
+		f, err := os.Create(pathname)
+		if err != nil {
+			return formats.Errorf("plan: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	fmt.Fprintf(w, "# %v\n", manga.Info.Title)
+	fmt.Fprintf(w, "# directory: %v\n", directory)
+	fmt.Fprintln(w, "# volume<TAB>chapter<TAB>path, lines starting with # are ignored")
+	for _, note := range notes {
+		fmt.Fprintf(w, "# %v\n", note)
+	}
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", entry.volume, entry.chapter, entry.path)
+	}
+
+	return nil
+}
+
+func applyMatchPlan(pathname, out string) error {
+	if out == "" {
+		return formats.Errorf("no output directory given")
+	}
+	f, err := os.Open(pathname)
+	if err != nil {
+		return formats.Errorf("plan: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.SplitN(text, "\t", 3)
+		if len(fields) != 3 {
+			return formats.Errorf("plan: line %v: malformed: %q", line, text)
+		}
+
+		source, err := filepath.Abs(fields[2])
+		if err != nil {
+			return formats.Errorf("plan: line %v: %w", line, err)
+		}
+		info, err := os.Stat(source)
+		if err != nil {
+			return formats.Errorf("plan: line %v: %w", line, err)
+		}
+		name := fields[1]
+		if !info.IsDir() {
+			name += filepath.Ext(source)
+		}
+		target := filepath.Join(out, fields[0], name)
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return formats.Errorf("plan: line %v: %w", line, err)
+		}
+		if err := os.Symlink(source, target); err != nil {
+			return formats.Errorf("plan: line %v: %w", line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func init() {
+	matchCmd.Flags().StringVarP(&matchDiskArg, "disk", "D", "", "directory to search for chapters")
+	matchCmd.Flags().StringVarP(&languageArg, "language", "l", "en", "language of chapters on MangaDex")
+	matchCmd.Flags().StringVarP(&matchPlanArg, "plan", "", "", "write the plan to this file instead of standard output")
+	matchCmd.Flags().StringVarP(&matchApplyArg, "apply", "", "", "create links for the chapters of this plan")
+	matchCmd.Flags().StringVarP(&matchOutArg, "out", "o", "", "directory to create links in")
+	rootCmd.AddCommand(matchCmd)
+}
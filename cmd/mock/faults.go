@@ -0,0 +1,77 @@
+package mock
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var errInjected = errors.New("injected network failure")
+
+// Faulty is a round tripper that makes requests fail or respond late
+// at random, so retries can be exercised without real outages.
+type Faulty struct {
+	base     http.RoundTripper
+	failRate float64
+	latency  time.Duration
+	rand     *rand.Rand
+	mutex    sync.Mutex
+}
+
+// NewFaulty makes the given fraction of requests to the base round
+// tripper fail, and delays all others by up to the given latency.
+// Failed requests either return an error or a server error, as
+// happens when connections drop or nodes are overloaded.
+func NewFaulty(base http.RoundTripper, failRate float64, latency time.Duration) *Faulty {
+	return &Faulty{
+		base:     base,
+		failRate: failRate,
+		latency:  latency,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (f *Faulty) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mutex.Lock()
+	fail, status := f.rand.Float64() < f.failRate, f.rand.Intn(2) == 0
+	delay := time.Duration(0)
+	if f.latency > 0 {
+		delay = time.Duration(f.rand.Int63n(int64(f.latency)))
+	}
+	f.mutex.Unlock()
+
+	select {
+	case <-time.After(delay):
+	case <-req.Context().Done():
+		closeBody(req)
+		return nil, req.Context().Err()
+	}
+	if fail {
+		closeBody(req)
+	}
+	if fail && status {
+		return &http.Response{
+			Status:     "503 Service Unavailable",
+			StatusCode: http.StatusServiceUnavailable,
+			Header:     make(http.Header),
+			Body:       io.NopCloser(bytes.NewReader(nil)),
+			Request:    req,
+		}, nil
+	} else if fail {
+		return nil, errInjected
+	}
+
+	return f.base.RoundTrip(req)
+}
+
+// closeBody closes the body of requests that are not passed on, as
+// round trippers are required to.
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
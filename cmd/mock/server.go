@@ -0,0 +1,101 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Filename returns the name of the fixture file for the given request
+// URL.  Fixture directories are flat, so the cleaned path and the
+// canonically ordered query are escaped into a single filename.
+func Filename(u *url.URL) string {
+	name := path.Clean("/" + u.Path)
+	if query := u.Query().Encode(); query != "" {
+		name += "?" + query
+	}
+
+	return url.PathEscape(name)
+}
+
+// Server serves recorded MangaDex API responses and images from a
+// directory, so downloads can be reproduced without network access.
+type Server struct {
+	directory string
+	logger    *log.Logger
+}
+
+func NewServer(directory string) *Server {
+	return &Server{
+		directory: directory,
+		logger:    log.New(os.Stderr, "", log.LstdFlags),
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := Filename(r.URL)
+	data, err := os.ReadFile(filepath.Join(s.directory, name))
+	if os.IsNotExist(err) {
+		s.logger.Printf("%v %v: missing fixture: %v", r.Method, r.URL, name)
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no fixture for %v", r.URL))
+		return
+	} else if err != nil {
+		s.logger.Printf("%v %v: %v", r.Method, r.URL, err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Recorded image server addresses must point back to this server
+	if strings.HasPrefix(path.Clean(r.URL.Path), "/at-home/") {
+		if data, err = rewriteBaseURL(data, "http://"+r.Host); err != nil {
+			s.logger.Printf("%v %v: %v", r.Method, r.URL, err)
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	s.logger.Printf("%v %v: %v", r.Method, r.URL, name)
+	if json.Valid(data) {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", http.DetectContentType(data))
+	}
+	w.Write(data) //nolint:errcheck
+}
+
+func rewriteBaseURL(data []byte, base string) ([]byte, error) {
+	v := make(map[string]interface{})
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	v["baseUrl"] = base
+
+	buf := bytes.NewBuffer(nil)
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeError responds in the format of MangaDex API errors, so
+// clients report the missing fixture.
+func writeError(w http.ResponseWriter, status int, detail string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+		"result": "error",
+		"errors": []map[string]interface{}{{
+			"status": status,
+			"title":  http.StatusText(status),
+			"detail": detail,
+		}},
+	})
+}
@@ -0,0 +1,219 @@
+package mock
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	paxStatus      = "SCHILY.xattr.user.kojirou.status"
+	paxContentType = "SCHILY.xattr.user.kojirou.content-type"
+	paxStub        = "SCHILY.xattr.user.kojirou.stub"
+)
+
+// imageStub replaces recorded image data, as only the dimensions of
+// images are relevant for most bug reports.
+type imageStub struct {
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Recorder is a round tripper that writes all responses to a tar
+// archive, which can later be used by a Replayer.
+type Recorder struct {
+	base   http.RoundTripper
+	tw     *tar.Writer
+	images bool
+	mutex  sync.Mutex
+}
+
+// NewRecorder records all responses of the base round tripper to w.
+// Images are replaced by their dimensions unless images is true.
+func NewRecorder(w io.Writer, base http.RoundTripper, images bool) *Recorder {
+	return &Recorder{
+		base:   base,
+		tw:     tar.NewWriter(w),
+		images: images,
+	}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	if err := r.record(req, resp, data); err != nil {
+		return nil, fmt.Errorf("record: %w", err)
+	}
+
+	return resp, nil
+}
+
+// Close writes the end of the archive, but does not close the
+// underlying writer.
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.tw.Close()
+}
+
+func (r *Recorder) record(req *http.Request, resp *http.Response, data []byte) error {
+	records := map[string]string{
+		paxStatus:      strconv.Itoa(resp.StatusCode),
+		paxContentType: resp.Header.Get("Content-Type"),
+	}
+	if config, format, err := image.DecodeConfig(bytes.NewReader(data)); err == nil && !r.images {
+		stub, err := json.Marshal(imageStub{format, config.Width, config.Height})
+		if err != nil {
+			return err
+		}
+		records[paxStub] = "image"
+		data = stub
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.tw.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       Filename(req.URL),
+		Size:       int64(len(data)),
+		Mode:       0644,
+		ModTime:    time.Now(),
+		PAXRecords: records,
+		Format:     tar.FormatPAX,
+	}); err != nil {
+		return err
+	}
+	_, err := r.tw.Write(data)
+
+	return err
+}
+
+type entry struct {
+	status      int
+	contentType string
+	data        []byte
+	stub        bool
+}
+
+// Replayer is a round tripper that answers requests from an archive
+// written by a Recorder, without any network access.
+type Replayer struct {
+	entries map[string]entry
+}
+
+// NewReplayer reads the complete archive.  Requests that were
+// recorded multiple times, e.g. because of retries, are answered
+// using the last recorded response.
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	entries := make(map[string]entry)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", hdr.Name, err)
+		}
+		status, err := strconv.Atoi(hdr.PAXRecords[paxStatus])
+		if err != nil {
+			return nil, fmt.Errorf("%v: status: %w", hdr.Name, err)
+		}
+		entries[hdr.Name] = entry{
+			status:      status,
+			contentType: hdr.PAXRecords[paxContentType],
+			data:        data,
+			stub:        hdr.PAXRecords[paxStub] == "image",
+		}
+	}
+
+	return &Replayer{entries: entries}, nil
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	e, ok := r.entries[Filename(req.URL)]
+	if !ok {
+		return nil, fmt.Errorf("not recorded: %v", req.URL)
+	}
+
+	data := e.data
+	if e.stub {
+		stub := imageStub{}
+		if err := json.Unmarshal(data, &stub); err != nil {
+			return nil, fmt.Errorf("image stub: %w", err)
+		}
+		blank, err := encodeBlank(stub)
+		if err != nil {
+			return nil, fmt.Errorf("image stub: %w", err)
+		}
+		data = blank
+	}
+
+	header := make(http.Header)
+	if e.contentType != "" {
+		header.Set("Content-Type", e.contentType)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%v %v", e.status, http.StatusText(e.status)),
+		StatusCode:    e.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
+
+// encodeBlank returns a white image with the dimensions and format of
+// the recorded image.
+func encodeBlank(stub imageStub) ([]byte, error) {
+	img := image.NewGray(image.Rect(0, 0, stub.Width, stub.Height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	buf := bytes.NewBuffer(nil)
+	switch stub.Format {
+	case "jpeg":
+		if err := jpeg.Encode(buf, img, nil); err != nil {
+			return nil, err
+		}
+	case "gif":
+		if err := gif.Encode(buf, img, nil); err != nil {
+			return nil, err
+		}
+	default:
+		if err := png.Encode(buf, img); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
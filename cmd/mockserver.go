@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/leotaku/kojirou/cmd/mock"
+	"github.com/spf13/cobra"
+)
+
+var mockListenArg string
+
+var mockServerCmd = &cobra.Command{
+	Use:   "mock-server [flags..] <directory>",
+	Short: "Serve recorded MangaDex responses from a fixture directory",
+	Long: `Serve recorded MangaDex responses from a fixture directory
+
+Every request is answered with the contents of a single file in
+the given directory.  Its name is the escaped request path with
+the query parameters in sorted order, e.g. the chapter feed of a
+manga is read from a file named like the following.
+
+  %2Fmanga%2FID%2Ffeed%3Flimit=500&offset=0&...
+
+Requests without a matching file are logged, so fixtures for a
+new bug report can be added one at a time.  Point Kojirou at the
+server using the "--api-base-url" option.
+
+  $ kojirou mock-server fixtures/
+  $ kojirou ID --api-base-url http://localhost:8080/`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		fmt.Printf("Serving %v on http://%v/\n", args[0], mockListenArg)
+
+		return http.ListenAndServe(mockListenArg, mock.NewServer(args[0]))
+	},
+	DisableFlagsInUseLine: true,
+}
+
+func init() {
+	mockServerCmd.Flags().StringVarP(&mockListenArg, "listen", "", "localhost:8080", "address to listen on")
+	rootCmd.AddCommand(mockServerCmd)
+}
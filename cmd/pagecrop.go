@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"image"
+	"strconv"
+
+	"github.com/leotaku/kojirou/cmd/config"
+	"github.com/leotaku/kojirou/cmd/crop"
+	"github.com/leotaku/kojirou/cmd/filter"
+	"github.com/leotaku/kojirou/cmd/formats"
+	md "github.com/leotaku/kojirou/mangadex"
+)
+
+// pageCrop is a fixed crop of the configured pages of a series.
+type pageCrop struct {
+	chapters      *filter.Ranges
+	pages         *filter.Ranges
+	margins       [4]int
+	rect          image.Rectangle
+	afterAutocrop bool
+}
+
+// loadCrops returns the fixed crops configured for the manga.
+func loadCrops(id string) []pageCrop {
+	series, ok := cfg.SeriesFor(id)
+	if !ok {
+		return nil
+	}
+
+	result := make([]pageCrop, 0, len(series.Crops))
+	for _, c := range series.Crops {
+		result = append(result, newPageCrop(c))
+	}
+
+	return result
+}
+
+// newPageCrop converts a validated crop of the configuration.
+func newPageCrop(c config.Crop) pageCrop {
+	result := pageCrop{afterAutocrop: c.AfterAutocrop}
+	if c.Chapters != "" {
+		ranges := filter.ParseRanges(c.Chapters)
+		result.chapters = &ranges
+	}
+	if c.Pages != "" {
+		ranges := filter.ParseRanges(c.Pages)
+		result.pages = &ranges
+	}
+	if c.Margins != nil {
+		copy(result.margins[:], c.Margins)
+	} else {
+		result.rect = image.Rect(c.Rect[0], c.Rect[1], c.Rect[2], c.Rect[3])
+	}
+
+	return result
+}
+
+func (c pageCrop) matches(page md.Image) bool {
+	if c.chapters != nil && !c.chapters.Contains(page.ChapterIdentifier) {
+		return false
+	}
+	// Pages are numbered from one, as displayed by readers
+	number := md.NewIdentifier(strconv.Itoa(page.ImageIdentifier + 1))
+
+	return c.pages == nil || c.pages.Contains(number)
+}
+
+// cropSettings returns the settings the page is processed with, which
+// include the first matching crop, if any.
+func cropSettings(crops []pageCrop, settings processing, page md.Image) processing {
+	for _, c := range crops {
+		if c.matches(page) {
+			settings.Autocrop = settings.Autocrop && c.afterAutocrop
+			settings.Margins = c.margins
+			settings.Rect = c.rect
+			break
+		}
+	}
+
+	return settings
+}
+
+// cropPage removes the fixed margins from the page, or keeps the fixed
+// rectangle of it, relative to its top left corner.
+func cropPage(img image.Image, margins [4]int, rect image.Rectangle) (image.Image, error) {
+	bounds := img.Bounds()
+	if rect.Empty() {
+		rect = image.Rect(
+			margins[3], margins[0],
+			bounds.Dx()-margins[1], bounds.Dy()-margins[2],
+		)
+	}
+	rect = rect.Add(bounds.Min).Intersect(bounds)
+	if rect.Empty() {
+		return nil, formats.Errorf("nothing left of %vx%v page", bounds.Dx(), bounds.Dy())
+	}
+
+	return crop.Crop(img, rect)
+}
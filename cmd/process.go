@@ -0,0 +1,729 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/leotaku/kojirou/cmd/cache"
+	"github.com/leotaku/kojirou/cmd/crop"
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/leotaku/kojirou/cmd/formats/kindle"
+	"github.com/leotaku/kojirou/cmd/hook"
+	md "github.com/leotaku/kojirou/mangadex"
+	"golang.org/x/image/draw"
+)
+
+const processedCacheSize = 32
+
+// Processed pages are shared across volumes and outputs, so identical
+// pages (e.g. credits) and outputs with identical settings are only
+// processed once per run.
+var processedStore = cache.NewStore(processedCacheSize)
+
+// Output of external commands is limited, so misbehaving commands
+// cannot exhaust memory or disk space.
+const hookOutputLimit = 256 << 20
+
+type processing struct {
+	Autocrop bool
+	// Fixed margins removed from the top, right, bottom and left, or
+	// the fixed rectangle kept, of pages with a configured crop
+	Margins [4]int
+	Rect    image.Rectangle
+	// Pages smaller than Size are enlarged by this command, if given
+	UpscaleCmd string
+	FilterCmd  string
+	// Pages are shrunk to fit this size, if given, where zero
+	// dimensions are not limited
+	Size         image.Point
+	ResizeFilter string
+	Levels       int
+	Dither       string
+	// Color pages are not converted to shades of gray, if enabled
+	KeepColor bool
+	// Percent of darkest and brightest pixels clipped when stretching
+	// contrast, if enabled
+	AutoLevels bool
+	BlackClip  float64
+	WhiteClip  float64
+	// Strength and radius in pixels of unsharp masking, if enabled
+	Sharpen       float64
+	SharpenRadius float64
+}
+
+// resizeFilters are the interpolations pages can be resized with.
+// Catmull-Rom is sharp and fast, Lanczos keeps slightly more detail of
+// fine screen tones, and nearest neighbor keeps pixel art crisp.
+var resizeFilters = map[string]draw.Interpolator{
+	"catmull-rom": draw.CatmullRom,
+	"lanczos":     &lanczosKernel,
+	"nearest":     draw.NearestNeighbor,
+}
+
+var lanczosKernel = draw.Kernel{Support: 3, At: func(t float64) float64 {
+	if t == 0 {
+		return 1
+	}
+	x := math.Pi * t
+	return 3 * math.Sin(x) * math.Sin(x/3) / (x * x)
+}}
+
+// Dithering methods for pages converted to shades of gray
+const (
+	ditherFloydSteinberg = "floyd-steinberg"
+	ditherOrdered        = "ordered"
+)
+
+// bayerMatrix orders thresholds for ordered dithering, so that screen
+// tones keep a regular pattern.
+var bayerMatrix = [8][8]float64{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+func processingFromFlags() processing {
+	// Sizes are validated before pages are processed
+	size, _ := parseSize(resizeArg)
+	if maxWidthArg > 0 {
+		size.X = maxWidthArg
+	}
+	if maxHeightArg > 0 {
+		size.Y = maxHeightArg
+	}
+	levels := grayLevelsArg
+	if ditherArg != "" && levels == 0 {
+		// Kindle and Kobo screens display this many shades
+		levels = 16
+	} else if grayscaleArg && levels == 0 {
+		levels = 256
+	}
+
+	result := processing{
+		Autocrop:   autocropArg,
+		UpscaleCmd: upscaleCmdArg,
+		FilterCmd:  filterCmdArg,
+		Size:       size,
+		Levels:     levels,
+		Dither:     ditherArg,
+	}
+	// Unused settings are left empty, so pages are only processed when
+	// necessary
+	if size != (image.Point{}) {
+		result.ResizeFilter = resizeFilterArg
+	}
+	if autoLevelsArg {
+		result.AutoLevels = true
+		result.BlackClip = blackClipArg
+		result.WhiteClip = whiteClipArg
+	}
+	if sharpenArg > 0 {
+		result.Sharpen = sharpenArg
+		result.SharpenRadius = sharpenRadiusArg
+	}
+	if levels > 0 {
+		result.KeepColor = keepColorArg
+	}
+
+	return result
+}
+
+func hookRunner() hook.Runner {
+	return hook.Runner{
+		Timeout:   hookTimeoutArg,
+		MaxOutput: hookOutputLimit,
+		Sandbox:   hookSandboxArg,
+	}
+}
+
+func (s processing) key() string {
+	return fmt.Sprintf("%+v", s)
+}
+
+func hashPages(pages md.ImageList, settings processing) []string {
+	if settings == (processing{}) && len(crops) == 0 {
+		return nil
+	}
+
+	hashes := make([]string, len(pages))
+	for i, page := range pages {
+		hashes[i] = cache.Hash(page.Image)
+	}
+
+	return hashes
+}
+
+// processPages returns the pages processed according to settings and
+// the configured crops.  The given pages are not modified, so they can
+// be processed again using different settings.
+func processPages(pages md.ImageList, sources []string, settings processing) (md.ImageList, error) {
+	if settings == (processing{}) && len(crops) == 0 {
+		return pages, nil
+	}
+
+	p := formats.VanishingProgress("Processing")
+	p.Increase(len(pages))
+	result := make(md.ImageList, len(pages))
+	for i, page := range pages {
+		result[i] = page
+		settings := cropSettings(crops, settings, page)
+		key := settings.key()
+		if settings == (processing{}) {
+			// Nothing to do for pages without a crop
+		} else if img, ok := processedStore.Get(sources[i], key); ok {
+			result[i].Image = img
+		} else if img, err := processPage(page.Image, settings); err != nil {
+			p.Cancel("Error")
+			return nil, formats.Errorf("chapter %v: page %v: %w", page.ChapterIdentifier, page.ImageIdentifier, err)
+		} else {
+			if err := processedStore.Add(sources[i], key, img); err != nil {
+				formats.Warn("page cache: %v", err)
+			}
+			result[i].Image = img
+		}
+		p.Add(1)
+	}
+	p.Done()
+
+	return result, nil
+}
+
+func processPage(img image.Image, settings processing) (image.Image, error) {
+	// Color is detected before processing, as filters may change it
+	color := settings.KeepColor && formats.IsColorPage(formats.Decoded(img))
+	if settings.Autocrop {
+		cropped, err := crop.Crop(img, crop.Limited(img, 0.1))
+		if err != nil {
+			return nil, formats.Errorf("autocrop: %w", err)
+		}
+		img = cropped
+	}
+	if settings.Margins != [4]int{} || !settings.Rect.Empty() {
+		cropped, err := cropPage(img, settings.Margins, settings.Rect)
+		if err != nil {
+			return nil, formats.Errorf("crop: %w", err)
+		}
+		img = cropped
+	}
+	if scale := upscaleFactor(img.Bounds(), settings.Size); settings.UpscaleCmd != "" && scale > 1 {
+		upscaled, err := upscalePage(img, settings.UpscaleCmd, scale)
+		if err != nil {
+			return nil, formats.Errorf("upscale: %w", err)
+		}
+		img = upscaled
+	}
+	if settings.FilterCmd != "" {
+		filtered, err := filterPage(img, settings.FilterCmd)
+		if err != nil {
+			return nil, formats.Errorf("filter: %w", err)
+		}
+		img = filtered
+	}
+	if settings.AutoLevels {
+		img = stretchPage(img, settings.BlackClip, settings.WhiteClip)
+	}
+	if settings.Size != (image.Point{}) {
+		img = resizePage(img, settings.Size, settings.ResizeFilter)
+	}
+	if settings.Sharpen > 0 {
+		img = sharpenPage(img, settings.Sharpen, settings.SharpenRadius)
+	}
+	if settings.Levels > 0 && !color {
+		img = grayPage(img, settings.Levels, settings.Dither)
+	}
+
+	return img, nil
+}
+
+// stretchPage stretches the histogram of the page, so that the given
+// percentages of pixels become pure black and white.  Washed-out scans
+// regain their contrast, while pages that already use the full range
+// are left unchanged.  Colors are stretched by their brightness, so
+// their hue is kept.
+func stretchPage(img image.Image, blackClip, whiteClip float64) image.Image {
+	img = formats.Decoded(img)
+	bounds := img.Bounds()
+	gray := formats.NewGray(bounds)
+	formats.ConvertGray(gray, img)
+	if _, ok := img.(*image.Gray); !ok {
+		// Brightness is only needed for the histogram of color pages
+		defer formats.ReleaseGray(gray)
+	}
+
+	histogram := [256]int{}
+	for _, v := range gray.Pix {
+		histogram[v]++
+	}
+	low, high := 0, 255
+	for limit, sum := blackClip/100*float64(len(gray.Pix)), histogram[0]; low < 255 && float64(sum) <= limit; sum += histogram[low] {
+		low++
+	}
+	for limit, sum := whiteClip/100*float64(len(gray.Pix)), histogram[255]; high > 0 && float64(sum) <= limit; sum += histogram[high] {
+		high--
+	}
+	if high <= low || (low == 0 && high == 255) {
+		return img
+	}
+
+	lookup := [256]uint8{}
+	for v := range lookup {
+		scaled := float64(v-low) * 255 / float64(high-low)
+		lookup[v] = uint8(math.Max(0, math.Min(255, math.Round(scaled))))
+	}
+	if _, ok := img.(*image.Gray); ok {
+		formats.ApplyLookup(gray, &lookup)
+		return gray
+	}
+	rgba := image.NewRGBA(bounds)
+	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+	formats.ApplyLookup(rgba, &lookup)
+
+	return rgba
+}
+
+// resizePage shrinks the page to fit the size while keeping its aspect
+// ratio.  Landscape pages like spreads are fitted to the rotated size,
+// as readers usually rotate them to fill the screen.
+func resizePage(img image.Image, size image.Point, filter string) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() > bounds.Dy() {
+		size = image.Pt(size.Y, size.X)
+	}
+	scale := 1.0
+	if size.X > 0 {
+		scale = math.Min(scale, float64(size.X)/float64(bounds.Dx()))
+	}
+	if size.Y > 0 {
+		scale = math.Min(scale, float64(size.Y)/float64(bounds.Dy()))
+	}
+	if scale >= 1 {
+		return img
+	}
+
+	width := int(math.Max(1, math.Round(float64(bounds.Dx())*scale)))
+	height := int(math.Max(1, math.Round(float64(bounds.Dy())*scale)))
+	var dst draw.Image = image.NewRGBA(image.Rect(0, 0, width, height))
+	if _, ok := formats.Decoded(img).(*image.Gray); ok {
+		dst = image.NewGray(dst.Bounds())
+	}
+	interpolator, ok := resizeFilters[filter]
+	if !ok {
+		interpolator = draw.CatmullRom
+	}
+	gray, isGray := formats.Decoded(img).(*image.Gray)
+	if kernel, ok := interpolator.(*draw.Kernel); ok && isGray {
+		formats.ScaleGray(dst.(*image.Gray), gray, kernel)
+		return dst
+	}
+	interpolator.Scale(dst, dst.Bounds(), formats.Decoded(img), bounds, draw.Src, nil)
+
+	return dst
+}
+
+// sharpenPage applies an unsharp mask to the page, which restores the
+// crispness of line art lost when shrinking pages.
+func sharpenPage(img image.Image, amount, radius float64) image.Image {
+	img = formats.Decoded(img)
+	bounds := img.Bounds()
+	var pix []uint8
+	var stride, channels int
+	var result image.Image
+	if _, ok := img.(*image.Gray); ok {
+		gray := image.NewGray(bounds)
+		draw.Draw(gray, bounds, img, bounds.Min, draw.Src)
+		pix, stride, channels, result = gray.Pix, gray.Stride, 1, gray
+	} else {
+		rgba := image.NewRGBA(bounds)
+		draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+		pix, stride, channels, result = rgba.Pix, rgba.Stride, 4, rgba
+	}
+
+	kernel := gaussianKernel(radius)
+	width, height := bounds.Dx(), bounds.Dy()
+	blurred, temp := scratchBuffer(len(pix)), scratchBuffer(len(pix))
+	defer scratchPool.Put(&blurred)
+	defer scratchPool.Put(&temp)
+	clampAt := func(v, max int) int {
+		if v < 0 {
+			return 0
+		} else if v >= max {
+			return max - 1
+		}
+		return v
+	}
+	// The blur is separable, so rows and columns are blurred in turn
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for c := 0; c < channels && c < 3; c++ {
+				sum := 0.0
+				for k, weight := range kernel {
+					sx := clampAt(x+k-len(kernel)/2, width)
+					sum += weight * float64(pix[y*stride+sx*channels+c])
+				}
+				temp[y*stride+x*channels+c] = sum
+			}
+		}
+	}
+	for y := 0; y < height; y++ {
+		row := blurred[y*stride:][:width*channels]
+		for i := range row {
+			row[i] = 0
+		}
+		for k, weight := range kernel {
+			sy := clampAt(y+k-len(kernel)/2, height)
+			formats.AddWeighted(row, temp[sy*stride:], weight)
+		}
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for c := 0; c < channels && c < 3; c++ {
+				i := y*stride + x*channels + c
+				v := float64(pix[i])
+				pix[i] = uint8(math.Max(0, math.Min(255, math.Round(v+amount*(v-blurred[i])))))
+			}
+		}
+	}
+
+	return result
+}
+
+// Blurred copies of pages take eight bytes per channel, so they are
+// reused across pages instead of being collected after every page.
+var scratchPool sync.Pool
+
+// scratchBuffer returns a buffer of the given length, whose values may
+// be left over from earlier pages.
+func scratchBuffer(size int) []float64 {
+	if buf, ok := scratchPool.Get().(*[]float64); ok && cap(*buf) >= size {
+		return (*buf)[:size]
+	}
+
+	return make([]float64, size)
+}
+
+// gaussianKernel returns the normalized weights of a gaussian blur
+// with the given radius as standard deviation.
+func gaussianKernel(radius float64) []float64 {
+	size := int(math.Ceil(radius*3))*2 + 1
+	kernel := make([]float64, size)
+	sum := 0.0
+	for i := range kernel {
+		x := float64(i - size/2)
+		kernel[i] = math.Exp(-x * x / (2 * radius * radius))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	return kernel
+}
+
+// grayPage converts the page to the given number of evenly spaced
+// shades of gray, so e-ink screens do not dither pages on their own.
+// Pages are optionally dithered, which keeps screen tones from turning
+// into flat areas of gray.
+func grayPage(img image.Image, levels int, dither string) image.Image {
+	img = formats.Decoded(img)
+	bounds := img.Bounds()
+	gray := image.NewGray(bounds)
+	formats.ConvertGray(gray, img)
+	if levels >= 256 {
+		return gray
+	}
+
+	step := 255 / float64(levels-1)
+	quantize := func(v float64) uint8 {
+		return uint8(math.Max(0, math.Min(255, math.Round(math.Round(v/step)*step))))
+	}
+	switch dither {
+	case ditherFloydSteinberg:
+		floydSteinberg(gray, quantize)
+	case ditherOrdered:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				i := gray.PixOffset(x, y)
+				offset := ((bayerMatrix[y&7][x&7]+0.5)/64 - 0.5) * step
+				gray.Pix[i] = quantize(float64(gray.Pix[i]) + offset)
+			}
+		}
+	default:
+		lookup := [256]uint8{}
+		for v := range lookup {
+			lookup[v] = quantize(float64(v))
+		}
+		formats.ApplyLookup(gray, &lookup)
+	}
+
+	return gray
+}
+
+// floydSteinberg quantizes the page in place, diffusing the error of
+// every pixel to its unprocessed neighbors.
+func floydSteinberg(gray *image.Gray, quantize func(float64) uint8) {
+	bounds := gray.Bounds()
+	width := bounds.Dx()
+	// Errors for the current and next row, padded by one pixel
+	current := make([]float64, width+2)
+	next := make([]float64, width+2)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := 0; x < width; x++ {
+			i := gray.PixOffset(bounds.Min.X+x, y)
+			v := float64(gray.Pix[i]) + current[x+1]
+			gray.Pix[i] = quantize(v)
+			e := v - float64(gray.Pix[i])
+			current[x+2] += e * 7 / 16
+			next[x] += e * 3 / 16
+			next[x+1] += e * 5 / 16
+			next[x+2] += e * 1 / 16
+		}
+		current, next = next, current
+		for i := range next {
+			next[i] = 0
+		}
+	}
+}
+
+// filterPage passes the page to the command as PNG on standard input
+// and reads the filtered page in any supported format from standard
+// output.
+func filterPage(img image.Image, command string) (image.Image, error) {
+	input := bytes.NewBuffer(nil)
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := enc.Encode(input, img); err != nil {
+		return nil, formats.Errorf("encode: %w", err)
+	}
+
+	output, err := hookRunner().Run(command, input.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	filtered, _, err := formats.DecodeImage(bytes.NewReader(output))
+	if err != nil {
+		return nil, formats.Errorf("decode: %w", err)
+	}
+
+	return filtered, nil
+}
+
+// upscaleFactor returns the factor pages need to be enlarged by to fit
+// the size, which is one for pages that already fill it or when no
+// size is given.  Upscalers usually support enlarging by two and four.
+func upscaleFactor(bounds image.Rectangle, size image.Point) int {
+	if size == (image.Point{}) {
+		return 1
+	}
+
+	fit := math.Inf(1)
+	if size.X > 0 {
+		fit = float64(size.X) / float64(bounds.Dx())
+	}
+	if size.Y > 0 {
+		fit = math.Min(fit, float64(size.Y)/float64(bounds.Dy()))
+	}
+
+	switch {
+	case fit <= 1:
+		return 1
+	case fit <= 2:
+		return 2
+	default:
+		return 4
+	}
+}
+
+// upscalePage passes the page to the upscaling command as a temporary
+// PNG file, where "{input}" is replaced by the path of the page,
+// "{output}" by the path the command should write the enlarged page
+// to, and "{scale}" by the factor it should be enlarged by.
+func upscalePage(img image.Image, command string, scale int) (image.Image, error) {
+	dir, err := os.MkdirTemp("", "kojirou-upscale-")
+	if err != nil {
+		return nil, formats.Errorf("temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input, output := filepath.Join(dir, "input.png"), filepath.Join(dir, "output.png")
+	data := bytes.NewBuffer(nil)
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := enc.Encode(data, img); err != nil {
+		return nil, formats.Errorf("encode: %w", err)
+	} else if err := os.WriteFile(input, data.Bytes(), 0644); err != nil {
+		return nil, formats.Errorf("input: %w", err)
+	}
+	args, err := hook.Split(command)
+	if err != nil {
+		return nil, err
+	}
+	for i, arg := range args {
+		args[i] = strings.NewReplacer("{input}", input, "{output}", output, "{scale}", fmt.Sprint(scale)).Replace(arg)
+	}
+	if _, err := hookRunner().RunArgs(args, nil); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		return nil, formats.Errorf("output: %w", err)
+	}
+	defer f.Close()
+	upscaled, _, err := formats.DecodeImage(f)
+	if err != nil {
+		return nil, formats.Errorf("decode: %w", err)
+	}
+
+	return upscaled, nil
+}
+
+// Default commands for encoding pages, which read the page as PNG on
+// standard input.  The placeholder "{quality}" is replaced by the
+// requested quality or otherwise the default quality of the command.
+var codecCommands = map[string]struct {
+	command string
+	quality int
+}{
+	kindle.CodecWebP: {"cwebp -quiet -q {quality} -o - -- -", 75},
+	kindle.CodecAVIF: {"magick png:- -quality {quality} avif:-", 50},
+}
+
+// encodePage passes the page to the command for the codec as PNG on
+// standard input and reads the encoded page from standard output.
+func encodePage(img image.Image, codec string, quality int) ([]byte, error) {
+	command, ok := codecCommands[codec]
+	if !ok {
+		return nil, formats.Errorf("not a supported codec: %v", codec)
+	} else if codecCmdArg != "" {
+		command.command = codecCmdArg
+	}
+	if quality == 0 {
+		quality = command.quality
+	}
+
+	input := bytes.NewBuffer(nil)
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := enc.Encode(input, img); err != nil {
+		return nil, formats.Errorf("encode: %w", err)
+	}
+
+	return hookRunner().Run(strings.ReplaceAll(command.command, "{quality}", fmt.Sprint(quality)), input.Bytes())
+}
+
+// encodeJPEGPage passes the page to the JPEG command as PGM or PPM on
+// standard input, which encoders like cjpeg of mozjpeg and
+// libjpeg-turbo read fastest, and reads the encoded page from standard
+// output.  The placeholder "{quality}" is replaced by the quality, and
+// options are appended as arguments of cjpeg.
+func encodeJPEGPage(img image.Image, quality int, options kindle.JPEGOptions) ([]byte, error) {
+	command := strings.ReplaceAll(jpegCmdArg, "{quality}", fmt.Sprint(quality))
+	switch options.Subsampling {
+	case "4:2:0":
+		command += " -sample 2x2"
+	case "4:4:4":
+		command += " -sample 1x1"
+	}
+	if options.Progressive != nil && *options.Progressive {
+		command += " -progressive"
+	} else if options.Progressive != nil {
+		command += " -baseline"
+	}
+	// Trellis quantization is enabled by default in mozjpeg
+	if options.Trellis != nil && !*options.Trellis {
+		command += " -notrellis"
+	}
+
+	return hookRunner().Run(command, encodePNM(img))
+}
+
+// encodePNM encodes grayscale pages as binary PGM and all other pages
+// as binary PPM.
+func encodePNM(img image.Image) []byte {
+	bounds := img.Bounds()
+	buf := bytes.NewBuffer(nil)
+	if gray, ok := img.(*image.Gray); ok {
+		fmt.Fprintf(buf, "P5\n%v %v\n255\n", bounds.Dx(), bounds.Dy())
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			i := gray.PixOffset(bounds.Min.X, y)
+			buf.Write(gray.Pix[i : i+bounds.Dx()])
+		}
+		return buf.Bytes()
+	}
+
+	rgba := image.NewRGBA(bounds)
+	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+	fmt.Fprintf(buf, "P6\n%v %v\n255\n", bounds.Dx(), bounds.Dy())
+	buf.Grow(3 * len(rgba.Pix) / 4)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		buf.Write(rgba.Pix[i : i+3])
+	}
+
+	return buf.Bytes()
+}
+
+// decodeAVIFPage passes the AVIF page to the decoding command on
+// standard input and reads the page as PNG from standard output.
+func decodeAVIFPage(data []byte) ([]byte, error) {
+	return hookRunner().Run(avifCmdArg, data)
+}
+
+// optimizePage passes the encoded page to the optimization command on
+// standard input and reads the optimized page from standard output.
+func optimizePage(data []byte) ([]byte, error) {
+	return hookRunner().Run(optimizeCmdArg, data)
+}
+
+// convertKFX passes the book to the KFX command as a temporary file,
+// where "{input}" is replaced by the path of the book and "{output}" by
+// a directory the command should write the converted book to.
+func convertKFX(azw3 []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "kojirou-kfx-")
+	if err != nil {
+		return nil, formats.Errorf("temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input, output := filepath.Join(dir, "book.azw3"), filepath.Join(dir, "output")
+	if err := os.WriteFile(input, azw3, 0644); err != nil {
+		return nil, formats.Errorf("input: %w", err)
+	} else if err := os.Mkdir(output, os.ModePerm); err != nil {
+		return nil, formats.Errorf("output: %w", err)
+	}
+	args, err := hook.Split(kfxCmdArg)
+	if err != nil {
+		return nil, err
+	}
+	for i, arg := range args {
+		args[i] = strings.NewReplacer("{input}", input, "{output}", output).Replace(arg)
+	}
+	if _, err := hookRunner().RunArgs(args, nil); err != nil {
+		return nil, err
+	}
+
+	// Converters write additional files like logs next to the book
+	result := ""
+	err = filepath.WalkDir(output, func(pathname string, d fs.DirEntry, err error) error {
+		if err == nil && result == "" && strings.EqualFold(filepath.Ext(pathname), ".kfx") {
+			result = pathname
+		}
+		return err
+	})
+	if err != nil {
+		return nil, formats.Errorf("output: %w", err)
+	} else if result == "" {
+		return nil, formats.Errorf("output: no kfx file written")
+	}
+
+	return os.ReadFile(result)
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/leotaku/kojirou/cmd/formats/kindle"
+	"github.com/spf13/pflag"
+)
+
+type deviceProfile struct {
+	name   string
+	size   image.Point
+	levels int
+	format string
+}
+
+// Device profiles with the screen resolution, the number of shades of
+// gray shown by the screen (zero for color screens) and the format
+// read natively by the device.
+var deviceProfiles = map[string]deviceProfile{
+	"paperwhite-11": {"Kindle Paperwhite 11th generation", image.Pt(1236, 1648), 16, kindle.FormatAZW3},
+	"oasis":         {"Kindle Oasis 2/3", image.Pt(1264, 1680), 16, kindle.FormatAZW3},
+	"scribe":        {"Kindle Scribe", image.Pt(1860, 2480), 16, kindle.FormatAZW3},
+	"kobo-libra":    {"Kobo Libra H2O/Libra 2", image.Pt(1264, 1680), 16, kindle.FormatKEPUB},
+	"generic-1080p": {"Tablets with 1080p screens", image.Pt(1080, 1920), 0, kindle.FormatCBZ},
+}
+
+// applyProfile maps a device profile onto the options of Kojirou.
+// Options that were given explicitly are never changed.
+func applyProfile(name string, flags *pflag.FlagSet) error {
+	profile, ok := deviceProfiles[name]
+	if !ok {
+		names := make([]string, 0, len(deviceProfiles))
+		for name := range deviceProfiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return formats.Errorf(`unknown profile: "%v" (one of %v)`, name, strings.Join(names, ", "))
+	}
+
+	if !flags.Changed("resize") {
+		resizeArg = fmt.Sprintf("%vx%v", profile.size.X, profile.size.Y)
+	}
+	if !flags.Changed("gray-levels") {
+		grayLevelsArg = profile.levels
+	}
+	if !flags.Changed("format") {
+		formatArg = profile.format
+	}
+
+	return nil
+}
+
+// parseSize parses sizes like "1236x1648", where the empty string
+// means no size.
+func parseSize(size string) (image.Point, error) {
+	if size == "" {
+		return image.Point{}, nil
+	}
+
+	result := image.Point{}
+	if _, err := fmt.Sscanf(size, "%dx%d", &result.X, &result.Y); err != nil {
+		return image.Point{}, formats.Errorf(`not a size: "%v"`, size)
+	} else if result.X <= 0 || result.Y <= 0 {
+		return image.Point{}, formats.Errorf(`not a positive size: "%v"`, size)
+	}
+
+	return result, nil
+}
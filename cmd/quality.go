@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"github.com/leotaku/kojirou/cmd/filter"
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/leotaku/kojirou/cmd/formats/download"
+	md "github.com/leotaku/kojirou/mangadex"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	qualitySamples = 3
+	qualityJobs    = 4
+)
+
+// rankByQuality downloads a few sample pages of every chapter that
+// was released by multiple groups, preferring the release with the
+// best resolution, sharpness and compression.  Measurements are
+// relative to the best release of the same chapter, so that all three
+// contribute equally.
+func rankByQuality(cl md.ChapterList) (md.ChapterList, error) {
+	type key struct{ volume, chapter md.Identifier }
+	releases := make(map[key]md.ChapterList)
+	for _, chapter := range cl {
+		if chapter.Info.GroupNames.String() != "Filesystem" {
+			k := key{chapter.Info.VolumeIdentifier, chapter.Info.Identifier}
+			releases[k] = append(releases[k], chapter)
+		}
+	}
+
+	p := formats.VanishingProgress("Sampling")
+	measured := make(map[string]formats.PageQuality)
+	results := make(chan struct {
+		id      string
+		quality formats.PageQuality
+	})
+	eg := new(errgroup.Group)
+	eg.SetLimit(qualityJobs)
+	go func() {
+		for _, chapters := range releases {
+			if len(chapters) < 2 {
+				continue
+			}
+			for _, chapter := range chapters {
+				chapter := chapter
+				p.Increase(1)
+				eg.Go(func() error {
+					pages, err := download.MangadexSamples(chapter, qualitySamples, dataSaverArg)
+					if err != nil {
+						return formats.Errorf("chapter %v: %w", chapter.Info.Identifier, err)
+					}
+					results <- struct {
+						id      string
+						quality formats.PageQuality
+					}{chapter.Info.ID, averageQuality(pages)}
+					return nil
+				})
+			}
+		}
+		eg.Wait() //nolint:errcheck
+		close(results)
+	}()
+	for result := range results {
+		measured[result.id] = result.quality
+		p.Add(1)
+	}
+	if err := eg.Wait(); err != nil {
+		p.Cancel("Error")
+		return nil, err
+	}
+	p.Done()
+
+	scores := make(map[string]float64)
+	for _, chapters := range releases {
+		best := formats.PageQuality{}
+		for _, chapter := range chapters {
+			q := measured[chapter.Info.ID]
+			best.Resolution = maxFloat(best.Resolution, q.Resolution)
+			best.Sharpness = maxFloat(best.Sharpness, q.Sharpness)
+			best.Compression = maxFloat(best.Compression, q.Compression)
+		}
+		for _, chapter := range chapters {
+			q := measured[chapter.Info.ID]
+			scores[chapter.Info.ID] = ratio(q.Resolution, best.Resolution) +
+				ratio(q.Sharpness, best.Sharpness) +
+				ratio(q.Compression, best.Compression)
+		}
+	}
+
+	return filter.SortByScore(cl, scores), nil
+}
+
+func averageQuality(pages md.ImageList) formats.PageQuality {
+	result := formats.PageQuality{}
+	for _, page := range pages {
+		q := formats.MeasureQuality(page)
+		result.Resolution += q.Resolution / float64(len(pages))
+		result.Sharpness += q.Sharpness / float64(len(pages))
+		result.Compression += q.Compression / float64(len(pages))
+	}
+
+	return result
+}
+
+func ratio(value, best float64) float64 {
+	if best == 0 {
+		return 0
+	}
+
+	return value / best
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
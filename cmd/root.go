@@ -1,10 +1,15 @@
 package cmd
 
 import (
+	"image/jpeg"
 	"os"
 	"runtime/pprof"
+	"time"
 
+	"github.com/leotaku/kojirou/cmd/config"
+	"github.com/leotaku/kojirou/cmd/formats"
 	"github.com/leotaku/kojirou/cmd/formats/download"
+	"github.com/leotaku/kojirou/cmd/formats/kindle"
 	"github.com/spf13/cobra"
 )
 
@@ -12,36 +17,132 @@ var (
 	identifierArg       string
 	languageArg         string
 	rankArg             string
+	mixedLanguagesArg   bool
+	keepTeasersArg      bool
+	mergeSubchaptersArg bool
+	splitByArg          string
+	interleaveArg       string
 	autocropArg         bool
+	stitchSpreadsArg    bool
+	dropCreditsArg      bool
+	creditHashesArg     string
+	dedupPagesArg       bool
+	kccPresetArg        string
+	profileArg          string
+	resizeArg           string
+	maxWidthArg         int
+	maxHeightArg        int
+	resizeFilterArg     string
+	grayLevelsArg       int
+	grayscaleArg        bool
+	ditherArg           string
+	keepColorArg        bool
+	colorQualityArg     int
+	autoLevelsArg       bool
+	blackClipArg        float64
+	whiteClipArg        float64
+	sharpenArg          float64
+	sharpenRadiusArg    float64
+	filterCmdArg        string
+	upscaleCmdArg       string
+	hookTimeoutArg      time.Duration
+	hookSandboxArg      bool
 	kindleFolderModeArg bool
+	indexArg            bool
+	contentWarningsArg  bool
+	discussionPageArg   bool
+	panelViewArg        bool
+	enrichArg           bool
+	contactSheetArg     bool
+	chapterMtimeArg     bool
 	dryRunArg           bool
+	linksArg            bool
+	strictArg           bool
+	asciiArg            bool
+	progressSocketArg   string
+	colorArg            formats.ColorMode
+	langArg             string
 	outArg              string
+	formatArg           string
+	sendArg             []string
+	sendOnlyArg         bool
+	stagingDirArg       string
+	syncArg             kindle.SyncPolicy
 	forceArg            bool
+	onExistingArg       kindle.ExistingPolicy
+	onCollisionArg      string
 	leftToRightArg      bool
 	fillVolumeNumberArg int
+	coverFallbackArg    string
 	dataSaverArg        download.DataSaverPolicy
+	maxMemoryArg        formats.ByteSize
+	maxPixelsArg        int64
+	noSIMDArg           bool
 	diskArg             string
+	diskSearchArg       bool
+	apiBaseURLArg       string
+	caFileArg           string
+	ipVersionArg        string
+	pinCertArg          []string
+	apiVersionArg       string
+	unpublishedArg      bool
+	ignoreBlockedArg    bool
+	ioWorkersArg        int
+	pageCacheArg        string
+	pageCacheLinksArg   bool
+	overlayIDsArg       bool
+	jpegQualityArg      int
+	pageCodecArg        string
+	codecQualityArg     int
+	codecCmdArg         string
+	jpegCmdArg          string
+	avifCmdArg          string
+	optimizeArg         bool
+	optimizeCmdArg      string
+	kfxCmdArg           string
+	sevenZipCmdArg      string
+	recordArg           string
+	recordImageDataArg  bool
+	replayArg           string
+	failRateArg         float64
+	injectLatencyArg    time.Duration
+	configArg           string
 	cpuprofileArg       string
 	memprofileArg       string
 	groupsFilter        string
 	chaptersFilter      string
 	volumesFilter       string
+	volumeMapArg        string
 	helpRankingFlag     bool
 	helpFilterFlag      bool
 )
 
+const version = "0.1"
+
+// defaultUserAgent identifies requests, so MangaDex can tell clients
+// apart when troubleshooting.
+const defaultUserAgent = "kojirou/" + version + " (+https://github.com/leotaku/kojirou)"
+
 var rootCmd = &cobra.Command{
 	Use:     "kojirou [flags..] <identifier>",
 	Short:   "Generate Kindle-compatible e-books from MangaDex",
-	Version: "0.1",
+	Version: version,
 	Args:    cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cmd.SilenceUsage = true
 		identifierArg = args[0]
 
-		return run()
+		return run(cmd.Flags())
 	},
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		formats.SetColorMode(colorArg)
+		if langArg != "" {
+			if err := formats.SetLanguage(langArg); err != nil {
+				return formats.Errorf("lang: %w", err)
+			}
+		}
+		cmd.Root().SetErrPrefix(formats.ErrorPrefix())
+
 		if cpuprofileArg != "" {
 			f, err := os.Create(cpuprofileArg)
 			if err != nil {
@@ -107,7 +208,12 @@ Prefer chapters that have been uploaded most recently.
   views-total:
 Prefer chapters by groups with the most total views.
   views:
-Prefer chapters with the most views.`,
+Prefer chapters with the most views.
+  quality:
+Prefer chapters with the best image quality.  This downloads
+a few sample pages of every chapter that was released by
+multiple groups and compares their resolution, sharpness and
+compression.`,
 }
 
 var helpFilterCmd = &cobra.Command{
@@ -153,7 +259,12 @@ chapters by groups that match the regular expression.
 
 Technically, the "--language" option is also implemented
 as a filter, however it is non-optional and must always be
-given.  It accepts the format of BCP 47 language tags.`,
+given.  It accepts the format of BCP 47 language tags.
+Should the selected chapters still end up in more than one
+language, for example because of chapters loaded from disk,
+Kojirou refuses to continue unless "--mixed-languages" is
+given, in which case the odd chapters are marked in the
+summary.`,
 }
 
 func Execute() {
@@ -167,28 +278,125 @@ func Execute() {
 }
 
 func init() {
+	download.SetUserAgent(defaultUserAgent)
 	rootCmd.Flags().StringVarP(&languageArg, "language", "l", "en", "language for chapter downloads")
 	rootCmd.Flags().StringVarP(&rankArg, "rank", "r", "most", "chapter ranking method to use")
+	rootCmd.Flags().BoolVarP(&mixedLanguagesArg, "mixed-languages", "", false, "allow volumes with chapters in multiple languages")
+	rootCmd.Flags().BoolVarP(&keepTeasersArg, "keep-teasers", "", false, "keep short teaser chapters duplicating full releases")
+	rootCmd.Flags().BoolVarP(&mergeSubchaptersArg, "merge-subchapters", "", false, "list parts of split chapters like 31.1 and 31.2 as one chapter")
+	rootCmd.Flags().StringVarP(&volumeMapArg, "volume-map", "", "", "assign chapters to the volumes listed in this file or URL")
+	rootCmd.Flags().StringVarP(&splitByArg, "split-by", "", "", "write separate volumes per group instead of merging them")
+	rootCmd.Flags().StringVarP(&interleaveArg, "interleave", "", "", "follow every page with the raw page in this language")
 	rootCmd.Flags().BoolVarP(&autocropArg, "autocrop", "a", false, "crop whitespace from pages automatically")
+	rootCmd.Flags().BoolVarP(&stitchSpreadsArg, "stitch-spreads", "", false, "join facing pages of double pages split in two")
+	rootCmd.Flags().BoolVarP(&dropCreditsArg, "drop-credits", "", false, "remove credit pages repeated across chapters")
+	rootCmd.Flags().StringVarP(&creditHashesArg, "credit-hashes", "", "", "also remove pages with hashes listed in this file")
+	rootCmd.Flags().BoolVarP(&dedupPagesArg, "dedup-pages", "", false, "remove pages looking like earlier pages of the volume")
+	rootCmd.Flags().BoolVarP(&grayscaleArg, "grayscale", "", false, "convert pages to grayscale before encoding")
+	rootCmd.Flags().StringVarP(&kccPresetArg, "kcc-preset", "", "", "use Kindle Comic Converter profile and options")
+	rootCmd.Flags().StringVarP(&profileArg, "profile", "", "", "use resolution, shades and format of this device")
+	rootCmd.Flags().StringVarP(&resizeArg, "resize", "", "", "shrink pages to fit this size, e.g. 1236x1648")
+	rootCmd.Flags().IntVarP(&maxWidthArg, "max-width", "", 0, "shrink pages to at most this width")
+	rootCmd.Flags().IntVarP(&maxHeightArg, "max-height", "", 0, "shrink pages to at most this height")
+	rootCmd.Flags().StringVarP(&resizeFilterArg, "resize-filter", "", "catmull-rom", "shrink pages with this filter (catmull-rom, lanczos or nearest)")
+	rootCmd.Flags().IntVarP(&grayLevelsArg, "gray-levels", "", 0, "convert pages to this many shades of gray (2 to 256)")
+	rootCmd.Flags().BoolVarP(&autoLevelsArg, "auto-levels", "", false, "stretch the contrast of washed-out pages")
+	rootCmd.Flags().Float64VarP(&blackClipArg, "black-clip", "", 0.5, "percent of darkest pixels made black by --auto-levels")
+	rootCmd.Flags().Float64VarP(&whiteClipArg, "white-clip", "", 0.5, "percent of brightest pixels made white by --auto-levels")
+	rootCmd.Flags().Float64VarP(&sharpenArg, "sharpen", "", 0, "sharpen pages after resizing by this amount, e.g. 0.5")
+	rootCmd.Flags().Float64VarP(&sharpenRadiusArg, "sharpen-radius", "", 1, "sharpen details of about this many pixels")
+	rootCmd.Flags().StringVarP(&ditherArg, "dither", "", "", "dither pages to 16 or --gray-levels shades (floyd-steinberg or ordered)")
+	rootCmd.Flags().BoolVarP(&keepColorArg, "keep-color", "", false, "keep colors of color pages when converting to grayscale")
+	rootCmd.Flags().IntVarP(&colorQualityArg, "color-quality", "", 0, "encode color pages kept by --keep-color with this quality (1 to 100, 0 for --jpeg-quality)")
+	rootCmd.Flags().StringVarP(&filterCmdArg, "filter-cmd", "", "", "filter pages through this command")
+	rootCmd.Flags().StringVarP(&upscaleCmdArg, "upscale-cmd", "", "", "enlarge pages smaller than the device with this command")
+	rootCmd.Flags().DurationVarP(&hookTimeoutArg, "hook-timeout", "", time.Minute, "kill external commands running longer than this")
+	rootCmd.Flags().BoolVarP(&hookSandboxArg, "hook-sandbox", "", false, "run external commands in a temporary directory")
 	rootCmd.Flags().BoolVarP(&kindleFolderModeArg, "kindle-folder-mode", "k", false, "generate folder structure for Kindle devices")
+	rootCmd.Flags().BoolVarP(&indexArg, "index", "", false, "generate a book listing all volumes with covers")
+	rootCmd.Flags().BoolVarP(&contactSheetArg, "contact-sheet", "", false, "write an image with thumbnails of all pages for volumes")
+	rootCmd.Flags().BoolVarP(&chapterMtimeArg, "chapter-mtime", "", false, "set modification times of volumes to the release of their newest chapter")
+	rootCmd.Flags().BoolVarP(&overlayIDsArg, "overlay-ids", "", false, "label pages with their chapter and page for review")
+	rootCmd.Flags().BoolVarP(&contentWarningsArg, "content-warnings", "", false, "add a page listing content warnings and tags to volumes")
+	rootCmd.Flags().BoolVarP(&discussionPageArg, "discussion-page", "", false, "add a page with a QR code leading to MangaDex comments to volumes")
+	rootCmd.Flags().BoolVarP(&panelViewArg, "panel-view", "", false, "let Kindle devices zoom into panels one after another on double-tap")
+	rootCmd.Flags().BoolVarP(&enrichArg, "enrich", "", false, "add metadata from sites other than MangaDex")
 	rootCmd.Flags().BoolVarP(&leftToRightArg, "left-to-right", "p", false, "make reading direction left to right")
 	rootCmd.Flags().IntVarP(&fillVolumeNumberArg, "fill-volume-number", "n", 0, "fill volume number with leading zeros in title")
+	rootCmd.Flags().StringVarP(&coverFallbackArg, "cover-fallback", "", "none", "cover for volumes without one (none, previous, main or first-page)")
 	rootCmd.Flags().VarP(&dataSaverArg, "data-saver", "s", "download lower quality images to save space")
 	rootCmd.Flags().BoolVarP(&dryRunArg, "dry-run", "d", false, "disable writing of any files")
-	rootCmd.Flags().StringVarP(&outArg, "out", "o", "", "output directory")
+	rootCmd.Flags().BoolVarP(&linksArg, "links", "", false, "list MangaDex links of selected chapters in the summary")
+	rootCmd.Flags().BoolVarP(&strictArg, "strict", "", false, "fail instead of writing volumes when there are warnings")
+	rootCmd.Flags().BoolVarP(&asciiArg, "ascii", "", false, "only use ASCII characters for progress bars")
+	rootCmd.Flags().StringVarP(&progressSocketArg, "progress-socket", "", "", "also send progress as JSON events to this Unix socket")
+	rootCmd.PersistentFlags().VarP(&colorArg, "color", "", "color output (auto, always or never)")
+	rootCmd.PersistentFlags().StringVarP(&langArg, "lang", "", "", "language of messages, e.g. pt-BR (default from locale)")
+	rootCmd.Flags().StringVarP(&outArg, "out", "o", "", "output directory, or zip or 7z archive to write all volumes into")
+	rootCmd.Flags().StringVarP(&formatArg, "format", "", "azw3", "format of volumes (azw3, kfx, epub, kepub, cbz, tachiyomi or images)")
+	rootCmd.Flags().IntVarP(&jpegQualityArg, "jpeg-quality", "", jpeg.DefaultQuality, "encode pages with this JPEG quality (1 to 100)")
+	rootCmd.Flags().StringVarP(&pageCodecArg, "page-codec", "", kindle.CodecJPEG, "encode pages with this codec (jpeg, png, or webp and avif for epub and cbz)")
+	rootCmd.Flags().IntVarP(&codecQualityArg, "codec-quality", "", 0, "encode webp and avif pages with this quality (1 to 100, 0 for the encoder default)")
+	rootCmd.Flags().StringVarP(&codecCmdArg, "codec-cmd", "", "", "encode webp and avif pages with this command")
+	rootCmd.Flags().StringVarP(&jpegCmdArg, "jpeg-cmd", "", "", "encode jpeg pages with this command, e.g. cjpeg of mozjpeg")
+	rootCmd.Flags().StringVarP(&avifCmdArg, "avif-cmd", "", "magick avif:- png:-", "decode avif pages with this command")
+	rootCmd.Flags().BoolVarP(&optimizeArg, "optimize", "", false, "losslessly shrink JPEG pages after encoding")
+	rootCmd.Flags().StringVarP(&optimizeCmdArg, "optimize-cmd", "", "jpegtran -copy none -optimize", "shrink JPEG pages with this command")
+	rootCmd.Flags().StringVarP(&kfxCmdArg, "kfx-cmd", "", "kindlepreviewer {input} -convert -output {output}", "convert books to kfx with this command")
+	rootCmd.Flags().StringVarP(&sevenZipCmdArg, "7z-cmd", "", "7z", "pack and extract 7z archives with this command")
+	rootCmd.Flags().StringArrayVarP(&sendArg, "send", "", nil, "also write volumes to this device directory")
+	rootCmd.Flags().BoolVarP(&sendOnlyArg, "send-only", "", false, "write volumes to --send devices without writing them to the output directory")
 	rootCmd.Flags().BoolVarP(&forceArg, "force", "f", false, "overwrite existing volumes")
+	rootCmd.Flags().VarP(&onExistingArg, "on-existing", "", "how to handle existing volumes")
+	rootCmd.Flags().StringVarP(&onCollisionArg, "on-collision", "", "abort", "how to handle filename collisions (abort or tag)")
+	rootCmd.Flags().StringVarP(&stagingDirArg, "staging-dir", "", "", "write files to this directory before moving them")
+	rootCmd.Flags().VarP(&syncArg, "sync", "", "how to ensure written files reach the disk (none, fsync or write-through)")
 	rootCmd.Flags().StringVarP(&diskArg, "disk", "D", "", "load additional content from disk")
+	rootCmd.Flags().BoolVarP(&diskSearchArg, "disk-search", "", false, "search the disk directory for the manga among other series")
+	rootCmd.Flags().IntVarP(&ioWorkersArg, "io-workers", "", 4, "read this many pages from disk at once")
+	rootCmd.Flags().StringVarP(&pageCacheArg, "page-cache", "", "", "keep processed pages in this directory for later runs")
+	rootCmd.Flags().BoolVarP(&pageCacheLinksArg, "page-cache-links", "", false, "hard-link identical pages in the --page-cache directory")
+	rootCmd.Flags().StringVarP(&apiBaseURLArg, "api-base-url", "", "", "use this server instead of MangaDex")
+	rootCmd.Flags().StringVarP(&ipVersionArg, "ip-version", "", download.IPVersionAuto, "connect using this IP version (4, 6 or auto)")
+	rootCmd.Flags().StringVarP(&caFileArg, "ca-file", "", "", "also trust the certificate authorities in this PEM file")
+	rootCmd.Flags().StringArrayVarP(&pinCertArg, "pin-cert", "", nil, "only accept keys with this SHA-256 hash for a host, e.g. api.mangadex.org=BASE64")
+	rootCmd.Flags().StringVarP(&apiVersionArg, "api-version", "", "", "request this version of the MangaDex API")
+	rootCmd.Flags().BoolVarP(&unpublishedArg, "include-unpublished", "", false, "include chapters only visible to the user of the access token")
+	rootCmd.Flags().StringVarP(&recordArg, "record", "", "", "record all responses to this archive")
+	rootCmd.Flags().BoolVarP(&recordImageDataArg, "record-image-data", "", false, "record images instead of their dimensions")
+	rootCmd.Flags().StringVarP(&replayArg, "replay", "", "", "replay responses from this archive")
+	rootCmd.Flags().Float64VarP(&failRateArg, "fail-rate", "", 0, "make this fraction of requests fail for testing")
+	rootCmd.Flags().DurationVarP(&injectLatencyArg, "inject-latency", "", 0, "delay requests by up to this long for testing")
+	rootCmd.Flags().VarP(&maxMemoryArg, "max-memory", "", "reduce concurrency and stage pages on disk when volumes use more memory than this size")
+	rootCmd.Flags().Int64VarP(&maxPixelsArg, "max-pixels", "", 100_000_000, "skip images with more pixels than this")
+	rootCmd.Flags().BoolVarP(&noSIMDArg, "no-simd", "", false, "process images without SIMD instructions of the processor")
+	rootCmd.Flags().StringVarP(&configArg, "config", "c", config.DefaultPath(), "load configuration from this file")
 	rootCmd.Flags().StringVarP(&cpuprofileArg, "cpuprofile", "", "", "write CPU profile to this file")
 	rootCmd.Flags().StringVarP(&memprofileArg, "memprofile", "", "", "write heap profile to this file")
 	rootCmd.Flags().StringVarP(&volumesFilter, "volumes", "V", "", "volume identifiers for chapter downloads")
 	rootCmd.Flags().StringVarP(&chaptersFilter, "chapters", "C", "", "chapter identifiers for chapter downloads")
 	rootCmd.Flags().StringVarP(&groupsFilter, "groups", "G", "", "scantlation groups for chapter downloads")
+	rootCmd.Flags().BoolVarP(&ignoreBlockedArg, "ignore-blocked", "", false, "include chapters by groups and uploaders blocked on MangaDex")
 	rootCmd.Flags().BoolVarP(&helpRankingFlag, "help-ranking", "R", false, "Help for chapter ranking")
 	rootCmd.Flags().BoolVarP(&helpFilterFlag, "help-filter", "F", false, "Help for chapter filtering")
+	rootCmd.Flags().SetAnnotation("volumes", filterAnnotation, []string{"true"})        //nolint:errcheck
+	rootCmd.Flags().SetAnnotation("chapters", filterAnnotation, []string{"true"})       //nolint:errcheck
+	rootCmd.Flags().SetAnnotation("groups", filterAnnotation, []string{"true"})         //nolint:errcheck
+	rootCmd.Flags().SetAnnotation("ignore-blocked", filterAnnotation, []string{"true"}) //nolint:errcheck
+	for _, name := range []string{
+		"language", "rank", "mixed-languages", "keep-teasers", "merge-subchapters", "volume-map", "split-by", "interleave", "autocrop", "stitch-spreads", "drop-credits", "dedup-pages", "kcc-preset",
+		"upscale-cmd", "filter-cmd", "content-warnings", "discussion-page", "panel-view", "left-to-right", "fill-volume-number", "cover-fallback", "data-saver", "groups", "format", "page-codec",
+		"profile", "resize", "max-width", "max-height", "resize-filter", "gray-levels", "grayscale", "dither", "keep-color", "color-quality",
+		"auto-levels", "black-clip", "white-clip", "sharpen", "sharpen-radius",
+	} {
+		rootCmd.Flags().SetAnnotation(name, seriesAnnotation, []string{"true"}) //nolint:errcheck
+	}
 	rootCmd.Flags().SortFlags = false
-	rootCmd.Flags().MarkHidden("cpuprofile") //nolint:errcheck
-	rootCmd.Flags().MarkHidden("memprofile") //nolint:errcheck
-	rootCmd.MarkFlagRequired("language")     //nolint:errcheck
+	rootCmd.Flags().MarkHidden("cpuprofile")     //nolint:errcheck
+	rootCmd.Flags().MarkHidden("memprofile")     //nolint:errcheck
+	rootCmd.Flags().MarkHidden("fail-rate")      //nolint:errcheck
+	rootCmd.Flags().MarkHidden("inject-latency") //nolint:errcheck
+	rootCmd.MarkFlagRequired("language")         //nolint:errcheck
 	rootCmd.SetHelpFunc(help)
 	rootCmd.SetUsageFunc(usage)
 	rootCmd.ParseFlags(os.Args) //nolint:errcheck
@@ -0,0 +1,296 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"fmt"
+	"image"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/leotaku/kojirou/cmd/formats/disk"
+	"github.com/leotaku/kojirou/cmd/formats/kindle"
+	md "github.com/leotaku/kojirou/mangadex"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/language"
+)
+
+//go:embed testdata/selftest
+var selftestFS embed.FS
+
+const (
+	selftestFixtures = "testdata/selftest/manga"
+	selftestGolden   = "testdata/selftest/golden.txt"
+)
+
+var selftestPrintArg bool
+
+var selftestCmd = &cobra.Command{
+	Use:   "selftest [flags..]",
+	Short: "Generate books from bundled fixtures and compare them against known outputs",
+	Long: `Generate books from bundled fixtures and compare them against known outputs
+
+The fixtures are tiny synthetic pages that are processed by the
+same pipeline as regular downloads, without any network access.
+A mismatch means that this build produces different books than
+the build the golden outputs were recorded with, e.g. because
+of a changed dependency or platform-specific image encoding.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		return runSelftest()
+	},
+	DisableFlagsInUseLine: true,
+}
+
+type selftestCase struct {
+	name         string
+	processing   processing
+	kindleFolder bool
+	leftToRight  bool
+	format       string
+}
+
+var selftestCases = []selftestCase{
+	{name: "plain"},
+	{name: "autocrop", processing: processing{Autocrop: true}},
+	{name: "profile", processing: processing{Size: image.Pt(32, 48), Levels: 16}},
+	{name: "dither", processing: processing{Levels: 16, Dither: ditherFloydSteinberg}},
+	{name: "lanczos", processing: processing{Size: image.Pt(32, 48), ResizeFilter: "lanczos"}},
+	{name: "sharpen", processing: processing{Size: image.Pt(32, 48), Sharpen: 0.5, SharpenRadius: 1}},
+	{name: "auto-levels", processing: processing{AutoLevels: true, BlackClip: 2, WhiteClip: 2}},
+	{name: "kindle-folder", kindleFolder: true},
+	{name: "left-to-right", leftToRight: true},
+	{name: "epub", format: kindle.FormatEPUB},
+	{name: "cbz", format: kindle.FormatCBZ},
+	{name: "kepub", format: kindle.FormatKEPUB},
+	{name: "images", format: kindle.FormatImages},
+	{name: "tachiyomi", format: kindle.FormatTachiyomi},
+}
+
+func runSelftest() error {
+	golden, err := loadGolden()
+	if err != nil {
+		return formats.Errorf("golden: %w", err)
+	}
+
+	tmp, err := os.MkdirTemp("", "kojirou-selftest-")
+	if err != nil {
+		return formats.Errorf("selftest: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	fixtures := filepath.Join(tmp, path.Base(selftestFixtures))
+	if err := extractFixtures(fixtures); err != nil {
+		return formats.Errorf("fixtures: %w", err)
+	}
+	p := formats.VanishingProgress("Fixtures")
+	manga, err := loadFixtures(fixtures, p)
+	if err != nil {
+		p.Cancel("Error")
+		return formats.Errorf("fixtures: %w", err)
+	}
+	p.Done()
+
+	mismatches := make([]string, 0)
+	for _, c := range selftestCases {
+		p := formats.TitledProgress(fmt.Sprintf("Selftest: %v", c.name))
+		hashes, err := c.run(*manga, fixtures, filepath.Join(tmp, c.name), p)
+		if err != nil {
+			p.Cancel("Error")
+			return formats.Errorf("case %v: %w", c.name, err)
+		}
+
+		failed := false
+		for _, name := range sortedKeys(hashes, golden[c.name]) {
+			got, want := hashes[name], golden[c.name][name]
+			if selftestPrintArg {
+				fmt.Printf("%v %v %v\n", c.name, name, got)
+			} else if got != want {
+				failed = true
+				mismatches = append(mismatches, fmt.Sprintf("  %v/%v: got %q, want %q", c.name, name, got, want))
+			}
+		}
+		if failed {
+			p.Cancel("Mismatch")
+		} else {
+			p.Done()
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return formats.Errorf("golden mismatch:\n%v", strings.Join(mismatches, "\n"))
+	}
+
+	return nil
+}
+
+// run writes all volumes of the manga to the output directory and
+// returns the hashes of all written files.
+func (c selftestCase) run(manga md.Manga, fixtures, out string, p formats.CliProgress) (map[string]string, error) {
+	dir := kindle.NewNormalizedDirectory(out, manga.Info.Title, c.kindleFolder).
+		WithMetadata(manga.Info).
+		WithFormat(c.format)
+	for _, volume := range manga.Sorted() {
+		pages, err := disk.LoadPages(volume.Sorted(), p)
+		if err != nil {
+			return nil, formats.Errorf("volume %v: pages: %w", volume.Info.Identifier, err)
+		}
+		pages, err = processPages(pages, hashPages(pages, c.processing), c.processing)
+		if err != nil {
+			return nil, formats.Errorf("volume %v: process: %w", volume.Info.Identifier, err)
+		}
+
+		mobi := volumeToMOBI(manga, relativeVolume(volume, fixtures), pages)
+		mobi.RightToLeft = !c.leftToRight
+		hash := volumeHash(volume)
+		filename, _ := dir.Filename(volume.Info.Identifier, hash, kindle.ExistingPolicyOverwrite)
+		wp := formats.VanishingProgress("Writing...")
+		if c.format == kindle.FormatImages {
+			err = dir.WriteImages(volume.Info.Identifier, filename, hash, "", nil, pages, wp)
+		} else {
+			err = dir.Write(volume.Info.Identifier, filename, hash, "", nil, mobi, wp)
+		}
+		if err != nil {
+			wp.Cancel("Error")
+			return nil, formats.Errorf("volume %v: write: %w", volume.Info.Identifier, err)
+		}
+		wp.Done()
+	}
+	if err := dir.WriteDetails(manga, p); err != nil {
+		return nil, formats.Errorf("details: %w", err)
+	}
+
+	return hashFiles(out)
+}
+
+func loadFixtures(fixtures string, p formats.Progress) (*md.Manga, error) {
+	manga, err := disk.LoadSkeleton(fixtures)
+	if err != nil {
+		return nil, formats.Errorf("skeleton: %w", err)
+	}
+	chapters, err := disk.LoadChapters(fixtures, language.English, p)
+	if err != nil {
+		return nil, formats.Errorf("chapters: %w", err)
+	}
+	covers, err := disk.LoadCovers(fixtures, p)
+	if err != nil {
+		return nil, formats.Errorf("covers: %w", err)
+	}
+	*manga = manga.WithChapters(chapters).WithCovers(covers)
+
+	return manga, nil
+}
+
+// relativeVolume makes chapter identifiers of the volume independent
+// of the temporary directory, as they are embedded in the books.
+func relativeVolume(volume md.Volume, root string) md.Volume {
+	chapters := make(map[md.Identifier]md.Chapter, len(volume.Chapters))
+	for id, chapter := range volume.Chapters {
+		if rel, err := filepath.Rel(root, chapter.Info.ID); err == nil {
+			chapter.Info.ID = filepath.ToSlash(rel)
+		}
+		chapters[id] = chapter
+	}
+	volume.Chapters = chapters
+
+	return volume
+}
+
+// hashFiles returns the SHA-256 hashes of all files in the directory
+// by relative path.  Manifests are ignored, as they contain the time
+// of writing.
+func hashFiles(root string) (map[string]string, error) {
+	result := make(map[string]string)
+	err := filepath.WalkDir(root, func(pathname string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() == ".kojirou.json" {
+			return err
+		}
+		data, err := os.ReadFile(pathname)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, pathname)
+		if err != nil {
+			return err
+		}
+		result[filepath.ToSlash(rel)] = fmt.Sprintf("%x", sha256.Sum256(data))
+
+		return nil
+	})
+
+	return result, err
+}
+
+func extractFixtures(target string) error {
+	return fs.WalkDir(selftestFS, selftestFixtures, func(pathname string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(target, filepath.FromSlash(strings.TrimPrefix(pathname, selftestFixtures)))
+		if d.IsDir() {
+			return os.MkdirAll(dest, 0755)
+		}
+		data, err := selftestFS.ReadFile(pathname)
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(dest, data, 0644)
+	})
+}
+
+// loadGolden parses lines of "case file hash" into hashes by file
+// and case.
+func loadGolden() (map[string]map[string]string, error) {
+	data, err := selftestFS.ReadFile(selftestGolden)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		} else if len(fields) != 3 {
+			return nil, formats.Errorf("malformed line: %q", scanner.Text())
+		}
+		if result[fields[0]] == nil {
+			result[fields[0]] = make(map[string]string)
+		}
+		result[fields[0]][fields[1]] = fields[2]
+	}
+
+	return result, scanner.Err()
+}
+
+func sortedKeys(maps ...map[string]string) []string {
+	seen := make(map[string]bool)
+	result := make([]string, 0)
+	for _, m := range maps {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				result = append(result, key)
+			}
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func init() {
+	selftestCmd.Flags().BoolVarP(&selftestPrintArg, "print", "", false, "print hashes instead of comparing them")
+	rootCmd.AddCommand(selftestCmd)
+}
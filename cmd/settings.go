@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/leotaku/kojirou/cmd/formats/kindle"
+	"github.com/spf13/pflag"
+)
+
+const seriesSettingsFilename = "kojirou.series.toml"
+
+// loadSeriesSettings applies the options remembered in the settings
+// file of a series.  Options that were given explicitly are never
+// changed.
+func loadSeriesSettings(pathname string, flags *pflag.FlagSet) error {
+	settings := make(map[string]interface{})
+	if _, err := toml.DecodeFile(pathname, &settings); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return formats.Errorf("decode: %w", err)
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := flags.Lookup(name)
+		if f == nil || len(f.Annotations[seriesAnnotation]) == 0 {
+			return formats.Errorf("unknown key: %v", name)
+		} else if f.Changed {
+			continue
+		}
+		if err := flags.Set(name, fmt.Sprint(settings[name])); err != nil {
+			return formats.Errorf("%v: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// writeSeriesSettings remembers all options for the series that were
+// given explicitly or loaded from earlier settings.
+func writeSeriesSettings(pathname string, flags *pflag.FlagSet) error {
+	settings := make(map[string]interface{})
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if !f.Changed || len(f.Annotations[seriesAnnotation]) == 0 || err != nil {
+			return
+		}
+		switch f.Value.Type() {
+		case "bool":
+			settings[f.Name], err = strconv.ParseBool(f.Value.String())
+		case "int", "int64":
+			settings[f.Name], err = strconv.ParseInt(f.Value.String(), 10, 64)
+		default:
+			settings[f.Name] = f.Value.String()
+		}
+	})
+	if err != nil {
+		return formats.Errorf("encode: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(pathname), os.ModePerm); err != nil {
+		return formats.Errorf("create: %w", err)
+	}
+	file, err := os.Create(pathname)
+	if err != nil {
+		return formats.Errorf("create: %w", err)
+	}
+	defer file.Close()
+	if err := toml.NewEncoder(file).Encode(settings); err != nil {
+		return formats.Errorf("encode: %w", err)
+	}
+
+	return file.Close()
+}
+
+// buildInfo describes the current run by the options that change the
+// generated books, in the form they were given on the command line.
+func buildInfo(flags *pflag.FlagSet) kindle.BuildInfo {
+	settings := make([]string, 0)
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Changed && len(f.Annotations[seriesAnnotation]) > 0 {
+			settings = append(settings, fmt.Sprintf("--%v=%v", f.Name, f.Value))
+		}
+	})
+
+	return kindle.BuildInfo{
+		Time:     time.Now(),
+		Version:  version,
+		Settings: strings.Join(settings, " "),
+	}
+}
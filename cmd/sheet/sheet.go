@@ -0,0 +1,76 @@
+package sheet
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	md "github.com/leotaku/kojirou/mangadex"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	columns     = 10
+	cellWidth   = 120
+	cellHeight  = 180
+	labelHeight = 16
+	gap         = 8
+)
+
+// Render draws all pages as a grid of small thumbnails, each labeled
+// with its chapter and page, so that upside-down, duplicated or
+// missing pages can be spotted at a glance.
+func Render(pages md.ImageList) image.Image {
+	rows := (len(pages) + columns - 1) / columns
+	cols := columns
+	if len(pages) < columns {
+		cols = len(pages)
+	}
+
+	width := gap + cols*(cellWidth+gap)
+	height := gap + rows*(cellHeight+labelHeight+gap)
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(result, result.Bounds(), image.White, image.Point{}, draw.Src)
+
+	for i, page := range pages {
+		if page.Image.Bounds().Empty() {
+			continue
+		}
+		x := gap + (i%columns)*(cellWidth+gap)
+		y := gap + (i/columns)*(cellHeight+labelHeight+gap)
+		cell := image.Rect(x, y, x+cellWidth, y+cellHeight)
+		xdraw.ApproxBiLinear.Scale(result, fit(page.Image.Bounds(), cell), page.Image, page.Image.Bounds(), draw.Src, nil)
+		label(result, image.Pt(x, y+cellHeight), fmt.Sprintf("%v/%v", page.ChapterIdentifier, page.ImageIdentifier+1))
+	}
+
+	return result
+}
+
+// fit returns the largest rectangle with the aspect ratio of bounds
+// that is centered in cell.
+func fit(bounds, cell image.Rectangle) image.Rectangle {
+	w, h := cell.Dx(), cell.Dy()
+	if bounds.Dx()*h > bounds.Dy()*w {
+		h = bounds.Dy() * w / bounds.Dx()
+	} else {
+		w = bounds.Dx() * h / bounds.Dy()
+	}
+	offset := image.Pt((cell.Dx()-w)/2, (cell.Dy()-h)/2)
+
+	return image.Rect(0, 0, w, h).Add(cell.Min).Add(offset)
+}
+
+func label(dst draw.Image, at image.Point, text string) {
+	face := basicfont.Face7x13
+	d := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(color.Black),
+		Face: face,
+		Dot:  fixed.P(at.X, at.Y+face.Ascent+(labelHeight-face.Height)/2),
+	}
+	d.DrawString(text)
+}
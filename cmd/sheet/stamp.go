@@ -0,0 +1,65 @@
+package sheet
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	md "github.com/leotaku/kojirou/mangadex"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font/basicfont"
+)
+
+// Labels are scaled up for pages larger than this, so they stay
+// readable on devices after the pages are scaled down.
+const stampReferenceHeight = 800
+
+// Stamp labels every page with its chapter and page in the top left
+// corner, so that problems found while reviewing a volume can be
+// traced back to the exact page.  Pages are not copied, as only the
+// pixels of the label are replaced.
+func Stamp(pages md.ImageList) md.ImageList {
+	result := make(md.ImageList, len(pages))
+	for i, page := range pages {
+		result[i] = page
+		bounds := page.Image.Bounds()
+		if bounds.Empty() {
+			continue
+		}
+		text := fmt.Sprintf("%v/%v", page.ChapterIdentifier, page.ImageIdentifier+1)
+		result[i].Image = stamp(page.Image, text)
+	}
+
+	return result
+}
+
+type stamped struct {
+	image.Image
+	label *image.RGBA
+}
+
+func (s stamped) At(x, y int) color.Color {
+	if image.Pt(x, y).In(s.label.Rect) {
+		return s.label.At(x, y)
+	}
+
+	return s.Image.At(x, y)
+}
+
+func stamp(img image.Image, text string) image.Image {
+	face := basicfont.Face7x13
+	small := image.NewRGBA(image.Rect(0, 0, len(text)*face.Advance+4, labelHeight))
+	draw.Draw(small, small.Bounds(), image.White, image.Point{}, draw.Src)
+	label(small, image.Pt(2, 0), text)
+
+	bounds := img.Bounds()
+	scale := bounds.Dy()/stampReferenceHeight + 1
+	rect := image.Rect(0, 0, small.Rect.Dx()*scale, small.Rect.Dy()*scale).
+		Add(bounds.Min).
+		Intersect(bounds)
+	large := image.NewRGBA(rect)
+	xdraw.NearestNeighbor.Scale(large, image.Rect(0, 0, small.Rect.Dx()*scale, small.Rect.Dy()*scale).Add(bounds.Min), small, small.Bounds(), draw.Src, nil)
+
+	return stamped{Image: img, label: large}
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/leotaku/kojirou/cmd/formats"
+	md "github.com/leotaku/kojirou/mangadex"
+)
+
+const (
+	// Largest difference in height, in percent, of pages forming a spread
+	spreadHeightTolerance = 2
+	// Largest mean difference in brightness of the adjoining edges
+	spreadEdgeDifference = 0.08
+	// Smallest fraction of edge samples that are not paper, so that
+	// pages with blank margins at their edges are not stitched
+	spreadEdgeContent = 0.15
+	spreadEdgeSamples = 200
+	spreadPaper       = 0.9
+)
+
+// stitchSpreads joins consecutive portrait pages of the same chapter
+// whose adjoining edges continue into each other, as happens when
+// double pages were uploaded as two single pages.  The first page of a
+// spread is placed on the right for right-to-left reading.  Stitched
+// spreads keep the identifier of their first page.
+func stitchSpreads(pages md.ImageList, rightToLeft bool) md.ImageList {
+	type key struct {
+		chapter md.Identifier
+		image   int
+	}
+	byKey := make(map[key]int)
+	for i, page := range pages {
+		byKey[key{page.ChapterIdentifier, page.ImageIdentifier}] = i
+	}
+
+	stitched := make(map[int]bool)
+	result := make(md.ImageList, 0, len(pages))
+	for i, page := range pages {
+		if stitched[i] {
+			continue
+		}
+		j, ok := byKey[key{page.ChapterIdentifier, page.ImageIdentifier + 1}]
+		if !ok || stitched[j] {
+			result = append(result, page)
+			continue
+		}
+		left, right := formats.Decoded(page.Image), formats.Decoded(pages[j].Image)
+		if rightToLeft {
+			left, right = right, left
+		}
+		if isSpread(left, right) {
+			page.Image = joinSpread(left, right)
+			stitched[j] = true
+		}
+		result = append(result, page)
+	}
+
+	return result
+}
+
+// isSpread reports whether the right edge of left continues into the
+// left edge of right.  Edges are compared at the same relative height,
+// so slightly differently scaled halves still match.
+func isSpread(left, right image.Image) bool {
+	lb, rb := left.Bounds(), right.Bounds()
+	if lb.Empty() || rb.Empty() || lb.Dx() >= lb.Dy() || rb.Dx() >= rb.Dy() {
+		return false
+	}
+	if diff := lb.Dy() - rb.Dy(); diff*100 > lb.Dy()*spreadHeightTolerance || -diff*100 > lb.Dy()*spreadHeightTolerance {
+		return false
+	}
+
+	difference, content := 0.0, 0
+	for i := 0; i < spreadEdgeSamples; i++ {
+		l := brightness(left, lb.Max.X-1, lb.Min.Y+i*lb.Dy()/spreadEdgeSamples)
+		r := brightness(right, rb.Min.X, rb.Min.Y+i*rb.Dy()/spreadEdgeSamples)
+		if l < spreadPaper || r < spreadPaper {
+			content++
+		}
+		if l > r {
+			difference += l - r
+		} else {
+			difference += r - l
+		}
+	}
+
+	return float64(content) >= spreadEdgeSamples*spreadEdgeContent &&
+		difference/spreadEdgeSamples <= spreadEdgeDifference
+}
+
+func brightness(img image.Image, x, y int) float64 {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 0xffff
+}
+
+// joinSpread places both pages next to each other, aligned at the top.
+func joinSpread(left, right image.Image) image.Image {
+	lb, rb := left.Bounds(), right.Bounds()
+	height := lb.Dy()
+	if rb.Dy() > height {
+		height = rb.Dy()
+	}
+	result := image.NewRGBA(image.Rect(0, 0, lb.Dx()+rb.Dx(), height))
+	draw.Draw(result, result.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(result, image.Rect(0, 0, lb.Dx(), lb.Dy()), left, lb.Min, draw.Src)
+	draw.Draw(result, image.Rect(lb.Dx(), 0, lb.Dx()+rb.Dx(), rb.Dy()), right, rb.Min, draw.Src)
+
+	return result
+}
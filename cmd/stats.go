@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/leotaku/kojirou/cmd/filter"
+	"github.com/leotaku/kojirou/cmd/formats"
+	"github.com/leotaku/kojirou/cmd/formats/download"
+	"github.com/leotaku/kojirou/cmd/formats/kindle"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/language"
+)
+
+var statsOfflineArg bool
+
+var statsCmd = &cobra.Command{
+	Use:   "stats [flags..] <directory>",
+	Short: "Summarize the volumes written to a library of series",
+	Long: `Summarize the volumes written to a library of series
+
+Every directory below the given directory that Kojirou wrote
+volumes to is listed with the number of volumes, their total size,
+the average number of pages and the formats they were written in.
+
+  $ kojirou stats library/
+
+Series are also checked for chapters updated on MangaDex since
+their newest volume was written, which are written by running
+Kojirou with "--on-existing update" again.  Use the "--offline"
+option to skip this check.
+
+Chapters of written volumes that were removed from MangaDex are
+listed with the date a run first noticed their removal, even once
+their volumes were written again without them.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		return printLibrary(args[0], !statsOfflineArg)
+	},
+	DisableFlagsInUseLine: true,
+}
+
+func printLibrary(directory string, checkUpdates bool) error {
+	library, err := kindle.ReadLibrary(directory)
+	if err != nil {
+		return formats.Errorf("library: %w", err)
+	}
+
+	reports := make([]formats.SeriesReport, 0, len(library))
+	for _, series := range library {
+		name, err := filepath.Rel(directory, series.Directory)
+		if err != nil || name == "." {
+			name = series.Directory
+		}
+		report := formats.SeriesReport{
+			Name:         name,
+			Volumes:      series.Volumes,
+			Pages:        series.Pages,
+			CountedPages: series.CountedPages,
+			Size:         series.Size,
+			Formats:      series.Formats,
+			Updated:      -1,
+		}
+		for _, tombstone := range series.Removed {
+			report.Removed = append(report.Removed, formats.RemovedChapter{
+				Chapter: tombstone.Identifier,
+				Volume:  tombstone.Volume,
+				Noticed: tombstone.Noticed,
+			})
+		}
+		if checkUpdates && series.Manga != "" {
+			if updated, err := updatedChapters(series); err != nil {
+				formats.Warn("%v: updates: %v", name, err)
+			} else {
+				report.Updated = updated
+			}
+		}
+		reports = append(reports, report)
+	}
+	formats.PrintLibrary(reports)
+	formats.PrintWarnings()
+
+	return nil
+}
+
+// updatedChapters counts chapters in the language of the series that
+// were updated on MangaDex after its newest volume was written.
+func updatedChapters(series kindle.LibrarySeries) (int, error) {
+	lang, err := language.Parse(series.Language)
+	if err != nil {
+		return 0, formats.Errorf("language: %w", err)
+	}
+	chapters, err := download.MangadexChapters(series.Manga)
+	if err != nil {
+		return 0, formats.Errorf("chapters: %w", err)
+	}
+
+	updated := 0
+	for _, chapter := range filter.FilterByLanguage(chapters, lang) {
+		if chapter.Info.Updated.After(series.Written) {
+			updated++
+		}
+	}
+
+	return updated, nil
+}
+
+func init() {
+	statsCmd.Flags().BoolVarP(&statsOfflineArg, "offline", "", false, "do not check MangaDex for updated chapters")
+	rootCmd.AddCommand(statsCmd)
+}
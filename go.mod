@@ -1,19 +1,32 @@
 module github.com/leotaku/kojirou
 
-go 1.16
+go 1.20
 
 require (
-	github.com/benbjohnson/clock v1.3.5 // indirect
+	github.com/BurntSushi/toml v1.3.2
 	github.com/cheggaaa/pb/v3 v3.1.5
 	github.com/fatih/color v1.17.0
 	github.com/hashicorp/go-retryablehttp v0.7.6
 	github.com/leotaku/mobi v0.5.0
-	github.com/rivo/uniseg v0.4.7 // indirect
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/spf13/cobra v1.8.0
 	github.com/spf13/pflag v1.0.5
 	go.uber.org/ratelimit v0.3.1
+	golang.org/x/image v0.12.0
 	golang.org/x/sync v0.7.0
+	golang.org/x/sys v0.20.0
 	golang.org/x/text v0.15.0
 )
 
+require (
+	github.com/VividCortex/ewma v1.2.0 // indirect
+	github.com/benbjohnson/clock v1.3.5 // indirect
+	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/mattn/go-colorable v0.1.13 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/mattn/go-runewidth v0.0.15 // indirect
+	github.com/rivo/uniseg v0.4.7 // indirect
+)
+
 // replace github.com/leotaku/mobi => ../mobi
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"reflect"
+	"sync"
 	"time"
 
 	"go.uber.org/ratelimit"
@@ -23,6 +26,11 @@ var APIBaseURL, _ = url.Parse(`https://api.mangadex.org/`)
 type Client struct {
 	http    *http.Client
 	baseURL url.URL
+	version string
+	token   string
+	warn    func(SchemaWarning)
+	warned  map[SchemaWarning]bool
+	mutex   sync.Mutex
 }
 
 func NewClient() *Client {
@@ -42,6 +50,30 @@ func (c *Client) WithHTTPClient(http *http.Client) *Client {
 	return c
 }
 
+// WithVersion makes the client request the given API version below
+// the versioned path "v<version>/", for servers that keep serving
+// older versions of their API.
+func (c *Client) WithVersion(version string) *Client {
+	c.version = version
+	return c
+}
+
+// WithToken makes the client authenticate all requests using the given
+// access token, so chapters only visible to the user are included.
+func (c *Client) WithToken(token string) *Client {
+	c.token = token
+	return c
+}
+
+// WithSchemaWarnings makes the client call the given function once
+// for every kind of difference between responses and the known
+// schema.  Such responses are still decoded as far as possible.
+func (c *Client) WithSchemaWarnings(warn func(SchemaWarning)) *Client {
+	c.warn = warn
+	c.warned = make(map[SchemaWarning]bool)
+	return c
+}
+
 func (c *Client) GetManga(ctx context.Context, mangaID string) (*Manga, error) {
 	v := new(Manga)
 	err := c.doJSON(ctx, "GET", "/manga/"+mangaID, v, nil)
@@ -80,6 +112,13 @@ func (c *Client) GetAtHome(ctx context.Context, chapterID string) (*AtHome, erro
 	return v, err
 }
 
+// GetSettings returns the settings of the authenticated user.
+func (c *Client) GetSettings(ctx context.Context) (*Settings, error) {
+	v := new(Settings)
+	err := c.doJSON(ctx, "GET", "/settings", v, nil)
+	return v, err
+}
+
 func (c *Client) PostIDMapping(ctx context.Context, tp string, legacyIDs ...int) (*IDMappingList, error) {
 	v := new(IDMappingList)
 	err := c.doJSON(ctx, "POST", "/legacy/mapping", &v, map[string]interface{}{
@@ -91,6 +130,9 @@ func (c *Client) PostIDMapping(ctx context.Context, tp string, legacyIDs ...int)
 }
 
 func (c *Client) doJSON(ctx context.Context, method, ref string, result, body interface{}) error {
+	if c.version != "" {
+		ref = "/v" + c.version + ref
+	}
 	url, err := c.baseURL.Parse(ref)
 	if err != nil {
 		return fmt.Errorf("url: %w", err)
@@ -109,6 +151,9 @@ func (c *Client) doJSON(ctx context.Context, method, ref string, result, body in
 		return fmt.Errorf("prepare: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
 
 	limitGlobal.Take()
 	resp, err := c.http.Do(req)
@@ -127,9 +172,37 @@ func (c *Client) doJSON(ctx context.Context, method, ref string, result, body in
 		} else {
 			return fmt.Errorf("status: %v", resp.Status)
 		}
-	} else if err := dec.Decode(result); err != nil {
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	// Fields of unexpected types are left empty and reported below
+	typeErr := new(json.UnmarshalTypeError)
+	if err := json.Unmarshal(data, result); err != nil && !errors.As(err, &typeErr) {
 		return fmt.Errorf("decode: %w", err)
 	}
+	if c.warn != nil {
+		c.checkSchema(result, data)
+	}
 
 	return nil
 }
+
+func (c *Client) checkSchema(result interface{}, data []byte) {
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return
+	}
+
+	checkSchema(reflect.TypeOf(result), generic, "", func(w SchemaWarning) {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if !c.warned[w] {
+			c.warned[w] = true
+			c.warn(w)
+		}
+	})
+}
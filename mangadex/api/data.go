@@ -2,7 +2,6 @@ package api
 
 import (
 	"encoding/json"
-	"fmt"
 	"time"
 )
 
@@ -30,7 +29,7 @@ type MangaData struct {
 	ID         string
 	Type       string
 	Attributes struct {
-		Title                          Localized
+		Title                          Localized `api:"required"`
 		AltTitles                      []Localized
 		Description                    Localized
 		IsLocked                       bool
@@ -43,7 +42,9 @@ type MangaData struct {
 		Year                           int
 		ContentRating                  string
 		ChapterNumbersResetOnNewVolume bool
-		Tags                           Relationships
+		AvailableTranslatedLanguages   []string
+		LatestUploadedChapter          string
+		Tags                           []TagData
 		State                          string
 		Version                        int
 		CreatedAt                      time.Time
@@ -52,6 +53,18 @@ type MangaData struct {
 	Relationships Relationships
 }
 
+type TagData struct {
+	ID         string
+	Type       string
+	Attributes struct {
+		Name        Localized
+		Description Localized
+		Group       string
+		Version     int
+	}
+	Relationships Relationships
+}
+
 type ChapterList struct {
 	Result   string
 	Response string
@@ -72,12 +85,13 @@ type ChapterData struct {
 	Type       string
 	Attributes struct {
 		Title              string
-		Volume             string
-		Chapter            string
+		Volume             string `api:"required"`
+		Chapter            string `api:"required"`
 		Pages              int
-		TranslatedLanguage string
+		TranslatedLanguage string `api:"required"`
 		Uploader           string
 		ExternalURL        string
+		IsUnavailable      bool
 		Version            int
 		CreatedAt          time.Time
 		UpdatedAt          time.Time
@@ -106,8 +120,8 @@ type CoverData struct {
 	ID         string
 	Type       string
 	Attributes struct {
-		Volume      string
-		FileName    string
+		Volume      string `api:"required"`
+		FileName    string `api:"required"`
 		Description string
 		Locale      string
 		Version     int
@@ -130,7 +144,7 @@ type AuthorData struct {
 	ID         string
 	Type       string
 	Attributes struct {
-		Name      string
+		Name      string `api:"required"`
 		ImageUrl  string
 		Biography Localized
 		Twitter   string
@@ -138,6 +152,7 @@ type AuthorData struct {
 		MelonBook string
 		FanBox    string
 		Booth     string
+		Namicomi  string
 		NicoVideo string
 		Skeb      string
 		Fantia    string
@@ -172,7 +187,7 @@ type GroupData struct {
 	ID         string
 	Type       string
 	Attributes struct {
-		Name             string
+		Name             string `api:"required"`
 		AltNames         []Localized
 		Website          string
 		IRCServer        string
@@ -187,6 +202,7 @@ type GroupData struct {
 		Official         bool
 		Inactive         bool
 		Verified         bool
+		ExLicensed       bool
 		PublishDelay     int
 		Leader           Relationship
 		Members          Relationships
@@ -219,14 +235,23 @@ type IDMappingData struct {
 
 type AtHome struct {
 	Result  string
-	BaseURL string
+	BaseURL string `api:"required"`
 	Chapter struct {
-		Hash      string
-		Data      []string
-		DataSaver []string
+		Hash      string   `api:"required"`
+		Data      []string `api:"required"`
+		DataSaver []string `api:"required"`
 	}
 }
 
+// Settings are stored by the MangaDex website for every user and
+// mostly have no meaning outside of it, so they are kept undecoded.
+type Settings struct {
+	Result    string
+	UpdatedAt time.Time
+	Settings  map[string]json.RawMessage
+	Template  string
+}
+
 type Relationships struct {
 	Manga      []string
 	Chapter    []string
@@ -274,8 +299,6 @@ func (rs *Relationships) UnmarshalJSON(data []byte) error {
 			rs.Member = append(rs.Member, r.ID)
 		case "creator":
 			rs.Creator = append(rs.Creator, r.ID)
-		default:
-			return fmt.Errorf("unsupported relationship: %v", r.Type)
 		}
 	}
 
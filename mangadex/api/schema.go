@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type SchemaWarningKind int
+
+const (
+	// A field that is not known to this client
+	UnknownField SchemaWarningKind = iota
+	// A required field that is not in the response
+	MissingField
+	// A field of a different type than expected, which is left empty
+	MismatchedField
+)
+
+// SchemaWarning describes a part of a response that does not match the
+// schema known to this client, but did not prevent decoding it.
+type SchemaWarning struct {
+	Kind SchemaWarningKind
+	// Path of the field, e.g. "data[].attributes.title"
+	Field string
+}
+
+func (w SchemaWarning) String() string {
+	switch w.Kind {
+	case UnknownField:
+		return fmt.Sprintf("api: unknown field: %v", w.Field)
+	case MissingField:
+		return fmt.Sprintf("api: missing field: %v", w.Field)
+	default:
+		return fmt.Sprintf("api: field of unexpected type: %v", w.Field)
+	}
+}
+
+var (
+	unmarshalerType    = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	relationshipsType  = reflect.TypeOf(Relationships{})
+	knownRelationships = map[string]bool{
+		"manga": true, "chapter": true, "author": true, "artist": true,
+		"scanlation_group": true, "tag": true, "user": true,
+		"custom_list": true, "cover_art": true, "leader": true,
+		"member": true, "creator": true,
+	}
+)
+
+// checkSchema compares the decoded JSON value against the given type
+// and reports all differences.  Fields are matched like encoding/json
+// does for untagged fields, and fields tagged `api:"required"` are
+// reported when missing.
+func checkSchema(t reflect.Type, v interface{}, path string, report func(SchemaWarning)) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if v == nil {
+		return
+	}
+
+	switch {
+	case t == relationshipsType:
+		checkRelationships(v, path, report)
+		return
+	case reflect.PtrTo(t).Implements(unmarshalerType):
+		return
+	}
+
+	mismatch := func() {
+		report(SchemaWarning{Kind: MismatchedField, Field: path})
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			mismatch()
+			return
+		}
+		matched := make(map[string]bool)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			key, val, ok := lookupFold(obj, field.Name)
+			if !ok {
+				if field.Tag.Get("api") == "required" {
+					report(SchemaWarning{Kind: MissingField, Field: joinPath(path, strings.ToLower(field.Name))})
+				}
+				continue
+			}
+			matched[key] = true
+			checkSchema(field.Type, val, joinPath(path, key), report)
+		}
+		for key := range obj {
+			if !matched[key] {
+				report(SchemaWarning{Kind: UnknownField, Field: joinPath(path, key)})
+			}
+		}
+	case reflect.Slice:
+		arr, ok := v.([]interface{})
+		if !ok {
+			mismatch()
+			return
+		}
+		for _, elem := range arr {
+			checkSchema(t.Elem(), elem, path+"[]", report)
+		}
+	case reflect.Map:
+		if _, ok := v.(map[string]interface{}); !ok {
+			mismatch()
+		}
+	case reflect.String:
+		if _, ok := v.(string); !ok {
+			mismatch()
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			mismatch()
+		}
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		if _, ok := v.(float64); !ok {
+			mismatch()
+		}
+	}
+}
+
+func checkRelationships(v interface{}, path string, report func(SchemaWarning)) {
+	arr, ok := v.([]interface{})
+	if !ok {
+		report(SchemaWarning{Kind: MismatchedField, Field: path})
+		return
+	}
+	for _, elem := range arr {
+		obj, _ := elem.(map[string]interface{})
+		if tp, _ := obj["type"].(string); !knownRelationships[tp] {
+			report(SchemaWarning{Kind: UnknownField, Field: fmt.Sprintf("%v[type=%v]", path, tp)})
+		}
+	}
+}
+
+func lookupFold(obj map[string]interface{}, name string) (string, interface{}, bool) {
+	if val, ok := obj[name]; ok {
+		return name, val, true
+	}
+	for key, val := range obj {
+		if strings.EqualFold(key, name) {
+			return key, val, true
+		}
+	}
+
+	return "", nil, false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
@@ -14,6 +14,7 @@ var CoverBaseURL, _ = url.Parse("https://uploads.mangadex.org/covers/")
 type Client struct {
 	base         *api.Client
 	coverBaseURL url.URL
+	unpublished  bool
 }
 
 func NewClient() *Client {
@@ -23,11 +24,44 @@ func NewClient() *Client {
 	}
 }
 
+// WithBaseURL makes the client use the given API server, which is
+// also expected to serve covers below "covers/".
+func (c *Client) WithBaseURL(base url.URL) *Client {
+	c.base.WithBaseURL(base)
+	if covers, err := base.Parse("covers/"); err == nil {
+		c.coverBaseURL = *covers
+	}
+	return c
+}
+
 func (c *Client) WithHTTPClient(http *http.Client) *Client {
 	c.base.WithHTTPClient(http)
 	return c
 }
 
+func (c *Client) WithAPIVersion(version string) *Client {
+	c.base.WithVersion(version)
+	return c
+}
+
+func (c *Client) WithToken(token string) *Client {
+	c.base.WithToken(token)
+	return c
+}
+
+// WithUnpublished makes the client include chapters that are not yet
+// published, which are only visible to authenticated members of the
+// uploading group.
+func (c *Client) WithUnpublished(unpublished bool) *Client {
+	c.unpublished = unpublished
+	return c
+}
+
+func (c *Client) WithSchemaWarnings(warn func(api.SchemaWarning)) *Client {
+	c.base.WithSchemaWarnings(warn)
+	return c
+}
+
 func (c *Client) FetchLegacy(ctx context.Context, tp string, legacyID int) (string, error) {
 	mapping, err := c.base.PostIDMapping(ctx, tp, legacyID)
 	if err != nil {
@@ -73,6 +107,10 @@ func (c *Client) FetchManga(ctx context.Context, mangaID string) (*Manga, error)
 
 func (c *Client) FetchChapters(ctx context.Context, mangaID string) (ChapterList, error) {
 	chapters := make([]api.ChapterData, 0)
+	futurePublish := "0"
+	if c.unpublished {
+		futurePublish = "1"
+	}
 
 	limit := 500
 	for offset := 0; ; offset += limit {
@@ -81,7 +119,7 @@ func (c *Client) FetchChapters(ctx context.Context, mangaID string) (ChapterList
 			Offset:        offset,
 			Order:         map[string]string{"updatedAt": "asc"},
 			EmptyPages:    "0",
-			FuturePublish: "0",
+			FuturePublish: futurePublish,
 			ExternalURL:   "0",
 		})
 		if err != nil {
@@ -103,6 +141,25 @@ func (c *Client) FetchChapters(ctx context.Context, mangaID string) (ChapterList
 	return convertChapters(chapters, groupMap), nil
 }
 
+// Blocked lists the groups and uploaders blocked by the authenticated
+// user in their MangaDex settings.
+type Blocked struct {
+	Groups    []string
+	Uploaders []string
+}
+
+func (c *Client) FetchBlocked(ctx context.Context) (*Blocked, error) {
+	settings, err := c.base.GetSettings(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get settings: %w", err)
+	}
+
+	return &Blocked{
+		Groups:    convertIDs(settings.Settings["excludedGroups"]),
+		Uploaders: convertIDs(settings.Settings["excludedUploaders"]),
+	}, nil
+}
+
 func (c *Client) FetchCovers(ctx context.Context, mangaID string) (PathList, error) {
 	covers := make([]api.CoverData, 0)
 	limit := 100
@@ -126,6 +183,22 @@ func (c *Client) FetchCovers(ctx context.Context, mangaID string) (PathList, err
 	return convertCovers(c.coverBaseURL.String(), mangaID, covers), nil
 }
 
+// FetchCover returns the path of a single cover of the manga.
+func (c *Client) FetchCover(ctx context.Context, mangaID, coverID string) (*Path, error) {
+	feed, err := c.base.GetCovers(ctx, api.QueryArgs{
+		IDs:    []string{coverID},
+		Mangas: []string{mangaID},
+		Limit:  1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get covers: %w", err)
+	} else if len(feed.Data) == 0 {
+		return nil, fmt.Errorf("cover %v: not found", coverID)
+	}
+
+	return &convertCovers(c.coverBaseURL.String(), mangaID, feed.Data)[0], nil
+}
+
 func (c *Client) FetchPaths(ctx context.Context, chapter *Chapter) (PathList, error) {
 	ah, err := c.base.GetAtHome(ctx, chapter.Info.ID)
 	if err != nil {
@@ -1,6 +1,7 @@
 package mangadex
 
 import (
+	"encoding/json"
 	"image"
 	"reflect"
 	"strings"
@@ -20,11 +21,47 @@ func convertManga(b *api.Manga, authors, artists *api.AuthorList) MangaInfo {
 		artistNames = append(artistNames, a.Attributes.Name)
 	}
 
+	tags := make([]Tag, 0)
+	for _, t := range b.Data.Attributes.Tags {
+		if len(t.Attributes.Name) > 0 {
+			tags = append(tags, Tag{
+				Name:  english(t.Attributes.Name),
+				Group: t.Attributes.Group,
+			})
+		}
+	}
+
+	altTitles := make([]string, 0)
+	for _, titles := range b.Data.Attributes.AltTitles {
+		for _, title := range titles {
+			altTitles = append(altTitles, title)
+		}
+	}
+
+	description := ""
+	if len(b.Data.Attributes.Description) > 0 {
+		description = english(b.Data.Attributes.Description)
+	}
+
+	coverID := ""
+	if len(b.Data.Relationships.CoverArt) > 0 {
+		coverID = b.Data.Relationships.CoverArt[0]
+	}
+
 	return MangaInfo{
-		Title:   first(b.Data.Attributes.Title),
-		Authors: authorNames,
-		Artists: artistNames,
-		ID:      b.Data.ID,
+		Title:         first(b.Data.Attributes.Title),
+		AltTitles:     altTitles,
+		Authors:       authorNames,
+		Artists:       artistNames,
+		ID:            b.Data.ID,
+		ContentRating: b.Data.Attributes.ContentRating,
+		Tags:          tags,
+		Status:        b.Data.Attributes.Status,
+		LastVolume:    NewWithFallback(b.Data.Attributes.LastVolume, "Unknown"),
+		LastChapter:   NewWithFallback(b.Data.Attributes.LastChapter, "Unknown"),
+		Description:   description,
+		Links:         b.Data.Attributes.Links,
+		CoverID:       coverID,
 	}
 }
 
@@ -36,6 +73,10 @@ func convertChapters(ca []api.ChapterData, groupMap map[string]api.GroupData) Ch
 		for _, id := range info.Relationships.Group {
 			groups = append(groups, groupMap[id].Attributes.Name)
 		}
+		uploader := info.Attributes.Uploader
+		if len(info.Relationships.User) > 0 {
+			uploader = info.Relationships.User[0]
+		}
 
 		sorted = append(sorted, Chapter{
 			Info: ChapterInfo{
@@ -43,8 +84,12 @@ func convertChapters(ca []api.ChapterData, groupMap map[string]api.GroupData) Ch
 				Language:         lang,
 				Views:            0, // FIXME
 				GroupNames:       groups,
+				GroupIDs:         info.Relationships.Group,
+				UploaderID:       uploader,
 				Published:        info.Attributes.PublishAt,
+				Updated:          info.Attributes.UpdatedAt,
 				ID:               info.ID,
+				Pages:            info.Attributes.Pages,
 				Identifier:       NewWithFallback(info.Attributes.Chapter, info.Attributes.Title),
 				VolumeIdentifier: NewWithFallback(info.Attributes.Volume, "Special"),
 			},
@@ -56,6 +101,28 @@ func convertChapters(ca []api.ChapterData, groupMap map[string]api.GroupData) Ch
 	return sorted
 }
 
+// convertIDs reads a list of IDs from user settings, where entries are
+// either plain IDs or objects with an "id" field.
+func convertIDs(data json.RawMessage) []string {
+	entries := make([]json.RawMessage, 0)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil
+	}
+
+	result := make([]string, 0)
+	for _, entry := range entries {
+		var id string
+		var object struct{ ID string }
+		if err := json.Unmarshal(entry, &id); err == nil && id != "" {
+			result = append(result, id)
+		} else if err := json.Unmarshal(entry, &object); err == nil && object.ID != "" {
+			result = append(result, object.ID)
+		}
+	}
+
+	return result
+}
+
 func convertCovers(coverBaseURL string, mangaID string, co []api.CoverData) PathList {
 	result := make(PathList, 0)
 	for _, info := range co {
@@ -79,6 +146,7 @@ func convertChapter(ch *Chapter, ah *api.AtHome) PathList {
 		result = append(result, Path{
 			DataURL:           dataURL,
 			DataSaverURL:      dataSaverURL,
+			Hash:              ah.Chapter.Hash + "/" + ah.Chapter.Data[i],
 			ImageIdentifier:   i,
 			ChapterIdentifier: ch.Info.Identifier,
 			VolumeIdentifier:  ch.Info.VolumeIdentifier,
@@ -111,6 +179,14 @@ func reverse(v interface{}) {
 	}
 }
 
+func english(m map[string]string) string {
+	if val, ok := m["en"]; ok {
+		return val
+	}
+
+	return first(m)
+}
+
 func first(m map[string]string) string {
 	for _, val := range m {
 		return val
@@ -117,6 +117,16 @@ func (n Identifier) IsNext(o Identifier) bool {
 	}
 }
 
+// Whole returns the identifier without its fractional part, so that
+// parts of split chapters like 5.1 and 5.2 share the identifier 5.
+func (n Identifier) Whole() Identifier {
+	if n.IsSpecial() {
+		return n
+	}
+
+	return Identifier{major: n.major}
+}
+
 func (n Identifier) MarshalText() ([]byte, error) {
 	return []byte(n.String()), nil
 }
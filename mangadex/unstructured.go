@@ -8,10 +8,34 @@ import (
 )
 
 type MangaInfo struct {
-	Title   string
-	Authors multiple
-	Artists multiple
-	ID      string
+	Title         string
+	AltTitles     []string
+	Authors       multiple
+	Artists       multiple
+	ID            string
+	ContentRating string
+	Tags          []Tag
+	// One of "ongoing", "completed", "hiatus" or "cancelled"
+	Status      string
+	LastVolume  Identifier
+	LastChapter Identifier
+	Description string
+	// Identifiers on other sites, e.g. "mal" for MyAnimeList
+	Links map[string]string
+	// Identifier of the main cover on MangaDex, if any
+	CoverID string
+
+	// Only known from other sites
+	Publisher string
+	Started   time.Time
+	Ended     time.Time
+}
+
+// Tag describes the manga, where the group is one of "content",
+// "format", "genre" or "theme".
+type Tag struct {
+	Name  string
+	Group string
 }
 
 type VolumeInfo struct {
@@ -23,8 +47,13 @@ type ChapterInfo struct {
 	Views      int
 	Language   language.Tag
 	GroupNames multiple
+	GroupIDs   []string
+	UploaderID string
 	Published  time.Time
+	Updated    time.Time
 	ID         string
+	Hash       string
+	Pages      int
 
 	// identifiers
 	Identifier       Identifier
@@ -33,6 +62,8 @@ type ChapterInfo struct {
 
 type Image struct {
 	Image image.Image
+	// Estimated encoding quality of the source, zero if unknown
+	Quality int
 
 	// identifiers
 	ImageIdentifier   int
@@ -43,6 +74,8 @@ type Image struct {
 type Path struct {
 	DataURL      string
 	DataSaverURL string
+	// Changes whenever the contents of the image change
+	Hash string
 
 	// identifiers
 	ImageIdentifier   int